@@ -0,0 +1,267 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+)
+
+// DefaultSessionTTL is how long a login is valid for, unless configured
+// otherwise with Config.SessionTTL
+const DefaultSessionTTL = 24 * time.Hour
+
+// dummyPasswordHash is checked against when someone logs in with an unknown
+// email address, so that the response takes as long as for a wrong password
+var dummyPasswordHash = func() []byte {
+	user := internal.User{}
+	user.SetPassword("not a real password")
+	return user.PasswordHash
+}()
+
+type RegisterHandlerParams struct {
+	Email     string `json:"email" form:"email" binding:"required,email,max=254"`
+	Password  string `json:"password" form:"password" binding:"required,min=8,max=72"`
+	FirstName string `json:"firstName" form:"firstName" binding:"required,max=64"`
+	LastName  string `json:"lastName" form:"lastName" binding:"required,max=64"`
+	Phone     string `json:"phone" form:"phone" binding:"required,max=32"`
+
+	Address struct {
+		AddressLine string `json:"addressLine" form:"addressLine" binding:"required,max=128"`
+		CountryCode string `json:"countryCode" form:"countryCode" binding:"required,len=2"`
+		City        string `json:"city" form:"city" binding:"required,max=64"`
+		ZipCode     string `json:"zipCode" form:"zipCode" binding:"required,max=16"`
+	} `json:"address" form:"address" binding:"required"`
+}
+
+// registerHandler creates a new user account
+func registerHandler(c *gin.Context) {
+	userRepo := dependencies(c).UserRepo
+
+	var params RegisterHandlerParams
+	err := c.Bind(&params)
+	if err != nil {
+		slog.InfoContext(c.Request.Context(), "Couldn't parse form parameters", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid data",
+		})
+		return
+	}
+
+	address := internal.UserAddress{
+		AddressLine: params.Address.AddressLine,
+		CountryCode: strings.ToUpper(params.Address.CountryCode),
+		City:        params.Address.City,
+		ZipCode:     params.Address.ZipCode,
+	}
+	user := internal.NewUser(normaliseEmail(params.Email), params.FirstName, params.LastName, params.Phone, address)
+
+	err = user.SetPassword(params.Password)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't hash password", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid password",
+		})
+		return
+	}
+
+	err = userRepo.AddUser(user)
+	if errors.Is(err, storage.ErrEmailTaken) {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "Email address is already registered",
+		})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't add new user to user repo", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to register user",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, user)
+}
+
+type LoginHandlerParams struct {
+	Email    string `json:"email" form:"email" binding:"required"`
+	Password string `json:"password" form:"password" binding:"required"`
+}
+
+// loginHandler checks the user's credentials and creates a session. The
+// returned token has to be sent as a bearer token with every request that
+// requires authentication.
+func loginHandler(c *gin.Context) {
+	deps := dependencies(c)
+
+	var params LoginHandlerParams
+	err := c.Bind(&params)
+	if err != nil {
+		slog.InfoContext(c.Request.Context(), "Couldn't parse form parameters", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid data",
+		})
+		return
+	}
+
+	user, err := deps.UserRepo.GetUserByEmail(normaliseEmail(params.Email))
+	if errors.Is(err, storage.ErrNotFound) {
+		(&internal.User{PasswordHash: dummyPasswordHash}).CheckPassword(params.Password)
+	} else if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't get user from user repo", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to log in",
+		})
+		return
+	}
+
+	if user == nil || !user.CheckPassword(params.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid email or password",
+		})
+		return
+	}
+
+	session, token, err := internal.NewSession(user.Id, deps.Config.sessionTTL())
+	if err == nil {
+		err = deps.UserRepo.AddSession(session)
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't create session for user", "userId", user.Id, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to log in",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":     token,
+		"expiresAt": session.ExpiresAt,
+	})
+}
+
+// logoutHandler ends the session the request was authenticated with
+func logoutHandler(c *gin.Context) {
+	userRepo := dependencies(c).UserRepo
+	session := c.MustGet("session").(*internal.Session)
+
+	err := userRepo.RemoveSession(session.TokenHash)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		slog.ErrorContext(c.Request.Context(), "Couldn't remove session of user", "userId", session.UserId, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to log out",
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// meHandler returns the authenticated user
+func meHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, authenticatedUser(c))
+}
+
+// authRequired rejects requests without a valid bearer token and attaches
+// the authenticated user and their session to the gin context
+func authRequired(c *gin.Context) {
+	userRepo := dependencies(c).UserRepo
+
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !found || token == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"message": "Authentication required",
+		})
+		return
+	}
+
+	session, err := userRepo.GetSession(internal.HashToken(token))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		slog.ErrorContext(c.Request.Context(), "Couldn't get session from user repo", "error", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to authenticate",
+		})
+		return
+	}
+
+	if session == nil || session.IsExpired(time.Now()) {
+		if session != nil {
+			userRepo.RemoveSession(session.TokenHash)
+		}
+
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid or expired token",
+		})
+		return
+	}
+
+	user, err := userRepo.GetUser(session.UserId.String())
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't get user of session", "userId", session.UserId, "error", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid or expired token",
+		})
+		return
+	}
+
+	c.Set("session", session)
+	c.Set("user", user)
+}
+
+// adminRequired rejects requests that aren't authenticated with the admin
+// token. Without a configured admin token, every request is rejected.
+// Logged in users are forbidden, everyone else is unauthorized.
+func adminRequired(c *gin.Context) {
+	deps := dependencies(c)
+
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if deps.Config.AdminToken != "" && found && subtle.ConstantTimeCompare([]byte(token), []byte(deps.Config.AdminToken)) == 1 {
+		return
+	}
+
+	session, _ := deps.UserRepo.GetSession(internal.HashToken(token))
+	if found && session != nil && !session.IsExpired(time.Now()) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"message": "Admin access required",
+		})
+		return
+	}
+
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+		"message": "Admin authentication required",
+	})
+}
+
+// authenticatedUser returns the user attached to the gin context by
+// authRequired
+func authenticatedUser(c *gin.Context) *internal.User {
+	return c.MustGet("user").(*internal.User)
+}
+
+// customer returns a copy of the authenticated user to make an order for,
+// with the IP address the request came from
+func customer(c *gin.Context) internal.User {
+	return customerFrom(c, authenticatedUser(c))
+}
+
+// customerFrom returns a copy of the user to make an order or payout for,
+// with the IP address the request came from
+func customerFrom(c *gin.Context, registered *internal.User) internal.User {
+	user := *registered
+	user.IpAddr = c.ClientIP()
+	// Orders and payouts have no use for the user's password
+	user.PasswordHash = nil
+
+	return user
+}
+
+func normaliseEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
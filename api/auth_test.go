@@ -0,0 +1,248 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+const registerRequestBody = `{
+	"email": "Someone@Example.com",
+	"password": "correct horse battery staple",
+	"firstName": "Some",
+	"lastName": "One",
+	"phone": "+4511111111",
+	"address": {
+		"addressLine": "Somewhere 1",
+		"countryCode": "dk",
+		"city": "Aalborg",
+		"zipCode": "9000"
+	}
+}`
+
+// sendRequest sends a JSON request, authenticated with the bearer token
+// unless it is empty
+func sendRequest(t *testing.T, handler http.Handler, method, target, token, body string) *httptest.ResponseRecorder {
+	resWriter := httptest.NewRecorder()
+	req, err := http.NewRequest(method, target, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to init request: %q\n", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	handler.ServeHTTP(resWriter, req)
+
+	return resWriter
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	engine := setupTestApi(t, createZotaAPIMock(), createOrderRepo(), createPayoutRepo(), createConfig())
+
+	resWriter := sendRequest(t, engine, "POST", "/auth/register", "", registerRequestBody)
+	if resWriter.Code != http.StatusCreated {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusCreated)
+	}
+
+	if strings.Contains(resWriter.Body.String(), "assword") {
+		t.Errorf("Server response %q contains the password", resWriter.Body.String())
+	}
+
+	resWriter = sendRequest(t, engine, "POST", "/auth/register", "", registerRequestBody)
+	if resWriter.Code != http.StatusConflict {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusConflict)
+	}
+
+	tests := []struct {
+		body         string
+		expectedCode int
+	}{
+		{`{"email": "someone@example.com", "password": "correct horse battery staple"}`, http.StatusOK},
+		{`{"email": " SOMEONE@example.com", "password": "correct horse battery staple"}`, http.StatusOK},
+		{`{"email": "someone@example.com", "password": "wrong password"}`, http.StatusUnauthorized},
+		{`{"email": "nobody@example.com", "password": "correct horse battery staple"}`, http.StatusUnauthorized},
+		{`{"email": "someone@example.com"}`, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		resWriter := sendRequest(t, engine, "POST", "/auth/login", "", test.body)
+		if resWriter.Code != test.expectedCode {
+			t.Errorf("Server response %d doesn't equal expected %d for %v", resWriter.Code, test.expectedCode, test.body)
+		}
+	}
+
+	resWriter = sendRequest(t, engine, "POST", "/auth/login", "", `{"email": "someone@example.com", "password": "correct horse battery staple"}`)
+
+	var login struct {
+		Token string `json:"token"`
+	}
+
+	err := json.Unmarshal(resWriter.Body.Bytes(), &login)
+	if err != nil || login.Token == "" {
+		t.Fatalf("Failed to parse login response %q: %v\n", resWriter.Body.String(), err)
+	}
+
+	resWriter = sendRequest(t, engine, "GET", "/auth/me", login.Token, "")
+	if resWriter.Code != http.StatusOK {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusOK)
+	}
+
+	var user internal.User
+	err = json.Unmarshal(resWriter.Body.Bytes(), &user)
+	if err != nil {
+		t.Fatalf("Failed to parse user: %q\n", err)
+	}
+
+	if user.Email != "someone@example.com" || user.Address.CountryCode != "DK" {
+		t.Errorf("Logged in user %+v doesn't match the registered user", user)
+	}
+
+	resWriter = sendRequest(t, engine, "POST", "/auth/logout", login.Token, "")
+	if resWriter.Code != http.StatusNoContent {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusNoContent)
+	}
+
+	resWriter = sendRequest(t, engine, "GET", "/auth/me", login.Token, "")
+	if resWriter.Code != http.StatusUnauthorized {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRegisterRejectsInvalidData(t *testing.T) {
+	engine := setupTestApi(t, createZotaAPIMock(), createOrderRepo(), createPayoutRepo(), createConfig())
+
+	tests := []string{
+		strings.Replace(registerRequestBody, "Someone@Example.com", "not an email", 1),
+		strings.Replace(registerRequestBody, "correct horse battery staple", "short", 1),
+		strings.Replace(registerRequestBody, "correct horse battery staple", strings.Repeat("a", 73), 1),
+		strings.Replace(registerRequestBody, `"dk"`, `"dnk"`, 1),
+	}
+
+	for _, body := range tests {
+		resWriter := sendRequest(t, engine, "POST", "/auth/register", "", body)
+		if resWriter.Code != http.StatusBadRequest {
+			t.Errorf("Server response %d doesn't equal expected %d for %v", resWriter.Code, http.StatusBadRequest, body)
+		}
+	}
+}
+
+func TestEndpointsRequireAuthentication(t *testing.T) {
+	userRepo := createUserRepo(t)
+	engine := setupTestApiWithUsers(createZotaAPIMock(), createOrderRepo(), createPayoutRepo(), userRepo, createConfig())
+
+	user, err := userRepo.GetUserByEmail("federlizer@protonmail.com")
+	if err != nil {
+		t.Fatalf("Failed to get user: %q\n", err)
+	}
+
+	err = userRepo.AddSession(&internal.Session{
+		TokenHash: internal.HashToken("expired-token"),
+		UserId:    user.Id,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("Failed to add session: %q\n", err)
+	}
+
+	tests := []struct {
+		method string
+		target string
+		token  string
+	}{
+		{"GET", "/order", ""},
+		{"POST", "/order", ""},
+		{"POST", "/admin/payout", ""},
+		{"GET", "/payout/e31edd0d-76a6-4f1c-be19-4504ff5b89d7", ""},
+		{"GET", "/auth/me", ""},
+		{"GET", "/order/e31edd0d-76a6-4f1c-be19-4504ff5b89d7/events", ""},
+		{"POST", "/order/e31edd0d-76a6-4f1c-be19-4504ff5b89d7/events/token", ""},
+		{"GET", "/order", "unknown-token"},
+		{"GET", "/order", "expired-token"},
+	}
+
+	for _, test := range tests {
+		resWriter := sendRequest(t, engine, test.method, test.target, test.token, "")
+		if resWriter.Code != http.StatusUnauthorized {
+			t.Errorf("Server response %d doesn't equal expected %d for %v %v", resWriter.Code, http.StatusUnauthorized, test.method, test.target)
+		}
+	}
+
+	_, err = userRepo.GetSession(internal.HashToken("expired-token"))
+	if err == nil {
+		t.Errorf("Expired session wasn't removed")
+	}
+}
+
+func TestOrdersAreMadeForAuthenticatedUser(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	zotaApi.depositResponse = &zota.ZotaDepositResponse{
+		Code: "200",
+		Data: &zota.ZotaDepositData{
+			OrderId:    "32ab44f0a5d9d6b6c0c9e41b1a9c7ea1a2b3c4d5",
+			DepositUrl: "https://zota.com/deposit",
+		},
+	}
+	orderRepo := createOrderRepo()
+	userRepo := createUserRepo(t)
+	engine := setupTestApiWithUsers(zotaApi, orderRepo, createPayoutRepo(), userRepo, createConfig())
+
+	// Someone else's order must not be listed
+	otherUser := internal.NewUser("someone@example.com", "Some", "One", "+4511111111", internal.UserAddress{})
+	amount, _ := internal.NewMoney(1337, "USD")
+	err := orderRepo.AddOrder(internal.NewOrder(otherUser, amount, "Other order"))
+	if err != nil {
+		t.Fatalf("Failed to add order: %q\n", err)
+	}
+
+	resWriter := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/order", strings.NewReader(`{"description": "Test order", "amount": 13.37}`))
+	if err != nil {
+		t.Fatalf("Failed to init request: %q\n", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "203.0.113.7:54321"
+
+	authorize(req)
+	engine.ServeHTTP(resWriter, req)
+
+	if resWriter.Code != http.StatusFound {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusFound)
+	}
+
+	depositRequest := zotaApi.depositRequest
+	if depositRequest.CustomerEmail != "federlizer@protonmail.com" || depositRequest.CustomerIP != "203.0.113.7" {
+		t.Errorf("Deposit request %+v wasn't made for the authenticated user", *depositRequest)
+	}
+
+	orders := listOrders(t, engine)
+	if len(orders) != 1 || orders[0].Description != "Test order" {
+		t.Errorf("Orders %+v don't contain only the user's own order", orders)
+	}
+}
+
+func TestGetPayoutOfOtherUser(t *testing.T) {
+	payoutRepo := createPayoutRepo()
+	engine := setupTestApi(t, createZotaAPIMock(), createOrderRepo(), payoutRepo, createConfig())
+
+	otherUser := internal.NewUser("someone@example.com", "Some", "One", "+4511111111", internal.UserAddress{})
+	amount, _ := internal.NewMoney(1337, "USD")
+	payout := internal.NewPayout(otherUser, &internal.BankAccount{}, amount, "Other payout")
+	err := payoutRepo.AddPayout(payout)
+	if err != nil {
+		t.Fatalf("Failed to add payout: %q\n", err)
+	}
+
+	resWriter := sendRequest(t, engine, "GET", "/payout/"+payout.Id.String(), testToken, "")
+	if resWriter.Code != http.StatusNotFound {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+// zotaCallbackHandler handles the callback notifications sent by Zota
+// whenever an order or a payout changes its status.
+//
+// Zota keeps retrying a callback until it receives a 200 response, so
+// callbacks that can't change the order anymore (replayed or out of order
+// ones) are acknowledged but otherwise ignored. Callbacks that can't be
+// trusted are rejected.
+func zotaCallbackHandler(c *gin.Context) {
+	deps := dependencies(c)
+	ctx := c.Request.Context()
+
+	var callback zota.ZotaCallback
+	err := c.ShouldBindJSON(&callback)
+	if err != nil {
+		slog.InfoContext(ctx, "Couldn't parse Zota callback", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid data",
+		})
+		return
+	}
+
+	logger := slog.With("orderId", callback.MerchantOrderId, "zotaOrderId", callback.OrderId, "status", callback.Status)
+
+	// Make sure that the callback was sent by Zota for one of our endpoints
+	if !isOwnEndpoint(deps.ZotaAPI, callback.EndpointId) || !callback.VerifySignature(callback.EndpointId, deps.ZotaAPI.SecretKey()) {
+		logger.WarnContext(ctx, "Received Zota callback with an invalid signature")
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid signature",
+		})
+		return
+	}
+
+	orderId := callback.MerchantOrderId
+	isDeposit := callback.Type != zota.CallbackTypePayout
+	var zotaOrderId, processorTransactionId string
+	var paymentStatus internal.PaymentStatus
+	var amount internal.Money
+	var updateStatus func(id string, expected internal.PaymentStatus, change internal.StatusChange) error
+
+	// Payouts are reported through the same callback as deposits
+	if !isDeposit {
+		payout, getErr := deps.PayoutRepo.GetPayout(orderId)
+		if payout != nil {
+			zotaOrderId, paymentStatus, amount = payout.ZotaOrderId, payout.PaymentStatus, payout.Amount
+		}
+		err = getErr
+		updateStatus = func(id string, expected internal.PaymentStatus, change internal.StatusChange) error {
+			return deps.PayoutRepo.UpdateStatus(id, expected, change.Status)
+		}
+	} else {
+		order, getErr := deps.OrderRepo.GetOrder(orderId)
+		if order != nil {
+			zotaOrderId, paymentStatus, amount = order.ZotaOrderId, order.PaymentStatus, order.Amount
+			processorTransactionId = order.ProcessorTransactionId
+		}
+		err = getErr
+		updateStatus = deps.OrderRepo.UpdateStatus
+	}
+
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Order not found",
+		})
+		return
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Couldn't get order for Zota callback", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to get order",
+		})
+		return
+	}
+
+	if zotaOrderId != "" && zotaOrderId != callback.OrderId {
+		logger.WarnContext(ctx, "Zota callback order ID doesn't match the order", "expectedZotaOrderId", zotaOrderId)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Order ID mismatch",
+		})
+		return
+	}
+
+	// The order's request to Zota might have timed out after Zota had
+	// accepted it
+	if zotaOrderId == "" && callback.OrderId != "" {
+		pollKind := storage.PollKindDeposit
+		if !isDeposit {
+			pollKind = storage.PollKindPayout
+		}
+		recordZotaOrderId(ctx, deps, pollKind, orderId, callback.OrderId, paymentStatus)
+	}
+
+	if isDeposit && callback.ProcessorTransactionId != "" && callback.ProcessorTransactionId != processorTransactionId {
+		err = deps.OrderRepo.SetProcessorTransactionId(orderId, callback.ProcessorTransactionId)
+		if err != nil {
+			logger.ErrorContext(ctx, "Couldn't store processor transaction ID of order", "error", err)
+		}
+	}
+
+	// A final status can't be changed, so anything that arrives after it
+	// is either a replay or out of order
+	if paymentStatus.IsFinal() {
+		logger.InfoContext(ctx, "Ignoring Zota callback for already finalised order", "paymentStatus", paymentStatus)
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Order already finalised",
+		})
+		return
+	}
+
+	newStatus, err := callback.PaymentStatusFor(amount)
+	change := internal.StatusChange{
+		Status:       newStatus,
+		Source:       internal.StatusSourceCallback,
+		ErrorMessage: callback.ErrorMessage,
+	}
+	if err != nil {
+		logger.WarnContext(ctx, "Failing order", "error", err)
+		change.ErrorMessage = err.Error()
+	}
+
+	// Non-final statuses only tell how far a deposit has got, those that
+	// don't move it forward are out of order or tell nothing new
+	if !newStatus.IsFinal() && (!isDeposit || !paymentStatus.CanTransitionTo(newStatus)) {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "OK",
+		})
+		return
+	}
+
+	err = updateStatus(orderId, paymentStatus, change)
+	if errors.Is(err, storage.ErrStatusConflict) {
+		// The poller or another callback has changed the order in the meantime
+		logger.InfoContext(ctx, "Ignoring Zota callback for concurrently changed order")
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Order already changed",
+		})
+		return
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Couldn't update status of order from Zota callback", "error", err)
+		// Zota will retry the callback
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to update order",
+		})
+		return
+	}
+
+	if newStatus.IsFinal() {
+		logger.InfoContext(ctx, "Order received final status from Zota callback", "paymentStatus", newStatus)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+	})
+}
+
+// recordZotaOrderId stores the Zota order ID of an order or payout whose
+// request to Zota timed out after Zota had accepted it, which is only learnt
+// from Zota's callback or redirect, and polls it from then on like any other
+// accepted one. kind is either storage.PollKindDeposit or
+// storage.PollKindPayout. Failures are logged, the caller carries on.
+func recordZotaOrderId(ctx context.Context, deps Dependencies, kind, id, zotaOrderId string, status internal.PaymentStatus) {
+	logger := slog.With("orderId", id, "zotaOrderId", zotaOrderId)
+
+	var err error
+	if kind == storage.PollKindDeposit {
+		err = deps.OrderRepo.SetZotaOrder(id, zotaOrderId, "")
+	} else {
+		err = deps.PayoutRepo.SetZotaOrderId(id, zotaOrderId)
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Couldn't store Zota order ID", "error", err)
+		return
+	}
+
+	if status.IsFinal() {
+		return
+	}
+
+	err = deps.Poller.Track(ctx, kind, id, zotaOrderId)
+	if err != nil {
+		logger.ErrorContext(ctx, "Couldn't start polling for order", "error", err)
+	}
+}
+
+// isOwnEndpoint reports whether the endpoint ID is the payout endpoint or the
+// deposit endpoint of one of the currencies we accept
+func isOwnEndpoint(zotaApi zota.IZotaAPI, endpointId string) bool {
+	if endpointId == zotaApi.EndpointId() {
+		return true
+	}
+
+	for _, currency := range zotaApi.Currencies() {
+		currencyEndpointId, err := zotaApi.EndpointIdFor(currency)
+		if err == nil && currencyEndpointId == endpointId {
+			return true
+		}
+	}
+
+	return false
+}
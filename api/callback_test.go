@@ -0,0 +1,241 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+func createTestOrder() *internal.Order {
+	user := internal.User{Email: "federlizer@protonmail.com"}
+	amount, _ := internal.NewMoney(1337, "USD")
+	order := internal.NewOrder(&user, amount, "Test order")
+	order.ZotaOrderId = "32ab44f0a5d9d6b6c0c9e41b1a9c7ea1a2b3c4d5"
+	order.PaymentStatus = internal.PaymentStatusSubmitted
+
+	return order
+}
+
+func createSignedCallback(api zota.IZotaAPI, order *internal.Order, status zota.OrderStatus) zota.ZotaCallback {
+	callback := zota.ZotaCallback{
+		Type:            "SALE",
+		Status:          status,
+		EndpointId:      api.EndpointId(),
+		OrderId:         order.ZotaOrderId,
+		MerchantOrderId: order.Id.String(),
+		Amount:          order.AmountStr(),
+		Currency:        "USD",
+		CustomerEmail:   order.User.Email,
+	}
+	callback.Signature = callback.GenSignature(api.EndpointId(), api.SecretKey())
+
+	return callback
+}
+
+func postCallback(t *testing.T, handler http.Handler, callback zota.ZotaCallback) *httptest.ResponseRecorder {
+	body, err := json.Marshal(callback)
+	if err != nil {
+		t.Fatalf("Failed to marshal callback: %q\n", err)
+	}
+
+	resWriter := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/zota/callback", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to init request: %q\n", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	handler.ServeHTTP(resWriter, req)
+
+	return resWriter
+}
+
+func TestZotaCallbackFinalisesOrder(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	orderRepo := createOrderRepo()
+	order := createTestOrder()
+	orderRepo.AddOrder(order)
+
+	engine := setupTestApi(t, zotaApi, orderRepo, createPayoutRepo(), createConfig())
+
+	callback := createSignedCallback(zotaApi, order, zota.Approved)
+	callback.ProcessorTransactionId = "PTX-13371337"
+
+	resWriter := postCallback(t, engine, callback)
+	if resWriter.Code != http.StatusOK {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusOK)
+	}
+
+	order = getStoredOrder(t, orderRepo, order.Id.String())
+	if order.PaymentStatus != internal.PaymentStatusApproved {
+		t.Errorf("Order status %q doesn't equal expected %q", order.PaymentStatus, internal.PaymentStatusApproved)
+	}
+
+	if order.ProcessorTransactionId != "PTX-13371337" {
+		t.Errorf("Processor transaction ID %q doesn't equal expected %q", order.ProcessorTransactionId, "PTX-13371337")
+	}
+}
+
+func TestZotaCallbackFinalisesTimedOutOrder(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	zotaApi.depositErr = context.DeadlineExceeded
+	orderRepo := createOrderRepo()
+	engine := setupTestApi(t, zotaApi, orderRepo, createPayoutRepo(), createConfig())
+
+	// Zota accepted the deposit, but we never heard back
+	resWriter := postOrder(t, engine, `{"description": "Test order", "amount": 13.37}`)
+	if resWriter.Code != http.StatusGatewayTimeout {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusGatewayTimeout)
+	}
+
+	orders, _ := orderRepo.GetAll()
+	if len(orders) != 1 || orders[0].PaymentStatus != internal.PaymentStatusCreated {
+		t.Fatalf("Orders %+v don't hold the timed out order", orders)
+	}
+	order := orders[0]
+	order.ZotaOrderId = "32ab44f0a5d9d6b6c0c9e41b1a9c7ea1a2b3c4d5"
+
+	resWriter = postCallback(t, engine, createSignedCallback(zotaApi, order, zota.Pending))
+	if resWriter.Code != http.StatusOK {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusOK)
+	}
+
+	// The order is polled like any other accepted one from now on
+	stored := getStoredOrder(t, orderRepo, order.Id.String())
+	if stored.PaymentStatus != internal.PaymentStatusPending || stored.ZotaOrderId != order.ZotaOrderId {
+		t.Errorf("Order %+v didn't record the callback's status and Zota order ID", stored)
+	}
+
+	resWriter = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/poller", nil)
+	engine.ServeHTTP(resWriter, req)
+
+	var tracked struct {
+		Tracking int `json:"tracking"`
+	}
+	json.Unmarshal(resWriter.Body.Bytes(), &tracked)
+	if tracked.Tracking != 1 {
+		t.Errorf("Poller is tracking %d orders instead of %d", tracked.Tracking, 1)
+	}
+
+	resWriter = postCallback(t, engine, createSignedCallback(zotaApi, order, zota.Approved))
+	if resWriter.Code != http.StatusOK {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusOK)
+	}
+
+	stored = getStoredOrder(t, orderRepo, order.Id.String())
+	if stored.PaymentStatus != internal.PaymentStatusApproved {
+		t.Errorf("Order status %q doesn't equal expected %q", stored.PaymentStatus, internal.PaymentStatusApproved)
+	}
+}
+
+func TestZotaCallbackRejectsInvalidSignature(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	orderRepo := createOrderRepo()
+	order := createTestOrder()
+	orderRepo.AddOrder(order)
+
+	engine := setupTestApi(t, zotaApi, orderRepo, createPayoutRepo(), createConfig())
+
+	callback := createSignedCallback(zotaApi, order, zota.Declined)
+	// Pretend someone tried to approve a declined order
+	callback.Status = zota.Approved
+
+	resWriter := postCallback(t, engine, callback)
+	if resWriter.Code != http.StatusUnauthorized {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusUnauthorized)
+	}
+
+	order = getStoredOrder(t, orderRepo, order.Id.String())
+	if order.PaymentStatus != internal.PaymentStatusSubmitted {
+		t.Errorf("Order status %q doesn't equal expected %q", order.PaymentStatus, internal.PaymentStatusSubmitted)
+	}
+}
+
+func TestZotaCallbackIgnoresOutOfOrderCallbacks(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	orderRepo := createOrderRepo()
+	order := createTestOrder()
+	orderRepo.AddOrder(order)
+
+	engine := setupTestApi(t, zotaApi, orderRepo, createPayoutRepo(), createConfig())
+
+	declined := createSignedCallback(zotaApi, order, zota.Declined)
+	declined.ErrorMessage = "Insufficient funds"
+	declined.Signature = declined.GenSignature(zotaApi.EndpointId(), zotaApi.SecretKey())
+
+	callbacks := []zota.ZotaCallback{
+		createSignedCallback(zotaApi, order, zota.Processing),
+		// A repeated status doesn't change anything
+		createSignedCallback(zotaApi, order, zota.Processing),
+		declined,
+		// Replayed and late callbacks shouldn't change a final status
+		createSignedCallback(zotaApi, order, zota.Declined),
+		createSignedCallback(zotaApi, order, zota.Approved),
+		createSignedCallback(zotaApi, order, zota.Pending),
+	}
+
+	for _, callback := range callbacks {
+		resWriter := postCallback(t, engine, callback)
+		if resWriter.Code != http.StatusOK {
+			t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusOK)
+		}
+	}
+
+	order = getStoredOrder(t, orderRepo, order.Id.String())
+	if order.PaymentStatus != internal.PaymentStatusDeclined {
+		t.Errorf("Order status %q doesn't equal expected %q", order.PaymentStatus, internal.PaymentStatusDeclined)
+	}
+
+	history, err := orderRepo.GetStatusHistory(order.Id.String())
+	if err != nil {
+		t.Fatalf("Failed to get status history: %q\n", err)
+	}
+
+	if len(history) != 3 || history[1].Status != internal.PaymentStatusProcessing || history[1].Source != internal.StatusSourceCallback ||
+		history[2].Status != internal.PaymentStatusDeclined || history[2].ErrorMessage != "Insufficient funds" {
+		t.Errorf("Status history %+v doesn't contain the callbacks' statuses", history)
+	}
+}
+
+func TestZotaCallbackRejectsUnknownOrder(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	orderRepo := createOrderRepo()
+	order := createTestOrder()
+
+	engine := setupTestApi(t, zotaApi, orderRepo, createPayoutRepo(), createConfig())
+
+	resWriter := postCallback(t, engine, createSignedCallback(zotaApi, order, zota.Approved))
+	if resWriter.Code != http.StatusNotFound {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusNotFound)
+	}
+}
+
+func TestZotaCallbackFailsOrderWithMismatchedAmount(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	orderRepo := createOrderRepo()
+	order := createTestOrder()
+	orderRepo.AddOrder(order)
+
+	engine := setupTestApi(t, zotaApi, orderRepo, createPayoutRepo(), createConfig())
+
+	callback := createSignedCallback(zotaApi, order, zota.Approved)
+	callback.Amount = "1.37"
+	callback.Signature = callback.GenSignature(zotaApi.EndpointId(), zotaApi.SecretKey())
+
+	resWriter := postCallback(t, engine, callback)
+	if resWriter.Code != http.StatusOK {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusOK)
+	}
+
+	order = getStoredOrder(t, orderRepo, order.Id.String())
+	if order.PaymentStatus != internal.PaymentStatusError {
+		t.Errorf("Order status %q doesn't equal expected %q", order.PaymentStatus, internal.PaymentStatusError)
+	}
+}
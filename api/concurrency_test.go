@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/events"
+	"github.com/federlizer/alokin-zota-integration/internal/poller"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+// TestConcurrentOrderFlow creates orders while their statuses are being
+// polled, callbacks are arriving and orders are being listed. Run with -race.
+func TestConcurrentOrderFlow(t *testing.T) {
+	const orderCount = 20
+
+	zotaApi := createZotaAPIMock()
+	zotaApi.depositResponse = &zota.ZotaDepositResponse{
+		Code: "200",
+		Data: &zota.ZotaDepositData{
+			OrderId:    "32ab44f0a5d9d6b6c0c9e41b1a9c7ea1a2b3c4d5",
+			DepositUrl: "https://zota.com/deposit",
+		},
+	}
+	zotaApi.orderStatusResponse = &zota.ZotaOrderStatusResponse{
+		Code: "200",
+		Data: &zota.ZotaOrderStatusData{Status: zota.Approved, Amount: "13.37", Currency: "USD"},
+	}
+	orderRepo := createOrderRepo()
+
+	payoutRepo := createPayoutRepo()
+
+	orderPoller := poller.New(zotaApi, orderRepo, payoutRepo, storage.NewMemoryPollSchedule(), poller.FixedPolicy{Interval: 10 * time.Millisecond, MaxAttempts: 20})
+	err := orderPoller.Start()
+	if err != nil {
+		t.Fatalf("Failed to start poller: %q\n", err)
+	}
+	defer orderPoller.Stop()
+
+	engine := SetupApi(Dependencies{
+		ZotaAPI:          zotaApi,
+		OrderRepo:        orderRepo,
+		PayoutRepo:       payoutRepo,
+		UserRepo:         createUserRepo(t),
+		IdempotencyStore: storage.NewMemoryIdempotencyStore(),
+		WebhookRepo:      storage.NewMemoryWebhookRepo(),
+		Bus:              events.NewBus(),
+		Poller:           orderPoller,
+		Config:           createConfig(),
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < orderCount; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			resWriter := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/order", strings.NewReader(`{"description": "Test order", "amount": 13.37}`))
+			req.Header.Set("Content-Type", "application/json")
+			authorize(req)
+			engine.ServeHTTP(resWriter, req)
+
+			if resWriter.Code != http.StatusFound {
+				t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusFound)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			orders := listOrders(t, engine)
+
+			// Race the poller with a callback for every order we can see
+			for _, order := range orders {
+				wg.Add(1)
+				go func(order *internal.Order) {
+					defer wg.Done()
+
+					order.User.Email = "federlizer@protonmail.com"
+					order.ZotaOrderId = "32ab44f0a5d9d6b6c0c9e41b1a9c7ea1a2b3c4d5"
+					postCallback(t, engine, createSignedCallback(zotaApi, order, zota.Declined))
+				}(order)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// Polls run in the background, wait until all of them are done
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		orders := listOrders(t, engine)
+
+		pending := 0
+		for _, order := range orders {
+			if !order.PaymentStatus.IsFinal() {
+				pending += 1
+			}
+		}
+
+		if len(orders) == orderCount && pending == 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d orders haven't been finalised", pending, len(orders))
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, order := range listOrders(t, engine) {
+		history, err := orderRepo.GetStatusHistory(order.Id.String())
+		if err != nil {
+			t.Fatalf("Failed to get status history: %q\n", err)
+		}
+
+		// The order is created, submitted and finalised by whoever came
+		// first, the other one is ignored
+		if len(history) != 3 {
+			t.Errorf("Order %v has %d status changes instead of %d", order.Id, len(history), 3)
+		}
+	}
+}
+
+func listOrders(t *testing.T, handler http.Handler) []*internal.Order {
+	resWriter := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/order", nil)
+	authorize(req)
+	handler.ServeHTTP(resWriter, req)
+
+	var response struct {
+		Orders []*internal.Order `json:"orders"`
+	}
+
+	err := json.Unmarshal(resWriter.Body.Bytes(), &response)
+	if err != nil {
+		t.Errorf("Failed to parse orders: %q\n", err)
+	}
+
+	return response.Orders
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+// Config holds the server configuration that the API handlers need
+type Config struct {
+	// PublicUrl is the URL the server is publicly reachable at. The
+	// redirect and callback URLs sent to Zota are built from it.
+	PublicUrl string
+	// CheckoutUrl is the page the customer starts the payment from
+	CheckoutUrl string
+
+	// SuccessPageUrl, FailurePageUrl and PendingPageUrl are the pages the
+	// customer is sent to after returning from Zota's deposit page. When a
+	// page isn't configured, the order's status is returned as JSON instead.
+	SuccessPageUrl string
+	FailurePageUrl string
+	PendingPageUrl string
+
+	// SessionTTL is how long a login is valid for, DefaultSessionTTL if zero
+	SessionTTL time.Duration
+	// TrustedProxies are the addresses of the proxies whose X-Forwarded-For
+	// headers are trusted to determine the client's IP address. If empty,
+	// the address of the connection is used.
+	TrustedProxies []string
+
+	// IdempotencyTTL is how long the response to a request with an
+	// Idempotency-Key is kept, DefaultIdempotencyTTL if zero
+	IdempotencyTTL time.Duration
+
+	// AdminToken is the bearer token of the /admin endpoints, which are
+	// disabled if it's empty
+	AdminToken string
+
+	// CheckZotaReadiness makes /readyz check that Zota's API can be reached
+	CheckZotaReadiness bool
+}
+
+func (c Config) sessionTTL() time.Duration {
+	if c.SessionTTL <= 0 {
+		return DefaultSessionTTL
+	}
+
+	return c.SessionTTL
+}
+
+func (c Config) idempotencyTTL() time.Duration {
+	if c.IdempotencyTTL <= 0 {
+		return DefaultIdempotencyTTL
+	}
+
+	return c.IdempotencyTTL
+}
+
+// MerchantUrls builds the URLs that are sent to Zota with every deposit request
+func (c Config) MerchantUrls() zota.MerchantUrls {
+	publicUrl := strings.TrimSuffix(c.PublicUrl, "/")
+
+	return zota.MerchantUrls{
+		RedirectUrl: publicUrl + "/deposit/return",
+		CallbackUrl: publicUrl + "/zota/callback",
+		CheckoutUrl: c.CheckoutUrl,
+	}
+}
+
+// ValidateUrls makes sure that every configured URL is an absolute URL
+func (c Config) ValidateUrls() error {
+	urls := []string{c.PublicUrl, c.CheckoutUrl, c.SuccessPageUrl, c.FailurePageUrl, c.PendingPageUrl}
+
+	for _, rawUrl := range urls {
+		if rawUrl == "" {
+			continue
+		}
+
+		parsedUrl, err := url.Parse(rawUrl)
+		if err != nil {
+			return err
+		}
+
+		if !parsedUrl.IsAbs() {
+			return fmt.Errorf("Configured URL %q is not an absolute URL", rawUrl)
+		}
+	}
+
+	return nil
+}
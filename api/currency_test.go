@@ -0,0 +1,106 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+func postOrder(t *testing.T, handler http.Handler, body string) *httptest.ResponseRecorder {
+	resWriter := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/order", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to init request: %q\n", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	authorize(req)
+	handler.ServeHTTP(resWriter, req)
+
+	return resWriter
+}
+
+func TestOrderEndpointUsesCurrencyEndpoint(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	zotaApi.depositResponse = &zota.ZotaDepositResponse{
+		Code: "200",
+		Data: &zota.ZotaDepositData{
+			OrderId:    "32ab44f0a5d9d6b6c0c9e41b1a9c7ea1a2b3c4d5",
+			DepositUrl: "https://zota.com/deposit",
+		},
+	}
+	orderRepo := createOrderRepo()
+	engine := setupTestApi(t, zotaApi, orderRepo, createPayoutRepo(), createConfig())
+
+	resWriter := postOrder(t, engine, `{"description": "Test order", "amount": 13.37, "currency": "eur"}`)
+	if resWriter.Code != http.StatusFound {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusFound)
+	}
+
+	request := zotaApi.depositRequest
+	if request.OrderCurrency != "EUR" || request.OrderAmount != "13.37" {
+		t.Errorf("Deposit request amount %v %v doesn't equal expected 13.37 EUR", request.OrderAmount, request.OrderCurrency)
+	}
+
+	// The request has to be signed with the EUR endpoint
+	expectedSignature := request.GenSignature("654321", zotaApi.SecretKey())
+	if request.Signature != expectedSignature {
+		t.Errorf("Deposit request signature %q doesn't equal expected %q", request.Signature, expectedSignature)
+	}
+
+	orders, _ := orderRepo.GetAll()
+	if len(orders) != 1 || orders[0].Amount.Currency() != "EUR" || orders[0].PaymentStatus != internal.PaymentStatusSubmitted {
+		t.Errorf("Stored orders %+v don't include the submitted EUR order", orders)
+	}
+}
+
+func TestOrderEndpointRejectsUnsupportedCurrency(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	orderRepo := createOrderRepo()
+	engine := setupTestApi(t, zotaApi, orderRepo, createPayoutRepo(), createConfig())
+
+	// JPY is a valid currency, but there's no Zota endpoint for it
+	for _, currency := range []string{"JPY", "XYZ"} {
+		resWriter := postOrder(t, engine, `{"description": "Test order", "amount": 1000, "currency": "`+currency+`"}`)
+		if resWriter.Code != http.StatusBadRequest {
+			t.Errorf("Server response %d doesn't equal expected %d for %v", resWriter.Code, http.StatusBadRequest, currency)
+		}
+	}
+
+	orders, _ := orderRepo.GetAll()
+	if len(orders) != 0 || zotaApi.depositRequest != nil {
+		t.Errorf("Orders in unsupported currencies were created")
+	}
+}
+
+func TestZotaCallbackAcceptsCurrencyEndpoints(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	orderRepo := createOrderRepo()
+	order := createTestOrder()
+	orderRepo.AddOrder(order)
+
+	engine := setupTestApi(t, zotaApi, orderRepo, createPayoutRepo(), createConfig())
+
+	tests := []struct {
+		endpointId string
+		expected   int
+	}{
+		{"999999", http.StatusUnauthorized},
+		{"654321", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		callback := createSignedCallback(zotaApi, order, zota.Approved)
+		callback.EndpointId = test.endpointId
+		callback.Signature = callback.GenSignature(test.endpointId, zotaApi.SecretKey())
+
+		resWriter := postCallback(t, engine, callback)
+		if resWriter.Code != test.expected {
+			t.Errorf("Server response %d doesn't equal expected %d for endpoint %v", resWriter.Code, test.expected, test.endpointId)
+		}
+	}
+}
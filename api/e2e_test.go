@@ -0,0 +1,375 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/events"
+	"github.com/federlizer/alokin-zota-integration/internal/poller"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+	"github.com/federlizer/alokin-zota-integration/zota"
+	"github.com/federlizer/alokin-zota-integration/zota/zotasim"
+)
+
+// e2eTimeout is how long a request to the simulator can take
+const e2eTimeout = 500 * time.Millisecond
+
+// e2eEnv is the API served over HTTP with a running poller, talking to the
+// Zota simulator. Zota's callbacks and redirects reach the API the same way
+// they would in production.
+type e2eEnv struct {
+	sim       *zotasim.Server
+	simUrl    string
+	server    *httptest.Server
+	orderRepo storage.OrderRepo
+	poller    *poller.Poller
+	// client doesn't follow redirects
+	client *http.Client
+}
+
+func startE2E(t *testing.T, policy poller.RetryPolicy, options ...zotasim.Option) *e2eEnv {
+	mock := createZotaAPIMock()
+
+	sim := zotasim.New(mock.MerchantId(), mock.SecretKey(), map[string]string{"USD": mock.EndpointId()}, options...)
+	simServer := httptest.NewServer(sim)
+	t.Cleanup(simServer.Close)
+
+	zotaApi, err := zota.NewZotaAPI(mock.SecretKey(), mock.EndpointId(), mock.MerchantId(), simServer.URL, zota.WithEndpoints(map[string]string{"USD": mock.EndpointId()}), zota.WithTimeout(e2eTimeout))
+	if err != nil {
+		t.Fatalf("Failed to create Zota API: %q\n", err)
+	}
+
+	// The server has to know its own URL before the API is set up, Zota's
+	// callbacks and redirects are sent to it
+	server := httptest.NewUnstartedServer(nil)
+	config := createConfig()
+	config.PublicUrl = "http://" + server.Listener.Addr().String()
+
+	bus := events.NewBus()
+	orderRepo := events.PublishingOrderRepo(createOrderRepo(), bus)
+	payoutRepo := createPayoutRepo()
+
+	orderPoller := poller.New(zotaApi, orderRepo, payoutRepo, storage.NewMemoryPollSchedule(), policy)
+	err = orderPoller.Start()
+	if err != nil {
+		t.Fatalf("Failed to start poller: %q\n", err)
+	}
+
+	server.Config.Handler = SetupApi(Dependencies{
+		ZotaAPI:          zotaApi,
+		OrderRepo:        orderRepo,
+		PayoutRepo:       payoutRepo,
+		UserRepo:         createUserRepo(t),
+		IdempotencyStore: storage.NewMemoryIdempotencyStore(),
+		WebhookRepo:      storage.NewMemoryWebhookRepo(),
+		Bus:              bus,
+		Poller:           orderPoller,
+		Config:           config,
+	})
+	server.Start()
+	t.Cleanup(server.Close)
+	t.Cleanup(orderPoller.Stop)
+	// Stop the script before the servers are closed
+	t.Cleanup(sim.Close)
+
+	return &e2eEnv{
+		sim:       sim,
+		simUrl:    simServer.URL,
+		server:    server,
+		orderRepo: orderRepo,
+		poller:    orderPoller,
+		client: &http.Client{
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// postOrder creates an order and returns the response, which redirects the
+// customer to the deposit page if the order has been accepted by Zota
+func (e *e2eEnv) postOrder(t *testing.T) *http.Response {
+	req, err := http.NewRequest("POST", e.server.URL+"/order", strings.NewReader(`{"description": "Test order", "amount": 13.37}`))
+	if err != nil {
+		t.Fatalf("Failed to init request: %q\n", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	authorize(req)
+
+	response, err := e.client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %q\n", err)
+	}
+	response.Body.Close()
+
+	return response
+}
+
+// createOrder creates an order that Zota accepts and returns it as Zota
+// received it
+func (e *e2eEnv) createOrder(t *testing.T) zotasim.Order {
+	response := e.postOrder(t)
+	if response.StatusCode != http.StatusFound {
+		t.Fatalf("Server response %d doesn't equal expected %d", response.StatusCode, http.StatusFound)
+	}
+
+	depositUrl := response.Header.Get("Location")
+	zotaOrder, err := e.sim.Order(depositUrl[strings.LastIndex(depositUrl, "/")+1:])
+	if err != nil {
+		t.Fatalf("Customer was redirected to %q instead of the deposit page: %q\n", depositUrl, err)
+	}
+
+	return zotaOrder
+}
+
+// waitForFinalStatus waits until the order has reached a final status and
+// returns it
+func (e *e2eEnv) waitForFinalStatus(t *testing.T, id string) *internal.Order {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		order := getStoredOrder(t, e.orderRepo, id)
+		if order.PaymentStatus.IsFinal() {
+			return order
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Order %v is still %v", id, order.PaymentStatus)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitUntilUntracked waits until the poller has stopped tracking every order
+func (e *e2eEnv) waitUntilUntracked(t *testing.T) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		tracked, err := e.poller.Tracked()
+		if err != nil {
+			t.Fatalf("Failed to get tracked orders: %q\n", err)
+		}
+
+		if len(tracked) == 0 {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Poller is still tracking %+v", tracked)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func getStatusHistory(t *testing.T, orderRepo storage.OrderRepo, id string) []internal.StatusChange {
+	history, err := orderRepo.GetStatusHistory(id)
+	if err != nil {
+		t.Fatalf("Failed to get status history: %q\n", err)
+	}
+
+	return history
+}
+
+// pollOnly never gives up and checks often enough for the tests
+var pollOnly = poller.FixedPolicy{Interval: 10 * time.Millisecond, MaxAttempts: 0}
+
+// callbacksOnly doesn't check orders before the tests are over
+var callbacksOnly = poller.FixedPolicy{Interval: time.Minute, MaxAttempts: 0}
+
+func TestE2EDepositApprovedByCustomer(t *testing.T) {
+	env := startE2E(t, callbacksOnly)
+	zotaOrder := env.createOrder(t)
+
+	// The customer pays on the deposit page and is redirected back to us
+	response, err := http.Get(env.simUrl + "/deposit/" + zotaOrder.OrderId + "?status=APPROVED")
+	if err != nil {
+		t.Fatalf("Failed to open deposit page: %q\n", err)
+	}
+	defer response.Body.Close()
+
+	var returned struct {
+		OrderId       string                 `json:"orderId"`
+		PaymentStatus internal.PaymentStatus `json:"paymentStatus"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&returned)
+	if err != nil {
+		t.Fatalf("Failed to parse response: %q\n", err)
+	}
+
+	if response.StatusCode != http.StatusOK || returned.OrderId != zotaOrder.MerchantOrderId || returned.PaymentStatus != internal.PaymentStatusApproved {
+		t.Errorf("Customer returned to %d %+v instead of the approved order", response.StatusCode, returned)
+	}
+
+	zotaOrder, _ = env.sim.Order(zotaOrder.OrderId)
+	order := getStoredOrder(t, env.orderRepo, zotaOrder.MerchantOrderId)
+	if order.PaymentStatus != internal.PaymentStatusApproved || order.ProcessorTransactionId != zotaOrder.ProcessorTransactionId {
+		t.Errorf("Order %+v hasn't been approved with transaction %v", *order, zotaOrder.ProcessorTransactionId)
+	}
+
+	// The callback is sent before the customer is redirected
+	history := getStatusHistory(t, env.orderRepo, order.Id.String())
+	if len(history) != 3 || history[2].Source != internal.StatusSourceCallback {
+		t.Errorf("Status history %+v doesn't end with the approving callback", history)
+	}
+}
+
+func TestE2EDepositApprovedByPolling(t *testing.T) {
+	env := startE2E(t, pollOnly, zotasim.WithoutCallbacks(), zotasim.WithScript(
+		zotasim.Step{Status: zota.Processing},
+		zotasim.Step{Status: zota.Approved, After: 50 * time.Millisecond},
+	))
+	zotaOrder := env.createOrder(t)
+
+	order := env.waitForFinalStatus(t, zotaOrder.MerchantOrderId)
+	if order.PaymentStatus != internal.PaymentStatusApproved || order.ZotaOrderId != zotaOrder.OrderId || order.ProcessorTransactionId == "" {
+		t.Errorf("Order %+v hasn't been approved", *order)
+	}
+
+	history := getStatusHistory(t, env.orderRepo, order.Id.String())
+	last := history[len(history)-1]
+	if last.Status != internal.PaymentStatusApproved || last.Source != internal.StatusSourcePoll {
+		t.Errorf("Last status change %+v wasn't polled", last)
+	}
+
+	env.waitUntilUntracked(t)
+}
+
+func TestE2EDepositFails(t *testing.T) {
+	tests := []struct {
+		status       zota.OrderStatus
+		errorMessage string
+		expected     internal.PaymentStatus
+	}{
+		{zota.Declined, "Insufficient funds", internal.PaymentStatusDeclined},
+		{zota.Filtered, "Suspected fraud", internal.PaymentStatusFiltered},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.status), func(t *testing.T) {
+			env := startE2E(t, callbacksOnly, zotasim.WithScript(
+				zotasim.Step{Status: zota.Processing, After: 50 * time.Millisecond},
+				zotasim.Step{Status: test.status, ErrorMessage: test.errorMessage, After: 50 * time.Millisecond},
+			))
+			zotaOrder := env.createOrder(t)
+
+			order := env.waitForFinalStatus(t, zotaOrder.MerchantOrderId)
+			if order.PaymentStatus != test.expected {
+				t.Errorf("Order status %v doesn't equal expected %v", order.PaymentStatus, test.expected)
+			}
+
+			history := getStatusHistory(t, env.orderRepo, order.Id.String())
+			last := history[len(history)-1]
+			if last.ErrorMessage != test.errorMessage || last.Source != internal.StatusSourceCallback {
+				t.Errorf("Last status change %+v doesn't have Zota's error message %q", last, test.errorMessage)
+			}
+		})
+	}
+}
+
+func TestE2EZotaTimeout(t *testing.T) {
+	env := startE2E(t, pollOnly, zotasim.WithoutCallbacks(), zotasim.WithScript(zotasim.Step{Status: zota.Approved}))
+
+	// Zota might have accepted an order it didn't respond to in time, so
+	// the order isn't failed, but it isn't tracked either
+	env.sim.InjectFault(zotasim.EndpointDeposit, zotasim.Fault{Delay: 2 * e2eTimeout, Times: 1})
+	response := env.postOrder(t)
+	if response.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("Server response %d doesn't equal expected %d", response.StatusCode, http.StatusGatewayTimeout)
+	}
+
+	orders, _ := env.orderRepo.GetAll()
+	if len(orders) != 1 || orders[0].PaymentStatus != internal.PaymentStatusCreated {
+		t.Errorf("Orders %+v don't contain the created order", orders)
+	}
+
+	tracked, _ := env.poller.Tracked()
+	if len(tracked) != 0 {
+		t.Errorf("Poller is tracking %+v", tracked)
+	}
+
+	// Order status requests that time out are retried
+	env.sim.InjectFault(zotasim.EndpointOrderStatus, zotasim.Fault{Delay: 2 * e2eTimeout, Times: 2})
+	zotaOrder := env.createOrder(t)
+
+	order := env.waitForFinalStatus(t, zotaOrder.MerchantOrderId)
+	if order.PaymentStatus != internal.PaymentStatusApproved {
+		t.Errorf("Order status %v doesn't equal expected %v", order.PaymentStatus, internal.PaymentStatusApproved)
+	}
+}
+
+func TestE2EMaxPollAttempts(t *testing.T) {
+	// The customer never finishes the deposit
+	env := startE2E(t, poller.FixedPolicy{Interval: 10 * time.Millisecond, MaxAttempts: 3}, zotasim.WithoutCallbacks())
+	zotaOrder := env.createOrder(t)
+
+	order := env.waitForFinalStatus(t, zotaOrder.MerchantOrderId)
+	if order.PaymentStatus != internal.PaymentStatusExpired {
+		t.Errorf("Order status %v doesn't equal expected %v", order.PaymentStatus, internal.PaymentStatusExpired)
+	}
+
+	history := getStatusHistory(t, env.orderRepo, order.Id.String())
+	last := history[len(history)-1]
+	if last.ErrorMessage != "No final status after 3 checks" || last.Source != internal.StatusSourcePoll {
+		t.Errorf("Last status change %+v doesn't say why the order expired", last)
+	}
+
+	env.waitUntilUntracked(t)
+}
+
+func TestE2ECallbackBeforePoll(t *testing.T) {
+	// The first check happens long after the callback has arrived
+	env := startE2E(t, poller.FixedPolicy{Interval: 500 * time.Millisecond, MaxAttempts: 0}, zotasim.WithScript(
+		zotasim.Step{Status: zota.Approved, After: 50 * time.Millisecond},
+	))
+	zotaOrder := env.createOrder(t)
+
+	order := env.waitForFinalStatus(t, zotaOrder.MerchantOrderId)
+	if order.PaymentStatus != internal.PaymentStatusApproved {
+		t.Errorf("Order status %v doesn't equal expected %v", order.PaymentStatus, internal.PaymentStatusApproved)
+	}
+
+	// The poller stops tracking the order without changing it again
+	env.waitUntilUntracked(t)
+
+	history := getStatusHistory(t, env.orderRepo, order.Id.String())
+	if len(history) != 3 || history[2].Source != internal.StatusSourceCallback {
+		t.Errorf("Status history %+v doesn't end with the approving callback", history)
+	}
+}
+
+func TestE2EDuplicateCallbacks(t *testing.T) {
+	env := startE2E(t, callbacksOnly, zotasim.WithScript(
+		zotasim.Step{Status: zota.Approved, After: 50 * time.Millisecond},
+	))
+	zotaOrder := env.createOrder(t)
+	env.waitForFinalStatus(t, zotaOrder.MerchantOrderId)
+
+	// Replayed callbacks are acknowledged, so Zota stops sending them
+	for i := 0; i < 2; i++ {
+		err := env.sim.ResendCallback(zotaOrder.OrderId)
+		if err != nil {
+			t.Errorf("Failed to resend callback: %q\n", err)
+		}
+	}
+
+	// So are callbacks of a different status once the order is final
+	err := env.sim.SetStatus(zotaOrder.OrderId, zota.Declined, "Insufficient funds")
+	if err != nil {
+		t.Errorf("Failed to send callback: %q\n", err)
+	}
+
+	order := getStoredOrder(t, env.orderRepo, zotaOrder.MerchantOrderId)
+	if order.PaymentStatus != internal.PaymentStatusApproved {
+		t.Errorf("Order status %v doesn't equal expected %v", order.PaymentStatus, internal.PaymentStatusApproved)
+	}
+
+	history := getStatusHistory(t, env.orderRepo, order.Id.String())
+	if len(history) != 3 {
+		t.Errorf("Order has %d status changes instead of %d: %+v", len(history), 3, history)
+	}
+}
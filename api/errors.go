@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+// zotaErrorResponse responds to a request that couldn't be completed because
+// a request to Zota failed. The status tells the client whether the request
+// itself was at fault, Zota is unavailable or we are misconfigured.
+func zotaErrorResponse(c *gin.Context, err error) {
+	slog.WarnContext(c.Request.Context(), "Request to Zota failed", "error", err)
+
+	var apiErr *zota.APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(apiErr.RetryAfter.Seconds()))))
+	}
+
+	status, message := zotaErrorStatus(err)
+	c.JSON(status, gin.H{
+		"message": message,
+	})
+}
+
+// zotaErrorStatus returns the HTTP status and message to respond with when a
+// request to Zota has failed with err
+func zotaErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, zota.ErrInvalidRequest):
+		// Zota's message tells the client what's wrong with their data
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, zota.ErrRateLimited):
+		return http.StatusServiceUnavailable, "Payment provider is busy, try again later"
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "Payment provider didn't respond in time"
+	default:
+		// Signature and credential errors, Zota's server errors, malformed
+		// responses and network errors aren't the client's fault
+		return http.StatusBadGateway, "Payment provider request failed"
+	}
+}
+
+// zotaMightHaveAccepted reports whether Zota might have accepted a request
+// that failed with err, e.g. because it timed out, Zota ran into an error
+// after accepting it or its response couldn't be understood
+func zotaMightHaveAccepted(err error) bool {
+	var apiErr *zota.APIError
+	if errors.As(err, &apiErr) {
+		return errors.Is(apiErr, zota.ErrServer)
+	}
+
+	return true
+}
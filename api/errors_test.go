@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+func TestOrderEndpointMapsZotaErrors(t *testing.T) {
+	// Orders Zota has rejected end in an error, the others might have been
+	// accepted after all
+	tests := []struct {
+		err      error
+		expected int
+		status   internal.PaymentStatus
+	}{
+		{&zota.APIError{HTTPStatus: 400, Code: "400", Message: "Invalid amount"}, http.StatusBadRequest, internal.PaymentStatusError},
+		{&zota.APIError{HTTPStatus: 401, Code: "401", Message: "Invalid signature"}, http.StatusBadGateway, internal.PaymentStatusError},
+		{&zota.APIError{HTTPStatus: 429, RetryAfter: 30 * time.Second}, http.StatusServiceUnavailable, internal.PaymentStatusCreated},
+		{&zota.APIError{HTTPStatus: 500, Code: "500"}, http.StatusBadGateway, internal.PaymentStatusCreated},
+		{fmt.Errorf("%w: unexpected end of JSON input", zota.ErrMalformedResponse), http.StatusBadGateway, internal.PaymentStatusCreated},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout, internal.PaymentStatusCreated},
+		// The mock responds without any data
+		{nil, http.StatusBadGateway, internal.PaymentStatusCreated},
+	}
+
+	for _, test := range tests {
+		zotaApi := createZotaAPIMock()
+		zotaApi.depositErr = test.err
+		orderRepo := createOrderRepo()
+		engine := setupTestApi(t, zotaApi, orderRepo, createPayoutRepo(), createConfig())
+
+		resWriter := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/order", strings.NewReader(`{"description": "Test order", "amount": 13.37}`))
+		if err != nil {
+			t.Fatalf("Failed to init request: %q\n", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		authorize(req)
+		engine.ServeHTTP(resWriter, req)
+
+		if resWriter.Code != test.expected {
+			t.Errorf("Server response %d doesn't equal expected %d for %v", resWriter.Code, test.expected, test.err)
+		}
+
+		orders, _ := orderRepo.GetAll()
+		if len(orders) != 1 || orders[0].PaymentStatus != test.status {
+			t.Errorf("Orders %+v don't contain an order with status %v for %v", orders, test.status, test.err)
+		}
+	}
+}
+
+func TestPayoutEndpointMapsZotaErrors(t *testing.T) {
+	// Payouts Zota has rejected end in an error, the others might have been
+	// accepted after all, so retrying them with the same key mustn't pay out
+	// again unless Zota certainly hasn't accepted them
+	tests := []struct {
+		err      error
+		expected int
+		status   internal.PaymentStatus
+		payouts  int
+	}{
+		{&zota.APIError{HTTPStatus: 400, Code: "400", Message: "Invalid amount"}, http.StatusBadRequest, internal.PaymentStatusError, 1},
+		{&zota.APIError{HTTPStatus: 401, Code: "401", Message: "Invalid signature"}, http.StatusBadGateway, internal.PaymentStatusError, 2},
+		{&zota.APIError{HTTPStatus: 429, RetryAfter: 30 * time.Second}, http.StatusServiceUnavailable, internal.PaymentStatusPending, 2},
+		{&zota.APIError{HTTPStatus: 500, Code: "500"}, http.StatusBadGateway, internal.PaymentStatusPending, 1},
+		{fmt.Errorf("%w: unexpected end of JSON input", zota.ErrMalformedResponse), http.StatusBadGateway, internal.PaymentStatusPending, 1},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout, internal.PaymentStatusPending, 1},
+		{context.Canceled, http.StatusBadGateway, internal.PaymentStatusPending, 1},
+		// The mock responds without any data
+		{nil, http.StatusBadGateway, internal.PaymentStatusPending, 1},
+	}
+
+	for _, test := range tests {
+		zotaApi := createZotaAPIMock()
+		zotaApi.payoutErr = test.err
+		payoutRepo := createPayoutRepo()
+		engine := setupTestApi(t, zotaApi, createOrderRepo(), payoutRepo, createConfig())
+
+		for i := 0; i < 2; i++ {
+			resWriter := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/admin/payout", strings.NewReader(payoutRequestBody))
+			if err != nil {
+				t.Fatalf("Failed to init request: %q\n", err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Idempotency-Key", "payout-1")
+			req.Header.Set("Authorization", "Bearer "+testAdminToken)
+
+			engine.ServeHTTP(resWriter, req)
+
+			if resWriter.Code != test.expected {
+				t.Errorf("Server response %d doesn't equal expected %d for %v", resWriter.Code, test.expected, test.err)
+			}
+		}
+
+		payouts, _ := payoutRepo.GetAll()
+		if len(payouts) != test.payouts {
+			t.Errorf("Number of payouts %d doesn't equal expected %d for %v", len(payouts), test.payouts, test.err)
+		}
+
+		for _, payout := range payouts {
+			if payout.PaymentStatus != test.status {
+				t.Errorf("Payout status %q doesn't equal expected %q for %v", payout.PaymentStatus, test.status, test.err)
+			}
+		}
+	}
+}
+
+func TestZotaErrorResponseSetsRetryAfter(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	zotaApi.payoutErr = &zota.APIError{HTTPStatus: 429, RetryAfter: 1500 * time.Millisecond}
+	engine := setupTestApi(t, zotaApi, createOrderRepo(), createPayoutRepo(), createConfig())
+
+	resWriter := postPayout(t, engine, `{
+		"userId": "6b1f3e0c-8f2a-4d5b-9c7e-1a2b3c4d5e6f",
+		"description": "Test payout",
+		"amount": 13.37,
+		"bankAccount": {"accountNumber": "DK5000400440116243", "accountName": "Nikola Velichkov", "countryCode": "DK"}
+	}`)
+
+	if resWriter.Code != http.StatusServiceUnavailable {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusServiceUnavailable)
+	}
+
+	if resWriter.Header().Get("Retry-After") != "2" {
+		t.Errorf("Retry-After header %q doesn't equal expected %q", resWriter.Header().Get("Retry-After"), "2")
+	}
+}
+
+func TestOrderEndpointRejectsInexactAmounts(t *testing.T) {
+	amounts := []string{`13.371`, `-13.37`, `0`, `1e3`, `"thirteen"`}
+
+	for _, amount := range amounts {
+		engine := setupTestApi(t, createZotaAPIMock(), createOrderRepo(), createPayoutRepo(), createConfig())
+
+		resWriter := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/order", strings.NewReader(`{"description": "Test order", "amount": `+amount+`}`))
+		if err != nil {
+			t.Fatalf("Failed to init request: %q\n", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		authorize(req)
+		engine.ServeHTTP(resWriter, req)
+
+		if resWriter.Code != http.StatusBadRequest {
+			t.Errorf("Server response %d doesn't equal expected %d for amount %s", resWriter.Code, http.StatusBadRequest, amount)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/federlizer/alokin-zota-integration/internal/events"
+)
+
+const (
+	// eventsKeepAlive is how often a comment is sent on idle event streams,
+	// so that proxies don't close the connection
+	eventsKeepAlive = 15 * time.Second
+	// eventsTokenTTL is how long an event stream token can be used to open
+	// the stream for
+	eventsTokenTTL = time.Minute
+)
+
+// newEventsTokenKey returns a random key to sign event stream tokens with.
+// Tokens are only valid for a minute, so they don't need to survive a
+// restart.
+func newEventsTokenKey() []byte {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		panic(err)
+	}
+
+	return key
+}
+
+// signEventsToken returns the signature of the event stream token of the
+// order for the user, which expires at the given Unix time
+func signEventsToken(key []byte, userId, orderId string, expiresAt int64) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s.%s.%d", userId, orderId, expiresAt)
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newEventsToken returns a token that lets the user open the event stream of
+// the order until expiresAt
+func newEventsToken(key []byte, userId, orderId string, expiresAt time.Time) string {
+	return fmt.Sprintf("%s.%d.%s", userId, expiresAt.Unix(), signEventsToken(key, userId, orderId, expiresAt.Unix()))
+}
+
+// verifyEventsToken returns the ID of the user the event stream token of the
+// order was made for, if it's valid and hasn't expired at the given time
+func verifyEventsToken(key []byte, token, orderId string, now time.Time) (string, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() >= expiresAt {
+		return "", false
+	}
+
+	expected := signEventsToken(key, parts[0], orderId, expiresAt)
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return "", false
+	}
+
+	return parts[0], true
+}
+
+// eventsTokenHandler returns a short-lived token for the event stream of one
+// of the user's orders. Browsers' EventSource can't send an Authorization
+// header, so the token is sent in the stream's token query parameter instead.
+func eventsTokenHandler(c *gin.Context) {
+	deps := dependencies(c)
+
+	order, ok := getOwnOrder(c, deps.OrderRepo)
+	if !ok {
+		return
+	}
+
+	expiresAt := time.Now().Add(eventsTokenTTL)
+	c.JSON(http.StatusOK, gin.H{
+		"token":     newEventsToken(deps.eventsTokenKey, authenticatedUser(c).Id.String(), order.Id.String(), expiresAt),
+		"expiresAt": expiresAt,
+	})
+}
+
+// eventsAuthRequired authenticates the request for an order's event stream
+// with the token query parameter if there's one, and like authRequired
+// otherwise. The token is only checked when the stream is opened.
+func eventsAuthRequired(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		authRequired(c)
+		return
+	}
+
+	deps := dependencies(c)
+
+	userId, ok := verifyEventsToken(deps.eventsTokenKey, token, c.Param("id"), time.Now())
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid or expired token",
+		})
+		return
+	}
+
+	user, err := deps.UserRepo.GetUser(userId)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't get user of event stream token", "userId", userId, "error", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid or expired token",
+		})
+		return
+	}
+
+	c.Set("user", user)
+}
+
+// orderEventsHandler streams the payment status changes of one of the user's
+// orders as server-sent events. The order's current status is sent first, and
+// the stream ends once the order has reached a final status.
+func orderEventsHandler(c *gin.Context) {
+	deps := dependencies(c)
+
+	// Subscribe before getting the order, so that no change can be missed
+	// in between
+	subscription := deps.Bus.Subscribe(c.Param("id"))
+	defer subscription.Close()
+
+	order, ok := getOwnOrder(c, deps.OrderRepo)
+	if !ok {
+		return
+	}
+
+	current := events.OrderEvent{
+		OrderId:   order.Id.String(),
+		From:      order.PaymentStatus,
+		Status:    order.PaymentStatus,
+		ChangedAt: order.UpdatedAt,
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.SSEvent("status", current)
+	if order.PaymentStatus.IsFinal() {
+		return
+	}
+	c.Writer.Flush()
+
+	streamEvents(c, subscription, true)
+}
+
+// allOrderEventsHandler streams the payment status changes of every order as
+// server-sent events
+func allOrderEventsHandler(c *gin.Context) {
+	bus := dependencies(c).Bus
+
+	subscription := bus.Subscribe("")
+	defer subscription.Close()
+
+	// Send the headers right away, the first event can take a while
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Writer.WriteHeaderNow()
+	c.Writer.Flush()
+
+	streamEvents(c, subscription, false)
+}
+
+// streamEvents sends the subscription's events until the client goes away,
+// the subscription is dropped or, if untilFinal is set, a final status has
+// been sent
+func streamEvents(c *gin.Context, subscription *events.Subscription, untilFinal bool) {
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-subscription.Events():
+			if !ok {
+				return false
+			}
+
+			c.SSEvent("status", event)
+			return !untilFinal || !event.Status.IsFinal()
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
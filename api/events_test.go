@@ -0,0 +1,289 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/events"
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+// openEventStream starts reading a server-sent event stream. The response
+// body is closed when the test ends.
+func openEventStream(t *testing.T, server *httptest.Server, target, token string) *bufio.Reader {
+	req, err := http.NewRequest("GET", server.URL+target, nil)
+	if err != nil {
+		t.Fatalf("Failed to init request: %q\n", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %q\n", err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Server response %d doesn't equal expected %d", res.StatusCode, http.StatusOK)
+	}
+
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("Content type %q isn't an event stream", res.Header.Get("Content-Type"))
+	}
+
+	return bufio.NewReader(res.Body)
+}
+
+// readEvent reads the next status event from the stream, skipping comments
+func readEvent(t *testing.T, stream *bufio.Reader) events.OrderEvent {
+	var name, data string
+
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event: %q\n", err)
+		}
+		line = strings.TrimRight(line, "\n")
+
+		if line == "" && data != "" {
+			break
+		}
+
+		if value, found := strings.CutPrefix(line, "event:"); found {
+			name = value
+		}
+		if value, found := strings.CutPrefix(line, "data:"); found {
+			data = value
+		}
+	}
+
+	if name != "status" {
+		t.Fatalf("Event %q doesn't equal expected %q", name, "status")
+	}
+
+	var event events.OrderEvent
+	err := json.Unmarshal([]byte(data), &event)
+	if err != nil {
+		t.Fatalf("Failed to parse event: %q\n", err)
+	}
+
+	return event
+}
+
+func TestOrderEventsStreamsStatusChanges(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	orderRepo := createOrderRepo()
+	userRepo := createUserRepo(t)
+	engine := setupTestApiWithBus(zotaApi, orderRepo, createPayoutRepo(), userRepo, events.NewBus(), createConfig())
+
+	// Closed after the event stream, see openEventStream
+	server := httptest.NewServer(engine)
+	t.Cleanup(server.Close)
+
+	order := addUserOrder(t, orderRepo, userRepo)
+	stream := openEventStream(t, server, "/order/"+order.Id.String()+"/events", testToken)
+
+	event := readEvent(t, stream)
+	if event.OrderId != order.Id.String() || event.Status != internal.PaymentStatusSubmitted {
+		t.Errorf("Initial event %+v doesn't contain the current status", event)
+	}
+
+	resWriter := postCallback(t, engine, createSignedCallback(zotaApi, order, zota.Approved))
+	if resWriter.Code != http.StatusOK {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusOK)
+	}
+
+	event = readEvent(t, stream)
+	if event.From != internal.PaymentStatusSubmitted || event.Status != internal.PaymentStatusApproved || event.Source != internal.StatusSourceCallback {
+		t.Errorf("Event %+v doesn't contain the status change", event)
+	}
+
+	// The stream ends with the final status
+	_, err := stream.ReadString('\n')
+	if err == nil {
+		t.Errorf("Event stream is still open after the final status")
+	}
+}
+
+func TestOrderEventsOfFinalOrder(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	orderRepo := createOrderRepo()
+	userRepo := createUserRepo(t)
+	engine := setupTestApiWithUsers(zotaApi, orderRepo, createPayoutRepo(), userRepo, createConfig())
+
+	order := addUserOrder(t, orderRepo, userRepo)
+	orderRepo.UpdateStatus(order.Id.String(), internal.PaymentStatusSubmitted, internal.StatusChange{Status: internal.PaymentStatusDeclined})
+
+	resWriter := sendRequest(t, engine, "GET", "/order/"+order.Id.String()+"/events", testToken, "")
+	if resWriter.Code != http.StatusOK {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusOK)
+	}
+
+	event := readEvent(t, bufio.NewReader(resWriter.Body))
+	if event.Status != internal.PaymentStatusDeclined {
+		t.Errorf("Event status %q doesn't equal expected %q", event.Status, internal.PaymentStatusDeclined)
+	}
+}
+
+func TestOrderEventsWithToken(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	orderRepo := createOrderRepo()
+	userRepo := createUserRepo(t)
+	engine := setupTestApiWithUsers(zotaApi, orderRepo, createPayoutRepo(), userRepo, createConfig())
+
+	order := addUserOrder(t, orderRepo, userRepo)
+	other := addUserOrder(t, orderRepo, userRepo)
+	orderRepo.UpdateStatus(order.Id.String(), internal.PaymentStatusSubmitted, internal.StatusChange{Status: internal.PaymentStatusApproved})
+
+	resWriter := sendRequest(t, engine, "POST", "/order/"+order.Id.String()+"/events/token", testToken, "")
+	if resWriter.Code != http.StatusOK {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusOK)
+	}
+
+	var response struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+	err := json.Unmarshal(resWriter.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("Failed to parse response: %q\n", err)
+	}
+
+	if time.Until(response.ExpiresAt) > eventsTokenTTL {
+		t.Errorf("Token expires at %v, later than in %v", response.ExpiresAt, eventsTokenTTL)
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		expected int
+	}{
+		// Like a browser's EventSource, without an Authorization header
+		{"token", "/order/" + order.Id.String() + "/events?token=" + url.QueryEscape(response.Token), http.StatusOK},
+		{"other order", "/order/" + other.Id.String() + "/events?token=" + url.QueryEscape(response.Token), http.StatusUnauthorized},
+		{"tampered token", "/order/" + order.Id.String() + "/events?token=" + url.QueryEscape(response.Token+"x"), http.StatusUnauthorized},
+		{"session token", "/order/" + order.Id.String() + "/events?token=" + testToken, http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resWriter := sendRequest(t, engine, "GET", test.target, "", "")
+			if resWriter.Code != test.expected {
+				t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, test.expected)
+			}
+
+			if test.expected == http.StatusOK {
+				event := readEvent(t, bufio.NewReader(resWriter.Body))
+				if event.Status != internal.PaymentStatusApproved {
+					t.Errorf("Event status %q doesn't equal expected %q", event.Status, internal.PaymentStatusApproved)
+				}
+			}
+		})
+	}
+
+	// Only the user's own orders get tokens
+	foreign := createTestOrder()
+	orderRepo.AddOrder(foreign)
+
+	resWriter = sendRequest(t, engine, "POST", "/order/"+foreign.Id.String()+"/events/token", testToken, "")
+	if resWriter.Code != http.StatusNotFound {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusNotFound)
+	}
+}
+
+func TestEventsTokenExpires(t *testing.T) {
+	key := newEventsTokenKey()
+	now := time.Now()
+	token := newEventsToken(key, testUserId, "e31edd0d-76a6-4f1c-be19-4504ff5b89d7", now.Add(eventsTokenTTL))
+
+	userId, ok := verifyEventsToken(key, token, "e31edd0d-76a6-4f1c-be19-4504ff5b89d7", now)
+	if !ok || userId != testUserId {
+		t.Errorf("Output (%q, %v) doesn't equal expected (%q, %v)", userId, ok, testUserId, true)
+	}
+
+	_, ok = verifyEventsToken(key, token, "e31edd0d-76a6-4f1c-be19-4504ff5b89d7", now.Add(eventsTokenTTL))
+	if ok {
+		t.Errorf("Token is still valid after it has expired")
+	}
+
+	_, ok = verifyEventsToken(newEventsTokenKey(), token, "e31edd0d-76a6-4f1c-be19-4504ff5b89d7", now)
+	if ok {
+		t.Errorf("Token is valid with another key")
+	}
+}
+
+func TestOrderEventsOfOtherUser(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	orderRepo := createOrderRepo()
+	engine := setupTestApi(t, zotaApi, orderRepo, createPayoutRepo(), createConfig())
+
+	order := createTestOrder()
+	orderRepo.AddOrder(order)
+
+	resWriter := sendRequest(t, engine, "GET", "/order/"+order.Id.String()+"/events", testToken, "")
+	if resWriter.Code != http.StatusNotFound {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusNotFound)
+	}
+}
+
+func TestAllOrderEventsRequiresAdminToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		adminToken string
+		token      string
+		expected   int
+	}{
+		{"no admin token configured", "", "", http.StatusUnauthorized},
+		{"missing token", testAdminToken, "", http.StatusUnauthorized},
+		{"unknown token", testAdminToken, "unknown-token", http.StatusUnauthorized},
+		// Users are authenticated, but not allowed in
+		{"user token", testAdminToken, testToken, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := createConfig()
+			config.AdminToken = test.adminToken
+			engine := setupTestApi(t, createZotaAPIMock(), createOrderRepo(), createPayoutRepo(), config)
+
+			resWriter := sendRequest(t, engine, "GET", "/admin/order/events", test.token, "")
+			if resWriter.Code != test.expected {
+				t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, test.expected)
+			}
+		})
+	}
+}
+
+func TestAllOrderEventsStreamsEveryOrder(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	orderRepo := createOrderRepo()
+	config := createConfig()
+	config.AdminToken = testAdminToken
+	engine := setupTestApiWithBus(zotaApi, orderRepo, createPayoutRepo(), createUserRepo(t), events.NewBus(), config)
+
+	// Closed after the event stream, see openEventStream
+	server := httptest.NewServer(engine)
+	t.Cleanup(server.Close)
+
+	stream := openEventStream(t, server, "/admin/order/events", testAdminToken)
+
+	order := createTestOrder()
+	orderRepo.AddOrder(order)
+
+	resWriter := postCallback(t, engine, createSignedCallback(zotaApi, order, zota.Declined))
+	if resWriter.Code != http.StatusOK {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusOK)
+	}
+
+	event := readEvent(t, stream)
+	if event.OrderId != order.Id.String() || event.Status != internal.PaymentStatusDeclined {
+		t.Errorf("Event %+v doesn't contain the status change", event)
+	}
+}
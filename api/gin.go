@@ -2,24 +2,72 @@ package api
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 
 	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/events"
+	"github.com/federlizer/alokin-zota-integration/internal/metrics"
+	"github.com/federlizer/alokin-zota-integration/internal/poller"
 	"github.com/federlizer/alokin-zota-integration/internal/storage"
+	"github.com/federlizer/alokin-zota-integration/internal/tracing"
 	"github.com/federlizer/alokin-zota-integration/zota"
 )
 
-func SetupApi(zotaApi zota.IZotaAPI, orderRepo storage.OrderRepo) *gin.Engine {
-	engine := gin.Default()
+// Dependencies are the services the API's handlers use. Metrics is
+// optional, every other field is required.
+type Dependencies struct {
+	ZotaAPI          zota.IZotaAPI
+	OrderRepo        storage.OrderRepo
+	PayoutRepo       storage.PayoutRepo
+	UserRepo         storage.UserRepo
+	IdempotencyStore storage.IdempotencyStore
+	WebhookRepo      storage.WebhookRepo
+	Bus              *events.Bus
+	Poller           *poller.Poller
+	// Metrics records the requests and orders, nil if they aren't recorded
+	Metrics *metrics.Metrics
+	Config  Config
+
+	// eventsTokenKey signs the tokens of order event streams, it's generated
+	// by SetupApi
+	eventsTokenKey []byte
+}
+
+// dependenciesKey is the key the request's Dependencies are set with
+const dependenciesKey = "dependencies"
+
+// dependencies returns the Dependencies of the request, whose repositories
+// are traced as part of the request
+func dependencies(c *gin.Context) Dependencies {
+	return c.MustGet(dependenciesKey).(Dependencies)
+}
+
+func SetupApi(deps Dependencies) *gin.Engine {
+	deps.eventsTokenKey = newEventsTokenKey()
 
-	// Don't trust any proxies:
+	engine := gin.New()
+	// otelgin records every request as a span, continuing the trace of the
+	// client's traceparent header if it sent one
+	engine.Use(requestId, accessLog, instrument(deps.Metrics), otelgin.Middleware(tracing.ServiceName), describeSpan, gin.Recovery())
+
+	// Only trust the configured proxies, if any:
 	// [GIN-debug] [WARNING] You trusted all proxies, this is NOT safe. We recommend you to set a value.
 	// Please check https://pkg.go.dev/github.com/gin-gonic/gin#readme-don-t-trust-all-proxies for details.
-	engine.SetTrustedProxies(nil)
+	err := engine.SetTrustedProxies(deps.Config.TrustedProxies)
+	if err != nil {
+		slog.Error("Couldn't set trusted proxies", "proxies", deps.Config.TrustedProxies, "error", err)
+		engine.SetTrustedProxies(nil)
+	}
 
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowAllOrigins = true
@@ -27,32 +75,144 @@ func SetupApi(zotaApi zota.IZotaAPI, orderRepo storage.OrderRepo) *gin.Engine {
 	engine.Use(cors.New(corsConfig))
 
 	engine.Use(func(c *gin.Context) {
-		c.Set("zotaApi", zotaApi)
-		c.Set("orderRepo", orderRepo)
+		// Repository calls are traced as part of the request
+		ctx := c.Request.Context()
+
+		requestDeps := deps
+		requestDeps.OrderRepo = tracing.OrderRepo(ctx, deps.OrderRepo)
+		requestDeps.PayoutRepo = tracing.PayoutRepo(ctx, deps.PayoutRepo)
+		requestDeps.UserRepo = tracing.UserRepo(ctx, deps.UserRepo)
+		c.Set(dependenciesKey, requestDeps)
 	})
 
 	engine.GET("/ping", pingHandler)
+	engine.GET("/healthz", healthzHandler)
+	engine.GET("/readyz", readyzHandler)
+	engine.GET("/poller", pollerHandler)
+	if deps.Metrics != nil {
+		engine.GET("/metrics", gin.WrapH(deps.Metrics.Handler()))
+	}
+
+	engine.POST("/auth/register", registerHandler)
+	engine.POST("/auth/login", loginHandler)
+
+	// Everything a user does with their money requires them to be logged in
+	authorized := engine.Group("/", authRequired)
+
+	authorized.POST("/auth/logout", logoutHandler)
+	authorized.GET("/auth/me", meHandler)
+
+	authorized.GET("/order", getOrdersHandler)
+	authorized.GET("/order/:id", getOrderHandler)
+	authorized.POST("/order/:id/events/token", eventsTokenHandler)
+	authorized.POST("/order", idempotent, orderHandler)
+
+	authorized.GET("/payout/:id", getPayoutHandler)
+
+	// Browsers can't send the Authorization header with an event stream
+	engine.GET("/order/:id/events", eventsAuthRequired, orderEventsHandler)
+
+	admin := engine.Group("/admin", adminRequired)
+
+	admin.GET("/order/events", allOrderEventsHandler)
+
+	// Nothing checks that a payout is covered by the user's deposits, so only
+	// the admin can make them
+	admin.POST("/payout", idempotent, payoutHandler)
+
+	admin.GET("/webhooks", listWebhooksHandler)
+	admin.POST("/webhooks", createWebhookHandler)
+	admin.GET("/webhooks/:id/deliveries", getWebhookDeliveriesHandler)
+	admin.POST("/webhooks/:id/replay", replayWebhookHandler)
+	admin.POST("/webhooks/:id/disable", disableWebhookHandler)
 
-	engine.GET("/order", getOrdersHandler)
-	engine.POST("/order", orderHandler)
+	engine.GET("/deposit/return", depositReturnHandler)
+	engine.POST("/zota/callback", zotaCallbackHandler)
 
 	return engine
 }
 
+// defaultCurrency is the currency of orders that don't specify one and of
+// every payout
+const defaultCurrency = "USD"
+
+// parseAmount parses the amount sent by the client, which needs to be a
+// positive, non-zero decimal in the currency
+func parseAmount(amount json.Number, currency string) (internal.Money, error) {
+	money, err := internal.ParseMoney(amount.String(), currency)
+	if err != nil {
+		return internal.Money{}, err
+	}
+
+	if !money.IsPositive() {
+		return internal.Money{}, errors.New("amount needs to be a positive number")
+	}
+
+	return money, nil
+}
+
 func pingHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"message": "pong",
 	})
 }
 
+// pollerHandler reports which orders and payouts the poller is tracking
+// and when each of them was last checked
+func pollerHandler(c *gin.Context) {
+	orderPoller := dependencies(c).Poller
+
+	entries, err := orderPoller.Tracked()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't get tracked orders from the poller", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to get tracked orders",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"running":  orderPoller.Running(),
+		"tracking": len(entries),
+		"orders":   entries,
+	})
+}
+
+// getOrdersHandler returns a page of the user's orders, see parseOrderQuery
+// for the supported filters
 func getOrdersHandler(c *gin.Context) {
 	type response struct {
-		Orders []*internal.Order `json:"orders"`
+		Orders     []*internal.Order `json:"orders"`
+		NextCursor string            `json:"nextCursor,omitempty"`
 	}
 
-	orderRepo := c.MustGet("orderRepo").(storage.OrderRepo)
-	orders := orderRepo.GetAll()
-	resp := response{Orders: orders}
+	orderRepo := dependencies(c).OrderRepo
+
+	query, err := parseOrderQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	query.UserId = authenticatedUser(c).Id.String()
+
+	page, err := orderRepo.QueryOrders(query)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't get orders from order repo", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to get orders",
+		})
+		return
+	}
+
+	resp := response{Orders: page.Orders, NextCursor: page.NextCursor}
 
 	data, err := json.Marshal(resp)
 	if err != nil {
@@ -62,63 +222,223 @@ func getOrdersHandler(c *gin.Context) {
 	c.Data(http.StatusOK, "application/json", data)
 }
 
+// parseOrderQuery parses the query parameters of GET /order:
+//
+//   - status: only orders with one of the payment statuses, repeated or comma separated
+//   - currency: only orders in the currency
+//   - createdFrom, createdTo: only orders created in [createdFrom, createdTo), as RFC 3339 timestamps
+//   - minAmount, maxAmount: only orders with an amount in [minAmount, maxAmount], requires a currency
+//   - sort: createdAt or amount, prefixed with "-" for descending order, -createdAt (newest first) by default
+//   - limit: the page size, up to storage.MaxOrderLimit
+//   - cursor: the nextCursor of the previous page
+func parseOrderQuery(c *gin.Context) (storage.OrderQuery, error) {
+	query := storage.OrderQuery{
+		SortBy:     storage.SortByCreatedAt,
+		Descending: true,
+		Cursor:     c.Query("cursor"),
+	}
+
+	for _, value := range c.QueryArray("status") {
+		for _, status := range strings.Split(value, ",") {
+			paymentStatus := internal.PaymentStatus(strings.ToUpper(strings.TrimSpace(status)))
+			if !paymentStatus.IsValid() {
+				return query, fmt.Errorf("Unknown payment status %q", status)
+			}
+
+			query.Statuses = append(query.Statuses, paymentStatus)
+		}
+	}
+
+	if c.Query("currency") != "" {
+		query.Currency = strings.ToUpper(c.Query("currency"))
+		_, err := internal.CurrencyExponent(query.Currency)
+		if err != nil {
+			return query, err
+		}
+	}
+
+	for param, created := range map[string]*time.Time{"createdFrom": &query.CreatedFrom, "createdTo": &query.CreatedTo} {
+		if c.Query(param) == "" {
+			continue
+		}
+
+		var err error
+		*created, err = time.Parse(time.RFC3339, c.Query(param))
+		if err != nil {
+			return query, fmt.Errorf("Invalid %v, expected an RFC 3339 timestamp", param)
+		}
+	}
+
+	for param, amount := range map[string]*int64{"minAmount": &query.MinAmount, "maxAmount": &query.MaxAmount} {
+		if c.Query(param) == "" {
+			continue
+		}
+
+		// Amounts in different currencies can't be compared
+		if query.Currency == "" {
+			return query, fmt.Errorf("Filtering by %v requires a currency", param)
+		}
+
+		money, err := internal.ParseMoney(c.Query(param), query.Currency)
+		if err != nil {
+			return query, err
+		}
+
+		*amount = money.Minor()
+	}
+
+	if c.Query("sort") != "" {
+		sortBy, descending := strings.CutPrefix(c.Query("sort"), "-")
+		query.SortBy = storage.OrderSort(sortBy)
+		query.Descending = descending
+
+		if query.SortBy != storage.SortByCreatedAt && query.SortBy != storage.SortByAmount {
+			return query, fmt.Errorf("Unknown sort field %q", sortBy)
+		}
+	}
+
+	if c.Query("limit") != "" {
+		limit, err := strconv.Atoi(c.Query("limit"))
+		if err != nil || limit < 1 || limit > storage.MaxOrderLimit {
+			return query, fmt.Errorf("Invalid limit, expected a number between 1 and %d", storage.MaxOrderLimit)
+		}
+
+		query.Limit = limit
+	}
+
+	return query, nil
+}
+
+// getOwnOrder gets the order with the ID in the path, if it was made by the
+// authenticated user. Otherwise, an error response is sent and false is
+// returned.
+func getOwnOrder(c *gin.Context, orderRepo storage.OrderRepo) (*internal.Order, bool) {
+	order, err := orderRepo.GetOrder(c.Param("id"))
+	// Don't let users find out about the orders of others
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && order.User.Id != authenticatedUser(c).Id) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Order not found",
+		})
+		return nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't get order from order repo", "orderId", c.Param("id"), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to get order",
+		})
+		return nil, false
+	}
+
+	return order, true
+}
+
+// getOrderHandler returns one of the user's orders with its status history.
+// With ?refresh=true, the order's status is checked with Zota and stored
+// before responding.
+func getOrderHandler(c *gin.Context) {
+	type response struct {
+		Order         *internal.Order         `json:"order"`
+		StatusHistory []internal.StatusChange `json:"statusHistory"`
+	}
+
+	deps := dependencies(c)
+
+	refresh := false
+	if c.Query("refresh") != "" {
+		var err error
+		refresh, err = strconv.ParseBool(c.Query("refresh"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid refresh parameter",
+			})
+			return
+		}
+	}
+
+	order, ok := getOwnOrder(c, deps.OrderRepo)
+	if !ok {
+		return
+	}
+
+	// Orders Zota hasn't accepted have no status to refresh
+	if refresh && order.ZotaOrderId != "" {
+		err := syncOrderStatus(c.Request.Context(), deps.ZotaAPI, deps.OrderRepo, order, order.ZotaOrderId, internal.StatusSourceManual)
+		if err != nil {
+			zotaErrorResponse(c, err)
+			return
+		}
+	}
+
+	history, err := deps.OrderRepo.GetStatusHistory(order.Id.String())
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't get status history of order", "orderId", order.Id, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to get order",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, response{Order: order, StatusHistory: history})
+}
+
 type OrderHandlerParams struct {
-	Description string  `json:"description" form:"description" binding:"required,max=128"`
-	Amount      float64 `json:"amount" form:"amount" binding:"required"`
+	Description string `json:"description" form:"description" binding:"required,max=128"`
+	// Amount is kept as the decimal that was sent, so it can be parsed
+	// exactly instead of going through a float64
+	Amount json.Number `json:"amount" form:"amount" binding:"required"`
+	// Currency is the ISO 4217 code of the order's currency, USD if empty
+	Currency string `json:"currency" form:"currency"`
 }
 
 func orderHandler(c *gin.Context) {
-	// TODO - is there a better way to do this?
-	zotaApi := c.MustGet("zotaApi").(zota.IZotaAPI)
-	orderRepo := c.MustGet("orderRepo").(storage.OrderRepo)
+	deps := dependencies(c)
 
 	var params OrderHandlerParams
 	err := c.Bind(&params)
 	if err != nil {
-		log.Printf("Couldn't parse form parameters: %v\n", err)
+		slog.InfoContext(c.Request.Context(), "Couldn't parse form parameters", "error", err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"message": "Invalid data",
 		})
 		return
 	}
 
-	// Ensure amount is a positive, non-zero number
-	if params.Amount <= 0 {
+	currency := strings.ToUpper(params.Currency)
+	if currency == "" {
+		currency = defaultCurrency
+	}
+
+	// Reject currencies we can't take deposits in before creating the order
+	endpointId, err := deps.ZotaAPI.EndpointIdFor(currency)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "amount needs to be a positive number",
+			"message":    fmt.Sprintf("Unsupported currency %q", params.Currency),
+			"currencies": deps.ZotaAPI.Currencies(),
 		})
 		return
 	}
 
-	// Init user (ideally, this would be somehow fetched from a DB
-	// based on authentication credentials provided by the user)
-	userAddress := internal.UserAddress{
-		AddressLine: "My lovely home address line",
-		CountryCode: "DK",
-		City:        "Aalborg",
-		ZipCode:     "9000",
+	amount, err := parseAmount(params.Amount, currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
 	}
 
-	user := internal.User{
-		Email:     "federlizer@protonmail.com",
-		FirstName: "Nikola",
-		LastName:  "Velichkov",
-		IpAddr:    "146.70.188.231",
-		Phone:     "+4550331329",
-		Address:   userAddress,
-	}
+	user := customer(c)
 
-	order := internal.NewOrder(&user, params.Amount, params.Description)
+	order := internal.NewOrder(&user, amount, params.Description)
 
 	addedToRepo := false
 	retries := 0
 	for !addedToRepo && retries < 10 {
 		// This call can only fail if there is a duplicate ID for an order
-		err := orderRepo.AddOrder(order)
+		err := deps.OrderRepo.AddOrder(order)
 		retries += 1
 
 		if err != nil {
-			log.Printf("Couldn't add new order to order repo. Likely a key error: %v\n", err)
+			slog.WarnContext(c.Request.Context(), "Couldn't add new order to order repo. Likely a key error", "orderId", order.Id, "error", err)
 			continue
 		}
 
@@ -133,21 +453,62 @@ func orderHandler(c *gin.Context) {
 		return
 	}
 
-	zotaDepositRequest := zota.FromOrder(order, zotaApi.EndpointId(), zotaApi.SecretKey())
+	zotaDepositRequest := zota.FromOrder(order, endpointId, deps.ZotaAPI.SecretKey(), deps.Config.MerchantUrls())
 
 	// Make request to Zota API
-	response, err := zotaApi.Deposit(zotaDepositRequest)
+	response, err := deps.ZotaAPI.DepositContext(c.Request.Context(), zotaDepositRequest)
+	if err == nil && (response == nil || response.Data == nil) {
+		// Not every IZotaAPI checks that an OK response has data
+		err = fmt.Errorf("%w: OK response with no data", zota.ErrMalformedResponse)
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": err.Error(),
-		})
+		// An order Zota has rejected will never be paid. On other errors, e.g.
+		// timeouts, Zota might have accepted it after all, so it's left as is.
+		outcome := metrics.OutcomeFailed
+		var apiErr *zota.APIError
+		if errors.As(err, &apiErr) && !apiErr.Temporary() {
+			outcome = metrics.OutcomeRejected
+			updateErr := deps.OrderRepo.UpdateStatus(order.Id.String(), internal.PaymentStatusCreated, internal.StatusChange{
+				Status:       internal.PaymentStatusError,
+				Source:       internal.StatusSourceApi,
+				ErrorMessage: apiErr.Message,
+			})
+			if updateErr != nil {
+				slog.ErrorContext(c.Request.Context(), "Couldn't update status of rejected order", "orderId", order.Id, "error", updateErr)
+			}
+		}
+		deps.Metrics.OrderCreated(outcome)
+
+		// Retrying the request would then create a second deposit
+		if zotaMightHaveAccepted(err) {
+			slog.WarnContext(c.Request.Context(), "Zota might have accepted the deposit, keeping the order", "orderId", order.Id, "error", err)
+			keepIdempotencyKey(c)
+		}
+
+		zotaErrorResponse(c, err)
 		return
 	}
 
-	zotaOrderStatusRequest := zota.NewZotaOrderStatusRequest(response.Data.OrderId, response.Data.MerchantOrderID)
+	deps.Metrics.OrderCreated(metrics.OutcomeSubmitted)
+
+	err = deps.OrderRepo.SetZotaOrder(order.Id.String(), response.Data.OrderId, response.Data.DepositUrl)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't store Zota order", "orderId", order.Id, "zotaOrderId", response.Data.OrderId, "error", err)
+	}
+
+	err = deps.OrderRepo.UpdateStatus(order.Id.String(), internal.PaymentStatusCreated, internal.StatusChange{
+		Status: internal.PaymentStatusSubmitted,
+		Source: internal.StatusSourceApi,
+	})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't update status of submitted order", "orderId", order.Id, "error", err)
+	}
 
 	// Start Order Status polling
-	go zotaApi.PollOrderStatus(zotaOrderStatusRequest, order)
+	err = deps.Poller.Track(c.Request.Context(), storage.PollKindDeposit, order.Id.String(), response.Data.OrderId)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't start polling for order", "orderId", order.Id, "error", err)
+	}
 
 	// Redirect user to deposit page
 	c.Redirect(http.StatusFound, response.Data.DepositUrl)
@@ -1,42 +1,210 @@
 package api
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/events"
+	"github.com/federlizer/alokin-zota-integration/internal/logging"
+	"github.com/federlizer/alokin-zota-integration/internal/metrics"
+	"github.com/federlizer/alokin-zota-integration/internal/poller"
 	"github.com/federlizer/alokin-zota-integration/internal/storage"
 	"github.com/federlizer/alokin-zota-integration/zota"
 )
 
-type zotaAPIMock struct{}
+type zotaAPIMock struct {
+	mu sync.Mutex
+	// depositRequest is the last deposit request that was made
+	depositRequest *zota.ZotaDepositRequest
+	// depositRequestId is the request ID the deposit request was made with
+	depositRequestId string
+	// depositSpan is the span context the deposit request was made in
+	depositSpan trace.SpanContext
 
-func (api *zotaAPIMock) SecretKey() string  { return "00000000-1111-2222-3333-444444444444" }
-func (api *zotaAPIMock) EndpointId() string { return "123456" }
-func (api *zotaAPIMock) MerchantId() string { return "COOKIES1337" }
-func (api *zotaAPIMock) BaseUrl() string    { return "https://federlizer.com/api/" }
+	depositResponse     *zota.ZotaDepositResponse
+	depositErr          error
+	orderStatusResponse *zota.ZotaOrderStatusResponse
+	orderStatusErr      error
+	payoutResponse      *zota.ZotaPayoutResponse
+	payoutErr           error
+}
+
+func (api *zotaAPIMock) SecretKey() string    { return "00000000-1111-2222-3333-444444444444" }
+func (api *zotaAPIMock) EndpointId() string   { return "123456" }
+func (api *zotaAPIMock) MerchantId() string   { return "COOKIES1337" }
+func (api *zotaAPIMock) Currencies() []string { return []string{"EUR", "USD"} }
+func (api *zotaAPIMock) EndpointIdFor(currency string) (string, error) {
+	switch currency {
+	case "USD":
+		return "123456", nil
+	case "EUR":
+		return "654321", nil
+	default:
+		return "", internal.ErrUnsupportedCurrency
+	}
+}
+func (api *zotaAPIMock) BaseUrl() string { return "https://federlizer.com/api/" }
 
 func (api *zotaAPIMock) Deposit(req *zota.ZotaDepositRequest) (*zota.ZotaDepositResponse, error) {
-	return nil, nil
+	return api.DepositContext(context.Background(), req)
+}
+func (api *zotaAPIMock) DepositContext(ctx context.Context, req *zota.ZotaDepositRequest) (*zota.ZotaDepositResponse, error) {
+	api.mu.Lock()
+	api.depositRequest = req
+	api.depositRequestId = logging.RequestId(ctx)
+	api.depositSpan = trace.SpanContextFromContext(ctx)
+	api.mu.Unlock()
+
+	return api.depositResponse, api.depositErr
+}
+func (api *zotaAPIMock) Payout(req *zota.ZotaPayoutRequest) (*zota.ZotaPayoutResponse, error) {
+	return api.PayoutContext(context.Background(), req)
+}
+func (api *zotaAPIMock) PayoutContext(ctx context.Context, req *zota.ZotaPayoutRequest) (*zota.ZotaPayoutResponse, error) {
+	return api.payoutResponse, api.payoutErr
 }
 func (api *zotaAPIMock) OrderStatus(req *zota.ZotaOrderStatusRequest) (*zota.ZotaOrderStatusResponse, error) {
-	return nil, nil
+	return api.OrderStatusContext(context.Background(), req)
 }
-func (api *zotaAPIMock) PollOrderStatus(req *zota.ZotaOrderStatusRequest, order *internal.Order) (*zota.ZotaOrderStatusResponse, error) {
-	return nil, nil
+func (api *zotaAPIMock) OrderStatusContext(ctx context.Context, req *zota.ZotaOrderStatusRequest) (*zota.ZotaOrderStatusResponse, error) {
+	return api.orderStatusResponse, api.orderStatusErr
 }
 
 func createZotaAPIMock() *zotaAPIMock {
 	return &zotaAPIMock{}
 }
 
-func createOrderRepo() *storage.OrderRepo {
-	return storage.NewOrderRepo()
+func createOrderRepo() *storage.MemoryOrderRepo {
+	return storage.NewMemoryOrderRepo()
+}
+
+func createPayoutRepo() *storage.MemoryPayoutRepo {
+	return storage.NewMemoryPayoutRepo()
+}
+
+// testUserId is the ID of testUser
+const testUserId = "6b1f3e0c-8f2a-4d5b-9c7e-1a2b3c4d5e6f"
+
+// testToken is the bearer token of testUser's session in the user repo
+// created by createUserRepo
+const testToken = "test-token"
+
+// testPasswordHash is only computed once, bcrypt is slow on purpose
+var testPasswordHash = sync.OnceValue(func() []byte {
+	user := internal.User{}
+	user.SetPassword("correct horse battery staple")
+	return user.PasswordHash
+})
+
+func testUser() *internal.User {
+	userAddress := internal.UserAddress{
+		AddressLine: "My lovely home address line",
+		CountryCode: "DK",
+		City:        "Aalborg",
+		ZipCode:     "9000",
+	}
+
+	user := internal.NewUser("federlizer@protonmail.com", "Nikola", "Velichkov", "+4550331329", userAddress)
+	user.Id = uuid.MustParse(testUserId)
+	user.PasswordHash = testPasswordHash()
+
+	return user
+}
+
+// createUserRepo creates a user repo with testUser logged in with testToken
+func createUserRepo(t *testing.T) *storage.MemoryUserRepo {
+	userRepo := storage.NewMemoryUserRepo()
+	user := testUser()
+
+	err := userRepo.AddUser(user)
+	if err != nil {
+		t.Fatalf("Failed to add user: %q\n", err)
+	}
+
+	err = userRepo.AddSession(&internal.Session{
+		TokenHash: internal.HashToken(testToken),
+		UserId:    user.Id,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Failed to add session: %q\n", err)
+	}
+
+	return userRepo
+}
+
+// authorize authenticates the request as testUser
+func authorize(req *http.Request) {
+	req.Header.Set("Authorization", "Bearer "+testToken)
+}
+
+func getStoredOrder(t *testing.T, orderRepo storage.OrderRepo, id string) *internal.Order {
+	order, err := orderRepo.GetOrder(id)
+	if err != nil {
+		t.Fatalf("Failed to get order %v: %q\n", id, err)
+	}
+
+	return order
+}
+
+func getStoredPayout(t *testing.T, payoutRepo storage.PayoutRepo, id string) *internal.Payout {
+	payout, err := payoutRepo.GetPayout(id)
+	if err != nil {
+		t.Fatalf("Failed to get payout %v: %q\n", id, err)
+	}
+
+	return payout
+}
+
+func createConfig() Config {
+	return Config{
+		PublicUrl:   "https://federlizer.com",
+		CheckoutUrl: "https://federlizer.com/checkout",
+		AdminToken:  testAdminToken,
+	}
+}
+
+// setupTestApi sets up the API with a poller that isn't running, so that
+// tracked orders are only scheduled, but never checked
+func setupTestApi(t *testing.T, zotaApi zota.IZotaAPI, orderRepo storage.OrderRepo, payoutRepo storage.PayoutRepo, config Config) *gin.Engine {
+	return setupTestApiWithUsers(zotaApi, orderRepo, payoutRepo, createUserRepo(t), config)
+}
+
+func setupTestApiWithUsers(zotaApi zota.IZotaAPI, orderRepo storage.OrderRepo, payoutRepo storage.PayoutRepo, userRepo storage.UserRepo, config Config) *gin.Engine {
+	return setupTestApiWithBus(zotaApi, orderRepo, payoutRepo, userRepo, events.NewBus(), config)
+}
+
+// setupTestApiWithBus sets up the API with every order status change
+// published on the bus
+func setupTestApiWithBus(zotaApi zota.IZotaAPI, orderRepo storage.OrderRepo, payoutRepo storage.PayoutRepo, userRepo storage.UserRepo, bus *events.Bus, config Config) *gin.Engine {
+	orderRepo = events.PublishingOrderRepo(orderRepo, bus)
+	orderPoller := poller.New(zotaApi, orderRepo, payoutRepo, storage.NewMemoryPollSchedule(), poller.FixedPolicy{Interval: time.Minute, MaxAttempts: 20})
+
+	return SetupApi(Dependencies{
+		ZotaAPI:          zotaApi,
+		OrderRepo:        orderRepo,
+		PayoutRepo:       payoutRepo,
+		UserRepo:         userRepo,
+		IdempotencyStore: storage.NewMemoryIdempotencyStore(),
+		WebhookRepo:      storage.NewMemoryWebhookRepo(),
+		Bus:              bus,
+		Poller:           orderPoller,
+		Metrics:          metrics.New(),
+		Config:           config,
+	})
 }
 
 func TestPingEndpoint(t *testing.T) {
-	engine := SetupApi(createZotaAPIMock(), *createOrderRepo())
+	engine := setupTestApi(t, createZotaAPIMock(), createOrderRepo(), createPayoutRepo(), createConfig())
 
 	resWriter := httptest.NewRecorder()
 	req, err := http.NewRequest("GET", "/ping", nil)
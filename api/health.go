@@ -0,0 +1,188 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/federlizer/alokin-zota-integration/internal/poller"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+// readinessTimeout is how long the readiness checks can take altogether
+const readinessTimeout = 5 * time.Second
+
+const (
+	checkOk     = "ok"
+	checkFailed = "failed"
+)
+
+// checkResult is the outcome of checking a single component. The message
+// only says what's wrong, the underlying error is logged instead, so that
+// the details of the storage don't leak to whoever can reach /readyz.
+type checkResult struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// zotaPinger is implemented by Zota clients that can check whether Zota's API
+// can be reached
+type zotaPinger interface {
+	Ping(ctx context.Context) error
+}
+
+// healthzHandler reports that the process is alive and serving requests. It
+// doesn't check any dependency, so that the process isn't restarted when one
+// of them is down.
+func healthzHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": checkOk,
+	})
+}
+
+// readyzHandler reports whether the server is ready to handle payments,
+// checking the storage, the poller, the Zota endpoints and, if configured,
+// that Zota can be reached. The checks can take readinessTimeout altogether.
+func readyzHandler(c *gin.Context) {
+	deps := dependencies(c)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]checkResult{
+		"storage": runCheck(ctx, "storage", func() checkResult {
+			return checkStorage(ctx, deps.OrderRepo, deps.PayoutRepo, deps.UserRepo, deps.WebhookRepo)
+		}),
+		"poller": runCheck(ctx, "poller", func() checkResult {
+			return checkPoller(ctx, deps.Poller)
+		}),
+		"config": checkConfig(deps.ZotaAPI),
+	}
+	if deps.Config.CheckZotaReadiness {
+		checks["zota"] = checkZota(ctx, deps.ZotaAPI)
+	}
+
+	status, httpStatus := checkOk, http.StatusOK
+	for _, result := range checks {
+		if result.Status != checkOk {
+			status, httpStatus = checkFailed, http.StatusServiceUnavailable
+		}
+	}
+
+	c.JSON(httpStatus, gin.H{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+func failedCheck(ctx context.Context, component, message string, err error) checkResult {
+	slog.WarnContext(ctx, "Readiness check failed", "component", component, "message", message, "error", err)
+
+	return checkResult{Status: checkFailed, Message: message}
+}
+
+// runCheck runs a check that can't be cancelled, e.g. because the repository
+// methods it calls don't take a context, failing it once ctx is done. The
+// check then carries on in the background until it returns.
+func runCheck(ctx context.Context, component string, check func() checkResult) checkResult {
+	result := make(chan checkResult, 1)
+	go func() {
+		result <- check()
+	}()
+
+	select {
+	case checked := <-result:
+		return checked
+	case <-ctx.Done():
+		return failedCheck(ctx, component, "Check didn't finish in time", ctx.Err())
+	}
+}
+
+// checkStorage looks up an entity that doesn't exist in every repository,
+// which only succeeds if the storage can be reached
+func checkStorage(ctx context.Context, orderRepo storage.OrderRepo, payoutRepo storage.PayoutRepo, userRepo storage.UserRepo, webhookRepo storage.WebhookRepo) checkResult {
+	missingId := uuid.Nil.String()
+
+	probes := []struct {
+		message string
+		probe   func() error
+	}{
+		{"Orders can't be read", func() error {
+			_, err := orderRepo.GetOrder(missingId)
+			return err
+		}},
+		{"Payouts can't be read", func() error {
+			_, err := payoutRepo.GetPayout(missingId)
+			return err
+		}},
+		{"Sessions can't be read", func() error {
+			_, err := userRepo.GetSession(missingId)
+			return err
+		}},
+		{"Webhook subscriptions can't be read", func() error {
+			_, err := webhookRepo.GetSubscription(missingId)
+			return err
+		}},
+	}
+
+	for _, probe := range probes {
+		err := probe.probe()
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return failedCheck(ctx, "storage", probe.message, err)
+		}
+	}
+
+	return checkResult{Status: checkOk}
+}
+
+// checkPoller makes sure that pending orders and payouts are being polled
+func checkPoller(ctx context.Context, orderPoller *poller.Poller) checkResult {
+	if !orderPoller.Running() {
+		return checkResult{Status: checkFailed, Message: "Poller isn't running"}
+	}
+
+	_, err := orderPoller.Tracked()
+	if err != nil {
+		return failedCheck(ctx, "poller", "Poll schedule can't be read", err)
+	}
+
+	return checkResult{Status: checkOk}
+}
+
+// checkConfig makes sure that Zota endpoints are configured for deposits in
+// the default currency and for payouts. The rest of the configuration is
+// validated at startup.
+func checkConfig(zotaApi zota.IZotaAPI) checkResult {
+	endpointId, err := zotaApi.EndpointIdFor(defaultCurrency)
+	if err != nil || endpointId == "" {
+		return checkResult{Status: checkFailed, Message: fmt.Sprintf("Zota endpoint for %s deposits isn't configured", defaultCurrency)}
+	}
+
+	if zotaApi.EndpointId() == "" {
+		return checkResult{Status: checkFailed, Message: "Zota endpoint for payouts isn't configured"}
+	}
+
+	return checkResult{Status: checkOk}
+}
+
+// checkZota makes sure that Zota's API can be reached
+func checkZota(ctx context.Context, zotaApi zota.IZotaAPI) checkResult {
+	pinger, ok := zotaApi.(zotaPinger)
+	if !ok {
+		return checkResult{Status: checkFailed, Message: "Zota client can't check whether Zota can be reached"}
+	}
+
+	err := pinger.Ping(ctx)
+	if err != nil {
+		return failedCheck(ctx, "zota", "Zota can't be reached", err)
+	}
+
+	return checkResult{Status: checkOk}
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/internal/events"
+	"github.com/federlizer/alokin-zota-integration/internal/poller"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+type readinessResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+func getReadiness(t *testing.T, zotaApi zota.IZotaAPI, webhookRepo storage.WebhookRepo, startPoller bool, config Config) (int, readinessResponse) {
+	orderRepo := createOrderRepo()
+	payoutRepo := createPayoutRepo()
+	orderPoller := poller.New(zotaApi, orderRepo, payoutRepo, storage.NewMemoryPollSchedule(), poller.FixedPolicy{Interval: time.Minute, MaxAttempts: 20})
+	if startPoller {
+		err := orderPoller.Start()
+		if err != nil {
+			t.Fatalf("Failed to start poller: %q\n", err)
+		}
+		defer orderPoller.Stop()
+	}
+
+	engine := SetupApi(Dependencies{
+		ZotaAPI:          zotaApi,
+		OrderRepo:        orderRepo,
+		PayoutRepo:       payoutRepo,
+		UserRepo:         createUserRepo(t),
+		IdempotencyStore: storage.NewMemoryIdempotencyStore(),
+		WebhookRepo:      webhookRepo,
+		Bus:              events.NewBus(),
+		Poller:           orderPoller,
+		Config:           config,
+	})
+
+	resWriter := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	engine.ServeHTTP(resWriter, req)
+
+	var response readinessResponse
+	err := json.Unmarshal(resWriter.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("Failed to parse response: %q\n", err)
+	}
+
+	return resWriter.Code, response
+}
+
+func createTestZotaAPI(t *testing.T, endpointId string, endpoints map[string]string, baseUrl string) *zota.ZotaAPI {
+	zotaApi, err := zota.NewZotaAPI("secret", endpointId, "COOKIES1337", baseUrl, zota.WithEndpoints(endpoints))
+	if err != nil {
+		t.Fatalf("Failed to create Zota API: %q\n", err)
+	}
+
+	return zotaApi
+}
+
+func TestHealthzEndpoint(t *testing.T) {
+	engine := setupTestApi(t, createZotaAPIMock(), createOrderRepo(), createPayoutRepo(), createConfig())
+
+	resWriter := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	engine.ServeHTTP(resWriter, req)
+
+	if resWriter.Code != http.StatusOK {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusOK)
+	}
+}
+
+func TestReadyzEndpoint(t *testing.T) {
+	zotaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer zotaServer.Close()
+
+	unreachableServer := httptest.NewServer(http.NotFoundHandler())
+	unreachableServer.Close()
+
+	closedDb, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "alokin.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %q\n", err)
+	}
+	closedDb.Close()
+
+	checkZota := createConfig()
+	checkZota.CheckZotaReadiness = true
+
+	tests := []struct {
+		name        string
+		zotaApi     zota.IZotaAPI
+		webhookRepo storage.WebhookRepo
+		startPoller bool
+		config      Config
+		// failed is the component that's expected to fail, if any
+		failed string
+	}{
+		{"ready", createZotaAPIMock(), storage.NewMemoryWebhookRepo(), true, createConfig(), ""},
+		{"poller stopped", createZotaAPIMock(), storage.NewMemoryWebhookRepo(), false, createConfig(), "poller"},
+		{"storage unavailable", createZotaAPIMock(), storage.NewSQLiteWebhookRepo(closedDb), true, createConfig(), "storage"},
+		{"no deposit endpoint", createTestZotaAPI(t, "", map[string]string{"EUR": "654321"}, zotaServer.URL), storage.NewMemoryWebhookRepo(), true, createConfig(), "config"},
+		{"no payout endpoint", createTestZotaAPI(t, "", map[string]string{"USD": "123456"}, zotaServer.URL), storage.NewMemoryWebhookRepo(), true, createConfig(), "config"},
+		{"no endpoints", createTestZotaAPI(t, "123456", nil, zotaServer.URL), storage.NewMemoryWebhookRepo(), true, createConfig(), "config"},
+		{"Zota reachable", createTestZotaAPI(t, "123456", map[string]string{"USD": "123456"}, zotaServer.URL), storage.NewMemoryWebhookRepo(), true, checkZota, ""},
+		{"Zota unreachable", createTestZotaAPI(t, "123456", map[string]string{"USD": "123456"}, unreachableServer.URL), storage.NewMemoryWebhookRepo(), true, checkZota, "zota"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, response := getReadiness(t, test.zotaApi, test.webhookRepo, test.startPoller, test.config)
+
+			expectedCode, expectedStatus := http.StatusOK, checkOk
+			if test.failed != "" {
+				expectedCode, expectedStatus = http.StatusServiceUnavailable, checkFailed
+			}
+
+			if code != expectedCode {
+				t.Errorf("Server response %d doesn't equal expected %d", code, expectedCode)
+			}
+
+			if response.Status != expectedStatus {
+				t.Errorf("Status %q doesn't equal expected %q", response.Status, expectedStatus)
+			}
+
+			expectedChecks := []string{"storage", "poller", "config"}
+			if test.config.CheckZotaReadiness {
+				expectedChecks = append(expectedChecks, "zota")
+			}
+
+			if len(response.Checks) != len(expectedChecks) {
+				t.Errorf("Checks %v don't equal expected %v", response.Checks, expectedChecks)
+			}
+
+			for _, component := range expectedChecks {
+				result := response.Checks[component]
+				if component == test.failed {
+					if result.Status != checkFailed || result.Message == "" {
+						t.Errorf("Check %q %+v didn't fail with a message", component, result)
+					}
+				} else if result.Status != checkOk {
+					t.Errorf("Check %q %+v didn't pass", component, result)
+				}
+			}
+		})
+	}
+}
+
+// slowWebhookRepo is a webhook repo whose reads don't return until release is
+// closed
+type slowWebhookRepo struct {
+	storage.WebhookRepo
+	release chan struct{}
+}
+
+func (r *slowWebhookRepo) GetSubscription(id string) (*storage.WebhookSubscription, error) {
+	<-r.release
+	return r.WebhookRepo.GetSubscription(id)
+}
+
+func TestReadyzStorageTimeout(t *testing.T) {
+	webhookRepo := &slowWebhookRepo{WebhookRepo: storage.NewMemoryWebhookRepo(), release: make(chan struct{})}
+	defer close(webhookRepo.release)
+
+	orderRepo, payoutRepo := createOrderRepo(), createPayoutRepo()
+	orderPoller := poller.New(createZotaAPIMock(), orderRepo, payoutRepo, storage.NewMemoryPollSchedule(), poller.FixedPolicy{Interval: time.Minute, MaxAttempts: 20})
+	engine := SetupApi(Dependencies{
+		ZotaAPI:          createZotaAPIMock(),
+		OrderRepo:        orderRepo,
+		PayoutRepo:       payoutRepo,
+		UserRepo:         createUserRepo(t),
+		IdempotencyStore: storage.NewMemoryIdempotencyStore(),
+		WebhookRepo:      webhookRepo,
+		Bus:              events.NewBus(),
+		Poller:           orderPoller,
+		Config:           createConfig(),
+	})
+
+	// The request's deadline is shorter than readinessTimeout
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	resWriter := httptest.NewRecorder()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "/readyz", nil)
+	engine.ServeHTTP(resWriter, req)
+
+	if resWriter.Code != http.StatusServiceUnavailable {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusServiceUnavailable)
+	}
+
+	var response readinessResponse
+	err := json.Unmarshal(resWriter.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("Failed to parse response: %q\n", err)
+	}
+
+	if response.Checks["storage"].Status != checkFailed {
+		t.Errorf("Check %q %+v didn't fail", "storage", response.Checks["storage"])
+	}
+}
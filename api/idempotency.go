@@ -0,0 +1,189 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+)
+
+const (
+	// DefaultIdempotencyTTL is how long an idempotency key is remembered,
+	// unless configured otherwise with Config.IdempotencyTTL
+	DefaultIdempotencyTTL = 24 * time.Hour
+
+	// idempotencyLockTimeout is how long a request can take before its
+	// idempotency key is considered abandoned. It needs to be longer than
+	// any request to Zota can take.
+	idempotencyLockTimeout = 2 * time.Minute
+
+	maxIdempotencyKeyLength = 255
+
+	// keepIdempotencyKeyFlag is set in the context by keepIdempotencyKey
+	keepIdempotencyKeyFlag = "keepIdempotencyKey"
+)
+
+// idempotencyRecorder keeps a copy of the response body, so it can be
+// returned again for repeated requests
+type idempotencyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *idempotencyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotent makes the request safe to retry when the client sends an
+// Idempotency-Key header. The response to the first request with a key is
+// stored and returned again for every repeat of the request, without
+// handling it again. Reusing a key for a different request, or while the
+// first request is still being handled, is a 409 Conflict.
+//
+// Keys are scoped to the authenticated user, or the admin. Server errors aren't stored,
+// so the request can be retried with the same key, unless the handler has
+// called keepIdempotencyKey.
+func idempotent(c *gin.Context) {
+	key := c.GetHeader("Idempotency-Key")
+	if key == "" {
+		return
+	}
+
+	if len(key) > maxIdempotencyKeyLength {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": fmt.Sprintf("Idempotency-Key can't be longer than %d characters", maxIdempotencyKeyLength),
+		})
+		return
+	}
+
+	deps := dependencies(c)
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid data",
+		})
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	now := time.Now()
+	record := storage.IdempotencyRecord{
+		Key:         idempotencyScope(c) + ":" + key,
+		Fingerprint: requestFingerprint(c.Request, body),
+		LockedUntil: now.Add(idempotencyLockTimeout),
+		ExpiresAt:   now.Add(deps.Config.idempotencyTTL()),
+	}
+
+	existing, err := deps.IdempotencyStore.Reserve(record, now)
+	if errors.Is(err, storage.ErrIdempotencyKeyExists) {
+		replayResponse(c, record, existing)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't reserve idempotency key", "error", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to handle request",
+		})
+		return
+	}
+
+	recorder := &idempotencyRecorder{ResponseWriter: c.Writer}
+	c.Writer = recorder
+
+	c.Next()
+
+	if c.Writer.Status() >= http.StatusInternalServerError && !c.GetBool(keepIdempotencyKeyFlag) {
+		err = deps.IdempotencyStore.Release(record.Key)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Couldn't release idempotency key", "error", err)
+		}
+		return
+	}
+
+	record.StatusCode = c.Writer.Status()
+	record.ContentType = c.Writer.Header().Get("Content-Type")
+	record.Location = c.Writer.Header().Get("Location")
+	record.Body = recorder.body.Bytes()
+
+	err = deps.IdempotencyStore.Complete(record)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't store response for idempotency key", "error", err)
+	}
+}
+
+// idempotencyScope returns the ID of the authenticated user, or "admin" for
+// the admin's requests, which aren't made by a user
+func idempotencyScope(c *gin.Context) string {
+	user, found := c.Get("user")
+	if !found {
+		return "admin"
+	}
+
+	return user.(*internal.User).Id.String()
+}
+
+// keepIdempotencyKey makes the idempotent middleware store the response to
+// the request even if it's a server error. Handlers call it when the request
+// might have had an effect despite failing, so that retrying it with the same
+// key doesn't have the effect twice.
+func keepIdempotencyKey(c *gin.Context) {
+	c.Set(keepIdempotencyKeyFlag, true)
+}
+
+// replayResponse responds to a repeated request with the stored response of
+// the first request with the same idempotency key
+func replayResponse(c *gin.Context, record storage.IdempotencyRecord, existing *storage.IdempotencyRecord) {
+	if existing.Fingerprint != record.Fingerprint {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"message": "Idempotency-Key has already been used for a different request",
+		})
+		return
+	}
+
+	if !existing.Completed {
+		c.Header("Retry-After", "1")
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"message": "A request with the same Idempotency-Key is still being processed",
+		})
+		return
+	}
+
+	c.Header("Idempotent-Replayed", "true")
+	if existing.Location != "" {
+		c.Header("Location", existing.Location)
+	}
+
+	if existing.ContentType == "" {
+		c.AbortWithStatus(existing.StatusCode)
+		c.Writer.Write(existing.Body)
+		return
+	}
+
+	c.Data(existing.StatusCode, existing.ContentType, existing.Body)
+	c.Abort()
+}
+
+// requestFingerprint identifies the request by its method, path and body
+func requestFingerprint(req *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", req.Method, req.URL.Path)
+	hash.Write(body)
+
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
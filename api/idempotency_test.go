@@ -0,0 +1,184 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+func postOrderWithKey(t *testing.T, handler http.Handler, key, body string) *httptest.ResponseRecorder {
+	resWriter := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/order", strings.NewReader(body))
+	if err != nil {
+		t.Errorf("Failed to init request: %q\n", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+
+	authorize(req)
+	handler.ServeHTTP(resWriter, req)
+
+	return resWriter
+}
+
+func countOrders(t *testing.T, orderRepo storage.OrderRepo) int {
+	page, err := orderRepo.QueryOrders(storage.OrderQuery{Limit: storage.MaxOrderLimit})
+	if err != nil {
+		t.Fatalf("Failed to query orders: %q\n", err)
+	}
+
+	return len(page.Orders)
+}
+
+func createDepositZotaAPIMock() *zotaAPIMock {
+	zotaApi := createZotaAPIMock()
+	zotaApi.depositResponse = &zota.ZotaDepositResponse{
+		Code: "200",
+		Data: &zota.ZotaDepositData{
+			OrderId:    "32ab44f0a5d9d6b6c0c9e41b1a9c7ea1a2b3c4d5",
+			DepositUrl: "https://zota.com/deposit",
+		},
+	}
+
+	return zotaApi
+}
+
+func TestIdempotentOrderIsOnlyCreatedOnce(t *testing.T) {
+	orderRepo := createOrderRepo()
+	engine := setupTestApi(t, createDepositZotaAPIMock(), orderRepo, createPayoutRepo(), createConfig())
+
+	body := `{"description": "Test order", "amount": 13.37}`
+
+	first := postOrderWithKey(t, engine, "order-1", body)
+	if first.Code != http.StatusFound {
+		t.Fatalf("Server response %d doesn't equal expected %d", first.Code, http.StatusFound)
+	}
+
+	repeat := postOrderWithKey(t, engine, "order-1", body)
+	if repeat.Code != http.StatusFound || repeat.Header().Get("Location") != first.Header().Get("Location") {
+		t.Errorf("Repeated response %d %q doesn't equal original %d %q", repeat.Code, repeat.Header().Get("Location"), first.Code, first.Header().Get("Location"))
+	}
+
+	if repeat.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Repeated response isn't marked as replayed")
+	}
+
+	if countOrders(t, orderRepo) != 1 {
+		t.Errorf("Number of orders %d doesn't equal expected %d", countOrders(t, orderRepo), 1)
+	}
+
+	// A different key is a different order
+	postOrderWithKey(t, engine, "order-2", body)
+	if countOrders(t, orderRepo) != 2 {
+		t.Errorf("Number of orders %d doesn't equal expected %d", countOrders(t, orderRepo), 2)
+	}
+
+	mismatch := postOrderWithKey(t, engine, "order-1", `{"description": "Test order", "amount": 99.99}`)
+	if mismatch.Code != http.StatusConflict {
+		t.Errorf("Server response %d doesn't equal expected %d", mismatch.Code, http.StatusConflict)
+	}
+}
+
+func TestIdempotentOrderConcurrentDuplicates(t *testing.T) {
+	const requests = 10
+
+	orderRepo := createOrderRepo()
+	engine := setupTestApi(t, createDepositZotaAPIMock(), orderRepo, createPayoutRepo(), createConfig())
+
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			resWriter := postOrderWithKey(t, engine, "order-1", `{"description": "Test order", "amount": 13.37}`)
+
+			// Duplicates either get the original response or are told to retry
+			if resWriter.Code != http.StatusFound && resWriter.Code != http.StatusConflict {
+				t.Errorf("Server response %d isn't %d or %d", resWriter.Code, http.StatusFound, http.StatusConflict)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if countOrders(t, orderRepo) != 1 {
+		t.Errorf("Number of orders %d doesn't equal expected %d", countOrders(t, orderRepo), 1)
+	}
+}
+
+func TestIdempotentOrderCanBeRetriedAfterServerError(t *testing.T) {
+	zotaApi := createDepositZotaAPIMock()
+	// Zota doesn't accept requests it's rate limiting
+	zotaApi.depositErr = &zota.APIError{HTTPStatus: http.StatusTooManyRequests}
+	engine := setupTestApi(t, zotaApi, createOrderRepo(), createPayoutRepo(), createConfig())
+
+	body := `{"description": "Test order", "amount": 13.37}`
+
+	resWriter := postOrderWithKey(t, engine, "order-1", body)
+	if resWriter.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusServiceUnavailable)
+	}
+
+	zotaApi.depositErr = nil
+
+	resWriter = postOrderWithKey(t, engine, "order-1", body)
+	if resWriter.Code != http.StatusFound || resWriter.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusFound)
+	}
+
+	// Client errors are stored like any other response
+	resWriter = postOrderWithKey(t, engine, "order-2", `{"description": "Test order"}`)
+	repeat := postOrderWithKey(t, engine, "order-2", `{"description": "Test order"}`)
+	if repeat.Code != http.StatusBadRequest || repeat.Body.String() != resWriter.Body.String() {
+		t.Errorf("Repeated response %d %q doesn't equal original %d %q", repeat.Code, repeat.Body.String(), resWriter.Code, resWriter.Body.String())
+	}
+}
+
+func TestIdempotentOrderIsKeptWhenZotaMightHaveAccepted(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{errors.New("connection reset by peer"), http.StatusBadGateway},
+		{&zota.APIError{HTTPStatus: http.StatusInternalServerError}, http.StatusBadGateway},
+	}
+
+	for _, test := range tests {
+		zotaApi := createDepositZotaAPIMock()
+		zotaApi.depositErr = test.err
+		orderRepo := createOrderRepo()
+		engine := setupTestApi(t, zotaApi, orderRepo, createPayoutRepo(), createConfig())
+
+		body := `{"description": "Test order", "amount": 13.37}`
+
+		first := postOrderWithKey(t, engine, "order-1", body)
+		if first.Code != test.expected {
+			t.Fatalf("Server response %d doesn't equal expected %d", first.Code, test.expected)
+		}
+
+		// Retrying after Zota recovers mustn't make a second deposit
+		zotaApi.depositErr = nil
+
+		repeat := postOrderWithKey(t, engine, "order-1", body)
+		if repeat.Code != test.expected || repeat.Body.String() != first.Body.String() {
+			t.Errorf("Repeated response %d %q doesn't equal original %d %q", repeat.Code, repeat.Body.String(), first.Code, first.Body.String())
+		}
+
+		if repeat.Header().Get("Idempotent-Replayed") != "true" {
+			t.Errorf("Repeated response after %q isn't marked as replayed", test.err)
+		}
+
+		if countOrders(t, orderRepo) != 1 {
+			t.Errorf("Number of orders %d doesn't equal expected %d", countOrders(t, orderRepo), 1)
+		}
+	}
+}
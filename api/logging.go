@@ -0,0 +1,55 @@
+package api
+
+import (
+	"log/slog"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/federlizer/alokin-zota-integration/internal/logging"
+)
+
+// RequestIdHeader is the header a request's ID is taken from and echoed in
+const RequestIdHeader = "X-Request-Id"
+
+// validRequestId matches the request IDs accepted from clients, so they
+// can't inject anything into the logs
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// requestId gives every request an ID, the one sent by the client if it's
+// valid. The ID is set on the request's context, so everything logged while
+// handling the request, including the requests made to Zota and the order
+// status checks the poller makes later on, carries it.
+func requestId(c *gin.Context) {
+	id := c.GetHeader(RequestIdHeader)
+	if !validRequestId.MatchString(id) {
+		id = uuid.NewString()
+	}
+
+	c.Request = c.Request.WithContext(logging.WithRequestId(c.Request.Context(), id))
+	c.Header(RequestIdHeader, id)
+
+	c.Next()
+}
+
+// accessLog logs every request once it has been handled. The query isn't
+// logged, since Zota's redirects carry their signature in it.
+func accessLog(c *gin.Context) {
+	start := time.Now()
+
+	c.Next()
+
+	level := slog.LevelInfo
+	if c.Writer.Status() >= 500 {
+		level = slog.LevelError
+	}
+
+	slog.Log(c.Request.Context(), level, "Handled request",
+		"method", c.Request.Method,
+		"path", c.Request.URL.Path,
+		"status", c.Writer.Status(),
+		"duration", time.Since(start),
+	)
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+func TestRequestIdIsPropagated(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	zotaApi.depositResponse = &zota.ZotaDepositResponse{
+		Code: "200",
+		Data: &zota.ZotaDepositData{
+			OrderId:    "32ab44f0a5d9d6b6c0c9e41b1a9c7ea1a2b3c4d5",
+			DepositUrl: "https://zota.com/deposit",
+		},
+	}
+	engine := setupTestApi(t, zotaApi, createOrderRepo(), createPayoutRepo(), createConfig())
+
+	tests := []struct {
+		name      string
+		requestId string
+		generated bool
+	}{
+		{"client ID", "5b0f9a52-checkout", false},
+		{"no ID", "", true},
+		{"invalid ID", "5b0f9a52\nlevel=ERROR", true},
+		{"too long ID", strings.Repeat("a", 129), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resWriter := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/order", strings.NewReader(`{"description": "Test order", "amount": 13.37}`))
+			if err != nil {
+				t.Fatalf("Failed to init request: %q\n", err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(RequestIdHeader, test.requestId)
+
+			authorize(req)
+			engine.ServeHTTP(resWriter, req)
+
+			if resWriter.Code != http.StatusFound {
+				t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusFound)
+			}
+
+			requestId := resWriter.Header().Get(RequestIdHeader)
+			if test.generated {
+				_, err = uuid.Parse(requestId)
+				if err != nil {
+					t.Errorf("Request ID %q isn't a generated UUID", requestId)
+				}
+			} else if requestId != test.requestId {
+				t.Errorf("Request ID %q doesn't equal expected %q", requestId, test.requestId)
+			}
+
+			if zotaApi.depositRequestId != requestId {
+				t.Errorf("Deposit request ID %q doesn't equal expected %q", zotaApi.depositRequestId, requestId)
+			}
+		})
+	}
+}
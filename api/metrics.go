@@ -0,0 +1,27 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/federlizer/alokin-zota-integration/internal/metrics"
+)
+
+// instrument records the route, status and duration of every request in
+// the metrics
+func instrument(appMetrics *metrics.Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		// Requests that didn't match a route would each get their own series
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		appMetrics.HTTPRequest(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+	}
+}
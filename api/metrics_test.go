@@ -0,0 +1,85 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/internal/events"
+	"github.com/federlizer/alokin-zota-integration/internal/metrics"
+	"github.com/federlizer/alokin-zota-integration/internal/poller"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	zotaApi.depositResponse = &zota.ZotaDepositResponse{
+		Code: "200",
+		Data: &zota.ZotaDepositData{
+			OrderId:    "32ab44f0a5d9d6b6c0c9e41b1a9c7ea1a2b3c4d5",
+			DepositUrl: "https://zota.com/deposit",
+		},
+	}
+	orderRepo := createOrderRepo()
+	payoutRepo := createPayoutRepo()
+	orderPoller := poller.New(zotaApi, orderRepo, payoutRepo, storage.NewMemoryPollSchedule(), poller.FixedPolicy{Interval: time.Minute, MaxAttempts: 20})
+	appMetrics := metrics.New()
+	engine := SetupApi(Dependencies{
+		ZotaAPI:          zotaApi,
+		OrderRepo:        orderRepo,
+		PayoutRepo:       payoutRepo,
+		UserRepo:         createUserRepo(t),
+		IdempotencyStore: storage.NewMemoryIdempotencyStore(),
+		WebhookRepo:      storage.NewMemoryWebhookRepo(),
+		Bus:              events.NewBus(),
+		Poller:           orderPoller,
+		Metrics:          appMetrics,
+		Config:           createConfig(),
+	})
+
+	postOrder(t, engine, `{"description": "Test order", "amount": 13.37}`)
+
+	zotaApi.depositErr = &zota.APIError{HTTPStatus: 400, Code: "400", Message: "Invalid amount"}
+	postOrder(t, engine, `{"description": "Test order", "amount": 13.37}`)
+
+	zotaApi.depositErr = &zota.APIError{HTTPStatus: 503, Code: "503"}
+	postOrder(t, engine, `{"description": "Test order", "amount": 13.37}`)
+
+	req, _ := http.NewRequest("GET", "/unknown/e31edd0d", nil)
+	engine.ServeHTTP(httptest.NewRecorder(), req)
+
+	resWriter := httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	engine.ServeHTTP(resWriter, req)
+
+	if resWriter.Code != http.StatusOK {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusOK)
+	}
+
+	if !strings.HasPrefix(resWriter.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Content type %q isn't Prometheus' text format", resWriter.Header().Get("Content-Type"))
+	}
+
+	body := resWriter.Body.String()
+	for _, expected := range []string{
+		`alokin_orders_created_total{outcome="submitted"} 1`,
+		`alokin_orders_created_total{outcome="rejected"} 1`,
+		`alokin_orders_created_total{outcome="failed"} 1`,
+		`alokin_http_requests_total{method="POST",route="/order",status="302"} 1`,
+		`alokin_http_requests_total{method="POST",route="/order",status="400"} 1`,
+		`alokin_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`alokin_http_request_duration_seconds_count{method="POST",route="/order"} 3`,
+	} {
+		if !strings.Contains(body, expected+"\n") {
+			t.Errorf("Metrics %q don't contain %q", body, expected)
+		}
+	}
+
+	// Order IDs in paths don't end up in the metrics
+	if strings.Contains(body, "e31edd0d") {
+		t.Errorf("Metrics %q contain a request's path", body)
+	}
+}
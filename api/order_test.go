@@ -0,0 +1,276 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+type orderDetails struct {
+	Order         *internal.Order         `json:"order"`
+	StatusHistory []internal.StatusChange `json:"statusHistory"`
+}
+
+// addUserOrder adds a pending order, already accepted by Zota, made by the
+// user logged in with testToken
+func addUserOrder(t *testing.T, orderRepo storage.OrderRepo, userRepo storage.UserRepo) *internal.Order {
+	user, err := userRepo.GetUserByEmail("federlizer@protonmail.com")
+	if err != nil {
+		t.Fatalf("Failed to get user: %q\n", err)
+	}
+
+	amount, _ := internal.NewMoney(1337, "USD")
+	order := internal.NewOrder(user, amount, "Test order")
+	order.ZotaOrderId = "32ab44f0a5d9d6b6c0c9e41b1a9c7ea1a2b3c4d5"
+	order.DepositUrl = "https://zota.com/deposit"
+	order.PaymentStatus = internal.PaymentStatusSubmitted
+
+	err = orderRepo.AddOrder(order)
+	if err != nil {
+		t.Fatalf("Failed to add order: %q\n", err)
+	}
+
+	return order
+}
+
+func parseOrderDetails(t *testing.T, body []byte) orderDetails {
+	var details orderDetails
+	err := json.Unmarshal(body, &details)
+	if err != nil {
+		t.Fatalf("Failed to parse order: %q\n", err)
+	}
+
+	return details
+}
+
+func TestGetOrder(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	orderRepo := createOrderRepo()
+	userRepo := createUserRepo(t)
+	engine := setupTestApiWithUsers(zotaApi, orderRepo, createPayoutRepo(), userRepo, createConfig())
+
+	order := addUserOrder(t, orderRepo, userRepo)
+	orderRepo.SetProcessorTransactionId(order.Id.String(), "PTX-13371337")
+	orderRepo.UpdateStatus(order.Id.String(), internal.PaymentStatusSubmitted, internal.StatusChange{
+		Status: internal.PaymentStatusApproved,
+		Source: internal.StatusSourceCallback,
+	})
+
+	resWriter := sendRequest(t, engine, "GET", "/order/"+order.Id.String(), testToken, "")
+	if resWriter.Code != http.StatusOK {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusOK)
+	}
+
+	details := parseOrderDetails(t, resWriter.Body.Bytes())
+	if details.Order.Id != order.Id || details.Order.ZotaOrderId != order.ZotaOrderId || details.Order.DepositUrl != order.DepositUrl {
+		t.Errorf("Order %+v doesn't equal expected %+v", *details.Order, *order)
+	}
+
+	if details.Order.ProcessorTransactionId != "PTX-13371337" {
+		t.Errorf("Processor transaction ID %q doesn't equal expected %q", details.Order.ProcessorTransactionId, "PTX-13371337")
+	}
+
+	if len(details.StatusHistory) != 2 || details.StatusHistory[1].Status != internal.PaymentStatusApproved ||
+		details.StatusHistory[1].Source != internal.StatusSourceCallback {
+		t.Errorf("Status history %+v doesn't contain every transition", details.StatusHistory)
+	}
+}
+
+func TestGetOrderNotFound(t *testing.T) {
+	orderRepo := createOrderRepo()
+	engine := setupTestApi(t, createZotaAPIMock(), orderRepo, createPayoutRepo(), createConfig())
+
+	// Orders of other users are hidden
+	otherOrder := createTestOrder()
+	orderRepo.AddOrder(otherOrder)
+
+	for _, id := range []string{otherOrder.Id.String(), "e31edd0d-76a6-4f1c-be19-4504ff5b89d7"} {
+		resWriter := sendRequest(t, engine, "GET", "/order/"+id, testToken, "")
+		if resWriter.Code != http.StatusNotFound {
+			t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestGetOrderRefresh(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	zotaApi.orderStatusResponse = &zota.ZotaOrderStatusResponse{
+		Code: "200",
+		Data: &zota.ZotaOrderStatusData{
+			Status:                 zota.Approved,
+			ProcessorTransactionId: "PTX-13371337",
+			Amount:                 "13.37",
+			Currency:               "USD",
+		},
+	}
+	orderRepo := createOrderRepo()
+	userRepo := createUserRepo(t)
+	engine := setupTestApiWithUsers(zotaApi, orderRepo, createPayoutRepo(), userRepo, createConfig())
+
+	order := addUserOrder(t, orderRepo, userRepo)
+
+	// Without refresh, the stored status is returned as is
+	resWriter := sendRequest(t, engine, "GET", "/order/"+order.Id.String(), testToken, "")
+	details := parseOrderDetails(t, resWriter.Body.Bytes())
+	if details.Order.PaymentStatus != internal.PaymentStatusSubmitted {
+		t.Errorf("Order status %q doesn't equal expected %q", details.Order.PaymentStatus, internal.PaymentStatusSubmitted)
+	}
+
+	resWriter = sendRequest(t, engine, "GET", "/order/"+order.Id.String()+"?refresh=true", testToken, "")
+	if resWriter.Code != http.StatusOK {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusOK)
+	}
+
+	details = parseOrderDetails(t, resWriter.Body.Bytes())
+	if details.Order.PaymentStatus != internal.PaymentStatusApproved || details.Order.ProcessorTransactionId != "PTX-13371337" {
+		t.Errorf("Order %+v wasn't refreshed", *details.Order)
+	}
+
+	if len(details.StatusHistory) != 2 || details.StatusHistory[1].Source != internal.StatusSourceManual {
+		t.Errorf("Status history %+v doesn't contain every transition", details.StatusHistory)
+	}
+
+	stored := getStoredOrder(t, orderRepo, order.Id.String())
+	if stored.PaymentStatus != internal.PaymentStatusApproved || stored.ProcessorTransactionId != "PTX-13371337" {
+		t.Errorf("Refreshed order %+v wasn't stored", *stored)
+	}
+}
+
+func TestGetOrderRefreshErrors(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	zotaApi.orderStatusErr = errors.New("connection refused")
+	orderRepo := createOrderRepo()
+	userRepo := createUserRepo(t)
+	engine := setupTestApiWithUsers(zotaApi, orderRepo, createPayoutRepo(), userRepo, createConfig())
+
+	order := addUserOrder(t, orderRepo, userRepo)
+
+	tests := []struct {
+		query        string
+		expectedCode int
+	}{
+		{"?refresh=true", http.StatusBadGateway},
+		{"?refresh=false", http.StatusOK},
+		{"?refresh=maybe", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		resWriter := sendRequest(t, engine, "GET", "/order/"+order.Id.String()+test.query, testToken, "")
+		if resWriter.Code != test.expectedCode {
+			t.Errorf("Server response %d doesn't equal expected %d for %v", resWriter.Code, test.expectedCode, test.query)
+		}
+	}
+}
+
+type orderList struct {
+	Orders     []*internal.Order `json:"orders"`
+	NextCursor string            `json:"nextCursor"`
+}
+
+func TestGetOrdersFiltersAndPages(t *testing.T) {
+	orderRepo := createOrderRepo()
+	userRepo := createUserRepo(t)
+	engine := setupTestApiWithUsers(createZotaAPIMock(), orderRepo, createPayoutRepo(), userRepo, createConfig())
+
+	var orders []*internal.Order
+	for i := 0; i < 3; i++ {
+		order := addUserOrder(t, orderRepo, userRepo)
+		orders = append(orders, order)
+	}
+	orderRepo.UpdateStatus(orders[1].Id.String(), internal.PaymentStatusSubmitted, internal.StatusChange{Status: internal.PaymentStatusApproved})
+
+	tests := []struct {
+		query       string
+		expectedIds []string
+	}{
+		{"?status=approved", []string{orders[1].Id.String()}},
+		{"?status=SUBMITTED,DECLINED&sort=createdAt", []string{orders[0].Id.String(), orders[2].Id.String()}},
+		{"?currency=usd&minAmount=13.37&maxAmount=13.37&limit=1", []string{orders[2].Id.String()}},
+		{"?currency=EUR", []string{}},
+		{"?createdTo=2000-01-01T00:00:00Z", []string{}},
+	}
+
+	for _, test := range tests {
+		resWriter := sendRequest(t, engine, "GET", "/order"+test.query, testToken, "")
+		if resWriter.Code != http.StatusOK {
+			t.Fatalf("Server response %d doesn't equal expected %d for %v", resWriter.Code, http.StatusOK, test.query)
+		}
+
+		var list orderList
+		json.Unmarshal(resWriter.Body.Bytes(), &list)
+
+		ids := []string{}
+		for _, order := range list.Orders {
+			ids = append(ids, order.Id.String())
+		}
+
+		if !reflect.DeepEqual(ids, test.expectedIds) {
+			t.Errorf("Orders %v don't equal expected %v for %v", ids, test.expectedIds, test.query)
+		}
+	}
+
+	// Page through the orders, newest first
+	var paged []string
+	var firstCursor string
+	target := "/order?limit=2"
+	for target != "" {
+		resWriter := sendRequest(t, engine, "GET", target, testToken, "")
+
+		var list orderList
+		json.Unmarshal(resWriter.Body.Bytes(), &list)
+
+		for _, order := range list.Orders {
+			paged = append(paged, order.Id.String())
+		}
+
+		target = ""
+		if list.NextCursor != "" {
+			target = "/order?limit=2&cursor=" + list.NextCursor
+		}
+		if firstCursor == "" {
+			firstCursor = list.NextCursor
+		}
+	}
+
+	expected := []string{orders[2].Id.String(), orders[1].Id.String(), orders[0].Id.String()}
+	if !reflect.DeepEqual(paged, expected) {
+		t.Errorf("Paged orders %v don't equal expected %v", paged, expected)
+	}
+
+	// A cursor only continues the query it was returned by
+	for _, query := range []string{"?limit=2&sort=createdAt", "?limit=2&currency=USD"} {
+		resWriter := sendRequest(t, engine, "GET", "/order"+query+"&cursor="+firstCursor, testToken, "")
+		if resWriter.Code != http.StatusBadRequest {
+			t.Errorf("Server response %d doesn't equal expected %d for %v", resWriter.Code, http.StatusBadRequest, query)
+		}
+	}
+}
+
+func TestGetOrdersRejectsInvalidQuery(t *testing.T) {
+	engine := setupTestApi(t, createZotaAPIMock(), createOrderRepo(), createPayoutRepo(), createConfig())
+
+	tests := []string{
+		"?status=SHIPPED",
+		"?currency=XXX",
+		"?createdFrom=yesterday",
+		"?minAmount=10",
+		"?currency=USD&maxAmount=10.001",
+		"?sort=description",
+		"?limit=0",
+		"?limit=1000",
+		"?cursor=not-a-cursor",
+	}
+
+	for _, query := range tests {
+		resWriter := sendRequest(t, engine, "GET", "/order"+query, testToken, "")
+		if resWriter.Code != http.StatusBadRequest {
+			t.Errorf("Server response %d doesn't equal expected %d for %v", resWriter.Code, http.StatusBadRequest, query)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+type PayoutHandlerParams struct {
+	// UserId is the ID of the registered user the payout is made to
+	UserId      string `json:"userId" form:"userId" binding:"required,uuid"`
+	Description string `json:"description" form:"description" binding:"required,max=128"`
+	// Amount is kept as the decimal that was sent, so it can be parsed
+	// exactly instead of going through a float64
+	Amount json.Number `json:"amount" form:"amount" binding:"required"`
+
+	BankAccount struct {
+		BankCode      string `json:"bankCode" form:"bankCode"`
+		AccountNumber string `json:"accountNumber" form:"accountNumber" binding:"required"`
+		AccountName   string `json:"accountName" form:"accountName" binding:"required"`
+		BranchName    string `json:"branchName" form:"branchName"`
+		CountryCode   string `json:"countryCode" form:"countryCode" binding:"required,len=2"`
+	} `json:"bankAccount" form:"bankAccount" binding:"required"`
+}
+
+func getPayoutHandler(c *gin.Context) {
+	payoutRepo := dependencies(c).PayoutRepo
+
+	payout, err := payoutRepo.GetPayout(c.Param("id"))
+	// Don't let users find out about the payouts of others
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && payout.User.Id != authenticatedUser(c).Id) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Payout not found",
+		})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't get payout from payout repo", "payoutId", c.Param("id"), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to get payout",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, payout)
+}
+
+func payoutHandler(c *gin.Context) {
+	deps := dependencies(c)
+
+	var params PayoutHandlerParams
+	err := c.Bind(&params)
+	if err != nil {
+		slog.InfoContext(c.Request.Context(), "Couldn't parse form parameters", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid data",
+		})
+		return
+	}
+
+	amount, err := parseAmount(params.Amount, defaultCurrency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	registered, err := deps.UserRepo.GetUser(params.UserId)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Unknown user",
+		})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't get user of payout", "userId", params.UserId, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to get user",
+		})
+		return
+	}
+
+	user := customerFrom(c, registered)
+	bankAccount := internal.BankAccount{
+		BankCode:      params.BankAccount.BankCode,
+		AccountNumber: params.BankAccount.AccountNumber,
+		AccountName:   params.BankAccount.AccountName,
+		BranchName:    params.BankAccount.BranchName,
+		CountryCode:   params.BankAccount.CountryCode,
+	}
+
+	payout := internal.NewPayout(&user, &bankAccount, amount, params.Description)
+
+	// This call can only fail if there is a duplicate ID for a payout
+	err = deps.PayoutRepo.AddPayout(payout)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't add new payout to payout repo", "payoutId", payout.Id, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to add new payout to repo",
+		})
+		return
+	}
+
+	zotaPayoutRequest := zota.FromPayout(payout, deps.ZotaAPI.EndpointId(), deps.ZotaAPI.SecretKey(), deps.Config.MerchantUrls())
+
+	// Make request to Zota API
+	response, err := deps.ZotaAPI.PayoutContext(c.Request.Context(), zotaPayoutRequest)
+	if err == nil && (response == nil || response.Data == nil) {
+		// Not every IZotaAPI checks that an OK response has data
+		err = fmt.Errorf("%w: OK response with no data", zota.ErrMalformedResponse)
+	}
+	if err != nil {
+		// A payout Zota has rejected will never be paid out. On other errors,
+		// e.g. timeouts, Zota might have accepted it after all, so it's left
+		// pending for Zota's callback to finalise.
+		var apiErr *zota.APIError
+		if errors.As(err, &apiErr) && !apiErr.Temporary() {
+			updateErr := deps.PayoutRepo.UpdateStatus(payout.Id.String(), internal.PaymentStatusPending, internal.PaymentStatusError)
+			if updateErr != nil {
+				slog.ErrorContext(c.Request.Context(), "Couldn't mark payout as failed", "payoutId", payout.Id, "error", updateErr)
+			}
+		}
+
+		// Retrying the request could then pay out twice
+		if zotaMightHaveAccepted(err) {
+			slog.WarnContext(c.Request.Context(), "Zota might have accepted the payout, keeping it pending", "payoutId", payout.Id, "error", err)
+			keepIdempotencyKey(c)
+		}
+
+		zotaErrorResponse(c, err)
+		return
+	}
+
+	err = deps.PayoutRepo.SetZotaOrderId(payout.Id.String(), response.Data.OrderId)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't store Zota order", "payoutId", payout.Id, "zotaOrderId", response.Data.OrderId, "error", err)
+	}
+	payout.ZotaOrderId = response.Data.OrderId
+
+	// Start Payout Status polling
+	err = deps.Poller.Track(c.Request.Context(), storage.PollKindPayout, payout.Id.String(), response.Data.OrderId)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't start polling for payout", "payoutId", payout.Id, "error", err)
+	}
+
+	c.JSON(http.StatusCreated, payout)
+}
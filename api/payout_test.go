@@ -0,0 +1,171 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+const payoutRequestBody = `{
+	"userId": "6b1f3e0c-8f2a-4d5b-9c7e-1a2b3c4d5e6f",
+	"description": "Test payout",
+	"amount": 13.37,
+	"bankAccount": {
+		"accountNumber": "DK5000400440116243",
+		"accountName": "Nikola Velichkov",
+		"countryCode": "DK"
+	}
+}`
+
+// postPayout makes a payout as the admin
+func postPayout(t *testing.T, handler http.Handler, body string) *httptest.ResponseRecorder {
+	resWriter := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/admin/payout", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to init request: %q\n", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+
+	handler.ServeHTTP(resWriter, req)
+
+	return resWriter
+}
+
+func TestPayoutEndpoint(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	zotaApi.payoutResponse = &zota.ZotaPayoutResponse{
+		Code: "200",
+		Data: &zota.ZotaPayoutData{OrderId: "9697960f4561f634dd5363590e55c93586a3721e"},
+	}
+	payoutRepo := createPayoutRepo()
+
+	engine := setupTestApi(t, zotaApi, createOrderRepo(), payoutRepo, createConfig())
+
+	resWriter := postPayout(t, engine, payoutRequestBody)
+	if resWriter.Code != http.StatusCreated {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusCreated)
+	}
+
+	var payout internal.Payout
+	err := json.Unmarshal(resWriter.Body.Bytes(), &payout)
+	if err != nil {
+		t.Fatalf("Failed to parse response: %q\n", err)
+	}
+
+	if payout.PaymentStatus != internal.PaymentStatusPending {
+		t.Errorf("Payout status %q doesn't equal expected %q", payout.PaymentStatus, internal.PaymentStatusPending)
+	}
+
+	stored, err := payoutRepo.GetPayout(payout.Id.String())
+	if err != nil || stored.ZotaOrderId != "9697960f4561f634dd5363590e55c93586a3721e" {
+		t.Fatalf("Payout %v wasn't stored with Zota's order ID", payout.Id)
+	}
+
+	resWriter = httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/payout/"+payout.Id.String(), nil)
+	if err != nil {
+		t.Fatalf("Failed to init request: %q\n", err)
+	}
+
+	authorize(req)
+	engine.ServeHTTP(resWriter, req)
+
+	if resWriter.Code != http.StatusOK {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusOK)
+	}
+}
+
+func TestPayoutEndpointRejectsInvalidData(t *testing.T) {
+	engine := setupTestApi(t, createZotaAPIMock(), createOrderRepo(), createPayoutRepo(), createConfig())
+
+	resWriter := postPayout(t, engine, `{"description": "Test payout", "amount": 13.37}`)
+	if resWriter.Code != http.StatusBadRequest {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusBadRequest)
+	}
+}
+
+func TestGetPayoutNotFound(t *testing.T) {
+	engine := setupTestApi(t, createZotaAPIMock(), createOrderRepo(), createPayoutRepo(), createConfig())
+
+	resWriter := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/payout/e31edd0d-76a6-4f1c-be19-4504ff5b89d7", nil)
+	if err != nil {
+		t.Fatalf("Failed to init request: %q\n", err)
+	}
+
+	authorize(req)
+	engine.ServeHTTP(resWriter, req)
+
+	if resWriter.Code != http.StatusNotFound {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusNotFound)
+	}
+}
+
+func TestZotaCallbackFinalisesPayout(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	payoutRepo := createPayoutRepo()
+
+	user := internal.User{Email: "federlizer@protonmail.com"}
+	bankAccount := internal.BankAccount{AccountNumber: "DK5000400440116243"}
+	amount, _ := internal.NewMoney(1337, "USD")
+	payout := internal.NewPayout(&user, &bankAccount, amount, "Test payout")
+	payout.ZotaOrderId = "9697960f4561f634dd5363590e55c93586a3721e"
+	payoutRepo.AddPayout(payout)
+
+	engine := setupTestApi(t, zotaApi, createOrderRepo(), payoutRepo, createConfig())
+
+	callback := zota.ZotaCallback{
+		Type:            zota.CallbackTypePayout,
+		Status:          zota.Approved,
+		EndpointId:      zotaApi.EndpointId(),
+		OrderId:         payout.ZotaOrderId,
+		MerchantOrderId: payout.Id.String(),
+		Amount:          payout.AmountStr(),
+		Currency:        "USD",
+		CustomerEmail:   payout.User.Email,
+	}
+	callback.Signature = callback.GenSignature(zotaApi.EndpointId(), zotaApi.SecretKey())
+
+	resWriter := postCallback(t, engine, callback)
+	if resWriter.Code != http.StatusOK {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusOK)
+	}
+
+	payout = getStoredPayout(t, payoutRepo, payout.Id.String())
+	if payout.PaymentStatus != internal.PaymentStatusApproved {
+		t.Errorf("Payout status %q doesn't equal expected %q", payout.PaymentStatus, internal.PaymentStatusApproved)
+	}
+}
+
+func TestPayoutEndpointRequiresAdmin(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	payoutRepo := createPayoutRepo()
+	engine := setupTestApi(t, zotaApi, createOrderRepo(), payoutRepo, createConfig())
+
+	// Users can't pay themselves out to whichever bank account they like
+	resWriter := sendRequest(t, engine, "POST", "/admin/payout", testToken, payoutRequestBody)
+	if resWriter.Code != http.StatusForbidden {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusForbidden)
+	}
+
+	payouts, _ := payoutRepo.GetAll()
+	if len(payouts) != 0 {
+		t.Errorf("Number of payouts %d doesn't equal expected %d", len(payouts), 0)
+	}
+}
+
+func TestPayoutEndpointRejectsUnknownUser(t *testing.T) {
+	engine := setupTestApi(t, createZotaAPIMock(), createOrderRepo(), createPayoutRepo(), createConfig())
+
+	body := strings.Replace(payoutRequestBody, testUserId, "e31edd0d-76a6-4f1c-be19-4504ff5b89d7", 1)
+	resWriter := postPayout(t, engine, body)
+	if resWriter.Code != http.StatusBadRequest {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+// depositReturnHandler handles the customer being redirected back from
+// Zota's deposit page. The status in the redirect is only informative, so the
+// order's status is checked with Zota before sending the customer to the
+// success, failure or pending page.
+func depositReturnHandler(c *gin.Context) {
+	deps := dependencies(c)
+
+	var redirect zota.ZotaRedirect
+	err := c.ShouldBindQuery(&redirect)
+	if err != nil {
+		slog.InfoContext(c.Request.Context(), "Couldn't parse Zota redirect parameters", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid data",
+		})
+		return
+	}
+
+	if !redirect.VerifySignature(deps.ZotaAPI.SecretKey()) {
+		slog.WarnContext(c.Request.Context(), "Received Zota redirect with an invalid signature", "orderId", redirect.MerchantOrderId)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid signature",
+		})
+		return
+	}
+
+	order, err := deps.OrderRepo.GetOrder(redirect.MerchantOrderId)
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && order.ZotaOrderId != "" && order.ZotaOrderId != redirect.OrderId) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Order not found",
+		})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't get order from order repo", "orderId", redirect.MerchantOrderId, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to get order",
+		})
+		return
+	}
+
+	// The deposit request might have timed out after Zota had accepted it
+	if order.ZotaOrderId == "" && redirect.OrderId != "" {
+		recordZotaOrderId(c.Request.Context(), deps, storage.PollKindDeposit, order.Id.String(), redirect.OrderId, order.PaymentStatus)
+		order.ZotaOrderId = redirect.OrderId
+	}
+
+	if !order.PaymentStatus.IsFinal() {
+		err = syncOrderStatus(c.Request.Context(), deps.ZotaAPI, deps.OrderRepo, order, redirect.OrderId, internal.StatusSourceRedirect)
+		if err != nil {
+			// The polling goroutine will pick up the status eventually
+			slog.WarnContext(c.Request.Context(), "Couldn't check order status", "orderId", order.Id, "error", err)
+		}
+	}
+
+	pageUrl := ""
+	switch {
+	case order.PaymentStatus == internal.PaymentStatusApproved:
+		pageUrl = deps.Config.SuccessPageUrl
+	case order.PaymentStatus.IsFinal():
+		pageUrl = deps.Config.FailurePageUrl
+	default:
+		pageUrl = deps.Config.PendingPageUrl
+	}
+
+	if pageUrl == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"orderId":       order.Id,
+			"paymentStatus": order.PaymentStatus,
+		})
+		return
+	}
+
+	c.Redirect(http.StatusFound, withOrderId(pageUrl, order.Id.String()))
+}
+
+// syncOrderStatus checks the order's status with Zota right away. The
+// processor transaction ID Zota reports is stored and, if the order has moved
+// on, so is its status, recorded as reported by source. The order is updated
+// to match what has been stored.
+//
+// Only the error of the request to Zota is returned, failing to store the
+// results is logged.
+func syncOrderStatus(ctx context.Context, zotaApi zota.IZotaAPI, orderRepo storage.OrderRepo, order *internal.Order, zotaOrderId, source string) error {
+	logger := slog.With("orderId", order.Id, "zotaOrderId", zotaOrderId)
+	request := zota.NewZotaOrderStatusRequest(zotaOrderId, order.Id.String())
+
+	zosr, err := zotaApi.OrderStatusContext(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	if zosr.Data != nil && zosr.Data.ProcessorTransactionId != "" && zosr.Data.ProcessorTransactionId != order.ProcessorTransactionId {
+		err = orderRepo.SetProcessorTransactionId(order.Id.String(), zosr.Data.ProcessorTransactionId)
+		if err != nil {
+			logger.ErrorContext(ctx, "Couldn't store processor transaction ID of order", "error", err)
+		} else {
+			order.ProcessorTransactionId = zosr.Data.ProcessorTransactionId
+		}
+	}
+
+	if zosr.Data == nil {
+		return nil
+	}
+
+	change := internal.StatusChange{Source: source, ErrorMessage: zosr.Data.ErrorMessage}
+	change.Status, err = zosr.Data.PaymentStatusFor(order.Amount)
+	if err != nil {
+		logger.WarnContext(ctx, "Failing order", "error", err)
+		change.ErrorMessage = err.Error()
+	}
+
+	if !order.PaymentStatus.CanTransitionTo(change.Status) {
+		return nil
+	}
+
+	err = orderRepo.UpdateStatus(order.Id.String(), order.PaymentStatus, change)
+	if errors.Is(err, storage.ErrStatusConflict) {
+		// Someone else has changed the order in the meantime, use their status
+		current, err := orderRepo.GetOrder(order.Id.String())
+		if err != nil {
+			logger.ErrorContext(ctx, "Couldn't get concurrently changed order", "error", err)
+			return nil
+		}
+
+		order.PaymentStatus = current.PaymentStatus
+		return nil
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Couldn't update status of order", "error", err)
+		return nil
+	}
+
+	logger.InfoContext(ctx, "Order received status from Zota", "status", zosr.Data.Status, "source", source)
+	order.PaymentStatus = change.Status
+	return nil
+}
+
+// withOrderId appends the order's ID to the page URL's query parameters
+func withOrderId(pageUrl, orderId string) string {
+	parsedUrl, err := url.Parse(pageUrl)
+	if err != nil {
+		return pageUrl
+	}
+
+	query := parsedUrl.Query()
+	query.Set("orderId", orderId)
+	parsedUrl.RawQuery = query.Encode()
+
+	return parsedUrl.String()
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+func createSignedRedirectUrl(api zota.IZotaAPI, order *internal.Order, status zota.OrderStatus) string {
+	redirect := zota.ZotaRedirect{
+		Status:          status,
+		OrderId:         order.ZotaOrderId,
+		MerchantOrderId: order.Id.String(),
+	}
+
+	query := url.Values{}
+	query.Set("status", string(redirect.Status))
+	query.Set("orderID", redirect.OrderId)
+	query.Set("merchantOrderID", redirect.MerchantOrderId)
+	query.Set("signature", redirect.GenSignature(api.SecretKey()))
+
+	return "/deposit/return?" + query.Encode()
+}
+
+func createOrderStatusResponse(order *internal.Order, status zota.OrderStatus) *zota.ZotaOrderStatusResponse {
+	return &zota.ZotaOrderStatusResponse{
+		Code: "200",
+		Data: &zota.ZotaOrderStatusData{
+			Status:          status,
+			OrderId:         order.ZotaOrderId,
+			MerchantOrderId: order.Id.String(),
+			Amount:          order.AmountStr(),
+			Currency:        order.Amount.Currency(),
+		},
+	}
+}
+
+func getDepositReturn(t *testing.T, handler http.Handler, target string) *httptest.ResponseRecorder {
+	resWriter := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		t.Fatalf("Failed to init request: %q\n", err)
+	}
+
+	handler.ServeHTTP(resWriter, req)
+
+	return resWriter
+}
+
+func TestDepositReturnRedirectsToSuccessPage(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	orderRepo := createOrderRepo()
+	order := createTestOrder()
+	orderRepo.AddOrder(order)
+	zotaApi.orderStatusResponse = createOrderStatusResponse(order, zota.Approved)
+
+	config := createConfig()
+	config.SuccessPageUrl = "https://federlizer.com/success"
+	config.FailurePageUrl = "https://federlizer.com/failure"
+	engine := setupTestApi(t, zotaApi, orderRepo, createPayoutRepo(), config)
+
+	resWriter := getDepositReturn(t, engine, createSignedRedirectUrl(zotaApi, order, zota.Approved))
+	if resWriter.Code != http.StatusFound {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusFound)
+	}
+
+	expectedLocation := "https://federlizer.com/success?orderId=" + order.Id.String()
+	if resWriter.Header().Get("Location") != expectedLocation {
+		t.Errorf("Redirect location %q doesn't equal expected %q", resWriter.Header().Get("Location"), expectedLocation)
+	}
+
+	order = getStoredOrder(t, orderRepo, order.Id.String())
+	if order.PaymentStatus != internal.PaymentStatusApproved {
+		t.Errorf("Order status %q doesn't equal expected %q", order.PaymentStatus, internal.PaymentStatusApproved)
+	}
+}
+
+func TestDepositReturnTrustsZotaOverRedirectStatus(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	orderRepo := createOrderRepo()
+	order := createTestOrder()
+	orderRepo.AddOrder(order)
+	zotaApi.orderStatusErr = errors.New("Zota is unreachable")
+
+	config := createConfig()
+	config.SuccessPageUrl = "https://federlizer.com/success"
+	config.PendingPageUrl = "https://federlizer.com/pending"
+	engine := setupTestApi(t, zotaApi, orderRepo, createPayoutRepo(), config)
+
+	resWriter := getDepositReturn(t, engine, createSignedRedirectUrl(zotaApi, order, zota.Approved))
+	if resWriter.Code != http.StatusFound {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusFound)
+	}
+
+	expectedLocation := "https://federlizer.com/pending?orderId=" + order.Id.String()
+	if resWriter.Header().Get("Location") != expectedLocation {
+		t.Errorf("Redirect location %q doesn't equal expected %q", resWriter.Header().Get("Location"), expectedLocation)
+	}
+
+	order = getStoredOrder(t, orderRepo, order.Id.String())
+	if order.PaymentStatus != internal.PaymentStatusSubmitted {
+		t.Errorf("Order status %q doesn't equal expected %q", order.PaymentStatus, internal.PaymentStatusSubmitted)
+	}
+}
+
+func TestDepositReturnRejectsInvalidSignature(t *testing.T) {
+	zotaApi := createZotaAPIMock()
+	orderRepo := createOrderRepo()
+	order := createTestOrder()
+	orderRepo.AddOrder(order)
+
+	engine := setupTestApi(t, zotaApi, orderRepo, createPayoutRepo(), createConfig())
+
+	// Pretend someone tried to approve a declined order
+	target := createSignedRedirectUrl(zotaApi, order, zota.Declined)
+	target = strings.Replace(target, "status=DECLINED", "status=APPROVED", 1)
+
+	resWriter := getDepositReturn(t, engine, target)
+	if resWriter.Code != http.StatusUnauthorized {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/federlizer/alokin-zota-integration/internal/logging"
+)
+
+// describeSpan names the span otelgin has started for the request after its
+// method and route, instead of just the route, and tags it with the
+// request's ID. The span is set on the request's context, so the requests
+// made to Zota and the repository calls made while handling the request are
+// its children.
+func describeSpan(c *gin.Context) {
+	// Requests that didn't match a route would each get their own name
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+
+	ctx := c.Request.Context()
+	span := trace.SpanFromContext(ctx)
+	span.SetName(c.Request.Method + " " + route)
+	span.SetAttributes(attribute.String(logging.RequestIdKey, logging.RequestId(ctx)))
+}
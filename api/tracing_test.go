@@ -0,0 +1,95 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/federlizer/alokin-zota-integration/internal/events"
+	"github.com/federlizer/alokin-zota-integration/internal/poller"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+	"github.com/federlizer/alokin-zota-integration/internal/tracing"
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+func TestRequestsAreTraced(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracing.SetDefault(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer tracing.SetDefault(noop.NewTracerProvider())
+
+	zotaApi := createZotaAPIMock()
+	zotaApi.depositResponse = &zota.ZotaDepositResponse{
+		Code: "200",
+		Data: &zota.ZotaDepositData{
+			OrderId:    "32ab44f0a5d9d6b6c0c9e41b1a9c7ea1a2b3c4d5",
+			DepositUrl: "https://zota.com/deposit",
+		},
+	}
+	orderRepo := createOrderRepo()
+	payoutRepo := createPayoutRepo()
+	orderPoller := poller.New(zotaApi, orderRepo, payoutRepo, storage.NewMemoryPollSchedule(), poller.FixedPolicy{Interval: time.Minute, MaxAttempts: 20})
+	engine := SetupApi(Dependencies{
+		ZotaAPI:          zotaApi,
+		OrderRepo:        orderRepo,
+		PayoutRepo:       payoutRepo,
+		UserRepo:         createUserRepo(t),
+		IdempotencyStore: storage.NewMemoryIdempotencyStore(),
+		WebhookRepo:      storage.NewMemoryWebhookRepo(),
+		Bus:              events.NewBus(),
+		Poller:           orderPoller,
+		Config:           createConfig(),
+	})
+
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	resWriter := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/order", strings.NewReader(`{"description": "Test order", "amount": 13.37}`))
+	if err != nil {
+		t.Fatalf("Failed to init request: %q\n", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(tracing.TraceparentHeader, traceparent)
+
+	authorize(req)
+	engine.ServeHTTP(resWriter, req)
+
+	if resWriter.Code != http.StatusFound {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusFound)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	// The request continues the client's trace
+	server, found := spans["POST /order"]
+	if !found || server.SpanKind() != trace.SpanKindServer || server.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("Server span %+v doesn't continue the client's trace", server)
+	}
+	traceId := server.SpanContext().TraceID()
+
+	for _, name := range []string{"UserRepo.GetSession", "UserRepo.GetUser", "OrderRepo.AddOrder", "OrderRepo.SetZotaOrder"} {
+		span, found := spans[name]
+		if !found || span.SpanContext().TraceID() != traceId || span.Parent().SpanID() != server.SpanContext().SpanID() {
+			t.Errorf("Span %q %+v isn't a child of the server span", name, span)
+		}
+	}
+
+	// The deposit request is made within the request's trace
+	if zotaApi.depositSpan.TraceID() != traceId {
+		t.Errorf("Deposit was requested in trace %q instead of %q", zotaApi.depositSpan.TraceID(), traceId)
+	}
+
+	// The poller's checks are linked to the request
+	entries, _ := orderPoller.Tracked()
+	if len(entries) != 1 || !strings.HasPrefix(entries[0].TraceParent, "00-"+traceId.String()+"-") {
+		t.Errorf("Poll entries %+v aren't linked to the request's trace", entries)
+	}
+}
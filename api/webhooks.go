@@ -0,0 +1,184 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+	"github.com/federlizer/alokin-zota-integration/internal/webhooks"
+)
+
+type WebhookParams struct {
+	Url        string   `json:"url" form:"url" binding:"required,max=2048"`
+	EventTypes []string `json:"eventTypes" form:"eventTypes" binding:"required"`
+	// Secret is generated if it isn't given
+	Secret string `json:"secret" form:"secret" binding:"omitempty,min=16,max=128"`
+}
+
+// listWebhooksHandler returns every webhook subscription, without their secrets
+func listWebhooksHandler(c *gin.Context) {
+	webhookRepo := dependencies(c).WebhookRepo
+
+	subscriptions, err := webhookRepo.GetSubscriptions()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't get webhook subscriptions", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to get webhook subscriptions",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"subscriptions": subscriptions,
+	})
+}
+
+// createWebhookHandler subscribes a URL to order events. The response is the
+// only time the subscription's secret is returned.
+func createWebhookHandler(c *gin.Context) {
+	webhookRepo := dependencies(c).WebhookRepo
+
+	var params WebhookParams
+	err := c.Bind(&params)
+	if err != nil {
+		slog.InfoContext(c.Request.Context(), "Couldn't parse form parameters", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid data",
+		})
+		return
+	}
+
+	subscription, err := webhooks.NewSubscription(params.Url, params.EventTypes, params.Secret)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	err = webhookRepo.AddSubscription(subscription)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't add webhook subscription", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to add webhook subscription",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, struct {
+		*storage.WebhookSubscription
+		Secret string `json:"secret"`
+	}{subscription, subscription.Secret})
+}
+
+// getWebhookDeliveriesHandler returns the deliveries of a subscription,
+// optionally only those in the state given by ?state=, e.g. the dead letters
+// with ?state=dead
+func getWebhookDeliveriesHandler(c *gin.Context) {
+	webhookRepo := dependencies(c).WebhookRepo
+
+	state := c.Query("state")
+	if state != "" && state != storage.DeliveryPending && state != storage.DeliveryDelivered && state != storage.DeliveryDead {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid state, expected pending, delivered or dead",
+		})
+		return
+	}
+
+	subscription, ok := getWebhookSubscription(c, webhookRepo)
+	if !ok {
+		return
+	}
+
+	deliveries, err := webhookRepo.GetDeliveries(subscription.Id, state)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't get deliveries of webhook subscription", "subscriptionId", subscription.Id, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to get webhook deliveries",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries": deliveries,
+	})
+}
+
+// replayWebhookHandler queues the dead letters of a subscription again, e.g.
+// once the subscriber's endpoint has been fixed
+func replayWebhookHandler(c *gin.Context) {
+	webhookRepo := dependencies(c).WebhookRepo
+
+	subscription, ok := getWebhookSubscription(c, webhookRepo)
+	if !ok {
+		return
+	}
+
+	if subscription.Disabled {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "Webhook subscription is disabled",
+		})
+		return
+	}
+
+	replayed, err := webhookRepo.ReplayDeliveries(subscription.Id, time.Now())
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't replay deliveries of webhook subscription", "subscriptionId", subscription.Id, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to replay webhook deliveries",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"replayed": replayed,
+	})
+}
+
+// disableWebhookHandler stops sending events to a subscription. Its queued
+// deliveries end up in the dead letters instead of being sent.
+func disableWebhookHandler(c *gin.Context) {
+	webhookRepo := dependencies(c).WebhookRepo
+
+	subscription, ok := getWebhookSubscription(c, webhookRepo)
+	if !ok {
+		return
+	}
+
+	err := webhookRepo.DisableSubscription(subscription.Id)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't disable webhook subscription", "subscriptionId", subscription.Id, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to disable webhook subscription",
+		})
+		return
+	}
+	subscription.Disabled = true
+
+	c.JSON(http.StatusOK, subscription)
+}
+
+// getWebhookSubscription gets the subscription with the ID in the path.
+// Otherwise, an error response is sent and false is returned.
+func getWebhookSubscription(c *gin.Context, webhookRepo storage.WebhookRepo) (*storage.WebhookSubscription, bool) {
+	subscription, err := webhookRepo.GetSubscription(c.Param("id"))
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Webhook subscription not found",
+		})
+		return nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Couldn't get webhook subscription", "subscriptionId", c.Param("id"), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to get webhook subscription",
+		})
+		return nil, false
+	}
+
+	return subscription, true
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/federlizer/alokin-zota-integration/internal/events"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+)
+
+const testAdminToken = "admin-token"
+
+func setupWebhookTestApi(t *testing.T, webhookRepo storage.WebhookRepo) *gin.Engine {
+	config := createConfig()
+	config.AdminToken = testAdminToken
+
+	return SetupApi(Dependencies{
+		ZotaAPI:          createZotaAPIMock(),
+		OrderRepo:        createOrderRepo(),
+		PayoutRepo:       createPayoutRepo(),
+		UserRepo:         createUserRepo(t),
+		IdempotencyStore: storage.NewMemoryIdempotencyStore(),
+		WebhookRepo:      webhookRepo,
+		Bus:              events.NewBus(),
+		Config:           config,
+	})
+}
+
+func TestCreateWebhook(t *testing.T) {
+	webhookRepo := storage.NewMemoryWebhookRepo()
+	engine := setupWebhookTestApi(t, webhookRepo)
+
+	resWriter := sendRequest(t, engine, "POST", "/admin/webhooks", testAdminToken, `{
+		"url": "https://merchant.com/webhooks",
+		"eventTypes": ["order.approved", "order.failed"]
+	}`)
+	if resWriter.Code != http.StatusCreated {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusCreated)
+	}
+
+	var created struct {
+		Id         string   `json:"id"`
+		EventTypes []string `json:"eventTypes"`
+		Secret     string   `json:"secret"`
+	}
+	err := json.Unmarshal(resWriter.Body.Bytes(), &created)
+	if err != nil {
+		t.Fatalf("Failed to parse subscription: %q\n", err)
+	}
+
+	stored, err := webhookRepo.GetSubscription(created.Id)
+	if err != nil {
+		t.Fatalf("Failed to get subscription: %q\n", err)
+	}
+
+	if created.Secret == "" || created.Secret != stored.Secret || len(created.EventTypes) != 2 {
+		t.Errorf("Created subscription %+v doesn't equal stored %+v", created, *stored)
+	}
+
+	// The secret is only returned when the subscription is created
+	resWriter = sendRequest(t, engine, "GET", "/admin/webhooks", testAdminToken, "")
+	if resWriter.Code != http.StatusOK {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusOK)
+	}
+
+	var list struct {
+		Subscriptions []map[string]any `json:"subscriptions"`
+	}
+	json.Unmarshal(resWriter.Body.Bytes(), &list)
+	if len(list.Subscriptions) != 1 || list.Subscriptions[0]["id"] != created.Id {
+		t.Errorf("Subscriptions %+v don't contain the created subscription", list.Subscriptions)
+	}
+
+	if _, found := list.Subscriptions[0]["secret"]; found {
+		t.Errorf("Listed subscription contains its secret")
+	}
+}
+
+func TestCreateWebhookRejectsInvalidData(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"missing URL", `{"eventTypes": ["order.approved"]}`},
+		{"relative URL", `{"url": "/webhooks", "eventTypes": ["order.approved"]}`},
+		{"unknown event type", `{"url": "https://merchant.com/webhooks", "eventTypes": ["order.pending"]}`},
+		{"short secret", `{"url": "https://merchant.com/webhooks", "eventTypes": ["order.approved"], "secret": "secret"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			engine := setupWebhookTestApi(t, storage.NewMemoryWebhookRepo())
+
+			resWriter := sendRequest(t, engine, "POST", "/admin/webhooks", testAdminToken, test.body)
+			if resWriter.Code != http.StatusBadRequest {
+				t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestWebhookEndpointsRequireAdminToken(t *testing.T) {
+	engine := setupWebhookTestApi(t, storage.NewMemoryWebhookRepo())
+
+	resWriter := sendRequest(t, engine, "GET", "/admin/webhooks", "", "")
+	if resWriter.Code != http.StatusUnauthorized {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusUnauthorized)
+	}
+
+	resWriter = sendRequest(t, engine, "GET", "/admin/webhooks", testToken, "")
+	if resWriter.Code != http.StatusForbidden {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusForbidden)
+	}
+}
+
+func TestReplayAndDisableWebhook(t *testing.T) {
+	webhookRepo := storage.NewMemoryWebhookRepo()
+	engine := setupWebhookTestApi(t, webhookRepo)
+
+	now := time.Now()
+	subscription := &storage.WebhookSubscription{
+		Id:         "subscription-1",
+		Url:        "https://merchant.com/webhooks",
+		Secret:     "secret",
+		EventTypes: []string{"order.approved"},
+		CreatedAt:  now,
+	}
+	webhookRepo.AddSubscription(subscription)
+	webhookRepo.AddDelivery(&storage.WebhookDelivery{
+		Id:             "delivery-1",
+		SubscriptionId: subscription.Id,
+		EventType:      "order.approved",
+		Payload:        []byte(`{}`),
+		State:          storage.DeliveryDead,
+		Attempts:       15,
+		LastError:      "Webhook endpoint responded with 500",
+		CreatedAt:      now,
+	})
+
+	resWriter := sendRequest(t, engine, "GET", "/admin/webhooks/subscription-1/deliveries?state=dead", testAdminToken, "")
+	if resWriter.Code != http.StatusOK {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusOK)
+	}
+
+	var deadLetters struct {
+		Deliveries []storage.WebhookDelivery `json:"deliveries"`
+	}
+	json.Unmarshal(resWriter.Body.Bytes(), &deadLetters)
+	if len(deadLetters.Deliveries) != 1 || deadLetters.Deliveries[0].LastError == "" {
+		t.Errorf("Dead letters %+v don't contain the dead delivery", deadLetters.Deliveries)
+	}
+
+	resWriter = sendRequest(t, engine, "GET", "/admin/webhooks/subscription-1/deliveries?state=lost", testAdminToken, "")
+	if resWriter.Code != http.StatusBadRequest {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusBadRequest)
+	}
+
+	resWriter = sendRequest(t, engine, "POST", "/admin/webhooks/subscription-1/replay", testAdminToken, "")
+	if resWriter.Code != http.StatusOK {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusOK)
+	}
+
+	deliveries, _ := webhookRepo.GetDeliveries(subscription.Id, storage.DeliveryPending)
+	if len(deliveries) != 1 || deliveries[0].Attempts != 0 {
+		t.Errorf("Deliveries %+v haven't been queued again", deliveries)
+	}
+
+	resWriter = sendRequest(t, engine, "POST", "/admin/webhooks/subscription-1/disable", testAdminToken, "")
+	if resWriter.Code != http.StatusOK {
+		t.Fatalf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusOK)
+	}
+
+	stored, _ := webhookRepo.GetSubscription(subscription.Id)
+	if !stored.Disabled {
+		t.Errorf("Subscription hasn't been disabled")
+	}
+
+	resWriter = sendRequest(t, engine, "POST", "/admin/webhooks/subscription-1/replay", testAdminToken, "")
+	if resWriter.Code != http.StatusConflict {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusConflict)
+	}
+
+	resWriter = sendRequest(t, engine, "POST", "/admin/webhooks/unknown/disable", testAdminToken, "")
+	if resWriter.Code != http.StatusNotFound {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, http.StatusNotFound)
+	}
+}
@@ -1,32 +1,486 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 
 	"github.com/federlizer/alokin-zota-integration/api"
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/events"
+	"github.com/federlizer/alokin-zota-integration/internal/logging"
+	"github.com/federlizer/alokin-zota-integration/internal/metrics"
+	"github.com/federlizer/alokin-zota-integration/internal/poller"
 	"github.com/federlizer/alokin-zota-integration/internal/storage"
+	"github.com/federlizer/alokin-zota-integration/internal/tracing"
+	"github.com/federlizer/alokin-zota-integration/internal/webhooks"
 	"github.com/federlizer/alokin-zota-integration/zota"
 )
 
 func main() {
+	logLevel, err := logging.ParseLevel(getEnv("ALOKIN_LOG_LEVEL", "info"))
+	if err != nil {
+		panic(err)
+	}
+
+	logger, err := logging.New(os.Stderr, logLevel, getEnv("ALOKIN_LOG_FORMAT", logging.FormatText))
+	if err != nil {
+		panic(err)
+	}
+	slog.SetDefault(logger)
+
 	zotaSecretKey := os.Getenv("ZOTA_SECRET_KEY")
 	zotaEndpointId := os.Getenv("ZOTA_ENDPOINT_ID")
 	zotaMerchantId := os.Getenv("ZOTA_MERCHANT_ID")
 	zotaBaseUrl := os.Getenv("ZOTA_BASE_URL")
 
-	zotaApi := zota.NewZotaAPI(
+	zotaEndpoints, err := parseEndpointIds(zotaEndpointId, os.Getenv("ZOTA_ENDPOINT_IDS"))
+	if err != nil {
+		panic(err)
+	}
+
+	zotaTimeout, err := time.ParseDuration(getEnv("ZOTA_TIMEOUT", zota.DefaultTimeout.String()))
+	if err != nil {
+		panic(err)
+	}
+
+	appMetrics := metrics.New()
+
+	// Set before the API and the Zota client are created, which only trace
+	// with the provider that's set by then
+	tracerProvider, err := newTracerProvider(getEnv("ALOKIN_TRACE_EXPORTER", "none"))
+	if err != nil {
+		panic(err)
+	}
+	if tracerProvider != nil {
+		tracing.SetDefault(tracerProvider)
+	}
+
+	shutdownTimeout, err := time.ParseDuration(getEnv("ALOKIN_SHUTDOWN_TIMEOUT", "30s"))
+	if err != nil {
+		panic(err)
+	}
+
+	zotaApi, err := zota.NewZotaAPI(
 		zotaSecretKey,
 		zotaEndpointId,
 		zotaMerchantId,
 		zotaBaseUrl,
+		zota.WithTimeout(zotaTimeout),
+		zota.WithEndpoints(zotaEndpoints),
+		zota.WithMetrics(appMetrics),
 	)
+	if err != nil {
+		panic(err)
+	}
+
+	sessionTTL, err := time.ParseDuration(getEnv("ALOKIN_SESSION_TTL", api.DefaultSessionTTL.String()))
+	if err != nil {
+		panic(err)
+	}
+
+	idempotencyTTL, err := time.ParseDuration(getEnv("ALOKIN_IDEMPOTENCY_TTL", api.DefaultIdempotencyTTL.String()))
+	if err != nil {
+		panic(err)
+	}
+
+	checkZotaReadiness, err := strconv.ParseBool(getEnv("ALOKIN_READYZ_CHECK_ZOTA", "false"))
+	if err != nil {
+		panic(err)
+	}
+
+	config := api.Config{
+		PublicUrl:      getEnv("ALOKIN_PUBLIC_URL", "http://localhost:8080"),
+		CheckoutUrl:    os.Getenv("ALOKIN_CHECKOUT_URL"),
+		SuccessPageUrl: os.Getenv("ALOKIN_SUCCESS_PAGE_URL"),
+		FailurePageUrl: os.Getenv("ALOKIN_FAILURE_PAGE_URL"),
+		PendingPageUrl: os.Getenv("ALOKIN_PENDING_PAGE_URL"),
+		SessionTTL:     sessionTTL,
+		TrustedProxies: splitList(os.Getenv("ALOKIN_TRUSTED_PROXIES")),
+		IdempotencyTTL: idempotencyTTL,
+		AdminToken:     os.Getenv("ALOKIN_ADMIN_TOKEN"),
+
+		CheckZotaReadiness: checkZotaReadiness,
+	}
+
+	err = config.ValidateUrls()
+	if err != nil {
+		panic(err)
+	}
+
+	repos, err := newRepositories(getEnv("ALOKIN_STORAGE", "memory"))
+	if err != nil {
+		panic(err)
+	}
+
+	// Every payment status change is published, no matter who makes it
+	bus := events.NewBus()
+	repos.orders = events.PublishingOrderRepo(repos.orders, bus)
+	repos.orders = metrics.InstrumentedOrderRepo(repos.orders, appMetrics)
+
+	// Webhook subscribers are notified whenever an order reaches a final status
+	backoff, err := newWebhookBackoff()
+	if err != nil {
+		panic(err)
+	}
+
+	webhookTimeout, err := time.ParseDuration(getEnv("ALOKIN_WEBHOOK_TIMEOUT", "10s"))
+	if err != nil {
+		panic(err)
+	}
+
+	dispatcher := webhooks.New(repos.webhooks, repos.orders, &http.Client{Timeout: webhookTimeout}, backoff)
+	err = dispatcher.Start()
+	if err != nil {
+		panic(err)
+	}
 
-	orderRepo := storage.NewOrderRepo()
+	retryPolicy, err := newRetryPolicy(getEnv("ALOKIN_POLL_POLICY", "exponential"))
+	if err != nil {
+		panic(err)
+	}
 
-	engine := api.SetupApi(zotaApi, *orderRepo)
-	addr := ":8080"
-	err := engine.Run(addr)
+	orderPoller := poller.New(zotaApi, repos.orders, repos.payouts, repos.pollSchedule, retryPolicy, poller.WithMetrics(appMetrics))
+	err = orderPoller.Start()
 	if err != nil {
 		panic(err)
 	}
+
+	engine := api.SetupApi(api.Dependencies{
+		ZotaAPI:          zotaApi,
+		OrderRepo:        repos.orders,
+		PayoutRepo:       repos.payouts,
+		UserRepo:         repos.users,
+		IdempotencyStore: repos.idempotency,
+		WebhookRepo:      repos.webhooks,
+		Bus:              bus,
+		Poller:           orderPoller,
+		Metrics:          appMetrics,
+		Config:           config,
+	})
+	server := &http.Server{
+		Addr:    ":8080",
+		Handler: engine,
+	}
+	// Open event streams would hold up the shutdown until it times out
+	server.RegisterOnShutdown(bus.Close)
+
+	// Orchestrators stop the application with SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("Listening for requests", "addr", server.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	failed := false
+	select {
+	case err := <-serverErr:
+		slog.Error("Server stopped unexpectedly", "error", err)
+		failed = true
+	case <-ctx.Done():
+		slog.Info("Shutting down", "timeout", shutdownTimeout)
+	}
+
+	// A second signal kills the application right away
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if !shutdown(shutdownCtx, server, orderPoller, dispatcher, tracerProvider, repos) || failed {
+		cancel()
+		os.Exit(1)
+	}
+}
+
+// shutdown stops the application before ctx is done. The server stops
+// accepting requests and lets the active ones finish, the poller and the
+// webhook dispatcher finish the check and delivery in progress, the spans
+// that haven't been exported yet are exported and the database is closed.
+// Whatever doesn't finish in time is aborted, and false is returned.
+//
+// Logs are written unbuffered and metrics are scraped, so neither of them
+// needs to be flushed.
+func shutdown(ctx context.Context, server *http.Server, orderPoller *poller.Poller, dispatcher *webhooks.Dispatcher, tracerProvider *sdktrace.TracerProvider, repos *repositories) bool {
+	clean := true
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		slog.Warn("Couldn't finish the active requests, closing their connections", "error", err)
+		server.Close()
+		clean = false
+	}
+
+	// Aborted checks aren't counted as attempts, the schedule is kept, so
+	// polling continues where it left off once the application is started
+	// again. Polling is stopped first, since finalising an order queues its
+	// webhook deliveries.
+	err = orderPoller.Shutdown(ctx)
+	if err != nil {
+		slog.Warn("Couldn't finish the order status check in progress", "error", err)
+		clean = false
+	}
+
+	// Aborted deliveries stay queued
+	err = dispatcher.Shutdown(ctx)
+	if err != nil {
+		slog.Warn("Couldn't finish the webhook delivery in progress", "error", err)
+		clean = false
+	}
+
+	if tracerProvider != nil {
+		err = tracerProvider.Shutdown(ctx)
+		if err != nil {
+			slog.Warn("Couldn't export the remaining spans", "error", err)
+			clean = false
+		}
+	}
+
+	if repos.db != nil {
+		err = repos.db.Close()
+		if err != nil {
+			slog.Warn("Couldn't close the database", "error", err)
+			clean = false
+		}
+	}
+
+	slog.Info("Shut down", "clean", clean)
+
+	return clean
+}
+
+// repositories holds the storage used by the application
+type repositories struct {
+	orders       storage.OrderRepo
+	payouts      storage.PayoutRepo
+	users        storage.UserRepo
+	idempotency  storage.IdempotencyStore
+	webhooks     storage.WebhookRepo
+	pollSchedule storage.PollSchedule
+	// db is the database of the repositories, nil if they're kept in memory
+	db *sql.DB
+}
+
+// newRepositories creates the repositories for the given storage backend
+func newRepositories(backend string) (*repositories, error) {
+	switch backend {
+	case "memory":
+		return &repositories{
+			orders:       storage.NewMemoryOrderRepo(),
+			payouts:      storage.NewMemoryPayoutRepo(),
+			users:        storage.NewMemoryUserRepo(),
+			idempotency:  storage.NewMemoryIdempotencyStore(),
+			webhooks:     storage.NewMemoryWebhookRepo(),
+			pollSchedule: storage.NewMemoryPollSchedule(),
+		}, nil
+	case "sqlite":
+		db, err := storage.OpenSQLite(getEnv("ALOKIN_SQLITE_PATH", "alokin.db"))
+		if err != nil {
+			return nil, err
+		}
+
+		return &repositories{
+			orders:       storage.NewSQLiteOrderRepo(db),
+			payouts:      storage.NewSQLitePayoutRepo(db),
+			users:        storage.NewSQLiteUserRepo(db),
+			idempotency:  storage.NewSQLiteIdempotencyStore(db),
+			webhooks:     storage.NewSQLiteWebhookRepo(db),
+			pollSchedule: storage.NewSQLitePollSchedule(db),
+			db:           db,
+		}, nil
+	default:
+		return nil, fmt.Errorf("Unknown storage backend %q", backend)
+	}
+}
+
+// parseEndpointIds returns the Zota endpoint ID of every currency deposits
+// can be made in. usdEndpointId is used for USD, unless value overrides it.
+// value lists additional endpoints as comma separated CURRENCY=ENDPOINT_ID
+// pairs, e.g. "EUR=222222,GBP=333333".
+func parseEndpointIds(usdEndpointId, value string) (map[string]string, error) {
+	endpoints := map[string]string{}
+	if usdEndpointId != "" {
+		endpoints["USD"] = usdEndpointId
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		currency, endpointId, found := strings.Cut(pair, "=")
+		currency = strings.ToUpper(strings.TrimSpace(currency))
+		endpointId = strings.TrimSpace(endpointId)
+		if !found || endpointId == "" {
+			return nil, fmt.Errorf("Invalid Zota endpoint %q: expected CURRENCY=ENDPOINT_ID", pair)
+		}
+
+		_, err := internal.CurrencyExponent(currency)
+		if err != nil {
+			return nil, err
+		}
+
+		endpoints[currency] = endpointId
+	}
+
+	return endpoints, nil
+}
+
+// newRetryPolicy creates the poller's retry policy of the given kind, either
+// "fixed" or "exponential", from the ALOKIN_POLL_* environment variables
+func newRetryPolicy(kind string) (poller.RetryPolicy, error) {
+	interval, err := time.ParseDuration(getEnv("ALOKIN_POLL_INTERVAL", "10s"))
+	if err != nil {
+		return nil, err
+	}
+
+	maxAttempts, err := strconv.Atoi(getEnv("ALOKIN_POLL_MAX_ATTEMPTS", "20"))
+	if err != nil {
+		return nil, err
+	}
+
+	var policy poller.RetryPolicy
+	switch kind {
+	case "fixed":
+		policy = poller.FixedPolicy{Interval: interval, MaxAttempts: maxAttempts}
+	case "exponential":
+		maxInterval, err := time.ParseDuration(getEnv("ALOKIN_POLL_MAX_INTERVAL", "5m"))
+		if err != nil {
+			return nil, err
+		}
+
+		multiplier, err := strconv.ParseFloat(getEnv("ALOKIN_POLL_MULTIPLIER", "2"), 64)
+		if err != nil {
+			return nil, err
+		}
+
+		jitter, err := strconv.ParseFloat(getEnv("ALOKIN_POLL_JITTER", "0.2"), 64)
+		if err != nil {
+			return nil, err
+		}
+
+		if maxInterval <= 0 || multiplier < 1 || jitter < 0 || jitter > 1 {
+			return nil, fmt.Errorf("Invalid exponential poll policy: max interval must be positive, multiplier at least 1 and jitter between 0 and 1")
+		}
+
+		policy = poller.ExponentialPolicy{
+			Initial:     interval,
+			Max:         maxInterval,
+			Multiplier:  multiplier,
+			Jitter:      jitter,
+			MaxAttempts: maxAttempts,
+		}
+	default:
+		return nil, fmt.Errorf("Unknown poll policy %q", kind)
+	}
+
+	deadline := os.Getenv("ALOKIN_POLL_DEADLINE")
+	if deadline == "" {
+		return policy, nil
+	}
+
+	deadlineDuration, err := time.ParseDuration(deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	return poller.DeadlinePolicy{Policy: policy, Deadline: deadlineDuration}, nil
+}
+
+// newWebhookBackoff creates the retry backoff of webhook deliveries from the
+// ALOKIN_WEBHOOK_* environment variables
+func newWebhookBackoff() (webhooks.Backoff, error) {
+	backoff := webhooks.DefaultBackoff
+
+	interval, err := time.ParseDuration(getEnv("ALOKIN_WEBHOOK_RETRY_INTERVAL", backoff.Initial.String()))
+	if err != nil {
+		return backoff, err
+	}
+
+	maxInterval, err := time.ParseDuration(getEnv("ALOKIN_WEBHOOK_MAX_RETRY_INTERVAL", backoff.Max.String()))
+	if err != nil {
+		return backoff, err
+	}
+
+	maxAttempts, err := strconv.Atoi(getEnv("ALOKIN_WEBHOOK_MAX_ATTEMPTS", strconv.Itoa(backoff.MaxAttempts)))
+	if err != nil {
+		return backoff, err
+	}
+
+	if maxAttempts < 1 {
+		return backoff, fmt.Errorf("Invalid webhook backoff: at least one attempt has to be made")
+	}
+
+	backoff.Initial = interval
+	backoff.Max = maxInterval
+	backoff.MaxAttempts = maxAttempts
+
+	return backoff, nil
+}
+
+// newTracerProvider creates the provider that exports spans with the
+// exporter of the given kind, either "none", "stdout" or "otlp". No provider
+// is created for "none", which disables tracing.
+func newTracerProvider(kind string) (*sdktrace.TracerProvider, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch kind {
+	case "none":
+		return nil, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		// The endpoint is the collector's base URL, like the collector's own
+		// OTEL_EXPORTER_OTLP_ENDPOINT
+		endpoint := strings.TrimSuffix(getEnv("ALOKIN_OTLP_ENDPOINT", "http://localhost:4318"), "/") + "/v1/traces"
+		exporter, err = otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint), otlptracehttp.WithTimeout(10*time.Second))
+	default:
+		return nil, fmt.Errorf("Unknown trace exporter %q", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return tracing.NewTracerProvider(exporter), nil
+}
+
+// getEnv returns the value of the environment variable named by the key,
+// or fallback if the variable is not set
+func getEnv(key, fallback string) string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+
+	return value
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
 }
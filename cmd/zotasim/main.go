@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/federlizer/alokin-zota-integration/zota/zotasim"
+)
+
+// zotasim serves a fake Zota API, configured with the same ZOTA_* environment
+// variables as the API server, so both can be started with the same
+// environment. Point the API server's ZOTA_BASE_URL at it.
+func main() {
+	zotaSecretKey := getEnv("ZOTA_SECRET_KEY", "zotasim-secret")
+	zotaMerchantId := getEnv("ZOTA_MERCHANT_ID", "ZOTASIM")
+	zotaEndpointId := getEnv("ZOTA_ENDPOINT_ID", "100000")
+
+	endpoints, err := parseEndpointIds(zotaEndpointId, os.Getenv("ZOTA_ENDPOINT_IDS"))
+	if err != nil {
+		panic(err)
+	}
+
+	script, err := zotasim.ParseScript(getEnv("ZOTASIM_SCRIPT", "PROCESSING:2s,APPROVED:3s"))
+	if err != nil {
+		panic(err)
+	}
+
+	options := []zotasim.Option{zotasim.WithScript(script...)}
+	if publicUrl := os.Getenv("ZOTASIM_PUBLIC_URL"); publicUrl != "" {
+		options = append(options, zotasim.WithPublicUrl(publicUrl))
+	}
+
+	if getEnv("ZOTASIM_CALLBACKS", "true") == "false" {
+		options = append(options, zotasim.WithoutCallbacks())
+	}
+
+	server := zotasim.New(zotaMerchantId, zotaSecretKey, endpoints, options...)
+	defer server.Close()
+
+	addr := getEnv("ZOTASIM_ADDR", ":8081")
+	slog.Info("Simulating Zota", "merchantId", zotaMerchantId, "endpoints", endpoints, "addr", addr)
+
+	err = http.ListenAndServe(addr, server)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// parseEndpointIds returns the endpoint ID of every currency, read the same
+// way as the API server does: usdEndpointId is used for USD, unless value,
+// a comma separated list of CURRENCY=ENDPOINT_ID pairs, overrides it
+func parseEndpointIds(usdEndpointId, value string) (map[string]string, error) {
+	endpoints := map[string]string{}
+	if usdEndpointId != "" {
+		endpoints["USD"] = usdEndpointId
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		currency, endpointId, found := strings.Cut(pair, "=")
+		currency = strings.ToUpper(strings.TrimSpace(currency))
+		endpointId = strings.TrimSpace(endpointId)
+		if !found || currency == "" || endpointId == "" {
+			return nil, fmt.Errorf("Invalid Zota endpoint %q: expected CURRENCY=ENDPOINT_ID", pair)
+		}
+
+		endpoints[currency] = endpointId
+	}
+
+	return endpoints, nil
+}
+
+// getEnv returns the value of the environment variable named by the key,
+// or fallback if the variable is not set
+func getEnv(key, fallback string) string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+
+	return value
+}
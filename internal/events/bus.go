@@ -0,0 +1,123 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+)
+
+// subscriptionBuffer is the number of events a subscriber can fall behind
+// before it's dropped
+const subscriptionBuffer = 16
+
+// OrderEvent is published whenever an order changes its payment status
+type OrderEvent struct {
+	OrderId   string                 `json:"orderId"`
+	From      internal.PaymentStatus `json:"from"`
+	Status    internal.PaymentStatus `json:"status"`
+	ChangedAt time.Time              `json:"changedAt"`
+	// Source and ErrorMessage are those of the internal.StatusChange
+	Source       string `json:"source"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+}
+
+// Bus delivers the published order events to every subscriber.
+//
+// Publishing never blocks - a subscriber that doesn't keep up with the events
+// is dropped, which closes its channel.
+type Bus struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]bool
+	closed      bool
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subscribers: make(map[*Subscription]bool),
+	}
+}
+
+// Subscription receives the events of a single order, or of every order
+type Subscription struct {
+	bus     *Bus
+	orderId string
+	events  chan OrderEvent
+}
+
+// Subscribe starts receiving the events of the order with the given ID, or
+// of every order if it's empty. The subscription has to be closed once it's
+// not needed anymore.
+func (b *Bus) Subscribe(orderId string) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subscription := &Subscription{
+		bus:     b,
+		orderId: orderId,
+		events:  make(chan OrderEvent, subscriptionBuffer),
+	}
+
+	if b.closed {
+		close(subscription.events)
+		return subscription
+	}
+	b.subscribers[subscription] = true
+
+	return subscription
+}
+
+// Close drops every subscription, which ends the event streams, e.g. when
+// the server is shutting down. Subscriptions made afterwards are closed
+// right away.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for subscription := range b.subscribers {
+		b.remove(subscription)
+	}
+}
+
+// Publish sends the event to every subscriber of the order
+func (b *Bus) Publish(event OrderEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for subscription := range b.subscribers {
+		if subscription.orderId != "" && subscription.orderId != event.OrderId {
+			continue
+		}
+
+		select {
+		case subscription.events <- event:
+		default:
+			b.remove(subscription)
+		}
+	}
+}
+
+// remove closes the subscription, the caller needs to hold the lock
+func (b *Bus) remove(subscription *Subscription) {
+	if !b.subscribers[subscription] {
+		return
+	}
+
+	delete(b.subscribers, subscription)
+	close(subscription.events)
+}
+
+// Events returns the channel the events are received on. It's closed when
+// the subscription is closed or has been dropped for falling behind.
+func (s *Subscription) Events() <-chan OrderEvent {
+	return s.events
+}
+
+// Close stops receiving events, it's safe to call more than once
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	s.bus.remove(s)
+}
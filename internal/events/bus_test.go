@@ -0,0 +1,133 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+)
+
+func TestBusDeliversEventsOfSubscribedOrder(t *testing.T) {
+	bus := NewBus()
+
+	order := bus.Subscribe("order-1")
+	defer order.Close()
+	all := bus.Subscribe("")
+	defer all.Close()
+
+	bus.Publish(OrderEvent{OrderId: "order-2", Status: internal.PaymentStatusApproved})
+	bus.Publish(OrderEvent{OrderId: "order-1", Status: internal.PaymentStatusDeclined})
+
+	event := <-order.Events()
+	if event.OrderId != "order-1" || event.Status != internal.PaymentStatusDeclined {
+		t.Errorf("Event %+v isn't the event of the subscribed order", event)
+	}
+
+	if len(order.Events()) != 0 {
+		t.Errorf("Subscription received %d events of other orders", len(order.Events()))
+	}
+
+	if len(all.Events()) != 2 {
+		t.Errorf("Subscription to every order received %d events, expected %d", len(all.Events()), 2)
+	}
+}
+
+func TestBusDropsSlowSubscribers(t *testing.T) {
+	bus := NewBus()
+
+	slow := bus.Subscribe("")
+	defer slow.Close()
+
+	for i := 0; i < subscriptionBuffer+1; i++ {
+		bus.Publish(OrderEvent{OrderId: "order-1", Status: internal.PaymentStatusPending})
+	}
+
+	received := 0
+	for range slow.Events() {
+		received++
+	}
+
+	if received != subscriptionBuffer {
+		t.Errorf("Dropped subscription received %d events, expected %d", received, subscriptionBuffer)
+	}
+
+	// Publishing to the remaining subscribers still works
+	bus.Publish(OrderEvent{OrderId: "order-1", Status: internal.PaymentStatusApproved})
+}
+
+func TestSubscriptionClose(t *testing.T) {
+	bus := NewBus()
+
+	subscription := bus.Subscribe("order-1")
+	subscription.Close()
+	subscription.Close()
+
+	bus.Publish(OrderEvent{OrderId: "order-1", Status: internal.PaymentStatusApproved})
+
+	_, ok := <-subscription.Events()
+	if ok {
+		t.Errorf("Closed subscription received an event")
+	}
+}
+
+func TestPublishingOrderRepo(t *testing.T) {
+	bus := NewBus()
+	subscription := bus.Subscribe("")
+	defer subscription.Close()
+
+	repo := PublishingOrderRepo(storage.NewMemoryOrderRepo(), bus)
+
+	user := internal.User{Email: "federlizer@protonmail.com"}
+	amount, _ := internal.NewMoney(1337, "USD")
+	order := internal.NewOrder(&user, amount, "Test order")
+
+	err := repo.AddOrder(order)
+	if err != nil {
+		t.Fatalf("Failed to add order: %q\n", err)
+	}
+
+	// Neither invalid transitions nor conflicting updates are published
+	repo.UpdateStatus(order.Id.String(), internal.PaymentStatusCreated, internal.StatusChange{Status: internal.PaymentStatusCreated})
+	repo.UpdateStatus(order.Id.String(), internal.PaymentStatusPending, internal.StatusChange{Status: internal.PaymentStatusDeclined})
+
+	err = repo.UpdateStatus(order.Id.String(), internal.PaymentStatusCreated, internal.StatusChange{
+		Status: internal.PaymentStatusSubmitted,
+		Source: internal.StatusSourceApi,
+	})
+	if err != nil {
+		t.Fatalf("Failed to update status: %q\n", err)
+	}
+
+	if len(subscription.Events()) != 1 {
+		t.Fatalf("%d events were published, expected %d", len(subscription.Events()), 1)
+	}
+
+	event := <-subscription.Events()
+	if event.OrderId != order.Id.String() || event.From != internal.PaymentStatusCreated || event.Status != internal.PaymentStatusSubmitted ||
+		event.Source != internal.StatusSourceApi || event.ChangedAt.IsZero() {
+		t.Errorf("Event %+v doesn't match the status change", event)
+	}
+}
+
+func TestBusClose(t *testing.T) {
+	bus := NewBus()
+
+	subscription := bus.Subscribe("order-1")
+	bus.Close()
+
+	_, ok := <-subscription.Events()
+	if ok {
+		t.Errorf("Subscription received an event after the bus was closed")
+	}
+
+	// Subscriptions made after closing the bus end right away
+	late := bus.Subscribe("")
+	_, ok = <-late.Events()
+	if ok {
+		t.Errorf("Subscription received an event after the bus was closed")
+	}
+
+	late.Close()
+	subscription.Close()
+	bus.Publish(OrderEvent{OrderId: "order-1", Status: internal.PaymentStatusApproved})
+}
@@ -0,0 +1,45 @@
+package events
+
+import (
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+)
+
+// publishingOrderRepo publishes an OrderEvent for every payment status change
+// made through it
+type publishingOrderRepo struct {
+	storage.OrderRepo
+	bus *Bus
+}
+
+// PublishingOrderRepo wraps the order repo, so that every payment status
+// change, whether it's made by the poller, a Zota callback or the API, is
+// published on the bus
+func PublishingOrderRepo(repo storage.OrderRepo, bus *Bus) storage.OrderRepo {
+	return &publishingOrderRepo{OrderRepo: repo, bus: bus}
+}
+
+func (r *publishingOrderRepo) UpdateStatus(id string, expected internal.PaymentStatus, change internal.StatusChange) error {
+	// The event carries the same time as the status history
+	if change.ChangedAt.IsZero() {
+		change.ChangedAt = time.Now()
+	}
+
+	err := r.OrderRepo.UpdateStatus(id, expected, change)
+	if err != nil {
+		return err
+	}
+
+	r.bus.Publish(OrderEvent{
+		OrderId:      id,
+		From:         expected,
+		Status:       change.Status,
+		ChangedAt:    change.ChangedAt,
+		Source:       change.Source,
+		ErrorMessage: change.ErrorMessage,
+	})
+
+	return nil
+}
@@ -0,0 +1,126 @@
+// Package logging sets up the application's structured logger.
+//
+// Every record is stripped of secrets, signatures and customer PII by the
+// key of its attributes, see Redacted, and records logged with a context
+// carry the ID of the request they were logged for, see WithRequestId.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// RequestIdKey is the attribute key of the request ID added to records
+const RequestIdKey = "requestId"
+
+// Redacted replaces the value of sensitive attributes
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are the attribute keys whose values are never logged,
+// compared in lower case
+var sensitiveKeys = map[string]bool{
+	"secret":        true,
+	"secretkey":     true,
+	"signature":     true,
+	"token":         true,
+	"password":      true,
+	"authorization": true,
+	"cookie":        true,
+
+	"email":         true,
+	"customeremail": true,
+	"phone":         true,
+	"firstname":     true,
+	"lastname":      true,
+	"address":       true,
+	"addressline":   true,
+	"ip":            true,
+	"ipaddr":        true,
+	"customerip":    true,
+	"accountnumber": true,
+	"accountname":   true,
+}
+
+// New creates a logger that writes records of at least the level to w in
+// the format, either FormatText or FormatJSON
+func New(w io.Writer, level slog.Level, format string) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case FormatText:
+		handler = slog.NewTextHandler(w, options)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf("Unknown log format %q", format)
+	}
+
+	return slog.New(contextHandler{handler}), nil
+}
+
+// ParseLevel parses a level name, e.g. "debug" or "WARN"
+func ParseLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(value))
+	if err != nil {
+		return level, fmt.Errorf("Unknown log level %q", value)
+	}
+
+	return level, nil
+}
+
+// redact replaces the values of sensitive attributes, in any group
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, Redacted)
+	}
+
+	return attr
+}
+
+type requestIdKey struct{}
+
+// WithRequestId returns a copy of ctx that carries the request ID. Records
+// logged with the context, or any context derived from it, include the ID.
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, requestId)
+}
+
+// RequestId returns the request ID ctx carries, if any
+func RequestId(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdKey{}).(string)
+	return requestId
+}
+
+// contextHandler adds the request ID of the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if requestId := RequestId(ctx); requestId != "" {
+		record.AddAttrs(slog.String(RequestIdKey, requestId))
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
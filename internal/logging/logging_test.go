@@ -0,0 +1,112 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+)
+
+func createTestLogger(t *testing.T, level slog.Level) (*slog.Logger, *bytes.Buffer) {
+	var buffer bytes.Buffer
+	logger, err := New(&buffer, level, FormatJSON)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %q\n", err)
+	}
+
+	return logger, &buffer
+}
+
+func TestLoggerRedactsSensitiveAttributes(t *testing.T) {
+	logger, buffer := createTestLogger(t, slog.LevelInfo)
+
+	user := internal.NewUser("federlizer@protonmail.com", "Nikola", "Velichkov", "+4550331329", internal.UserAddress{})
+	amount, _ := internal.NewMoney(1337, "USD")
+	order := internal.NewOrder(user, amount, "Test order")
+
+	logger.Info("Test record",
+		"customerEmail", "federlizer@protonmail.com",
+		"Signature", "4e8d2a6f",
+		slog.Group("request", "secretKey", "00000000-1111-2222-3333-444444444444", "orderId", "e31edd0d"),
+		"order", order,
+		"user", user,
+	)
+
+	output := buffer.String()
+	for _, secret := range []string{"federlizer@protonmail.com", "4e8d2a6f", "00000000-1111-2222-3333-444444444444", "Velichkov", "+4550331329"} {
+		if strings.Contains(output, secret) {
+			t.Errorf("Record %q contains %q", output, secret)
+		}
+	}
+
+	var record struct {
+		CustomerEmail string `json:"customerEmail"`
+		Request       struct {
+			SecretKey string `json:"secretKey"`
+			OrderId   string `json:"orderId"`
+		} `json:"request"`
+		Order struct {
+			Id string `json:"id"`
+		} `json:"order"`
+	}
+	err := json.Unmarshal(buffer.Bytes(), &record)
+	if err != nil {
+		t.Fatalf("Failed to parse record: %q\n", err)
+	}
+
+	if record.CustomerEmail != Redacted || record.Request.SecretKey != Redacted {
+		t.Errorf("Record %q doesn't mark the redacted attributes", output)
+	}
+
+	if record.Request.OrderId != "e31edd0d" || record.Order.Id != order.Id.String() {
+		t.Errorf("Record %q doesn't contain the order's ID", output)
+	}
+}
+
+func TestLoggerAddsRequestId(t *testing.T) {
+	logger, buffer := createTestLogger(t, slog.LevelDebug)
+
+	ctx := WithRequestId(context.Background(), "5b0f9a52")
+	logger.With("orderId", "e31edd0d").DebugContext(ctx, "Test record")
+	logger.Info("Record without a request")
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Logged %d records instead of %d", len(lines), 2)
+	}
+
+	if !strings.Contains(lines[0], `"requestId":"5b0f9a52"`) || !strings.Contains(lines[0], `"orderId":"e31edd0d"`) {
+		t.Errorf("Record %q doesn't contain the request ID", lines[0])
+	}
+
+	if strings.Contains(lines[1], RequestIdKey) {
+		t.Errorf("Record %q contains a request ID", lines[1])
+	}
+}
+
+func TestLoggerLevelAndFormat(t *testing.T) {
+	logger, buffer := createTestLogger(t, slog.LevelWarn)
+	logger.Info("Ignored record")
+	if buffer.Len() != 0 {
+		t.Errorf("Record %q below the level was logged", buffer.String())
+	}
+
+	level, err := ParseLevel("debug")
+	if err != nil || level != slog.LevelDebug {
+		t.Errorf("Parsed level %v, %v doesn't equal expected %v", level, err, slog.LevelDebug)
+	}
+
+	_, err = ParseLevel("verbose")
+	if err == nil {
+		t.Errorf("Expected level %q to be rejected", "verbose")
+	}
+
+	_, err = New(&bytes.Buffer{}, slog.LevelInfo, "xml")
+	if err == nil {
+		t.Errorf("Expected format %q to be rejected", "xml")
+	}
+}
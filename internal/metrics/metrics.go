@@ -0,0 +1,184 @@
+// Package metrics exposes how the integration behaves, e.g. how many orders
+// are created and how long Zota takes to respond, to Prometheus.
+//
+// Every method of Metrics can be called on a nil *Metrics, which doesn't
+// record anything, so components don't need to check whether they've been
+// given metrics.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Outcomes of creating an order, see Metrics.OrderCreated
+const (
+	// OutcomeSubmitted orders have been accepted by Zota
+	OutcomeSubmitted = "submitted"
+	// OutcomeRejected orders have been rejected by Zota and will never be paid
+	OutcomeRejected = "rejected"
+	// OutcomeFailed orders couldn't be submitted to Zota, e.g. because it
+	// timed out. Zota might have accepted them after all.
+	OutcomeFailed = "failed"
+)
+
+// CodeError is the code of Zota requests that didn't get a response
+const CodeError = "error"
+
+var (
+	latencyBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+	// Deposits take from seconds, when the customer pays right away, to
+	// hours, when they never do and polling gives up
+	finalStatusBuckets = []float64{10, 30, 60, 120, 300, 600, 1800, 3600, 7200, 21600, 86400}
+)
+
+// Metrics are the metrics of the integration. They're kept in a registry of
+// their own, rather than Prometheus' default one, so that every Metrics can be
+// scraped on its own.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	ordersCreated         *prometheus.CounterVec
+	zotaRequests          *prometheus.CounterVec
+	zotaLatency           *prometheus.HistogramVec
+	timeToFinalStatus     *prometheus.HistogramVec
+	pollsInFlight         *prometheus.GaugeVec
+	pollAttemptsExhausted *prometheus.CounterVec
+	httpRequests          *prometheus.CounterVec
+	httpLatency           *prometheus.HistogramVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		ordersCreated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "alokin_orders_created_total",
+			Help: "Orders created through the API, by whether Zota accepted them.",
+		}, []string{"outcome"}),
+		zotaRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "alokin_zota_requests_total",
+			Help: "Requests made to Zota's API, by endpoint and response code.",
+		}, []string{"endpoint", "code"}),
+		zotaLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "alokin_zota_request_duration_seconds",
+			Help:    "Time taken by requests to Zota's API.",
+			Buckets: latencyBuckets,
+		}, []string{"endpoint"}),
+		timeToFinalStatus: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "alokin_deposit_time_to_final_status_seconds",
+			Help:    "Time from creating a deposit until it reached a final status, by status.",
+			Buckets: finalStatusBuckets,
+		}, []string{"status"}),
+		pollsInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "alokin_polls_in_flight",
+			Help: "Orders and payouts the poller is checking with Zota, by kind.",
+		}, []string{"kind"}),
+		pollAttemptsExhausted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "alokin_poll_attempts_exhausted_total",
+			Help: "Orders and payouts the poller gave up on before they reached a final status, by kind.",
+		}, []string{"kind"}),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "alokin_http_requests_total",
+			Help: "Requests handled by the API, by method, route and response status.",
+		}, []string{"method", "route", "status"}),
+		httpLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "alokin_http_request_duration_seconds",
+			Help:    "Time taken to handle requests to the API, by method and route.",
+			Buckets: latencyBuckets,
+		}, []string{"method", "route"}),
+	}
+
+	m.registry.MustRegister(
+		m.ordersCreated,
+		m.zotaRequests,
+		m.zotaLatency,
+		m.timeToFinalStatus,
+		m.pollsInFlight,
+		m.pollAttemptsExhausted,
+		m.httpRequests,
+		m.httpLatency,
+		// The Go runtime's and the process' metrics, e.g. memory and open
+		// file descriptors
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// Handler serves the metrics to Prometheus' scrapes
+func (m *Metrics) Handler() http.Handler {
+	if m == nil {
+		return http.NotFoundHandler()
+	}
+
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// OrderCreated counts an order created through the API with the outcome,
+// e.g. OutcomeSubmitted
+func (m *Metrics) OrderCreated(outcome string) {
+	if m == nil {
+		return
+	}
+
+	m.ordersCreated.WithLabelValues(outcome).Inc()
+}
+
+// ZotaRequest records a request to Zota's endpoint, e.g. "deposit", which got
+// a response with the code, or CodeError if it didn't get a response
+func (m *Metrics) ZotaRequest(endpoint, code string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.zotaRequests.WithLabelValues(endpoint, code).Inc()
+	m.zotaLatency.WithLabelValues(endpoint).Observe(duration.Seconds())
+}
+
+// FinalStatus records how long a deposit took to reach its final status
+func (m *Metrics) FinalStatus(status string, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.timeToFinalStatus.WithLabelValues(status).Observe(elapsed.Seconds())
+}
+
+// SetPollsInFlight sets how many orders or payouts of the kind the poller is
+// checking
+func (m *Metrics) SetPollsInFlight(kind string, count int) {
+	if m == nil {
+		return
+	}
+
+	m.pollsInFlight.WithLabelValues(kind).Set(float64(count))
+}
+
+// PollAttemptsExhausted counts an order or payout of the kind the poller gave
+// up on
+func (m *Metrics) PollAttemptsExhausted(kind string) {
+	if m == nil {
+		return
+	}
+
+	m.pollAttemptsExhausted.WithLabelValues(kind).Inc()
+}
+
+// HTTPRequest records a request handled by the API. The route is the
+// pattern the request matched, e.g. "/order/:id", so that the number of
+// series doesn't grow with the number of orders.
+func (m *Metrics) HTTPRequest(method, route string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	m.httpLatency.WithLabelValues(method, route).Observe(duration.Seconds())
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	metrics := New()
+	metrics.OrderCreated(OutcomeSubmitted)
+	metrics.OrderCreated(OutcomeSubmitted)
+	metrics.ZotaRequest("deposit", "200", 500*time.Millisecond)
+	metrics.SetPollsInFlight(storage.PollKindDeposit, 3)
+	metrics.HTTPRequest("GET", "/order/:id", 200, 50*time.Millisecond)
+
+	resWriter := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(resWriter, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := resWriter.Body.String()
+	expected := []string{
+		"# TYPE alokin_orders_created_total counter",
+		`alokin_orders_created_total{outcome="submitted"} 2`,
+		`alokin_zota_requests_total{code="200",endpoint="deposit"} 1`,
+		`alokin_zota_request_duration_seconds_bucket{endpoint="deposit",le="0.5"} 1`,
+		`alokin_zota_request_duration_seconds_bucket{endpoint="deposit",le="0.25"} 0`,
+		`alokin_polls_in_flight{kind="deposit"} 3`,
+		`alokin_http_requests_total{method="GET",route="/order/:id",status="200"} 1`,
+		"go_goroutines",
+	}
+	for _, series := range expected {
+		if !strings.Contains(body, series) {
+			t.Errorf("Metrics %q don't contain %q", body, series)
+		}
+	}
+}
+
+func TestNilMetricsDontRecord(t *testing.T) {
+	var metrics *Metrics
+	metrics.OrderCreated(OutcomeSubmitted)
+	metrics.ZotaRequest("deposit", "200", time.Second)
+	metrics.SetPollsInFlight(storage.PollKindDeposit, 1)
+
+	resWriter := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(resWriter, httptest.NewRequest("GET", "/metrics", nil))
+	if resWriter.Code != 404 {
+		t.Errorf("Server response %d doesn't equal expected %d", resWriter.Code, 404)
+	}
+}
+
+func TestInstrumentedOrderRepoRecordsFinalStatus(t *testing.T) {
+	metrics := New()
+	orderRepo := InstrumentedOrderRepo(storage.NewMemoryOrderRepo(), metrics)
+
+	user := internal.NewUser("federlizer@protonmail.com", "Nikola", "Velichkov", "+4550331329", internal.UserAddress{})
+	amount, _ := internal.NewMoney(1337, "USD")
+	order := internal.NewOrder(user, amount, "Test order")
+	err := orderRepo.AddOrder(order)
+	if err != nil {
+		t.Fatalf("Failed to add order: %q\n", err)
+	}
+
+	for _, status := range []internal.PaymentStatus{internal.PaymentStatusSubmitted, internal.PaymentStatusApproved} {
+		current := getOrderStatus(t, orderRepo, order.Id.String())
+		err = orderRepo.UpdateStatus(order.Id.String(), current, internal.StatusChange{Status: status, Source: internal.StatusSourceApi})
+		if err != nil {
+			t.Fatalf("Failed to update status: %q\n", err)
+		}
+	}
+
+	resWriter := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(resWriter, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := resWriter.Body.String()
+	if !strings.Contains(body, `alokin_deposit_time_to_final_status_seconds_count{status="APPROVED"} 1`) {
+		t.Errorf("Metrics %q don't record the approved order", body)
+	}
+
+	if strings.Contains(body, `status="SUBMITTED"`) {
+		t.Errorf("Metrics %q record a status that isn't final", body)
+	}
+}
+
+func getOrderStatus(t *testing.T, orderRepo storage.OrderRepo, id string) internal.PaymentStatus {
+	order, err := orderRepo.GetOrder(id)
+	if err != nil {
+		t.Fatalf("Failed to get order %v: %q\n", id, err)
+	}
+
+	return order.PaymentStatus
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+)
+
+// instrumentedOrderRepo records how long every order that reaches a final
+// payment status through it took to get there
+type instrumentedOrderRepo struct {
+	storage.OrderRepo
+	metrics *Metrics
+}
+
+// InstrumentedOrderRepo wraps the order repo, so that the time it took orders
+// to reach a final payment status is recorded, whether it's set by the
+// poller, a Zota callback or the API
+func InstrumentedOrderRepo(repo storage.OrderRepo, metrics *Metrics) storage.OrderRepo {
+	return &instrumentedOrderRepo{OrderRepo: repo, metrics: metrics}
+}
+
+func (r *instrumentedOrderRepo) UpdateStatus(id string, expected internal.PaymentStatus, change internal.StatusChange) error {
+	if change.ChangedAt.IsZero() {
+		change.ChangedAt = time.Now()
+	}
+
+	err := r.OrderRepo.UpdateStatus(id, expected, change)
+	if err != nil || !change.Status.IsFinal() {
+		return err
+	}
+
+	// The status has been stored, failing to record it mustn't make the
+	// caller retry the update
+	order, err := r.OrderRepo.GetOrder(id)
+	if err != nil {
+		slog.Error("Couldn't get order to record its time to final status", "orderId", id, "error", err)
+		return nil
+	}
+
+	r.metrics.FinalStatus(string(change.Status), change.ChangedAt.Sub(order.CreatedAt))
+	return nil
+}
@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidAmount       = errors.New("Invalid amount")
+	ErrUnsupportedCurrency = errors.New("Unsupported currency")
+)
+
+// currencyExponents holds the number of digits after the decimal point of
+// the minor unit of every supported ISO 4217 currency
+var currencyExponents = map[string]int{
+	"AUD": 2,
+	"BHD": 3,
+	"BRL": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"CLP": 0,
+	"CNY": 2,
+	"CZK": 2,
+	"DKK": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"HKD": 2,
+	"HUF": 2,
+	"IDR": 2,
+	"INR": 2,
+	"ISK": 0,
+	"JOD": 3,
+	"JPY": 0,
+	"KES": 2,
+	"KRW": 0,
+	"KWD": 3,
+	"MXN": 2,
+	"MYR": 2,
+	"NGN": 2,
+	"NOK": 2,
+	"NZD": 2,
+	"OMR": 3,
+	"PHP": 2,
+	"PLN": 2,
+	"RON": 2,
+	"SEK": 2,
+	"SGD": 2,
+	"THB": 2,
+	"TND": 3,
+	"TRY": 2,
+	"UGX": 0,
+	"USD": 2,
+	"VND": 0,
+	"ZAR": 2,
+}
+
+// CurrencyExponent returns the number of digits after the decimal point of
+// the currency's minor unit, e.g. 2 for USD (cents) and 0 for JPY
+func CurrencyExponent(currency string) (int, error) {
+	exponent, ok := currencyExponents[currency]
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrUnsupportedCurrency, currency)
+	}
+
+	return exponent, nil
+}
+
+// Money is an exact amount of money in a currency, stored as an integer
+// number of the currency's minor units (e.g. cents)
+type Money struct {
+	minor    int64
+	currency string
+}
+
+// NewMoney creates an amount of minor units of the currency
+func NewMoney(minor int64, currency string) (Money, error) {
+	_, err := CurrencyExponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return Money{minor: minor, currency: currency}, nil
+}
+
+// ParseMoney parses a decimal amount, e.g. "13.37", in the currency.
+//
+// Parsing is strict - signs, exponents, separators and digits beyond the
+// currency's minor unit (other than trailing zeros) are rejected instead of
+// being rounded away.
+func ParseMoney(amount, currency string) (Money, error) {
+	exponent, err := CurrencyExponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	whole, fraction, hasPoint := strings.Cut(amount, ".")
+	if whole == "" || (hasPoint && fraction == "") || !isDigits(whole) || !isDigits(fraction) {
+		return Money{}, fmt.Errorf("%w %q", ErrInvalidAmount, amount)
+	}
+
+	trimmed := strings.TrimRight(fraction, "0")
+	if len(trimmed) > exponent {
+		return Money{}, fmt.Errorf("%w %q: %v allows at most %d decimals", ErrInvalidAmount, amount, currency, exponent)
+	}
+
+	digits := whole + trimmed + strings.Repeat("0", exponent-len(trimmed))
+	minor, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w %q: out of range", ErrInvalidAmount, amount)
+	}
+
+	return Money{minor: minor, currency: currency}, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Minor returns the amount in the currency's minor units
+func (m Money) Minor() int64 {
+	return m.minor
+}
+
+func (m Money) Currency() string {
+	return m.currency
+}
+
+// IsPositive reports whether the amount is greater than zero
+func (m Money) IsPositive() bool {
+	return m.minor > 0
+}
+
+// Equal reports whether both amounts are the same in the same currency
+func (m Money) Equal(other Money) bool {
+	return m.minor == other.minor && m.currency == other.currency
+}
+
+// String formats the amount with exactly as many decimals as the currency's
+// minor unit has, e.g. "13.37" and "10.00" for USD or "1000" for JPY. This is
+// the format Zota expects amounts in.
+func (m Money) String() string {
+	exponent := currencyExponents[m.currency]
+
+	sign := ""
+	minor := m.minor
+	if minor < 0 {
+		sign = "-"
+	}
+
+	digits := strconv.FormatUint(absInt64(minor), 10)
+	if exponent == 0 {
+		return sign + digits
+	}
+
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+
+	point := len(digits) - exponent
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+func absInt64(n int64) uint64 {
+	if n == math.MinInt64 {
+		return uint64(math.MaxInt64) + 1
+	}
+	if n < 0 {
+		return uint64(-n)
+	}
+
+	return uint64(n)
+}
+
+// MarshalJSON encodes the amount as a JSON number with exactly the digits
+// returned by String
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		amount   string
+		currency string
+		expected int64
+	}{
+		{"13.37", "USD", 1337},
+		{"0.3", "USD", 30},
+		{"100", "USD", 10000},
+		{"13.370", "USD", 1337},
+		{"1000", "JPY", 1000},
+		{"1000.00", "JPY", 1000},
+		{"1.234", "KWD", 1234},
+		{"0", "EUR", 0},
+	}
+
+	for _, test := range tests {
+		money, err := ParseMoney(test.amount, test.currency)
+		if err != nil {
+			t.Errorf("Failed to parse %q %v: %q\n", test.amount, test.currency, err)
+			continue
+		}
+
+		if money.Minor() != test.expected || money.Currency() != test.currency {
+			t.Errorf("Output %d %v does not equal expected %d %v\n", money.Minor(), money.Currency(), test.expected, test.currency)
+		}
+	}
+}
+
+func TestParseMoneyRejectsInvalidAmounts(t *testing.T) {
+	tests := []struct {
+		amount   string
+		currency string
+		expected error
+	}{
+		{"", "USD", ErrInvalidAmount},
+		{"13.371", "USD", ErrInvalidAmount},
+		{"1000.5", "JPY", ErrInvalidAmount},
+		{"-13.37", "USD", ErrInvalidAmount},
+		{"+13.37", "USD", ErrInvalidAmount},
+		{"1e3", "USD", ErrInvalidAmount},
+		{"1,000.00", "USD", ErrInvalidAmount},
+		{".5", "USD", ErrInvalidAmount},
+		{"5.", "USD", ErrInvalidAmount},
+		{" 5", "USD", ErrInvalidAmount},
+		{"92233720368547758.08", "USD", ErrInvalidAmount},
+		{"13.37", "XXX", ErrUnsupportedCurrency},
+		{"13.37", "usd", ErrUnsupportedCurrency},
+	}
+
+	for _, test := range tests {
+		_, err := ParseMoney(test.amount, test.currency)
+		if !errors.Is(err, test.expected) {
+			t.Errorf("Parsing %q %v returned %v instead of %v", test.amount, test.currency, err, test.expected)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		minor    int64
+		currency string
+		expected string
+	}{
+		{1337, "USD", "13.37"},
+		{30, "USD", "0.30"},
+		{5, "USD", "0.05"},
+		{10000, "USD", "100.00"},
+		{0, "USD", "0.00"},
+		{-1337, "USD", "-13.37"},
+		{1000, "JPY", "1000"},
+		{1234, "KWD", "1.234"},
+	}
+
+	for _, test := range tests {
+		money, err := NewMoney(test.minor, test.currency)
+		if err != nil {
+			t.Fatalf("Failed to create money: %q\n", err)
+		}
+
+		output := money.String()
+		if output != test.expected {
+			t.Errorf("Output %q does not equal expected %q\n", output, test.expected)
+		}
+	}
+}
+
+func TestOrderJSON(t *testing.T) {
+	amount, _ := ParseMoney("0.3", "USD")
+	order := NewOrder(&User{Email: "federlizer@protonmail.com"}, amount, "Test order")
+
+	data, err := json.Marshal(order)
+	if err != nil {
+		t.Fatalf("Failed to marshal order: %q\n", err)
+	}
+
+	var fields map[string]json.RawMessage
+	json.Unmarshal(data, &fields)
+	if string(fields["amount"]) != "0.30" || string(fields["currency"]) != `"USD"` {
+		t.Errorf("Output %s does not have the exact amount and currency\n", data)
+	}
+
+	var decoded Order
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal order: %q\n", err)
+	}
+
+	if !decoded.Amount.Equal(amount) || decoded.Id != order.Id {
+		t.Errorf("Output %+v does not equal expected %+v\n", decoded, order)
+	}
+}
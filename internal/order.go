@@ -1,41 +1,94 @@
 package internal
 
 import (
-	"strconv"
+	"encoding/json"
+	"log/slog"
+	"time"
 
 	"github.com/google/uuid"
 )
 
-type PaymentStatus string
-
-const (
-	PaymentStatusPending  PaymentStatus = "PENDING"
-	PaymentStatusApproved               = "APPROVED"
-	PaymentStatusFailed                 = "FAILED"
-)
-
 type Order struct {
 	Id            uuid.UUID     `json:"id"`
 	Description   string        `json:"description"`
-	Amount        float64       `json:"amount"`
+	Amount        Money         `json:"amount"`
 	User          User          `json:"-"`
 	PaymentStatus PaymentStatus `json:"paymentStatus"`
+	// ZotaOrderId is the order ID assigned by Zota once the deposit
+	// request has been accepted
+	ZotaOrderId string `json:"zotaOrderId"`
+	// DepositUrl is the Zota page the user completes the deposit on
+	DepositUrl string `json:"depositUrl"`
+	// ProcessorTransactionId is the ID of the transaction at the payment
+	// processor, once Zota has reported it
+	ProcessorTransactionId string    `json:"processorTransactionId"`
+	CreatedAt              time.Time `json:"createdAt"`
+	// UpdatedAt is when the order was last changed, e.g. its payment status
+	UpdatedAt time.Time `json:"updatedAt"`
 }
 
-func NewOrder(user *User, amount float64, description string) *Order {
+func NewOrder(user *User, amount Money, description string) *Order {
 	orderId := uuid.New()
+	now := time.Now()
 
 	return &Order{
 		Id:            orderId,
 		Amount:        amount,
 		Description:   description,
 		User:          *user,
-		PaymentStatus: PaymentStatusPending,
+		PaymentStatus: PaymentStatusCreated,
+		CreatedAt:     now,
+		UpdatedAt:     now,
 	}
 }
 
 func (o *Order) AmountStr() string {
-	// Need to do it like this if we want to ommit the zeroes and allow
-	// amounts that have more than 2 decimals after floating point
-	return strconv.FormatFloat(o.Amount, 'f', -1, 64)
+	return o.Amount.String()
+}
+
+// MarshalJSON adds the amount's currency next to the amount
+func (o Order) MarshalJSON() ([]byte, error) {
+	type order Order
+
+	return json.Marshal(struct {
+		order
+		Currency string `json:"currency"`
+	}{order(o), o.Amount.Currency()})
+}
+
+// UnmarshalJSON parses the amount in the currency next to it
+func (o *Order) UnmarshalJSON(data []byte) error {
+	type order Order
+
+	var decoded struct {
+		order
+		Amount   json.Number `json:"amount"`
+		Currency string      `json:"currency"`
+	}
+
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return err
+	}
+
+	amount, err := ParseMoney(decoded.Amount.String(), decoded.Currency)
+	if err != nil {
+		return err
+	}
+
+	*o = Order(decoded.order)
+	o.Amount = amount
+
+	return nil
+}
+
+// LogValue logs the order without the customer's details
+func (o Order) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", o.Id.String()),
+		slog.String("paymentStatus", string(o.PaymentStatus)),
+		slog.String("amount", o.Amount.String()),
+		slog.String("currency", o.Amount.Currency()),
+		slog.String("zotaOrderId", o.ZotaOrderId),
+	)
 }
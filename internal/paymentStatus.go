@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+type PaymentStatus string
+
+const (
+	// PaymentStatusCreated is the status of an order that hasn't been sent
+	// to Zota yet
+	PaymentStatusCreated PaymentStatus = "CREATED"
+	// PaymentStatusSubmitted is the status of an order that Zota has accepted
+	PaymentStatusSubmitted PaymentStatus = "SUBMITTED"
+	// PaymentStatusProcessing is the status of a payment Zota is processing
+	PaymentStatusProcessing PaymentStatus = "PROCESSING"
+	// PaymentStatusPending is the status of a payment that is waiting for its
+	// next step, e.g. the user completing the deposit
+	PaymentStatusPending PaymentStatus = "PENDING"
+	// PaymentStatusApproved is the final status of a successful payment
+	PaymentStatusApproved PaymentStatus = "APPROVED"
+	// PaymentStatusDeclined is the final status of a payment that the payment
+	// processor has declined
+	PaymentStatusDeclined PaymentStatus = "DECLINED"
+	// PaymentStatusFiltered is the final status of a payment that Zota's
+	// fraud-prevention system has declined
+	PaymentStatusFiltered PaymentStatus = "FILTERED"
+	// PaymentStatusError is the final status of a payment that failed due to
+	// an error, e.g. Zota rejecting the request or approving a different amount
+	PaymentStatusError PaymentStatus = "ERROR"
+	// PaymentStatusExpired is the final status of a payment that didn't reach
+	// any other final status in time
+	PaymentStatusExpired PaymentStatus = "EXPIRED"
+)
+
+// PaymentStatuses holds every payment status, in the order a payment goes
+// through them
+var PaymentStatuses = []PaymentStatus{
+	PaymentStatusCreated,
+	PaymentStatusSubmitted,
+	PaymentStatusProcessing,
+	PaymentStatusPending,
+	PaymentStatusApproved,
+	PaymentStatusDeclined,
+	PaymentStatusFiltered,
+	PaymentStatusError,
+	PaymentStatusExpired,
+}
+
+var finalStatuses = []PaymentStatus{
+	PaymentStatusApproved,
+	PaymentStatusDeclined,
+	PaymentStatusFiltered,
+	PaymentStatusError,
+	PaymentStatusExpired,
+}
+
+// transitions holds the statuses a payment can transition to from each
+// status. Final statuses can't transition to anything. A deposit request that
+// timed out leaves the order CREATED even if Zota has accepted it, so a
+// CREATED payment can move to any status Zota reports.
+var transitions = map[PaymentStatus][]PaymentStatus{
+	PaymentStatusCreated:    append([]PaymentStatus{PaymentStatusSubmitted, PaymentStatusProcessing, PaymentStatusPending}, finalStatuses...),
+	PaymentStatusSubmitted:  append([]PaymentStatus{PaymentStatusProcessing, PaymentStatusPending}, finalStatuses...),
+	PaymentStatusProcessing: append([]PaymentStatus{PaymentStatusPending}, finalStatuses...),
+	PaymentStatusPending:    append([]PaymentStatus{PaymentStatusProcessing}, finalStatuses...),
+}
+
+// ErrInvalidTransition is returned when a payment would change to a status
+// that it can't reach from its current one
+var ErrInvalidTransition = errors.New("Invalid payment status transition")
+
+// IsValid reports whether the payment status is one of the PaymentStatuses
+func (s PaymentStatus) IsValid() bool {
+	return slices.Contains(PaymentStatuses, s)
+}
+
+// IsFinal reports whether the payment status can no longer change
+func (s PaymentStatus) IsFinal() bool {
+	return slices.Contains(finalStatuses, s)
+}
+
+// CanTransitionTo reports whether a payment in this status can change to next
+func (s PaymentStatus) CanTransitionTo(next PaymentStatus) bool {
+	return slices.Contains(transitions[s], next)
+}
+
+// ValidateTransition returns ErrInvalidTransition if a payment in this status
+// can't change to next
+func (s PaymentStatus) ValidateTransition(next PaymentStatus) error {
+	if !s.CanTransitionTo(next) {
+		return fmt.Errorf("%w from %v to %v", ErrInvalidTransition, s, next)
+	}
+
+	return nil
+}
+
+const (
+	// StatusSourceApi is the source of changes made while handling the
+	// merchant's own API requests, e.g. creating an order
+	StatusSourceApi = "api"
+	// StatusSourcePoll is the source of changes reported by polling Zota
+	StatusSourcePoll = "poll"
+	// StatusSourceCallback is the source of changes reported by Zota's callbacks
+	StatusSourceCallback = "callback"
+	// StatusSourceRedirect is the source of changes checked with Zota when
+	// the user is redirected back after a deposit
+	StatusSourceRedirect = "redirect"
+	// StatusSourceManual is the source of changes checked with Zota on request,
+	// e.g. refreshing an order
+	StatusSourceManual = "manual"
+)
+
+// StatusChange records the payment status an order has transitioned to, when
+// and why it happened
+type StatusChange struct {
+	Status    PaymentStatus `json:"status"`
+	ChangedAt time.Time     `json:"changedAt"`
+	// Source is what reported the change, one of the StatusSource constants
+	Source string `json:"source"`
+	// ErrorMessage is the reason a payment failed, as reported by Zota
+	ErrorMessage string `json:"errorMessage,omitempty"`
+}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPaymentStatusTransitions(t *testing.T) {
+	tests := []struct {
+		from  PaymentStatus
+		to    PaymentStatus
+		valid bool
+	}{
+		{PaymentStatusCreated, PaymentStatusSubmitted, true},
+		{PaymentStatusCreated, PaymentStatusError, true},
+		{PaymentStatusCreated, PaymentStatusApproved, true},
+		{PaymentStatusCreated, PaymentStatusPending, true},
+		{PaymentStatusCreated, PaymentStatusCreated, false},
+		{PaymentStatusSubmitted, PaymentStatusProcessing, true},
+		{PaymentStatusSubmitted, PaymentStatusDeclined, true},
+		{PaymentStatusSubmitted, PaymentStatusCreated, false},
+		{PaymentStatusProcessing, PaymentStatusPending, true},
+		{PaymentStatusPending, PaymentStatusProcessing, true},
+		{PaymentStatusPending, PaymentStatusApproved, true},
+		{PaymentStatusPending, PaymentStatusExpired, true},
+		{PaymentStatusPending, PaymentStatusPending, false},
+		{PaymentStatusPending, PaymentStatusSubmitted, false},
+		{PaymentStatusApproved, PaymentStatusDeclined, false},
+		{PaymentStatusApproved, PaymentStatusPending, false},
+		{PaymentStatusFiltered, PaymentStatusApproved, false},
+	}
+
+	for _, test := range tests {
+		err := test.from.ValidateTransition(test.to)
+		if test.valid && err != nil {
+			t.Errorf("Failed to transition from %v to %v: %q\n", test.from, test.to, err)
+		}
+
+		if !test.valid && !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("Transition from %v to %v returned %v, expected %v", test.from, test.to, err, ErrInvalidTransition)
+		}
+	}
+}
+
+func TestFinalPaymentStatuses(t *testing.T) {
+	for _, status := range PaymentStatuses {
+		final := status == PaymentStatusApproved || status == PaymentStatusDeclined || status == PaymentStatusFiltered ||
+			status == PaymentStatusError || status == PaymentStatusExpired
+
+		if status.IsFinal() != final {
+			t.Errorf("%v reported as final %v, expected %v", status, status.IsFinal(), final)
+		}
+
+		if final && len(transitions[status]) != 0 {
+			t.Errorf("Final status %v can transition to %v", status, transitions[status])
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	"github.com/google/uuid"
+)
+
+// Payout is a withdrawal of money from the merchant back to a user
+type Payout struct {
+	Id            uuid.UUID     `json:"id"`
+	Description   string        `json:"description"`
+	Amount        Money         `json:"amount"`
+	User          User          `json:"-"`
+	BankAccount   BankAccount   `json:"-"`
+	PaymentStatus PaymentStatus `json:"paymentStatus"`
+	// ZotaOrderId is the order ID assigned by Zota once the payout
+	// request has been accepted
+	ZotaOrderId string `json:"zotaOrderId"`
+}
+
+// BankAccount holds the details of the bank account a payout is sent to
+type BankAccount struct {
+	BankCode      string
+	AccountNumber string
+	AccountName   string
+	BranchName    string
+	CountryCode   string
+}
+
+func NewPayout(user *User, bankAccount *BankAccount, amount Money, description string) *Payout {
+	payoutId := uuid.New()
+
+	return &Payout{
+		Id:            payoutId,
+		Amount:        amount,
+		Description:   description,
+		User:          *user,
+		BankAccount:   *bankAccount,
+		PaymentStatus: PaymentStatusPending,
+	}
+}
+
+func (p *Payout) AmountStr() string {
+	return p.Amount.String()
+}
+
+// MarshalJSON adds the amount's currency next to the amount
+func (p Payout) MarshalJSON() ([]byte, error) {
+	type payout Payout
+
+	return json.Marshal(struct {
+		payout
+		Currency string `json:"currency"`
+	}{payout(p), p.Amount.Currency()})
+}
+
+// UnmarshalJSON parses the amount in the currency next to it
+func (p *Payout) UnmarshalJSON(data []byte) error {
+	type payout Payout
+
+	var decoded struct {
+		payout
+		Amount   json.Number `json:"amount"`
+		Currency string      `json:"currency"`
+	}
+
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return err
+	}
+
+	amount, err := ParseMoney(decoded.Amount.String(), decoded.Currency)
+	if err != nil {
+		return err
+	}
+
+	*p = Payout(decoded.payout)
+	p.Amount = amount
+
+	return nil
+}
+
+// LogValue logs the payout without the customer's or bank account's details
+func (p Payout) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", p.Id.String()),
+		slog.String("paymentStatus", string(p.PaymentStatus)),
+		slog.String("amount", p.Amount.String()),
+		slog.String("currency", p.Amount.Currency()),
+		slog.String("zotaOrderId", p.ZotaOrderId),
+	)
+}
+
+// LogValue logs the bank account without its number or holder
+func (b BankAccount) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("bankCode", b.BankCode),
+		slog.String("countryCode", b.CountryCode),
+	)
+}
@@ -0,0 +1,110 @@
+package poller
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+)
+
+// MaxDelay is the longest the poller waits between checks. A check further in
+// the future couldn't be stored, times are stored in nanoseconds since the
+// Unix epoch, which only reach the year 2262.
+const MaxDelay = 100 * 365 * 24 * time.Hour
+
+// RetryPolicy decides when the poller checks an order that hasn't reached a
+// final status again, and when it gives up on it
+type RetryPolicy interface {
+	// NextDelay returns how long to wait before checking the entry again,
+	// given the attempts that have been made so far. If polling should give
+	// up on the entry, false is returned. Delays longer than MaxDelay are
+	// shortened to it.
+	NextDelay(entry storage.PollEntry, now time.Time) (time.Duration, bool)
+}
+
+// FixedPolicy checks orders in a fixed interval, up to MaxAttempts times.
+// A MaxAttempts of 0 never gives up.
+type FixedPolicy struct {
+	Interval    time.Duration
+	MaxAttempts int
+}
+
+func (p FixedPolicy) NextDelay(entry storage.PollEntry, now time.Time) (time.Duration, bool) {
+	if p.MaxAttempts > 0 && entry.Attempts >= p.MaxAttempts {
+		return 0, false
+	}
+
+	return p.Interval, true
+}
+
+// ExponentialPolicy multiplies the delay between checks by Multiplier after
+// every attempt, starting from Initial and capped at Max. The delay is
+// randomised by up to +/- Jitter (a fraction between 0 and 1) so that orders
+// created at the same time aren't all checked at once. A MaxAttempts of 0
+// never gives up. Without a Max, the delay grows up to MaxDelay.
+type ExponentialPolicy struct {
+	Initial     time.Duration
+	Max         time.Duration
+	Multiplier  float64
+	Jitter      float64
+	MaxAttempts int
+
+	// random returns a number in [0, 1), rand.Float64 if nil
+	random func() float64
+}
+
+func (p ExponentialPolicy) NextDelay(entry storage.PollEntry, now time.Time) (time.Duration, bool) {
+	if p.MaxAttempts > 0 && entry.Attempts >= p.MaxAttempts {
+		return 0, false
+	}
+
+	delay := float64(p.Initial) * math.Pow(p.Multiplier, float64(entry.Attempts))
+	if p.Max > 0 && delay > float64(p.Max) {
+		delay = float64(p.Max)
+	}
+
+	if p.Jitter > 0 {
+		random := p.random
+		if random == nil {
+			random = rand.Float64
+		}
+
+		delay = delay * (1 + p.Jitter*(2*random()-1))
+	}
+
+	// Converting a float that's out of range, e.g. once the delay has grown
+	// to +Inf, to a time.Duration doesn't give a meaningful duration
+	if delay >= float64(MaxDelay) {
+		return MaxDelay, true
+	}
+
+	return time.Duration(delay), true
+}
+
+// DeadlinePolicy gives up on orders that haven't reached a final status
+// within Deadline of the poller starting to track them. Until then, the
+// delays of the wrapped Policy are used.
+type DeadlinePolicy struct {
+	Policy   RetryPolicy
+	Deadline time.Duration
+}
+
+func (p DeadlinePolicy) NextDelay(entry storage.PollEntry, now time.Time) (time.Duration, bool) {
+	deadline := entry.StartedAt.Add(p.Deadline)
+	if !now.Before(deadline) {
+		return 0, false
+	}
+
+	delay, ok := p.Policy.NextDelay(entry, now)
+	if !ok {
+		return 0, false
+	}
+
+	// Make one last check right at the deadline
+	if now.Add(delay).After(deadline) {
+		delay = deadline.Sub(now)
+	}
+
+	return delay, true
+}
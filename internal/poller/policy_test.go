@@ -0,0 +1,131 @@
+package poller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+)
+
+func TestFixedPolicy(t *testing.T) {
+	policy := FixedPolicy{Interval: 10 * time.Second, MaxAttempts: 3}
+	now := time.Now()
+
+	tests := []struct {
+		attempts      int
+		expectedDelay time.Duration
+		expectedOk    bool
+	}{
+		{0, 10 * time.Second, true},
+		{2, 10 * time.Second, true},
+		{3, 0, false},
+	}
+
+	for _, test := range tests {
+		delay, ok := policy.NextDelay(storage.PollEntry{Attempts: test.attempts, StartedAt: now}, now)
+		if delay != test.expectedDelay || ok != test.expectedOk {
+			t.Errorf("Output (%v, %v) does not equal expected (%v, %v) after %d attempts\n", delay, ok, test.expectedDelay, test.expectedOk, test.attempts)
+		}
+	}
+}
+
+func TestExponentialPolicy(t *testing.T) {
+	policy := ExponentialPolicy{
+		Initial:     time.Second,
+		Max:         time.Minute,
+		Multiplier:  2,
+		MaxAttempts: 10,
+	}
+	now := time.Now()
+
+	tests := []struct {
+		attempts      int
+		expectedDelay time.Duration
+		expectedOk    bool
+	}{
+		{0, time.Second, true},
+		{1, 2 * time.Second, true},
+		{4, 16 * time.Second, true},
+		{6, time.Minute, true},
+		{10, 0, false},
+	}
+
+	for _, test := range tests {
+		delay, ok := policy.NextDelay(storage.PollEntry{Attempts: test.attempts, StartedAt: now}, now)
+		if delay != test.expectedDelay || ok != test.expectedOk {
+			t.Errorf("Output (%v, %v) does not equal expected (%v, %v) after %d attempts\n", delay, ok, test.expectedDelay, test.expectedOk, test.attempts)
+		}
+	}
+}
+
+func TestExponentialPolicyWithoutMax(t *testing.T) {
+	policy := ExponentialPolicy{Initial: time.Second, Multiplier: 2, Jitter: 0.5, random: func() float64 { return 0.99 }}
+	now := time.Now()
+
+	// The delay would overflow a time.Duration after ~33 attempts and
+	// float64 after ~1024
+	previous := time.Duration(0)
+	for _, attempts := range []int{10, 40, 100, 2000} {
+		delay, ok := policy.NextDelay(storage.PollEntry{Attempts: attempts, StartedAt: now}, now)
+		if !ok || delay < previous {
+			t.Errorf("Output (%v, %v) is shorter than the delay %v of fewer attempts after %d attempts\n", delay, ok, previous, attempts)
+		}
+
+		previous = delay
+	}
+
+	if previous != MaxDelay {
+		t.Errorf("Output %v does not equal expected %v\n", previous, MaxDelay)
+	}
+}
+
+func TestExponentialPolicyJitter(t *testing.T) {
+	policy := ExponentialPolicy{Initial: 10 * time.Second, Multiplier: 2, Jitter: 0.5}
+	now := time.Now()
+
+	tests := []struct {
+		random        float64
+		expectedDelay time.Duration
+	}{
+		{0, 5 * time.Second},
+		{0.5, 10 * time.Second},
+		{0.75, 12500 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		policy.random = func() float64 { return test.random }
+
+		delay, _ := policy.NextDelay(storage.PollEntry{StartedAt: now}, now)
+		if delay != test.expectedDelay {
+			t.Errorf("Output %v does not equal expected %v\n", delay, test.expectedDelay)
+		}
+	}
+}
+
+func TestDeadlinePolicy(t *testing.T) {
+	policy := DeadlinePolicy{
+		Policy:   FixedPolicy{Interval: time.Minute},
+		Deadline: 10 * time.Minute,
+	}
+	startedAt := time.Now()
+
+	tests := []struct {
+		elapsed       time.Duration
+		expectedDelay time.Duration
+		expectedOk    bool
+	}{
+		{0, time.Minute, true},
+		// The last check happens right at the deadline
+		{9*time.Minute + 30*time.Second, 30 * time.Second, true},
+		{10 * time.Minute, 0, false},
+	}
+
+	for _, test := range tests {
+		entry := storage.PollEntry{Attempts: 100, StartedAt: startedAt}
+
+		delay, ok := policy.NextDelay(entry, startedAt.Add(test.elapsed))
+		if delay != test.expectedDelay || ok != test.expectedOk {
+			t.Errorf("Output (%v, %v) does not equal expected (%v, %v) after %v\n", delay, ok, test.expectedDelay, test.expectedOk, test.elapsed)
+		}
+	}
+}
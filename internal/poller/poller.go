@@ -0,0 +1,590 @@
+package poller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/logging"
+	"github.com/federlizer/alokin-zota-integration/internal/metrics"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+	"github.com/federlizer/alokin-zota-integration/internal/tracing"
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+// unresolvedInterval is how often orders are checked once the policy has
+// given up on them, for as long as Zota rejects our credentials or doesn't
+// know the order
+const unresolvedInterval = 5 * time.Minute
+
+// Poller periodically checks the status of pending orders and payouts with
+// Zota's Order Status request until they reach a final status.
+//
+// The poller's schedule is kept in a storage.PollSchedule, so when a durable
+// schedule is used, polling resumes where it left off after a restart.
+type Poller struct {
+	zotaApi    zota.IZotaAPI
+	orderRepo  storage.OrderRepo
+	payoutRepo storage.PayoutRepo
+	schedule   storage.PollSchedule
+
+	// policy decides when an order is checked again and when an order that
+	// still hasn't reached a final status expires
+	policy RetryPolicy
+	// tick is how often the schedule is searched for due entries
+	tick time.Duration
+	// pausedUntil is set when Zota asks us to back off, no entries are
+	// checked before then. Only used by the polling goroutine.
+	pausedUntil time.Time
+	// metrics records the entries in flight and those polling gave up on
+	metrics *metrics.Metrics
+
+	mu      sync.Mutex
+	running bool
+	// cancel aborts the request to Zota that's in progress, if any
+	cancel context.CancelFunc
+	// stopping is closed once no more checks should be started
+	stopping chan struct{}
+	done     chan struct{}
+}
+
+// Option configures a Poller created by New
+type Option func(p *Poller)
+
+// WithMetrics records how many orders and payouts are being polled and how
+// many polling gave up on in the metrics
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(p *Poller) {
+		p.metrics = m
+	}
+}
+
+func New(zotaApi zota.IZotaAPI, orderRepo storage.OrderRepo, payoutRepo storage.PayoutRepo, schedule storage.PollSchedule, policy RetryPolicy, options ...Option) *Poller {
+	// Search the schedule at least as often as the policy wants the first
+	// check of a new order to happen
+	tick := time.Second
+	firstDelay, ok := policy.NextDelay(storage.PollEntry{StartedAt: time.Now()}, time.Now())
+	if ok && firstDelay > 0 && firstDelay < tick {
+		tick = firstDelay
+	}
+
+	p := &Poller{
+		zotaApi:    zotaApi,
+		orderRepo:  orderRepo,
+		payoutRepo: payoutRepo,
+		schedule:   schedule,
+		policy:     policy,
+		tick:       tick,
+	}
+
+	for _, option := range options {
+		option(p)
+	}
+
+	return p
+}
+
+// Start resumes polling for every order and payout that hasn't reached a
+// final status yet and starts the polling goroutine
+func (p *Poller) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.running {
+		return errors.New("Poller is already running")
+	}
+
+	err := p.resume()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.stopping = make(chan struct{})
+	p.done = make(chan struct{})
+	p.running = true
+
+	go p.run(ctx, p.done)
+
+	return nil
+}
+
+// Stop stops the polling goroutine, aborting the check that's currently in
+// progress, if any, and waits for the goroutine to exit. The schedule is kept,
+// so polling continues from the same point once the poller is started again.
+func (p *Poller) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.running {
+		return
+	}
+
+	close(p.stopping)
+	p.cancel()
+	<-p.done
+	p.running = false
+}
+
+// Shutdown stops the polling goroutine like Stop, but lets the check that's
+// in progress, if any, finish first, so that its outcome is recorded. If ctx
+// is done before then, the check is aborted like Stop does and ctx's error
+// is returned.
+func (p *Poller) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.running {
+		return nil
+	}
+
+	close(p.stopping)
+
+	var err error
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	p.cancel()
+	<-p.done
+	p.running = false
+
+	return err
+}
+
+// Running reports whether the polling goroutine is running
+func (p *Poller) Running() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.running
+}
+
+// Track schedules the order or payout with the given ID to be checked
+// with Zota. kind is either storage.PollKindDeposit or storage.PollKindPayout.
+// The checks are logged with the request ID ctx carries, if any, and their
+// spans are linked to the span it carries.
+func (p *Poller) Track(ctx context.Context, kind, id, zotaOrderId string) error {
+	now := time.Now()
+	entry := storage.PollEntry{
+		Id:          id,
+		Kind:        kind,
+		ZotaOrderId: zotaOrderId,
+		StartedAt:   now,
+		RequestId:   logging.RequestId(ctx),
+		TraceParent: tracing.Traceparent(ctx),
+	}
+
+	delay, _ := p.policy.NextDelay(entry, now)
+	entry.NextCheckAt = now.Add(min(delay, MaxDelay))
+
+	return p.schedule.Schedule(entry)
+}
+
+// Tracked returns every order and payout that's currently being tracked,
+// ordered by when they're due to be checked next
+func (p *Poller) Tracked() ([]storage.PollEntry, error) {
+	entries, err := p.schedule.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].NextCheckAt.Before(entries[j].NextCheckAt)
+	})
+
+	return entries, nil
+}
+
+// resume schedules an immediate check for every pending order and payout
+// that isn't in the schedule already, e.g. because it was created before the
+// schedule was persisted
+func (p *Poller) resume() error {
+	entries, err := p.schedule.GetAll()
+	if err != nil {
+		return err
+	}
+
+	scheduled := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		scheduled[entry.Id] = true
+	}
+
+	orders, err := p.orderRepo.GetAll()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, order := range orders {
+		id := order.Id.String()
+		if scheduled[id] || order.PaymentStatus.IsFinal() || order.ZotaOrderId == "" {
+			continue
+		}
+
+		err := p.schedule.Schedule(storage.PollEntry{
+			Id:          id,
+			Kind:        storage.PollKindDeposit,
+			ZotaOrderId: order.ZotaOrderId,
+			StartedAt:   now,
+			NextCheckAt: now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	payouts, err := p.payoutRepo.GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, payout := range payouts {
+		id := payout.Id.String()
+		if scheduled[id] || payout.PaymentStatus.IsFinal() || payout.ZotaOrderId == "" {
+			continue
+		}
+
+		err := p.schedule.Schedule(storage.PollEntry{
+			Id:          id,
+			Kind:        storage.PollKindPayout,
+			ZotaOrderId: payout.ZotaOrderId,
+			StartedAt:   now,
+			NextCheckAt: now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Poller) run(ctx context.Context, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(p.tick)
+	defer ticker.Stop()
+
+	p.checkDue(ctx, time.Now())
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.stopping:
+			return
+		case now := <-ticker.C:
+			p.checkDue(ctx, now)
+		}
+	}
+}
+
+// isStopping reports whether the poller is being stopped
+func (p *Poller) isStopping() bool {
+	select {
+	case <-p.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkDue checks every entry in the schedule that's due at the given time
+func (p *Poller) checkDue(ctx context.Context, now time.Time) {
+	entries, err := p.schedule.GetAll()
+	if err != nil {
+		slog.ErrorContext(ctx, "Couldn't get the poll schedule", "error", err)
+		return
+	}
+
+	p.recordInFlight(entries)
+
+	for _, entry := range entries {
+		if entry.NextCheckAt.After(now) {
+			continue
+		}
+
+		// Don't hold up shutdown with the remaining checks
+		if ctx.Err() != nil || p.isStopping() {
+			return
+		}
+
+		// Zota has asked us to back off, the remaining entries stay due
+		if now.Before(p.pausedUntil) {
+			return
+		}
+
+		p.check(ctx, entry, now)
+	}
+}
+
+// check queries Zota for the status of a single entry and either finalises
+// it or schedules its next check
+func (p *Poller) check(ctx context.Context, entry storage.PollEntry, now time.Time) {
+	// Tie the check to the request that created the order, including the
+	// request to Zota
+	ctx = logging.WithRequestId(ctx, entry.RequestId)
+	logger := entryLogger(entry)
+
+	// Every check is a trace of its own, linked to the span of the request
+	// that started polling
+	origin := tracing.ParseTraceparent(entry.TraceParent)
+	ctx, span := tracing.Start(ctx, "Poller check",
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.Link{SpanContext: origin}),
+		trace.WithAttributes(
+			attribute.String("poll.kind", entry.Kind),
+			attribute.String("poll.id", entry.Id),
+			attribute.String("zota.order_id", entry.ZotaOrderId),
+			attribute.Int("poll.attempt", entry.Attempts+1),
+		),
+	)
+	defer span.End()
+
+	status, amount, err := p.currentStatus(ctx, entry)
+	if errors.Is(err, storage.ErrNotFound) {
+		logger.WarnContext(ctx, "Stopped polling for unknown order")
+		p.remove(ctx, entry)
+		return
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Couldn't get the stored status", "error", err)
+		tracing.RecordError(span, err)
+		return
+	}
+
+	// The order might have already been finalised by a callback from Zota
+	if status.IsFinal() {
+		logger.InfoContext(ctx, "Order has already been finalised, stopping polling", "status", status)
+		p.remove(ctx, entry)
+		return
+	}
+
+	zosr, err := p.zotaApi.OrderStatusContext(ctx, zota.NewZotaOrderStatusRequest(entry.ZotaOrderId, entry.Id))
+	if ctx.Err() != nil {
+		// The poller is being stopped, this check doesn't count as an attempt
+		return
+	}
+
+	entry.Attempts += 1
+	entry.LastCheckedAt = now
+	// Entries scheduled before StartedAt was stored
+	if entry.StartedAt.IsZero() {
+		entry.StartedAt = now
+	}
+
+	permanent := false
+	// unresolved is set when Zota couldn't tell us anything about the order,
+	// since it rejected our credentials or doesn't know the order (yet)
+	unresolved := false
+	var retryAfter time.Duration
+
+	switch {
+	case err != nil:
+		logger.WarnContext(ctx, "Received error when checking order status", "attempt", entry.Attempts, "error", err)
+		tracing.RecordError(span, err)
+
+		// Errors that aren't responses from Zota, e.g. timeouts and network
+		// errors, are always worth retrying
+		var apiErr *zota.APIError
+		if errors.As(err, &apiErr) {
+			retryAfter = apiErr.RetryAfter
+
+			// A wrong secret key or signature would otherwise fail every
+			// pending order, whose callbacks would then be ignored
+			if errors.Is(err, zota.ErrUnauthorized) || errors.Is(err, zota.ErrNotFound) {
+				logger.ErrorContext(ctx, "Zota couldn't give the order's status, keeping it pending", "attempt", entry.Attempts, "error", err)
+				unresolved = true
+			} else {
+				permanent = !apiErr.Temporary()
+			}
+		}
+	case zosr.IsInFinalStatus():
+		logger.InfoContext(ctx, "Received a final status", "zotaStatus", zosr.Data.Status, "attempt", entry.Attempts)
+
+		change := internal.StatusChange{Source: internal.StatusSourcePoll, ErrorMessage: zosr.Data.ErrorMessage}
+		change.Status, err = zosr.Data.PaymentStatusFor(amount)
+		if err != nil {
+			logger.WarnContext(ctx, "Failing order", "error", err)
+			change.ErrorMessage = err.Error()
+		}
+
+		p.recordTransaction(ctx, entry, zosr.Data.ProcessorTransactionId)
+		p.finalise(ctx, entry, status, change)
+		return
+	case zosr.Data != nil:
+		logger.DebugContext(ctx, "Received a status that isn't final", "zotaStatus", zosr.Data.Status, "attempt", entry.Attempts)
+		status = p.recordProgress(ctx, entry, status, zosr.Data.Status.PaymentStatus())
+	}
+
+	// Zota won't give us a status for this order no matter how often we ask
+	if permanent {
+		logger.ErrorContext(ctx, "Received a permanent error, stopping polling", "error", err)
+		p.finalise(ctx, entry, status, internal.StatusChange{
+			Status:       internal.PaymentStatusError,
+			Source:       internal.StatusSourcePoll,
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
+
+	if retryAfter > 0 {
+		logger.WarnContext(ctx, "Zota asked us to back off", "retryAfter", retryAfter)
+		p.pausedUntil = now.Add(retryAfter)
+	}
+
+	delay, ok := p.policy.NextDelay(entry, now)
+	if !ok && unresolved {
+		// The order isn't expired over an error that isn't about the order
+		delay, ok = unresolvedInterval, true
+	}
+
+	if !ok {
+		logger.WarnContext(ctx, "Gave up before receiving a final status", "attempts", entry.Attempts)
+		p.metrics.PollAttemptsExhausted(entry.Kind)
+		p.finalise(ctx, entry, status, internal.StatusChange{
+			Status:       internal.PaymentStatusExpired,
+			Source:       internal.StatusSourcePoll,
+			ErrorMessage: fmt.Sprintf("No final status after %d checks", entry.Attempts),
+		})
+		return
+	}
+
+	if delay < retryAfter {
+		delay = retryAfter
+	}
+
+	entry.NextCheckAt = now.Add(min(delay, MaxDelay))
+	err = p.schedule.Schedule(entry)
+	if err != nil {
+		logger.ErrorContext(ctx, "Couldn't reschedule order", "error", err)
+	}
+}
+
+// recordInFlight records how many orders and payouts are in the schedule
+func (p *Poller) recordInFlight(entries []storage.PollEntry) {
+	counts := map[string]int{storage.PollKindDeposit: 0, storage.PollKindPayout: 0}
+	for _, entry := range entries {
+		counts[entry.Kind] += 1
+	}
+
+	for kind, count := range counts {
+		p.metrics.SetPollsInFlight(kind, count)
+	}
+}
+
+// finalise changes the entry from its current status to the final one and
+// removes it from the schedule. If something else has changed the status
+// first, the entry is kept, so that the next check finds out whether the
+// order has been finalised or still needs to be polled.
+func (p *Poller) finalise(ctx context.Context, entry storage.PollEntry, current internal.PaymentStatus, change internal.StatusChange) {
+	err := p.updateStatus(ctx, entry, current, change)
+	switch {
+	case errors.Is(err, storage.ErrStatusConflict):
+		entryLogger(entry).InfoContext(ctx, "Order has been changed concurrently, checking it again", "status", change.Status, "error", err)
+		return
+	case errors.Is(err, internal.ErrInvalidTransition):
+		entryLogger(entry).InfoContext(ctx, "Order can't change to the polled status, ignoring it", "status", change.Status, "error", err)
+	case err != nil:
+		// Keep the entry, so it's checked again on the next tick
+		entryLogger(entry).ErrorContext(ctx, "Couldn't update status", "status", change.Status, "error", err)
+		return
+	default:
+		entryLogger(entry).InfoContext(ctx, "Order has been finalised", "status", change.Status)
+	}
+
+	p.remove(ctx, entry)
+}
+
+// recordProgress stores the non-final status Zota has reported for a deposit,
+// if it's a change, and returns the status the deposit is in afterwards.
+// Payouts only ever go from pending to a final status.
+func (p *Poller) recordProgress(ctx context.Context, entry storage.PollEntry, current, status internal.PaymentStatus) internal.PaymentStatus {
+	if entry.Kind != storage.PollKindDeposit || status == current || !current.CanTransitionTo(status) {
+		return current
+	}
+
+	err := tracing.OrderRepo(ctx, p.orderRepo).UpdateStatus(entry.Id, current, internal.StatusChange{
+		Status: status,
+		Source: internal.StatusSourcePoll,
+	})
+	if err != nil {
+		entryLogger(entry).ErrorContext(ctx, "Couldn't update status", "status", status, "error", err)
+		return current
+	}
+
+	return status
+}
+
+// recordTransaction stores the processor transaction ID Zota has reported
+// for a deposit
+func (p *Poller) recordTransaction(ctx context.Context, entry storage.PollEntry, processorTransactionId string) {
+	if entry.Kind != storage.PollKindDeposit || processorTransactionId == "" {
+		return
+	}
+
+	err := tracing.OrderRepo(ctx, p.orderRepo).SetProcessorTransactionId(entry.Id, processorTransactionId)
+	if err != nil {
+		entryLogger(entry).ErrorContext(ctx, "Couldn't store processor transaction ID", "error", err)
+	}
+}
+
+func (p *Poller) remove(ctx context.Context, entry storage.PollEntry) {
+	err := p.schedule.Remove(entry.Id)
+	if err != nil {
+		entryLogger(entry).ErrorContext(ctx, "Couldn't remove order from the poll schedule", "error", err)
+	}
+}
+
+// entryLogger returns a logger that adds the kind and ID of the entry to
+// every record
+func entryLogger(entry storage.PollEntry) *slog.Logger {
+	return slog.With("kind", entry.Kind, "id", entry.Id, "zotaOrderId", entry.ZotaOrderId)
+}
+
+// currentStatus returns the stored status of the order or payout and the
+// amount it was made with
+func (p *Poller) currentStatus(ctx context.Context, entry storage.PollEntry) (internal.PaymentStatus, internal.Money, error) {
+	switch entry.Kind {
+	case storage.PollKindDeposit:
+		order, err := tracing.OrderRepo(ctx, p.orderRepo).GetOrder(entry.Id)
+		if err != nil {
+			return "", internal.Money{}, err
+		}
+
+		return order.PaymentStatus, order.Amount, nil
+	case storage.PollKindPayout:
+		payout, err := tracing.PayoutRepo(ctx, p.payoutRepo).GetPayout(entry.Id)
+		if err != nil {
+			return "", internal.Money{}, err
+		}
+
+		return payout.PaymentStatus, payout.Amount, nil
+	default:
+		return "", internal.Money{}, fmt.Errorf("Unknown poll entry kind %q", entry.Kind)
+	}
+}
+
+// updateStatus changes the status of the order or payout from current
+func (p *Poller) updateStatus(ctx context.Context, entry storage.PollEntry, current internal.PaymentStatus, change internal.StatusChange) error {
+	switch entry.Kind {
+	case storage.PollKindDeposit:
+		return tracing.OrderRepo(ctx, p.orderRepo).UpdateStatus(entry.Id, current, change)
+	case storage.PollKindPayout:
+		return tracing.PayoutRepo(ctx, p.payoutRepo).UpdateStatus(entry.Id, current, change.Status)
+	default:
+		return fmt.Errorf("Unknown poll entry kind %q", entry.Kind)
+	}
+}
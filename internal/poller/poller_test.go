@@ -0,0 +1,719 @@
+package poller
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/logging"
+	"github.com/federlizer/alokin-zota-integration/internal/metrics"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+	"github.com/federlizer/alokin-zota-integration/internal/tracing"
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+type zotaAPIMock struct {
+	mu           sync.Mutex
+	status       zota.OrderStatus
+	errorMessage string
+	err          error
+	requests     int
+	// requestId is the request ID the last request was made for
+	requestId string
+
+	// started and release, if set, hold the requests in progress: started
+	// receives once a request has been made, which then waits until release
+	// is closed or the request is aborted
+	started chan struct{}
+	release chan struct{}
+}
+
+func (api *zotaAPIMock) SecretKey() string    { return "00000000-1111-2222-3333-444444444444" }
+func (api *zotaAPIMock) EndpointId() string   { return "123456" }
+func (api *zotaAPIMock) MerchantId() string   { return "COOKIES1337" }
+func (api *zotaAPIMock) Currencies() []string { return []string{"USD"} }
+func (api *zotaAPIMock) EndpointIdFor(currency string) (string, error) {
+	return "123456", nil
+}
+func (api *zotaAPIMock) BaseUrl() string { return "https://federlizer.com/api/" }
+
+func (api *zotaAPIMock) Deposit(req *zota.ZotaDepositRequest) (*zota.ZotaDepositResponse, error) {
+	return api.DepositContext(context.Background(), req)
+}
+func (api *zotaAPIMock) DepositContext(ctx context.Context, req *zota.ZotaDepositRequest) (*zota.ZotaDepositResponse, error) {
+	return nil, nil
+}
+func (api *zotaAPIMock) Payout(req *zota.ZotaPayoutRequest) (*zota.ZotaPayoutResponse, error) {
+	return api.PayoutContext(context.Background(), req)
+}
+func (api *zotaAPIMock) PayoutContext(ctx context.Context, req *zota.ZotaPayoutRequest) (*zota.ZotaPayoutResponse, error) {
+	return nil, nil
+}
+func (api *zotaAPIMock) OrderStatus(req *zota.ZotaOrderStatusRequest) (*zota.ZotaOrderStatusResponse, error) {
+	return api.OrderStatusContext(context.Background(), req)
+}
+func (api *zotaAPIMock) OrderStatusContext(ctx context.Context, req *zota.ZotaOrderStatusRequest) (*zota.ZotaOrderStatusResponse, error) {
+	if api.release != nil {
+		api.started <- struct{}{}
+
+		select {
+		case <-api.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	api.requests += 1
+	api.requestId = logging.RequestId(ctx)
+	if api.err != nil {
+		return nil, api.err
+	}
+
+	return &zota.ZotaOrderStatusResponse{
+		Code: "200",
+		Data: &zota.ZotaOrderStatusData{
+			Status:                 api.status,
+			ErrorMessage:           api.errorMessage,
+			ProcessorTransactionId: "PTX-13371337",
+			OrderId:                req.OrderId,
+			MerchantOrderId:        req.MerchantOrderId,
+			Amount:                 "13.37",
+			Currency:               "USD",
+		},
+	}, nil
+}
+
+func (api *zotaAPIMock) requestCount() int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	return api.requests
+}
+
+func createTrackedOrder(t *testing.T, orderRepo storage.OrderRepo) *internal.Order {
+	user := internal.User{Email: "federlizer@protonmail.com"}
+	amount, _ := internal.NewMoney(1337, "USD")
+	order := internal.NewOrder(&user, amount, "Test order")
+	order.ZotaOrderId = "32ab44f0a5d9d6b6c0c9e41b1a9c7ea1a2b3c4d5"
+	order.PaymentStatus = internal.PaymentStatusSubmitted
+
+	err := orderRepo.AddOrder(order)
+	if err != nil {
+		t.Fatalf("Failed to add order: %q\n", err)
+	}
+
+	return order
+}
+
+func expectStatus(t *testing.T, orderRepo storage.OrderRepo, id string, expected internal.PaymentStatus) {
+	order, err := orderRepo.GetOrder(id)
+	if err != nil {
+		t.Fatalf("Failed to get order: %q\n", err)
+	}
+
+	if order.PaymentStatus != expected {
+		t.Errorf("Order status %q doesn't equal expected %q", order.PaymentStatus, expected)
+	}
+}
+
+func expectTracking(t *testing.T, p *Poller, expected int) {
+	entries, err := p.Tracked()
+	if err != nil {
+		t.Fatalf("Failed to get tracked orders: %q\n", err)
+	}
+
+	if len(entries) != expected {
+		t.Errorf("Number of tracked orders %d doesn't equal expected %d", len(entries), expected)
+	}
+}
+
+func TestPollerFinalisesOrder(t *testing.T) {
+	zotaApi := &zotaAPIMock{status: zota.Processing}
+	orderRepo := storage.NewMemoryOrderRepo()
+	p := New(zotaApi, orderRepo, storage.NewMemoryPayoutRepo(), storage.NewMemoryPollSchedule(), FixedPolicy{Interval: time.Minute, MaxAttempts: 20})
+
+	order := createTrackedOrder(t, orderRepo)
+	p.Track(context.Background(), storage.PollKindDeposit, order.Id.String(), order.ZotaOrderId)
+
+	// Nothing is due yet
+	p.checkDue(context.Background(), time.Now())
+	if zotaApi.requestCount() != 0 {
+		t.Errorf("Poller checked an order that wasn't due yet")
+	}
+
+	now := time.Now().Add(time.Minute)
+	p.checkDue(context.Background(), now)
+	expectStatus(t, orderRepo, order.Id.String(), internal.PaymentStatusProcessing)
+
+	entries, _ := p.Tracked()
+	if len(entries) != 1 || !entries[0].LastCheckedAt.Equal(now) || entries[0].Attempts != 1 {
+		t.Fatalf("Poll entry %+v wasn't updated after the check", entries)
+	}
+
+	zotaApi.status = zota.Approved
+	p.checkDue(context.Background(), now.Add(time.Minute))
+	expectStatus(t, orderRepo, order.Id.String(), internal.PaymentStatusApproved)
+	expectTracking(t, p, 0)
+
+	stored, _ := orderRepo.GetOrder(order.Id.String())
+	if stored.ProcessorTransactionId != "PTX-13371337" {
+		t.Errorf("Processor transaction ID %q doesn't equal expected %q", stored.ProcessorTransactionId, "PTX-13371337")
+	}
+
+	history, _ := orderRepo.GetStatusHistory(order.Id.String())
+	if len(history) != 3 || history[1].Status != internal.PaymentStatusProcessing || history[2].Source != internal.StatusSourcePoll {
+		t.Errorf("Status history %+v doesn't contain the polled statuses", history)
+	}
+}
+
+func TestPollerRecordsDeclineReason(t *testing.T) {
+	zotaApi := &zotaAPIMock{status: zota.Declined, errorMessage: "Insufficient funds"}
+	orderRepo := storage.NewMemoryOrderRepo()
+	p := New(zotaApi, orderRepo, storage.NewMemoryPayoutRepo(), storage.NewMemoryPollSchedule(), FixedPolicy{Interval: time.Minute, MaxAttempts: 20})
+
+	order := createTrackedOrder(t, orderRepo)
+	p.Track(context.Background(), storage.PollKindDeposit, order.Id.String(), order.ZotaOrderId)
+
+	p.checkDue(context.Background(), time.Now().Add(time.Minute))
+	expectStatus(t, orderRepo, order.Id.String(), internal.PaymentStatusDeclined)
+
+	history, _ := orderRepo.GetStatusHistory(order.Id.String())
+	last := history[len(history)-1]
+	if last.ErrorMessage != "Insufficient funds" || last.Source != internal.StatusSourcePoll {
+		t.Errorf("Status change %+v doesn't contain the reason of the decline", last)
+	}
+}
+
+func TestPollerFailsOrderAfterMaxAttempts(t *testing.T) {
+	zotaApi := &zotaAPIMock{status: zota.Pending}
+	orderRepo := storage.NewMemoryOrderRepo()
+	pollerMetrics := metrics.New()
+	p := New(zotaApi, orderRepo, storage.NewMemoryPayoutRepo(), storage.NewMemoryPollSchedule(), FixedPolicy{Interval: time.Minute, MaxAttempts: 3}, WithMetrics(pollerMetrics))
+
+	order := createTrackedOrder(t, orderRepo)
+	p.Track(context.Background(), storage.PollKindDeposit, order.Id.String(), order.ZotaOrderId)
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		now = now.Add(time.Minute)
+		p.checkDue(context.Background(), now)
+	}
+
+	if zotaApi.requestCount() != 3 {
+		t.Errorf("Number of Zota requests %d doesn't equal expected %d", zotaApi.requestCount(), 3)
+	}
+
+	expectStatus(t, orderRepo, order.Id.String(), internal.PaymentStatusExpired)
+	expectTracking(t, p, 0)
+	expectMetric(t, pollerMetrics, `alokin_polls_in_flight{kind="deposit"} 1`)
+	expectMetric(t, pollerMetrics, `alokin_poll_attempts_exhausted_total{kind="deposit"} 1`)
+
+	// The gauge is updated on the next tick
+	p.checkDue(context.Background(), now.Add(time.Minute))
+	expectMetric(t, pollerMetrics, `alokin_polls_in_flight{kind="deposit"} 0`)
+}
+
+func expectMetric(t *testing.T, m *metrics.Metrics, expected string) {
+	t.Helper()
+
+	resWriter := httptest.NewRecorder()
+	m.Handler().ServeHTTP(resWriter, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !strings.Contains(resWriter.Body.String(), expected+"\n") {
+		t.Errorf("Metrics %q don't contain %q", resWriter.Body.String(), expected)
+	}
+}
+
+func TestPollerStopsForFinalisedOrders(t *testing.T) {
+	zotaApi := &zotaAPIMock{status: zota.Approved}
+	orderRepo := storage.NewMemoryOrderRepo()
+	p := New(zotaApi, orderRepo, storage.NewMemoryPayoutRepo(), storage.NewMemoryPollSchedule(), FixedPolicy{Interval: time.Minute, MaxAttempts: 20})
+
+	order := createTrackedOrder(t, orderRepo)
+	p.Track(context.Background(), storage.PollKindDeposit, order.Id.String(), order.ZotaOrderId)
+
+	// Pretend a callback has declined the order in the meantime
+	orderRepo.UpdateStatus(order.Id.String(), internal.PaymentStatusSubmitted, internal.StatusChange{Status: internal.PaymentStatusDeclined})
+
+	p.checkDue(context.Background(), time.Now().Add(time.Minute))
+
+	if zotaApi.requestCount() != 0 {
+		t.Errorf("Poller checked an order that has already been finalised")
+	}
+
+	expectStatus(t, orderRepo, order.Id.String(), internal.PaymentStatusDeclined)
+	expectTracking(t, p, 0)
+}
+
+func TestPollerResumesAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alokin.db")
+	db, err := storage.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %q\n", err)
+	}
+
+	zotaApi := &zotaAPIMock{status: zota.Processing}
+	orderRepo := storage.NewSQLiteOrderRepo(db)
+	p := New(zotaApi, orderRepo, storage.NewMemoryPayoutRepo(), storage.NewSQLitePollSchedule(db), FixedPolicy{Interval: time.Minute, MaxAttempts: 20})
+
+	tracked := createTrackedOrder(t, orderRepo)
+	p.Track(logging.WithRequestId(context.Background(), "5b0f9a52"), storage.PollKindDeposit, tracked.Id.String(), tracked.ZotaOrderId)
+	p.checkDue(context.Background(), time.Now().Add(time.Minute))
+
+	// Checks are made for the request that created the order
+	if zotaApi.requestId != "5b0f9a52" {
+		t.Errorf("Request ID %q of the check doesn't equal expected %q", zotaApi.requestId, "5b0f9a52")
+	}
+
+	// An order that was created before the schedule was persisted
+	untracked := createTrackedOrder(t, orderRepo)
+
+	db.Close()
+
+	// "Restart" the application
+	db, err = storage.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %q\n", err)
+	}
+	defer db.Close()
+
+	zotaApi.status = zota.Approved
+	orderRepo = storage.NewSQLiteOrderRepo(db)
+	p = New(zotaApi, orderRepo, storage.NewMemoryPayoutRepo(), storage.NewSQLitePollSchedule(db), FixedPolicy{Interval: time.Minute, MaxAttempts: 20})
+
+	err = p.resume()
+	if err != nil {
+		t.Fatalf("Failed to resume polling: %q\n", err)
+	}
+
+	entries, _ := p.Tracked()
+	if len(entries) != 2 {
+		t.Fatalf("Number of tracked orders %d doesn't equal expected %d", len(entries), 2)
+	}
+
+	for _, entry := range entries {
+		if entry.Id == tracked.Id.String() && (entry.Attempts != 1 || entry.RequestId != "5b0f9a52") {
+			t.Errorf("Poll entry %+v lost its attempts or request ID after restarting", entry)
+		}
+	}
+
+	p.checkDue(context.Background(), time.Now().Add(time.Hour))
+	expectStatus(t, orderRepo, tracked.Id.String(), internal.PaymentStatusApproved)
+	expectStatus(t, orderRepo, untracked.Id.String(), internal.PaymentStatusApproved)
+	expectTracking(t, p, 0)
+}
+
+func TestPollerStoresLongDelays(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alokin.db")
+	db, err := storage.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %q\n", err)
+	}
+
+	// Without a Max, the delay grows past what can be stored
+	policy := ExponentialPolicy{Initial: time.Second, Multiplier: 2}
+
+	zotaApi := &zotaAPIMock{status: zota.Processing}
+	orderRepo := storage.NewSQLiteOrderRepo(db)
+	schedule := storage.NewSQLitePollSchedule(db)
+	p := New(zotaApi, orderRepo, storage.NewMemoryPayoutRepo(), schedule, policy)
+
+	tracked := createTrackedOrder(t, orderRepo)
+	p.Track(context.Background(), storage.PollKindDeposit, tracked.Id.String(), tracked.ZotaOrderId)
+
+	entries, _ := schedule.GetAll()
+	entries[0].Attempts = 2000
+	schedule.Schedule(entries[0])
+
+	now := time.Now().Add(time.Minute)
+	p.checkDue(context.Background(), now)
+
+	db.Close()
+
+	db, err = storage.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %q\n", err)
+	}
+	defer db.Close()
+
+	entries, err = storage.NewSQLitePollSchedule(db).GetAll()
+	if err != nil {
+		t.Fatalf("Failed to get poll schedule: %q\n", err)
+	}
+
+	if len(entries) != 1 || !entries[0].NextCheckAt.Equal(now.Add(MaxDelay)) {
+		t.Errorf("Poll entries %+v aren't due in %v", entries, MaxDelay)
+	}
+}
+
+func TestPollerResumesPayoutsAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alokin.db")
+	db, err := storage.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %q\n", err)
+	}
+
+	zotaApi := &zotaAPIMock{status: zota.Processing}
+	payoutRepo := storage.NewSQLitePayoutRepo(db)
+	p := New(zotaApi, storage.NewSQLiteOrderRepo(db), payoutRepo, storage.NewSQLitePollSchedule(db), FixedPolicy{Interval: time.Minute, MaxAttempts: 20})
+
+	user := internal.User{Email: "federlizer@protonmail.com"}
+	amount, _ := internal.NewMoney(1337, "USD")
+	payout := internal.NewPayout(&user, &internal.BankAccount{AccountNumber: "DK5000400440116243", CountryCode: "DK"}, amount, "Test payout")
+	payout.ZotaOrderId = "9697960f4561f634dd5363590e55c93586a3721e"
+
+	err = payoutRepo.AddPayout(payout)
+	if err != nil {
+		t.Fatalf("Failed to add payout: %q\n", err)
+	}
+
+	p.Track(context.Background(), storage.PollKindPayout, payout.Id.String(), payout.ZotaOrderId)
+	p.checkDue(context.Background(), time.Now().Add(time.Minute))
+
+	db.Close()
+
+	// "Restart" the application
+	db, err = storage.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %q\n", err)
+	}
+	defer db.Close()
+
+	zotaApi.status = zota.Approved
+	payoutRepo = storage.NewSQLitePayoutRepo(db)
+	p = New(zotaApi, storage.NewSQLiteOrderRepo(db), payoutRepo, storage.NewSQLitePollSchedule(db), FixedPolicy{Interval: time.Minute, MaxAttempts: 20})
+
+	err = p.resume()
+	if err != nil {
+		t.Fatalf("Failed to resume polling: %q\n", err)
+	}
+
+	entries, _ := p.Tracked()
+	if len(entries) != 1 || entries[0].Kind != storage.PollKindPayout || entries[0].Attempts != 1 {
+		t.Fatalf("Poll entries %+v don't hold the payout's attempts after restarting", entries)
+	}
+
+	p.checkDue(context.Background(), time.Now().Add(time.Hour))
+
+	stored, err := payoutRepo.GetPayout(payout.Id.String())
+	if err != nil {
+		t.Fatalf("Failed to get payout: %q\n", err)
+	}
+
+	if stored.PaymentStatus != internal.PaymentStatusApproved {
+		t.Errorf("Payout status %q doesn't equal expected %q", stored.PaymentStatus, internal.PaymentStatusApproved)
+	}
+	expectTracking(t, p, 0)
+}
+
+func TestPollerStartStop(t *testing.T) {
+	zotaApi := &zotaAPIMock{status: zota.Approved}
+	orderRepo := storage.NewMemoryOrderRepo()
+	p := New(zotaApi, orderRepo, storage.NewMemoryPayoutRepo(), storage.NewMemoryPollSchedule(), FixedPolicy{Interval: 10 * time.Millisecond, MaxAttempts: 20})
+
+	order := createTrackedOrder(t, orderRepo)
+
+	err := p.Start()
+	if err != nil {
+		t.Fatalf("Failed to start poller: %q\n", err)
+	}
+
+	if !p.Running() {
+		t.Errorf("Poller isn't running after being started")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for zotaApi.requestCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	p.Stop()
+
+	if p.Running() {
+		t.Errorf("Poller is still running after being stopped")
+	}
+
+	expectStatus(t, orderRepo, order.Id.String(), internal.PaymentStatusApproved)
+}
+
+func TestPollerShutdown(t *testing.T) {
+	tests := []struct {
+		name string
+		// finish is whether the check in progress finishes before the
+		// shutdown times out
+		finish bool
+	}{
+		{"check finishes", true},
+		{"check is aborted", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			zotaApi := &zotaAPIMock{status: zota.Approved, started: make(chan struct{}, 1), release: make(chan struct{})}
+			orderRepo := storage.NewMemoryOrderRepo()
+			p := New(zotaApi, orderRepo, storage.NewMemoryPayoutRepo(), storage.NewMemoryPollSchedule(), FixedPolicy{Interval: 10 * time.Millisecond, MaxAttempts: 20})
+
+			order := createTrackedOrder(t, orderRepo)
+			p.Track(context.Background(), storage.PollKindDeposit, order.Id.String(), order.ZotaOrderId)
+
+			err := p.Start()
+			if err != nil {
+				t.Fatalf("Failed to start poller: %q\n", err)
+			}
+
+			select {
+			case <-zotaApi.started:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Poller didn't check the order")
+			}
+
+			timeout := 50 * time.Millisecond
+			if test.finish {
+				timeout = 5 * time.Second
+				time.AfterFunc(10*time.Millisecond, func() { close(zotaApi.release) })
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			err = p.Shutdown(ctx)
+			if p.Running() {
+				t.Errorf("Poller is still running after shutting down")
+			}
+
+			if test.finish {
+				if err != nil {
+					t.Errorf("Failed to shut down poller: %q\n", err)
+				}
+
+				expectStatus(t, orderRepo, order.Id.String(), internal.PaymentStatusApproved)
+				expectTracking(t, p, 0)
+				return
+			}
+
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Error %v isn't context.DeadlineExceeded", err)
+			}
+
+			// The aborted check is made again once the poller is started again
+			expectStatus(t, orderRepo, order.Id.String(), internal.PaymentStatusSubmitted)
+			entries, _ := p.Tracked()
+			if len(entries) != 1 || entries[0].Attempts != 0 {
+				t.Errorf("Poll entries %+v weren't kept as they were", entries)
+			}
+		})
+	}
+}
+
+func TestPollerFailsOrderOnPermanentError(t *testing.T) {
+	zotaApi := &zotaAPIMock{err: &zota.APIError{HTTPStatus: 400}}
+	orderRepo := storage.NewMemoryOrderRepo()
+	p := New(zotaApi, orderRepo, storage.NewMemoryPayoutRepo(), storage.NewMemoryPollSchedule(), FixedPolicy{Interval: time.Minute, MaxAttempts: 20})
+
+	order := createTrackedOrder(t, orderRepo)
+	p.Track(context.Background(), storage.PollKindDeposit, order.Id.String(), order.ZotaOrderId)
+
+	p.checkDue(context.Background(), time.Now().Add(time.Minute))
+
+	if zotaApi.requestCount() != 1 {
+		t.Errorf("Number of Zota requests %d doesn't equal expected %d", zotaApi.requestCount(), 1)
+	}
+
+	expectStatus(t, orderRepo, order.Id.String(), internal.PaymentStatusError)
+	expectTracking(t, p, 0)
+}
+
+func TestPollerRetriesTemporaryErrors(t *testing.T) {
+	zotaApi := &zotaAPIMock{err: &zota.APIError{HTTPStatus: 503}}
+	orderRepo := storage.NewMemoryOrderRepo()
+	p := New(zotaApi, orderRepo, storage.NewMemoryPayoutRepo(), storage.NewMemoryPollSchedule(), FixedPolicy{Interval: time.Minute, MaxAttempts: 20})
+
+	order := createTrackedOrder(t, orderRepo)
+	p.Track(context.Background(), storage.PollKindDeposit, order.Id.String(), order.ZotaOrderId)
+
+	now := time.Now().Add(time.Minute)
+	p.checkDue(context.Background(), now)
+
+	expectStatus(t, orderRepo, order.Id.String(), internal.PaymentStatusSubmitted)
+
+	entries, _ := p.Tracked()
+	if len(entries) != 1 || !entries[0].NextCheckAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("Poll entry %+v wasn't rescheduled after a temporary error", entries)
+	}
+}
+
+func TestPollerKeepsOrdersZotaCantReport(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"unauthorized", &zota.APIError{HTTPStatus: 401, Code: "401"}},
+		{"forbidden", &zota.APIError{HTTPStatus: 200, Code: "403"}},
+		{"not found", &zota.APIError{HTTPStatus: 404}},
+		{"malformed response", zota.ErrMalformedResponse},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			zotaApi := &zotaAPIMock{err: test.err}
+			orderRepo := storage.NewMemoryOrderRepo()
+			p := New(zotaApi, orderRepo, storage.NewMemoryPayoutRepo(), storage.NewMemoryPollSchedule(), FixedPolicy{Interval: time.Minute, MaxAttempts: 2})
+
+			order := createTrackedOrder(t, orderRepo)
+			p.Track(context.Background(), storage.PollKindDeposit, order.Id.String(), order.ZotaOrderId)
+
+			now := time.Now().Add(time.Minute)
+			p.checkDue(context.Background(), now)
+			expectStatus(t, orderRepo, order.Id.String(), internal.PaymentStatusSubmitted)
+			expectTracking(t, p, 1)
+
+			// Orders aren't expired over credentials Zota rejects or orders
+			// it doesn't know, only over errors that are about the order
+			p.checkDue(context.Background(), now.Add(time.Minute))
+
+			unresolved := errors.Is(test.err, zota.ErrUnauthorized) || errors.Is(test.err, zota.ErrNotFound)
+			if !unresolved {
+				expectStatus(t, orderRepo, order.Id.String(), internal.PaymentStatusExpired)
+				expectTracking(t, p, 0)
+				return
+			}
+
+			expectStatus(t, orderRepo, order.Id.String(), internal.PaymentStatusSubmitted)
+			entries, _ := p.Tracked()
+			if len(entries) != 1 || !entries[0].NextCheckAt.Equal(now.Add(time.Minute+unresolvedInterval)) {
+				t.Errorf("Poll entries %+v weren't rescheduled after the policy gave up", entries)
+			}
+		})
+	}
+}
+
+func TestPollerRespectsRetryAfter(t *testing.T) {
+	zotaApi := &zotaAPIMock{err: &zota.APIError{HTTPStatus: 429, RetryAfter: 10 * time.Minute}}
+	orderRepo := storage.NewMemoryOrderRepo()
+	p := New(zotaApi, orderRepo, storage.NewMemoryPayoutRepo(), storage.NewMemoryPollSchedule(), FixedPolicy{Interval: time.Minute, MaxAttempts: 20})
+
+	first := createTrackedOrder(t, orderRepo)
+	p.Track(context.Background(), storage.PollKindDeposit, first.Id.String(), first.ZotaOrderId)
+	second := createTrackedOrder(t, orderRepo)
+	p.Track(context.Background(), storage.PollKindDeposit, second.Id.String(), second.ZotaOrderId)
+
+	// The first rate limited check pauses every other check
+	now := time.Now().Add(time.Minute)
+	p.checkDue(context.Background(), now)
+	if zotaApi.requestCount() != 1 {
+		t.Errorf("Number of Zota requests %d doesn't equal expected %d", zotaApi.requestCount(), 1)
+	}
+
+	p.checkDue(context.Background(), now.Add(5*time.Minute))
+	if zotaApi.requestCount() != 1 {
+		t.Errorf("Poller checked orders before the Retry-After delay had passed")
+	}
+
+	entries, _ := p.Tracked()
+	for _, entry := range entries {
+		if entry.Attempts == 1 && !entry.NextCheckAt.Equal(now.Add(10*time.Minute)) {
+			t.Errorf("Poll entry %+v wasn't rescheduled after the Retry-After delay", entry)
+		}
+	}
+
+	zotaApi.mu.Lock()
+	zotaApi.err = nil
+	zotaApi.status = zota.Approved
+	zotaApi.mu.Unlock()
+
+	p.checkDue(context.Background(), now.Add(10*time.Minute))
+	expectStatus(t, orderRepo, first.Id.String(), internal.PaymentStatusApproved)
+	expectStatus(t, orderRepo, second.Id.String(), internal.PaymentStatusApproved)
+	expectTracking(t, p, 0)
+}
+
+func TestPollerChecksAreLinkedToRequest(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracing.SetDefault(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer tracing.SetDefault(noop.NewTracerProvider())
+
+	zotaApi := &zotaAPIMock{status: zota.Approved}
+	orderRepo := storage.NewMemoryOrderRepo()
+	p := New(zotaApi, orderRepo, storage.NewMemoryPayoutRepo(), storage.NewMemoryPollSchedule(), FixedPolicy{Interval: time.Minute, MaxAttempts: 20})
+
+	order := createTrackedOrder(t, orderRepo)
+	ctx, request := tracing.Start(context.Background(), "POST /order")
+	p.Track(ctx, storage.PollKindDeposit, order.Id.String(), order.ZotaOrderId)
+	request.End()
+
+	entries, _ := p.Tracked()
+	if len(entries) != 1 || entries[0].TraceParent != tracing.Traceparent(ctx) {
+		t.Fatalf("Poll entries %+v don't carry the request's traceparent %q", entries, tracing.Traceparent(ctx))
+	}
+
+	p.checkDue(context.Background(), time.Now().Add(time.Minute))
+	expectStatus(t, orderRepo, order.Id.String(), internal.PaymentStatusApproved)
+
+	var check sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "Poller check" {
+			check = span
+		}
+	}
+	if check == nil {
+		t.Fatalf("Check span wasn't recorded")
+	}
+
+	// Every check is a trace of its own
+	expected := request.SpanContext()
+	if check.SpanContext().TraceID() == expected.TraceID() || check.Parent().IsValid() {
+		t.Errorf("Check span wasn't recorded in a trace of its own")
+	}
+
+	links := check.Links()
+	if len(links) != 1 || links[0].SpanContext.TraceID() != expected.TraceID() || links[0].SpanContext.SpanID() != expected.SpanID() {
+		t.Errorf("Links %+v of the check don't link to the request's span %+v", links, expected)
+	}
+}
+
+func TestPollerExpiresOrderAfterProgress(t *testing.T) {
+	zotaApi := &zotaAPIMock{status: zota.Pending}
+	orderRepo := storage.NewMemoryOrderRepo()
+	p := New(zotaApi, orderRepo, storage.NewMemoryPayoutRepo(), storage.NewMemoryPollSchedule(), FixedPolicy{Interval: time.Minute, MaxAttempts: 1})
+
+	order := createTrackedOrder(t, orderRepo)
+	p.Track(context.Background(), storage.PollKindDeposit, order.Id.String(), order.ZotaOrderId)
+
+	// The last attempt records the progress and gives up in the same check
+	p.checkDue(context.Background(), time.Now().Add(time.Minute))
+	expectStatus(t, orderRepo, order.Id.String(), internal.PaymentStatusExpired)
+	expectTracking(t, p, 0)
+}
+
+func TestPollerKeepsOrderChangedConcurrently(t *testing.T) {
+	zotaApi := &zotaAPIMock{status: zota.Processing}
+	orderRepo := storage.NewMemoryOrderRepo()
+	p := New(zotaApi, orderRepo, storage.NewMemoryPayoutRepo(), storage.NewMemoryPollSchedule(), FixedPolicy{Interval: time.Minute, MaxAttempts: 20})
+
+	order := createTrackedOrder(t, orderRepo)
+	p.Track(context.Background(), storage.PollKindDeposit, order.Id.String(), order.ZotaOrderId)
+
+	// A callback changes the order between reading and finalising it
+	entries, _ := p.Tracked()
+	err := orderRepo.UpdateStatus(order.Id.String(), internal.PaymentStatusSubmitted, internal.StatusChange{Status: internal.PaymentStatusPending, Source: internal.StatusSourceCallback})
+	if err != nil {
+		t.Fatalf("Failed to update order: %q\n", err)
+	}
+
+	p.finalise(context.Background(), entries[0], internal.PaymentStatusSubmitted, internal.StatusChange{Status: internal.PaymentStatusExpired, Source: internal.StatusSourcePoll})
+	expectStatus(t, orderRepo, order.Id.String(), internal.PaymentStatusPending)
+	expectTracking(t, p, 1)
+}
@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is a logged in user's bearer token. Only the hash of the token is
+// stored, so the token can't be recovered from storage.
+type Session struct {
+	TokenHash string
+	UserId    uuid.UUID
+	ExpiresAt time.Time
+}
+
+// NewSession creates a session for the user that expires after ttl and
+// returns it with its token, which is only known to the client from then on
+func NewSession(userId uuid.UUID, ttl time.Duration) (*Session, string, error) {
+	tokenBytes := make([]byte, 32)
+	_, err := rand.Read(tokenBytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+	session := &Session{
+		TokenHash: HashToken(token),
+		UserId:    userId,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	return session, token, nil
+}
+
+// HashToken returns the hash sessions are looked up by
+func HashToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// IsExpired reports whether the session has expired at the given time
+func (s *Session) IsExpired(now time.Time) bool {
+	return !now.Before(s.ExpiresAt)
+}
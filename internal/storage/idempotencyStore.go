@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrIdempotencyKeyExists is returned by Reserve when a request with the same
+// idempotency key has been made before
+var ErrIdempotencyKeyExists = errors.New("Idempotency key has already been used")
+
+// IdempotencyRecord is a request made with an idempotency key and, once it
+// has been handled, the response to it
+type IdempotencyRecord struct {
+	Key string
+	// Fingerprint identifies the request, so that reusing the key for a
+	// different request can be detected
+	Fingerprint string
+
+	// Completed is false while the request is still being handled
+	Completed   bool
+	StatusCode  int
+	ContentType string
+	Location    string
+	Body        []byte
+
+	// LockedUntil is when a request that hasn't completed is considered
+	// abandoned, e.g. because the server was restarted while handling it
+	LockedUntil time.Time
+	// ExpiresAt is when the key can be reused for a new request
+	ExpiresAt time.Time
+}
+
+// IdempotencyStore stores the requests made with idempotency keys and their
+// responses.
+//
+// Implementations are safe for concurrent use.
+type IdempotencyStore interface {
+	// Reserve stores the record of a new request. If an unexpired record
+	// with the same key exists, it's returned with ErrIdempotencyKeyExists
+	// instead, unless it has been abandoned. Expired records are removed.
+	Reserve(record IdempotencyRecord, now time.Time) (*IdempotencyRecord, error)
+	// Complete stores the response to the request, the record is replaced
+	Complete(record IdempotencyRecord) error
+	// Release removes the record, so the key can be used again
+	Release(key string) error
+}
+
+// MemoryIdempotencyStore is a simple in-memory IdempotencyStore
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]IdempotencyRecord
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		records: make(map[string]IdempotencyRecord),
+	}
+}
+
+func (s *MemoryIdempotencyStore) Reserve(record IdempotencyRecord, now time.Time) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, existing := range s.records {
+		if !now.Before(existing.ExpiresAt) {
+			delete(s.records, key)
+		}
+	}
+
+	existing, exists := s.records[record.Key]
+	if exists && (existing.Completed || now.Before(existing.LockedUntil)) {
+		return &existing, ErrIdempotencyKeyExists
+	}
+
+	s.records[record.Key] = record
+	return nil, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(record IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, exists := s.records[record.Key]
+	if !exists {
+		return ErrNotFound
+	}
+
+	record.Completed = true
+	s.records[record.Key] = record
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, exists := s.records[key]
+	if !exists {
+		return ErrNotFound
+	}
+
+	delete(s.records, key)
+	return nil
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testIdempotencyStore checks the behaviour every IdempotencyStore
+// implementation must have
+func testIdempotencyStore(t *testing.T, store IdempotencyStore) {
+	now := time.Now()
+	record := IdempotencyRecord{
+		Key:         "user:key",
+		Fingerprint: "fingerprint",
+		LockedUntil: now.Add(time.Minute),
+		ExpiresAt:   now.Add(time.Hour),
+	}
+
+	existing, err := store.Reserve(record, now)
+	if err != nil || existing != nil {
+		t.Fatalf("Failed to reserve new key: %v\n", err)
+	}
+
+	existing, err = store.Reserve(record, now)
+	if !errors.Is(err, ErrIdempotencyKeyExists) || existing.Completed {
+		t.Errorf("Reserving a pending key returned %+v, %v, expected the pending record", existing, err)
+	}
+
+	// The first request was abandoned, e.g. because the server was restarted
+	existing, err = store.Reserve(record, now.Add(2*time.Minute))
+	if err != nil || existing != nil {
+		t.Errorf("Reserving an abandoned key returned %+v, %v, expected it to be taken over", existing, err)
+	}
+
+	record.StatusCode = 302
+	record.Location = "https://zota.com/deposit"
+	record.Body = []byte("body")
+	err = store.Complete(record)
+	if err != nil {
+		t.Fatalf("Failed to complete record: %q\n", err)
+	}
+
+	existing, err = store.Reserve(record, now.Add(30*time.Minute))
+	if !errors.Is(err, ErrIdempotencyKeyExists) {
+		t.Fatalf("Reserving a completed key returned %v, expected %v", err, ErrIdempotencyKeyExists)
+	}
+
+	if !existing.Completed || existing.StatusCode != 302 || existing.Location != record.Location || string(existing.Body) != "body" {
+		t.Errorf("Completed record %+v doesn't contain the response", *existing)
+	}
+
+	// Expired keys can be reused
+	existing, err = store.Reserve(record, now.Add(time.Hour))
+	if err != nil || existing != nil {
+		t.Errorf("Reserving an expired key returned %+v, %v, expected it to be reused", existing, err)
+	}
+
+	err = store.Release(record.Key)
+	if err != nil {
+		t.Fatalf("Failed to release key: %q\n", err)
+	}
+
+	err = store.Release(record.Key)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Releasing a released key returned %v, expected %v", err, ErrNotFound)
+	}
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	testIdempotencyStore(t, NewMemoryIdempotencyStore())
+}
+
+func TestSQLiteIdempotencyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alokin.db")
+	db, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %q\n", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	testIdempotencyStore(t, NewSQLiteIdempotencyStore(db))
+
+	// Completed requests are remembered across restarts
+	now := time.Now()
+	record := IdempotencyRecord{Key: "user:restart", Fingerprint: "fingerprint", ExpiresAt: now.Add(time.Hour)}
+	store := NewSQLiteIdempotencyStore(db)
+	store.Reserve(record, now)
+	record.StatusCode = 201
+	store.Complete(record)
+	db.Close()
+
+	db, err = OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %q\n", err)
+	}
+
+	existing, err := NewSQLiteIdempotencyStore(db).Reserve(record, now)
+	if !errors.Is(err, ErrIdempotencyKeyExists) || existing.StatusCode != 201 {
+		t.Errorf("Reserving a key completed before the restart returned %+v, %v", existing, err)
+	}
+}
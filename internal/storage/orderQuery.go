@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+)
+
+// ErrInvalidCursor is returned by QueryOrders when the cursor wasn't
+// returned by a query with the same sorting and filters
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+// OrderSort is the field orders are sorted by
+type OrderSort string
+
+const (
+	SortByCreatedAt OrderSort = "createdAt"
+	SortByAmount    OrderSort = "amount"
+)
+
+const (
+	// DefaultOrderLimit is the page size of queries without a limit
+	DefaultOrderLimit = 50
+	// MaxOrderLimit is the largest page size a query can ask for
+	MaxOrderLimit = 100
+)
+
+// OrderQuery selects, sorts and pages the orders returned by QueryOrders.
+// Zero values don't filter anything.
+type OrderQuery struct {
+	// UserId only selects the orders made by the user with this ID
+	UserId string
+	// Statuses only selects orders with one of these payment statuses
+	Statuses []internal.PaymentStatus
+	Currency string
+	// CreatedFrom and CreatedTo select orders created in [CreatedFrom, CreatedTo)
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// MinAmount and MaxAmount select orders with an amount in
+	// [MinAmount, MaxAmount], in minor units. They're only meaningful
+	// together with a Currency.
+	MinAmount int64
+	MaxAmount int64
+
+	// SortBy is the field orders are sorted by, SortByCreatedAt if empty.
+	// Orders with the same value are sorted by their ID, so the order is
+	// stable across pages.
+	SortBy     OrderSort
+	Descending bool
+
+	// Limit is the maximum number of orders returned, DefaultOrderLimit if
+	// zero and at most MaxOrderLimit
+	Limit int
+	// Cursor continues the query after the last order of a previous page,
+	// see OrderPage.NextCursor
+	Cursor string
+}
+
+// OrderPage is a single page of the orders selected by an OrderQuery
+type OrderPage struct {
+	Orders []*internal.Order
+	// NextCursor continues the query with the next page, it's empty if this
+	// is the last page
+	NextCursor string
+}
+
+// normalise fills in the defaults of the query and validates it
+func (q OrderQuery) normalise() (OrderQuery, error) {
+	if q.SortBy == "" {
+		q.SortBy = SortByCreatedAt
+	}
+
+	if q.SortBy != SortByCreatedAt && q.SortBy != SortByAmount {
+		return q, fmt.Errorf("Unknown sort field %q", q.SortBy)
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = DefaultOrderLimit
+	}
+	if q.Limit > MaxOrderLimit {
+		q.Limit = MaxOrderLimit
+	}
+
+	return q, nil
+}
+
+// matches reports whether the order is selected by the query's filters
+func (q OrderQuery) matches(order *internal.Order) bool {
+	if q.UserId != "" && order.User.Id.String() != q.UserId {
+		return false
+	}
+
+	if len(q.Statuses) > 0 {
+		found := false
+		for _, status := range q.Statuses {
+			found = found || order.PaymentStatus == status
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if q.Currency != "" && order.Amount.Currency() != q.Currency {
+		return false
+	}
+
+	if !q.CreatedFrom.IsZero() && order.CreatedAt.Before(q.CreatedFrom) {
+		return false
+	}
+
+	if !q.CreatedTo.IsZero() && !order.CreatedAt.Before(q.CreatedTo) {
+		return false
+	}
+
+	if q.MinAmount != 0 && order.Amount.Minor() < q.MinAmount {
+		return false
+	}
+
+	if q.MaxAmount != 0 && order.Amount.Minor() > q.MaxAmount {
+		return false
+	}
+
+	return true
+}
+
+// sortKey returns the value of the field the query sorts by
+func (q OrderQuery) sortKey(order *internal.Order) int64 {
+	if q.SortBy == SortByAmount {
+		return order.Amount.Minor()
+	}
+
+	return order.CreatedAt.UnixNano()
+}
+
+// orderCursor is the position of the last order of a page. It's only valid
+// for the query that returned it, so it remembers the query's sorting and a
+// hash of its filters.
+type orderCursor struct {
+	sortBy     OrderSort
+	descending bool
+	filters    string
+	key        int64
+	orderId    string
+}
+
+func (c orderCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s:%s:%d:%s", c.sortBy, direction(c.descending), c.filters, c.key, c.orderId)))
+}
+
+// direction returns the name of the sort direction
+func direction(descending bool) string {
+	if descending {
+		return "desc"
+	}
+
+	return "asc"
+}
+
+// filterHash returns a hash of the query's filters, so that a cursor can't
+// continue a query with different filters, which might skip or repeat orders
+func (q OrderQuery) filterHash() string {
+	statuses := make([]string, len(q.Statuses))
+	for i, status := range q.Statuses {
+		statuses[i] = string(status)
+	}
+	sort.Strings(statuses)
+
+	var createdFrom, createdTo int64
+	if !q.CreatedFrom.IsZero() {
+		createdFrom = q.CreatedFrom.UnixNano()
+	}
+	if !q.CreatedTo.IsZero() {
+		createdTo = q.CreatedTo.UnixNano()
+	}
+
+	filters := fmt.Sprintf("%q|%q|%q|%d|%d|%d|%d", q.UserId, strings.Join(statuses, ","), q.Currency, createdFrom, createdTo, q.MinAmount, q.MaxAmount)
+	hash := sha256.Sum256([]byte(filters))
+
+	return hex.EncodeToString(hash[:8])
+}
+
+// decodeCursor parses the query's cursor, which needs to have been returned
+// by a query with the same sorting and filters
+func decodeCursor(query OrderQuery) (*orderCursor, error) {
+	if query.Cursor == "" {
+		return nil, nil
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(decoded), ":", 5)
+	if len(parts) != 5 || OrderSort(parts[0]) != query.SortBy || parts[1] != direction(query.Descending) || parts[2] != query.filterHash() {
+		return nil, ErrInvalidCursor
+	}
+
+	key, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &orderCursor{sortBy: query.SortBy, descending: query.Descending, filters: parts[2], key: key, orderId: parts[4]}, nil
+}
+
+// after reports whether the order comes after the cursor in the query's order
+func (c *orderCursor) after(query OrderQuery, order *internal.Order) bool {
+	key := query.sortKey(order)
+	id := order.Id.String()
+
+	if query.Descending {
+		return key < c.key || (key == c.key && id < c.orderId)
+	}
+
+	return key > c.key || (key == c.key && id > c.orderId)
+}
+
+// nextCursor returns the cursor to continue after the last of the orders
+func nextCursor(query OrderQuery, orders []*internal.Order) string {
+	last := orders[len(orders)-1]
+
+	return orderCursor{
+		sortBy:     query.SortBy,
+		descending: query.Descending,
+		filters:    query.filterHash(),
+		key:        query.sortKey(last),
+		orderId:    last.Id.String(),
+	}.encode()
+}
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+)
+
+// addQueryTestOrders adds orders of two users with different amounts,
+// currencies, creation times and statuses. The orders of the first user are
+// returned in the order they were created in.
+func addQueryTestOrders(t *testing.T, repo OrderRepo) (*internal.User, []*internal.Order) {
+	user := createTestUser("federlizer@protonmail.com")
+	otherUser := createTestUser("someone@example.com")
+
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	amounts := []struct {
+		minor    int64
+		currency string
+	}{
+		{500, "USD"},
+		{1337, "USD"},
+		{1337, "EUR"},
+		{100, "USD"},
+		{99900, "USD"},
+	}
+
+	var orders []*internal.Order
+	for i, amount := range amounts {
+		money, _ := internal.NewMoney(amount.minor, amount.currency)
+
+		order := internal.NewOrder(user, money, "Test order")
+		order.CreatedAt = start.Add(time.Duration(i) * time.Hour)
+		orders = append(orders, order)
+
+		otherOrder := internal.NewOrder(otherUser, money, "Other order")
+		otherOrder.CreatedAt = order.CreatedAt
+
+		for _, o := range []*internal.Order{order, otherOrder} {
+			err := repo.AddOrder(o)
+			if err != nil {
+				t.Fatalf("Failed to add order: %q\n", err)
+			}
+		}
+	}
+
+	err := repo.UpdateStatus(orders[1].Id.String(), internal.PaymentStatusCreated, internal.StatusChange{Status: internal.PaymentStatusSubmitted})
+	if err != nil {
+		t.Fatalf("Failed to update status: %q\n", err)
+	}
+	orders[1].PaymentStatus = internal.PaymentStatusSubmitted
+
+	return user, orders
+}
+
+func expectOrderIds(t *testing.T, name string, actual []*internal.Order, expected ...*internal.Order) {
+	t.Helper()
+
+	actualIds := make([]uuid.UUID, len(actual))
+	for i, order := range actual {
+		actualIds[i] = order.Id
+	}
+
+	expectedIds := make([]uuid.UUID, len(expected))
+	for i, order := range expected {
+		expectedIds[i] = order.Id
+	}
+
+	if len(actualIds) != len(expectedIds) {
+		t.Errorf("%v: Orders %v don't equal expected %v", name, actualIds, expectedIds)
+		return
+	}
+
+	for i := range actualIds {
+		if actualIds[i] != expectedIds[i] {
+			t.Errorf("%v: Orders %v don't equal expected %v", name, actualIds, expectedIds)
+			return
+		}
+	}
+}
+
+// testQueryOrders checks the behaviour every OrderRepo implementation of
+// QueryOrders must have
+func testQueryOrders(t *testing.T, repo OrderRepo) {
+	user, orders := addQueryTestOrders(t, repo)
+	userId := user.Id.String()
+
+	tests := []struct {
+		name     string
+		query    OrderQuery
+		expected []*internal.Order
+	}{
+		{"user", OrderQuery{UserId: userId}, orders},
+		{"descending", OrderQuery{UserId: userId, Descending: true}, []*internal.Order{orders[4], orders[3], orders[2], orders[1], orders[0]}},
+		{"status", OrderQuery{UserId: userId, Statuses: []internal.PaymentStatus{internal.PaymentStatusSubmitted}}, []*internal.Order{orders[1]}},
+		{"currency", OrderQuery{UserId: userId, Currency: "EUR"}, []*internal.Order{orders[2]}},
+		{"created", OrderQuery{UserId: userId, CreatedFrom: orders[1].CreatedAt, CreatedTo: orders[3].CreatedAt}, []*internal.Order{orders[1], orders[2]}},
+		{"amount", OrderQuery{UserId: userId, Currency: "USD", MinAmount: 500, MaxAmount: 1337}, []*internal.Order{orders[0], orders[1]}},
+		{"sort by amount", OrderQuery{UserId: userId, Currency: "USD", SortBy: SortByAmount}, []*internal.Order{orders[3], orders[0], orders[1], orders[4]}},
+		{"limit", OrderQuery{UserId: userId, Limit: 2}, []*internal.Order{orders[0], orders[1]}},
+	}
+
+	for _, test := range tests {
+		page, err := repo.QueryOrders(test.query)
+		if err != nil {
+			t.Fatalf("%v: Failed to query orders: %q\n", test.name, err)
+		}
+
+		expectOrderIds(t, test.name, page.Orders, test.expected...)
+	}
+
+	all, err := repo.QueryOrders(OrderQuery{Limit: MaxOrderLimit})
+	if err != nil {
+		t.Fatalf("Failed to query orders: %q\n", err)
+	}
+
+	if len(all.Orders) != 2*len(orders) || all.NextCursor != "" {
+		t.Errorf("Query without filters returned %d orders instead of %d", len(all.Orders), 2*len(orders))
+	}
+
+	_, err = repo.QueryOrders(OrderQuery{Cursor: "not a cursor"})
+	if !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Querying with an invalid cursor returned %v, expected %v", err, ErrInvalidCursor)
+	}
+}
+
+// testQueryOrdersPages checks that paging through the orders returns every
+// order exactly once, in a stable order, even if orders have the same value
+// of the sort field
+func testQueryOrdersPages(t *testing.T, repo OrderRepo) {
+	user, orders := addQueryTestOrders(t, repo)
+
+	for _, descending := range []bool{false, true} {
+		query := OrderQuery{UserId: user.Id.String(), SortBy: SortByAmount, Descending: descending, Limit: 2}
+
+		var paged []*internal.Order
+		for pages := 0; pages < 5; pages++ {
+			page, err := repo.QueryOrders(query)
+			if err != nil {
+				t.Fatalf("Failed to query orders: %q\n", err)
+			}
+
+			paged = append(paged, page.Orders...)
+			if page.NextCursor == "" {
+				break
+			}
+
+			query.Cursor = page.NextCursor
+		}
+
+		all, err := repo.QueryOrders(OrderQuery{UserId: user.Id.String(), SortBy: SortByAmount, Descending: descending})
+		if err != nil {
+			t.Fatalf("Failed to query orders: %q\n", err)
+		}
+
+		expectOrderIds(t, "pages", paged, all.Orders...)
+		if len(paged) != len(orders) {
+			t.Errorf("Paging returned %d orders instead of %d", len(paged), len(orders))
+		}
+	}
+
+	// A cursor can't be used to continue a query with a different sorting
+	// or different filters
+	page, _ := repo.QueryOrders(OrderQuery{UserId: user.Id.String(), Currency: "USD", Limit: 1})
+	invalid := []OrderQuery{
+		{UserId: user.Id.String(), Currency: "USD", SortBy: SortByAmount},
+		{UserId: user.Id.String(), Currency: "USD", Descending: true},
+		{UserId: user.Id.String(), Currency: "EUR"},
+		{UserId: user.Id.String(), Currency: "USD", Statuses: []internal.PaymentStatus{internal.PaymentStatusPending}},
+		{UserId: user.Id.String(), Currency: "USD", MinAmount: 500},
+		{UserId: user.Id.String(), Currency: "USD", CreatedFrom: orders[0].CreatedAt},
+		{Currency: "USD"},
+	}
+
+	for _, query := range invalid {
+		query.Cursor = page.NextCursor
+		_, err := repo.QueryOrders(query)
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Querying %+v with the cursor of another query returned %v, expected %v", query, err, ErrInvalidCursor)
+		}
+	}
+
+	_, err := repo.QueryOrders(OrderQuery{UserId: user.Id.String(), Currency: "USD", Limit: 1, Cursor: page.NextCursor})
+	if err != nil {
+		t.Errorf("Querying with the cursor of the same query failed: %q\n", err)
+	}
+}
+
+func TestMemoryOrderRepoQueryOrders(t *testing.T) {
+	testQueryOrders(t, NewMemoryOrderRepo())
+	testQueryOrdersPages(t, NewMemoryOrderRepo())
+}
+
+func TestSQLiteOrderRepoQueryOrders(t *testing.T) {
+	testQueryOrders(t, openTestDB(t, filepath.Join(t.TempDir(), "alokin.db")))
+	testQueryOrdersPages(t, openTestDB(t, filepath.Join(t.TempDir(), "alokin.db")))
+}
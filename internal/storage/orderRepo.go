@@ -2,48 +2,256 @@ package storage
 
 import (
 	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/federlizer/alokin-zota-integration/internal"
 )
 
-// OrderRepo is a simple in-memory storage for created Orders
-type OrderRepo struct {
+var (
+	// ErrNotFound is returned by the repositories when the requested entity doesn't exist
+	ErrNotFound = errors.New("Not found")
+	// ErrStatusConflict is returned by UpdateStatus when the current payment
+	// status isn't the expected one, i.e. someone else has changed it first
+	ErrStatusConflict = errors.New("Payment status has been changed concurrently")
+)
+
+// OrderRepo stores the created Orders and every change of their payment status.
+//
+// Implementations are safe for concurrent use. Orders are always handed out as
+// copies, so the only way to change a stored order is through the repository.
+type OrderRepo interface {
+	AddOrder(order *internal.Order) error
+	GetOrder(id string) (*internal.Order, error)
+	GetAll() ([]*internal.Order, error)
+	// QueryOrders returns a page of the orders selected by the query
+	QueryOrders(query OrderQuery) (*OrderPage, error)
+	// SetZotaOrder stores the order ID and deposit URL that Zota has
+	// assigned to the order
+	SetZotaOrder(id, zotaOrderId, depositUrl string) error
+	// SetProcessorTransactionId stores the ID of the order's transaction at
+	// the payment processor
+	SetProcessorTransactionId(id, processorTransactionId string) error
+	// UpdateStatus changes the payment status of the order from expected to
+	// the change's status and records the change in the order's status
+	// history. If the order's current status isn't expected,
+	// ErrStatusConflict is returned, and if expected can't transition to the
+	// change's status, internal.ErrInvalidTransition is returned. In both cases
+	// nothing is changed. A zero ChangedAt is set to the current time.
+	//
+	// An order that reaches a final status is recorded as finalised in the
+	// same update, until AckFinalised is called for it.
+	UpdateStatus(id string, expected internal.PaymentStatus, change internal.StatusChange) error
+	GetStatusHistory(id string) ([]internal.StatusChange, error)
+	// GetFinalised returns the IDs of up to limit orders that have reached a
+	// final status but haven't been acknowledged yet, the longest finalised
+	// first
+	GetFinalised(limit int) ([]string, error)
+	// AckFinalised acknowledges that the order's final status has been
+	// handled, so GetFinalised no longer returns it
+	AckFinalised(id string) error
+}
+
+// MemoryOrderRepo is a simple in-memory storage for created Orders
+type MemoryOrderRepo struct {
+	mu sync.RWMutex
 	// Orders holds all orders that have been created.
 	// The key is the ID of the order and the value is the order itself.
 	orders map[string]*internal.Order
+	// History holds the status changes of every order, keyed by the order's ID
+	history map[string][]internal.StatusChange
+	// Finalised holds the IDs of the finalised orders that haven't been
+	// acknowledged yet, the longest finalised first
+	finalised []string
 }
 
-func NewOrderRepo() *OrderRepo {
-	return &OrderRepo{
-		orders: make(map[string]*internal.Order),
+func NewMemoryOrderRepo() *MemoryOrderRepo {
+	return &MemoryOrderRepo{
+		orders:  make(map[string]*internal.Order),
+		history: make(map[string][]internal.StatusChange),
 	}
 }
 
-func (r *OrderRepo) AddOrder(order *internal.Order) error {
+func (r *MemoryOrderRepo) AddOrder(order *internal.Order) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	_, exists := r.orders[order.Id.String()]
 	if exists {
 		return errors.New("Another order with the same ID already exists")
 	}
 
-	r.orders[order.Id.String()] = order
+	stored := *order
+	r.orders[order.Id.String()] = &stored
+	r.history[order.Id.String()] = []internal.StatusChange{
+		{Status: order.PaymentStatus, ChangedAt: time.Now(), Source: internal.StatusSourceApi},
+	}
 	return nil
 }
 
-func (r *OrderRepo) GetOrder(id string) *internal.Order {
+func (r *MemoryOrderRepo) GetOrder(id string) (*internal.Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	order, exists := r.orders[id]
 	if !exists {
-		return nil
+		return nil, ErrNotFound
+	}
+
+	orderCopy := *order
+	return &orderCopy, nil
+}
+
+func (r *MemoryOrderRepo) GetAll() ([]*internal.Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	orderArray := make([]*internal.Order, 0, len(r.orders))
+
+	for _, order := range r.orders {
+		orderCopy := *order
+		orderArray = append(orderArray, &orderCopy)
 	}
 
-	return order
+	return orderArray, nil
 }
 
-func (r *OrderRepo) GetAll() []*internal.Order {
+func (r *MemoryOrderRepo) QueryOrders(query OrderQuery) (*OrderPage, error) {
+	query, err := query.normalise()
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := decodeCursor(query)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	orderArray := make([]*internal.Order, 0)
 
 	for _, order := range r.orders {
-		orderArray = append(orderArray, order)
+		if !query.matches(order) || (cursor != nil && !cursor.after(query, order)) {
+			continue
+		}
+
+		orderCopy := *order
+		orderArray = append(orderArray, &orderCopy)
 	}
 
-	return orderArray
+	sort.Slice(orderArray, func(i, j int) bool {
+		if query.Descending {
+			i, j = j, i
+		}
+
+		keyI, keyJ := query.sortKey(orderArray[i]), query.sortKey(orderArray[j])
+		if keyI != keyJ {
+			return keyI < keyJ
+		}
+
+		return orderArray[i].Id.String() < orderArray[j].Id.String()
+	})
+
+	page := &OrderPage{Orders: orderArray}
+	if len(orderArray) > query.Limit {
+		page.Orders = orderArray[:query.Limit]
+		page.NextCursor = nextCursor(query, page.Orders)
+	}
+
+	return page, nil
+}
+
+func (r *MemoryOrderRepo) SetZotaOrder(id, zotaOrderId, depositUrl string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	order, exists := r.orders[id]
+	if !exists {
+		return ErrNotFound
+	}
+
+	order.ZotaOrderId = zotaOrderId
+	order.DepositUrl = depositUrl
+	order.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *MemoryOrderRepo) SetProcessorTransactionId(id, processorTransactionId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	order, exists := r.orders[id]
+	if !exists {
+		return ErrNotFound
+	}
+
+	order.ProcessorTransactionId = processorTransactionId
+	order.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *MemoryOrderRepo) UpdateStatus(id string, expected internal.PaymentStatus, change internal.StatusChange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	order, exists := r.orders[id]
+	if !exists {
+		return ErrNotFound
+	}
+
+	if order.PaymentStatus != expected {
+		return ErrStatusConflict
+	}
+
+	err := expected.ValidateTransition(change.Status)
+	if err != nil {
+		return err
+	}
+
+	if change.ChangedAt.IsZero() {
+		change.ChangedAt = time.Now()
+	}
+
+	order.PaymentStatus = change.Status
+	order.UpdatedAt = change.ChangedAt
+	r.history[id] = append(r.history[id], change)
+	if change.Status.IsFinal() {
+		r.finalised = append(r.finalised, id)
+	}
+	return nil
+}
+
+func (r *MemoryOrderRepo) GetStatusHistory(id string) ([]internal.StatusChange, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	history, exists := r.history[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	historyCopy := make([]internal.StatusChange, len(history))
+	copy(historyCopy, history)
+	return historyCopy, nil
+}
+
+func (r *MemoryOrderRepo) GetFinalised(limit int) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.Clone(r.finalised[:min(limit, len(r.finalised))]), nil
+}
+
+func (r *MemoryOrderRepo) AckFinalised(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.finalised = slices.DeleteFunc(r.finalised, func(finalised string) bool {
+		return finalised == id
+	})
+	return nil
 }
@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+)
+
+// hammerOrderRepo creates orders while concurrently finalising them from
+// competing pollers and callbacks and listing them. Run with -race.
+func hammerOrderRepo(t *testing.T, repo OrderRepo) {
+	const orderCount = 20
+	const finalisers = 4
+
+	var wg sync.WaitGroup
+	orderIds := make(chan string, orderCount)
+	var successes sync.Map
+
+	for i := 0; i < orderCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			order := createTestOrder()
+			err := repo.AddOrder(order)
+			if err != nil {
+				t.Errorf("Failed to add order: %q\n", err)
+				return
+			}
+
+			// Mutating the order after adding it must not leak into the repo
+			order.PaymentStatus = internal.PaymentStatusApproved
+			orderIds <- order.Id.String()
+
+			for j := 0; j < finalisers; j++ {
+				wg.Add(1)
+				go func(j int) {
+					defer wg.Done()
+
+					status := internal.PaymentStatusApproved
+					if j%2 == 1 {
+						status = internal.PaymentStatusDeclined
+					}
+
+					err := repo.UpdateStatus(order.Id.String(), internal.PaymentStatusPending, internal.StatusChange{Status: status})
+					if errors.Is(err, ErrStatusConflict) {
+						return
+					}
+					if err != nil {
+						t.Errorf("Failed to update status: %q\n", err)
+						return
+					}
+
+					_, loaded := successes.LoadOrStore(order.Id.String(), status)
+					if loaded {
+						t.Errorf("Order %v was finalised more than once", order.Id)
+					}
+				}(j)
+			}
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			orders, err := repo.GetAll()
+			if err != nil {
+				t.Errorf("Failed to list orders: %q\n", err)
+				return
+			}
+
+			for _, order := range orders {
+				// Returned orders are copies, changing them must be safe
+				order.PaymentStatus = internal.PaymentStatusDeclined
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(orderIds)
+
+	for id := range orderIds {
+		order, err := repo.GetOrder(id)
+		if err != nil {
+			t.Fatalf("Failed to get order %v: %q\n", id, err)
+		}
+
+		expected, ok := successes.Load(id)
+		if !ok || order.PaymentStatus != expected {
+			t.Errorf("Order status %q doesn't equal the successfully stored %v", order.PaymentStatus, expected)
+		}
+
+		history, err := repo.GetStatusHistory(id)
+		if err != nil {
+			t.Fatalf("Failed to get status history of %v: %q\n", id, err)
+		}
+
+		if len(history) != 2 {
+			t.Errorf("Order %v has %d status changes instead of %d", id, len(history), 2)
+		}
+	}
+}
+
+func TestMemoryOrderRepoConcurrentAccess(t *testing.T) {
+	hammerOrderRepo(t, NewMemoryOrderRepo())
+}
+
+func TestSQLiteOrderRepoConcurrentAccess(t *testing.T) {
+	hammerOrderRepo(t, openTestDB(t, filepath.Join(t.TempDir(), "alokin.db")))
+}
+
+func TestMemoryOrderRepoReturnsCopies(t *testing.T) {
+	repo := NewMemoryOrderRepo()
+	order := createTestOrder()
+	repo.AddOrder(order)
+
+	stored, err := repo.GetOrder(order.Id.String())
+	if err != nil {
+		t.Fatalf("Failed to get order: %q\n", err)
+	}
+
+	stored.PaymentStatus = internal.PaymentStatusApproved
+	stored.ZotaOrderId = "changed"
+
+	stored, err = repo.GetOrder(order.Id.String())
+	if err != nil {
+		t.Fatalf("Failed to get order: %q\n", err)
+	}
+
+	if stored.PaymentStatus != internal.PaymentStatusPending || stored.ZotaOrderId != "" {
+		t.Errorf("Changing a returned order changed the stored order %+v", stored)
+	}
+}
+
+// testUpdateStatus checks that only valid transitions from the current status
+// are stored, along with what reported them
+func testUpdateStatus(t *testing.T, repo OrderRepo) {
+	order := createTestOrder()
+	repo.AddOrder(order)
+	id := order.Id.String()
+
+	err := repo.UpdateStatus(id, internal.PaymentStatusPending, internal.StatusChange{
+		Status: internal.PaymentStatusProcessing,
+		Source: internal.StatusSourcePoll,
+	})
+	if err != nil {
+		t.Fatalf("Failed to update status: %q\n", err)
+	}
+
+	err = repo.UpdateStatus(id, internal.PaymentStatusPending, internal.StatusChange{Status: internal.PaymentStatusApproved})
+	if !errors.Is(err, ErrStatusConflict) {
+		t.Errorf("UpdateStatus error %v doesn't equal expected %v", err, ErrStatusConflict)
+	}
+
+	err = repo.UpdateStatus(id, internal.PaymentStatusProcessing, internal.StatusChange{Status: internal.PaymentStatusSubmitted})
+	if !errors.Is(err, internal.ErrInvalidTransition) {
+		t.Errorf("UpdateStatus error %v doesn't equal expected %v", err, internal.ErrInvalidTransition)
+	}
+
+	err = repo.UpdateStatus(id, internal.PaymentStatusProcessing, internal.StatusChange{
+		Status:       internal.PaymentStatusDeclined,
+		Source:       internal.StatusSourceCallback,
+		ErrorMessage: "Insufficient funds",
+	})
+	if err != nil {
+		t.Fatalf("Failed to update status: %q\n", err)
+	}
+
+	// Final statuses can't be changed
+	err = repo.UpdateStatus(id, internal.PaymentStatusDeclined, internal.StatusChange{Status: internal.PaymentStatusApproved})
+	if !errors.Is(err, internal.ErrInvalidTransition) {
+		t.Errorf("UpdateStatus error %v doesn't equal expected %v", err, internal.ErrInvalidTransition)
+	}
+
+	history, err := repo.GetStatusHistory(id)
+	if err != nil {
+		t.Fatalf("Failed to get status history: %q\n", err)
+	}
+
+	expected := []internal.StatusChange{
+		{Status: internal.PaymentStatusPending, Source: internal.StatusSourceApi},
+		{Status: internal.PaymentStatusProcessing, Source: internal.StatusSourcePoll},
+		{Status: internal.PaymentStatusDeclined, Source: internal.StatusSourceCallback, ErrorMessage: "Insufficient funds"},
+	}
+	if len(history) != len(expected) {
+		t.Fatalf("Status history %+v doesn't equal expected %+v", history, expected)
+	}
+
+	for i, change := range history {
+		if change.ChangedAt.IsZero() {
+			t.Errorf("Status change %+v doesn't have a time", change)
+		}
+
+		change.ChangedAt = time.Time{}
+		if change != expected[i] {
+			t.Errorf("Status change %+v doesn't equal expected %+v", change, expected[i])
+		}
+	}
+
+	stored, err := repo.GetOrder(id)
+	if err != nil {
+		t.Fatalf("Failed to get order: %q\n", err)
+	}
+
+	if stored.PaymentStatus != internal.PaymentStatusDeclined || !stored.UpdatedAt.Equal(history[2].ChangedAt) {
+		t.Errorf("Order %+v doesn't match its last status change %+v", *stored, history[2])
+	}
+
+	// Only the final status finalised the order
+	finalised, err := repo.GetFinalised(10)
+	if err != nil {
+		t.Fatalf("Failed to get finalised orders: %q\n", err)
+	}
+
+	if !slices.Equal(finalised, []string{id}) {
+		t.Errorf("Finalised orders %v don't equal expected %v", finalised, []string{id})
+	}
+
+	err = repo.AckFinalised(id)
+	if err != nil {
+		t.Fatalf("Failed to acknowledge finalised order: %q\n", err)
+	}
+
+	finalised, err = repo.GetFinalised(10)
+	if err != nil || len(finalised) != 0 {
+		t.Errorf("Acknowledged order is still finalised: %v, %v", finalised, err)
+	}
+}
+
+func TestMemoryOrderRepoUpdateStatus(t *testing.T) {
+	testUpdateStatus(t, NewMemoryOrderRepo())
+}
+
+func TestSQLiteOrderRepoUpdateStatus(t *testing.T) {
+	testUpdateStatus(t, openTestDB(t, filepath.Join(t.TempDir(), "alokin.db")))
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+)
+
+// PayoutRepo stores the created Payouts.
+//
+// Implementations are safe for concurrent use. Payouts are always handed out
+// as copies, so the only way to change a stored payout is through the repository.
+type PayoutRepo interface {
+	AddPayout(payout *internal.Payout) error
+	GetPayout(id string) (*internal.Payout, error)
+	GetAll() ([]*internal.Payout, error)
+	// SetZotaOrderId stores the order ID that Zota has assigned to the payout
+	SetZotaOrderId(id, zotaOrderId string) error
+	// UpdateStatus changes the payment status of the payout from expected to
+	// status. If the payout's current status isn't expected, ErrStatusConflict
+	// is returned, and if expected can't transition to status,
+	// internal.ErrInvalidTransition is returned. In both cases nothing is
+	// changed.
+	UpdateStatus(id string, expected, status internal.PaymentStatus) error
+}
+
+// MemoryPayoutRepo is a simple in-memory storage for created Payouts
+type MemoryPayoutRepo struct {
+	mu sync.RWMutex
+	// Payouts holds all payouts that have been created.
+	// The key is the ID of the payout and the value is the payout itself.
+	payouts map[string]*internal.Payout
+}
+
+func NewMemoryPayoutRepo() *MemoryPayoutRepo {
+	return &MemoryPayoutRepo{
+		payouts: make(map[string]*internal.Payout),
+	}
+}
+
+func (r *MemoryPayoutRepo) AddPayout(payout *internal.Payout) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, exists := r.payouts[payout.Id.String()]
+	if exists {
+		return errors.New("Another payout with the same ID already exists")
+	}
+
+	stored := *payout
+	r.payouts[payout.Id.String()] = &stored
+	return nil
+}
+
+func (r *MemoryPayoutRepo) GetPayout(id string) (*internal.Payout, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	payout, exists := r.payouts[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	payoutCopy := *payout
+	return &payoutCopy, nil
+}
+
+func (r *MemoryPayoutRepo) GetAll() ([]*internal.Payout, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	payoutArray := make([]*internal.Payout, 0, len(r.payouts))
+
+	for _, payout := range r.payouts {
+		payoutCopy := *payout
+		payoutArray = append(payoutArray, &payoutCopy)
+	}
+
+	return payoutArray, nil
+}
+
+func (r *MemoryPayoutRepo) SetZotaOrderId(id, zotaOrderId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	payout, exists := r.payouts[id]
+	if !exists {
+		return ErrNotFound
+	}
+
+	payout.ZotaOrderId = zotaOrderId
+	return nil
+}
+
+func (r *MemoryPayoutRepo) UpdateStatus(id string, expected, status internal.PaymentStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	payout, exists := r.payouts[id]
+	if !exists {
+		return ErrNotFound
+	}
+
+	if payout.PaymentStatus != expected {
+		return ErrStatusConflict
+	}
+
+	err := expected.ValidateTransition(status)
+	if err != nil {
+		return err
+	}
+
+	payout.PaymentStatus = status
+	return nil
+}
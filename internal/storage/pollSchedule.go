@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// PollKindDeposit marks poll entries for orders
+	PollKindDeposit = "deposit"
+	// PollKindPayout marks poll entries for payouts
+	PollKindPayout = "payout"
+)
+
+// PollEntry is a single order or payout that the poller checks with Zota
+type PollEntry struct {
+	// Id is the merchant's ID of the order or payout
+	Id string `json:"id"`
+	// Kind is either PollKindDeposit or PollKindPayout
+	Kind        string `json:"kind"`
+	ZotaOrderId string `json:"zotaOrderId"`
+	Attempts    int    `json:"attempts"`
+	// StartedAt is when the poller started tracking the entry
+	StartedAt time.Time `json:"startedAt"`
+	// NextCheckAt is when the entry is due to be checked next
+	NextCheckAt time.Time `json:"nextCheckAt"`
+	// LastCheckedAt is when the entry was last checked, zero if never
+	LastCheckedAt time.Time `json:"lastCheckedAt"`
+	// RequestId is the ID of the request that started the tracking, which
+	// the entry's checks are logged with
+	RequestId string `json:"requestId,omitempty"`
+	// TraceParent identifies the span of the request that started the
+	// tracking, which the entry's checks are linked to
+	TraceParent string `json:"traceParent,omitempty"`
+}
+
+// PollSchedule stores which orders the poller has to check and when, so that
+// polling can be resumed after a restart.
+//
+// Implementations are safe for concurrent use.
+type PollSchedule interface {
+	// Schedule adds the entry to the schedule, replacing any existing
+	// entry with the same ID
+	Schedule(entry PollEntry) error
+	Remove(id string) error
+	GetAll() ([]PollEntry, error)
+}
+
+// MemoryPollSchedule is a simple in-memory PollSchedule
+type MemoryPollSchedule struct {
+	mu      sync.RWMutex
+	entries map[string]PollEntry
+}
+
+func NewMemoryPollSchedule() *MemoryPollSchedule {
+	return &MemoryPollSchedule{
+		entries: make(map[string]PollEntry),
+	}
+}
+
+func (s *MemoryPollSchedule) Schedule(entry PollEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[entry.Id] = entry
+	return nil
+}
+
+func (s *MemoryPollSchedule) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, id)
+	return nil
+}
+
+func (s *MemoryPollSchedule) GetAll() ([]PollEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]PollEntry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	_ "modernc.org/sqlite"
+)
+
+// migrations holds the SQLite schema, one migration per entry. Migrations are
+// applied in order and never changed once released - add a new entry instead.
+var migrations = []string{
+	// 1: orders, the users they were made for and their status history
+	`CREATE TABLE users (
+		id            INTEGER PRIMARY KEY AUTOINCREMENT,
+		email         TEXT NOT NULL,
+		first_name    TEXT NOT NULL,
+		last_name     TEXT NOT NULL,
+		ip_addr       TEXT NOT NULL,
+		phone         TEXT NOT NULL,
+		address_line  TEXT NOT NULL,
+		country_code  TEXT NOT NULL,
+		city          TEXT NOT NULL,
+		zip_code      TEXT NOT NULL
+	);
+
+	CREATE TABLE orders (
+		id              TEXT PRIMARY KEY,
+		description     TEXT NOT NULL,
+		amount          REAL NOT NULL,
+		user_id         INTEGER NOT NULL REFERENCES users(id),
+		payment_status  TEXT NOT NULL,
+		zota_order_id   TEXT NOT NULL DEFAULT '',
+		deposit_url     TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE order_status_history (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		order_id    TEXT NOT NULL REFERENCES orders(id),
+		status      TEXT NOT NULL,
+		changed_at  INTEGER NOT NULL
+	);
+
+	CREATE INDEX order_status_history_order_id ON order_status_history(order_id);`,
+
+	// 2: the poller's schedule
+	`CREATE TABLE poll_schedule (
+		id               TEXT PRIMARY KEY,
+		kind             TEXT NOT NULL,
+		zota_order_id    TEXT NOT NULL,
+		attempts         INTEGER NOT NULL,
+		next_check_at    INTEGER NOT NULL,
+		last_checked_at  INTEGER NOT NULL
+	);`,
+
+	// 3: when the poller started tracking an entry, for deadline based retry policies
+	`ALTER TABLE poll_schedule ADD COLUMN started_at INTEGER NOT NULL DEFAULT 0;`,
+
+	// 4: exact order amounts in minor units, every order so far was made in USD
+	`ALTER TABLE orders ADD COLUMN amount_minor INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE orders ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD';
+	UPDATE orders SET amount_minor = CAST(ROUND(amount * 100) AS INTEGER);
+	ALTER TABLE orders DROP COLUMN amount;`,
+
+	// 5: registered users, their sessions and the user every order was made by
+	`CREATE TABLE accounts (
+		id             TEXT PRIMARY KEY,
+		email          TEXT NOT NULL UNIQUE,
+		password_hash  BLOB NOT NULL,
+		first_name     TEXT NOT NULL,
+		last_name      TEXT NOT NULL,
+		phone          TEXT NOT NULL,
+		address_line   TEXT NOT NULL,
+		country_code   TEXT NOT NULL,
+		city           TEXT NOT NULL,
+		zip_code       TEXT NOT NULL,
+		created_at     INTEGER NOT NULL
+	);
+
+	CREATE TABLE sessions (
+		token_hash  TEXT PRIMARY KEY,
+		account_id  TEXT NOT NULL REFERENCES accounts(id),
+		expires_at  INTEGER NOT NULL
+	);
+
+	ALTER TABLE users ADD COLUMN account_id TEXT NOT NULL DEFAULT '';
+	CREATE INDEX users_account_id ON users(account_id);`,
+
+	// 6: the ID of the order's transaction at the payment processor
+	`ALTER TABLE orders ADD COLUMN processor_transaction_id TEXT NOT NULL DEFAULT '';`,
+
+	// 7: when orders were created and last changed, backfilled from their
+	// status history, and indexes to sort orders by them
+	`ALTER TABLE orders ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE orders ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+	UPDATE orders SET
+		created_at = COALESCE((SELECT MIN(changed_at) FROM order_status_history h WHERE h.order_id = orders.id), 0),
+		updated_at = COALESCE((SELECT MAX(changed_at) FROM order_status_history h WHERE h.order_id = orders.id), 0);
+	CREATE INDEX orders_created_at ON orders(created_at, id);
+	CREATE INDEX orders_currency_amount ON orders(currency, amount_minor, id);`,
+
+	// 8: requests made with idempotency keys and their responses
+	`CREATE TABLE idempotency_keys (
+		key           TEXT PRIMARY KEY,
+		fingerprint   TEXT NOT NULL,
+		completed     INTEGER NOT NULL,
+		status_code   INTEGER NOT NULL,
+		content_type  TEXT NOT NULL,
+		location      TEXT NOT NULL,
+		body          BLOB,
+		locked_until  INTEGER NOT NULL,
+		expires_at    INTEGER NOT NULL
+	);
+
+	CREATE INDEX idempotency_keys_expires_at ON idempotency_keys(expires_at);`,
+
+	// 9: webhook subscriptions and the queue of deliveries to them
+	`CREATE TABLE webhook_subscriptions (
+		id           TEXT PRIMARY KEY,
+		url          TEXT NOT NULL,
+		secret       TEXT NOT NULL,
+		event_types  TEXT NOT NULL,
+		disabled     INTEGER NOT NULL,
+		created_at   INTEGER NOT NULL
+	);
+
+	CREATE TABLE webhook_deliveries (
+		id               TEXT PRIMARY KEY,
+		subscription_id  TEXT NOT NULL REFERENCES webhook_subscriptions(id),
+		event_id         TEXT NOT NULL,
+		event_type       TEXT NOT NULL,
+		payload          BLOB NOT NULL,
+		state            TEXT NOT NULL,
+		attempts         INTEGER NOT NULL,
+		next_attempt_at  INTEGER NOT NULL,
+		last_attempt_at  INTEGER NOT NULL,
+		last_error       TEXT NOT NULL,
+		created_at       INTEGER NOT NULL
+	);
+
+	CREATE INDEX webhook_deliveries_due ON webhook_deliveries(state, next_attempt_at);
+	CREATE INDEX webhook_deliveries_subscription_id ON webhook_deliveries(subscription_id, created_at);`,
+
+	// 10: what reported each status change and why payments failed. Failures
+	// used to be recorded as FAILED without telling them apart, ERROR is the
+	// closest of the final statuses that replaced it.
+	`ALTER TABLE order_status_history ADD COLUMN source TEXT NOT NULL DEFAULT '';
+	ALTER TABLE order_status_history ADD COLUMN error_message TEXT NOT NULL DEFAULT '';
+	UPDATE orders SET payment_status = 'ERROR' WHERE payment_status = 'FAILED';
+	UPDATE order_status_history SET status = 'ERROR' WHERE status = 'FAILED';`,
+
+	// 11: the request that started polling for an order, to correlate logs
+	`ALTER TABLE poll_schedule ADD COLUMN request_id TEXT NOT NULL DEFAULT '';`,
+
+	// 12: the span of the request that started polling, to link its checks
+	`ALTER TABLE poll_schedule ADD COLUMN trace_parent TEXT NOT NULL DEFAULT '';`,
+
+	// 13: payouts, the users they were made for and the bank accounts they're
+	// sent to
+	`CREATE TABLE payouts (
+		id                 TEXT PRIMARY KEY,
+		description        TEXT NOT NULL,
+		amount_minor       INTEGER NOT NULL,
+		currency           TEXT NOT NULL,
+		user_id            INTEGER NOT NULL REFERENCES users(id),
+		bank_code          TEXT NOT NULL,
+		account_number     TEXT NOT NULL,
+		account_name       TEXT NOT NULL,
+		branch_name        TEXT NOT NULL,
+		bank_country_code  TEXT NOT NULL,
+		payment_status     TEXT NOT NULL,
+		zota_order_id      TEXT NOT NULL DEFAULT ''
+	);`,
+
+	// 14: orders that reached a final status, until their webhooks have
+	// been queued. They're recorded with the status, so none are lost if the
+	// process stops in between.
+	`CREATE TABLE finalised_orders (
+		seq       INTEGER PRIMARY KEY AUTOINCREMENT,
+		order_id  TEXT NOT NULL UNIQUE REFERENCES orders(id)
+	);`,
+}
+
+// OpenSQLite opens the SQLite database at path, creating it if it doesn't
+// exist yet, and applies any missing schema migrations
+func OpenSQLite(path string) (*sql.DB, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)", path)
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite only allows a single writer at a time
+	db.SetMaxOpenConns(1)
+
+	err = migrate(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// migrate applies every migration that hasn't been applied to the database yet
+func migrate(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return err
+	}
+
+	var version int
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(migrations[i])
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Couldn't apply migration %d: %w", i+1, err)
+		}
+
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, i+1)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+
+		slog.Info("Applied SQLite migration", "version", i+1)
+	}
+
+	return nil
+}
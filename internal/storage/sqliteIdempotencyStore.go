@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SQLiteIdempotencyStore is a durable IdempotencyStore backed by SQLite, so
+// that retries are recognised across restarts
+type SQLiteIdempotencyStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteIdempotencyStore(db *sql.DB) *SQLiteIdempotencyStore {
+	return &SQLiteIdempotencyStore{db}
+}
+
+func (s *SQLiteIdempotencyStore) Reserve(record IdempotencyRecord, now time.Time) (*IdempotencyRecord, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM idempotency_keys WHERE expires_at <= ?`, now.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+
+	var existing IdempotencyRecord
+	var lockedUntil, expiresAt int64
+	err = tx.QueryRow(
+		`SELECT key, fingerprint, completed, status_code, content_type, location, body, locked_until, expires_at
+		FROM idempotency_keys WHERE key = ?`,
+		record.Key,
+	).Scan(
+		&existing.Key, &existing.Fingerprint, &existing.Completed, &existing.StatusCode, &existing.ContentType,
+		&existing.Location, &existing.Body, &lockedUntil, &expiresAt,
+	)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if err == nil {
+		existing.LockedUntil = time.Unix(0, lockedUntil)
+		existing.ExpiresAt = time.Unix(0, expiresAt)
+
+		if existing.Completed || now.Before(existing.LockedUntil) {
+			return &existing, ErrIdempotencyKeyExists
+		}
+
+		// The request has been abandoned, take over its key
+		_, err = tx.Exec(`DELETE FROM idempotency_keys WHERE key = ?`, record.Key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO idempotency_keys (key, fingerprint, completed, status_code, content_type, location, body, locked_until, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.Key, record.Fingerprint, record.Completed, record.StatusCode, record.ContentType,
+		record.Location, record.Body, record.LockedUntil.UnixNano(), record.ExpiresAt.UnixNano(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, tx.Commit()
+}
+
+func (s *SQLiteIdempotencyStore) Complete(record IdempotencyRecord) error {
+	result, err := s.db.Exec(
+		`UPDATE idempotency_keys
+		SET fingerprint = ?, completed = 1, status_code = ?, content_type = ?, location = ?, body = ?, locked_until = ?, expires_at = ?
+		WHERE key = ?`,
+		record.Fingerprint, record.StatusCode, record.ContentType, record.Location, record.Body,
+		record.LockedUntil.UnixNano(), record.ExpiresAt.UnixNano(), record.Key,
+	)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
+
+func (s *SQLiteIdempotencyStore) Release(key string) error {
+	result, err := s.db.Exec(`DELETE FROM idempotency_keys WHERE key = ?`, key)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
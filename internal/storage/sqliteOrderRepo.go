@@ -0,0 +1,419 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+)
+
+// SQLiteOrderRepo is a durable storage for created Orders backed by SQLite
+type SQLiteOrderRepo struct {
+	db *sql.DB
+}
+
+func NewSQLiteOrderRepo(db *sql.DB) *SQLiteOrderRepo {
+	return &SQLiteOrderRepo{db}
+}
+
+const selectOrders = `
+	SELECT o.id, o.description, o.amount_minor, o.currency, o.payment_status, o.zota_order_id, o.deposit_url,
+		o.processor_transaction_id, o.created_at, o.updated_at,
+		u.account_id, u.email, u.first_name, u.last_name, u.ip_addr, u.phone,
+		u.address_line, u.country_code, u.city, u.zip_code
+	FROM orders o
+	JOIN users u ON u.id = o.user_id`
+
+func (r *SQLiteOrderRepo) AddOrder(order *internal.Order) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	userId, err := insertUser(tx, order.User)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO orders (id, description, amount_minor, currency, user_id, payment_status, zota_order_id, deposit_url,
+			processor_transaction_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		order.Id.String(), order.Description, order.Amount.Minor(), order.Amount.Currency(), userId, order.PaymentStatus, order.ZotaOrderId, order.DepositUrl,
+		order.ProcessorTransactionId, order.CreatedAt.UnixNano(), order.UpdatedAt.UnixNano(),
+	)
+	if err != nil {
+		return err
+	}
+
+	err = insertStatusChange(tx, order.Id.String(), internal.StatusChange{
+		Status:    order.PaymentStatus,
+		ChangedAt: time.Now(),
+		Source:    internal.StatusSourceApi,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *SQLiteOrderRepo) GetOrder(id string) (*internal.Order, error) {
+	row := r.db.QueryRow(selectOrders+` WHERE o.id = ?`, id)
+
+	order, err := scanOrder(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+
+	return order, err
+}
+
+func (r *SQLiteOrderRepo) GetAll() ([]*internal.Order, error) {
+	return r.queryOrders(selectOrders)
+}
+
+func (r *SQLiteOrderRepo) QueryOrders(query OrderQuery) (*OrderPage, error) {
+	query, err := query.normalise()
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := decodeCursor(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var conditions []string
+	var args []any
+
+	if query.UserId != "" {
+		conditions = append(conditions, `u.account_id = ?`)
+		args = append(args, query.UserId)
+	}
+
+	if len(query.Statuses) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(query.Statuses)), ", ")
+		conditions = append(conditions, `o.payment_status IN (`+placeholders+`)`)
+		for _, status := range query.Statuses {
+			args = append(args, status)
+		}
+	}
+
+	if query.Currency != "" {
+		conditions = append(conditions, `o.currency = ?`)
+		args = append(args, query.Currency)
+	}
+
+	if !query.CreatedFrom.IsZero() {
+		conditions = append(conditions, `o.created_at >= ?`)
+		args = append(args, query.CreatedFrom.UnixNano())
+	}
+
+	if !query.CreatedTo.IsZero() {
+		conditions = append(conditions, `o.created_at < ?`)
+		args = append(args, query.CreatedTo.UnixNano())
+	}
+
+	if query.MinAmount != 0 {
+		conditions = append(conditions, `o.amount_minor >= ?`)
+		args = append(args, query.MinAmount)
+	}
+
+	if query.MaxAmount != 0 {
+		conditions = append(conditions, `o.amount_minor <= ?`)
+		args = append(args, query.MaxAmount)
+	}
+
+	sortColumn := `o.created_at`
+	if query.SortBy == SortByAmount {
+		sortColumn = `o.amount_minor`
+	}
+
+	direction, comparison := `ASC`, `>`
+	if query.Descending {
+		direction, comparison = `DESC`, `<`
+	}
+
+	if cursor != nil {
+		conditions = append(conditions, fmt.Sprintf(`(%[1]s %[2]s ? OR (%[1]s = ? AND o.id %[2]s ?))`, sortColumn, comparison))
+		args = append(args, cursor.key, cursor.key, cursor.orderId)
+	}
+
+	sqlQuery := selectOrders
+	if len(conditions) > 0 {
+		sqlQuery += ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+
+	// Fetch one more order than needed to find out if there's a next page
+	sqlQuery += fmt.Sprintf(` ORDER BY %[1]s %[2]s, o.id %[2]s LIMIT ?`, sortColumn, direction)
+	args = append(args, query.Limit+1)
+
+	orderArray, err := r.queryOrders(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &OrderPage{Orders: orderArray}
+	if len(orderArray) > query.Limit {
+		page.Orders = orderArray[:query.Limit]
+		page.NextCursor = nextCursor(query, page.Orders)
+	}
+
+	return page, nil
+}
+
+func (r *SQLiteOrderRepo) queryOrders(query string, args ...any) ([]*internal.Order, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orderArray := make([]*internal.Order, 0)
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		orderArray = append(orderArray, order)
+	}
+
+	return orderArray, rows.Err()
+}
+
+func (r *SQLiteOrderRepo) SetZotaOrder(id, zotaOrderId, depositUrl string) error {
+	result, err := r.db.Exec(
+		`UPDATE orders SET zota_order_id = ?, deposit_url = ?, updated_at = ? WHERE id = ?`,
+		zotaOrderId, depositUrl, time.Now().UnixNano(), id,
+	)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
+
+func (r *SQLiteOrderRepo) SetProcessorTransactionId(id, processorTransactionId string) error {
+	result, err := r.db.Exec(
+		`UPDATE orders SET processor_transaction_id = ?, updated_at = ? WHERE id = ?`,
+		processorTransactionId, time.Now().UnixNano(), id,
+	)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
+
+func (r *SQLiteOrderRepo) UpdateStatus(id string, expected internal.PaymentStatus, change internal.StatusChange) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current internal.PaymentStatus
+	err = tx.QueryRow(`SELECT payment_status FROM orders WHERE id = ?`, id).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if current != expected {
+		return ErrStatusConflict
+	}
+
+	err = expected.ValidateTransition(change.Status)
+	if err != nil {
+		return err
+	}
+
+	if change.ChangedAt.IsZero() {
+		change.ChangedAt = time.Now()
+	}
+
+	_, err = tx.Exec(`UPDATE orders SET payment_status = ?, updated_at = ? WHERE id = ?`, change.Status, change.ChangedAt.UnixNano(), id)
+	if err != nil {
+		return err
+	}
+
+	err = insertStatusChange(tx, id, change)
+	if err != nil {
+		return err
+	}
+
+	if change.Status.IsFinal() {
+		_, err = tx.Exec(`INSERT INTO finalised_orders (order_id) VALUES (?)`, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *SQLiteOrderRepo) GetStatusHistory(id string) ([]internal.StatusChange, error) {
+	rows, err := r.db.Query(
+		`SELECT status, changed_at, source, error_message FROM order_status_history WHERE order_id = ? ORDER BY id`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := make([]internal.StatusChange, 0)
+	for rows.Next() {
+		var change internal.StatusChange
+		var changedAt int64
+
+		err := rows.Scan(&change.Status, &changedAt, &change.Source, &change.ErrorMessage)
+		if err != nil {
+			return nil, err
+		}
+
+		change.ChangedAt = time.Unix(0, changedAt)
+		history = append(history, change)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(history) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return history, nil
+}
+
+func (r *SQLiteOrderRepo) GetFinalised(limit int) ([]string, error) {
+	rows, err := r.db.Query(`SELECT order_id FROM finalised_orders ORDER BY seq LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (r *SQLiteOrderRepo) AckFinalised(id string) error {
+	_, err := r.db.Exec(`DELETE FROM finalised_orders WHERE order_id = ?`, id)
+	return err
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanOrder(row scanner) (*internal.Order, error) {
+	var order internal.Order
+	var id, currency, userId string
+	var amountMinor, createdAt, updatedAt int64
+
+	err := row.Scan(
+		&id, &order.Description, &amountMinor, &currency, &order.PaymentStatus, &order.ZotaOrderId, &order.DepositUrl,
+		&order.ProcessorTransactionId, &createdAt, &updatedAt,
+		&userId, &order.User.Email, &order.User.FirstName, &order.User.LastName, &order.User.IpAddr, &order.User.Phone,
+		&order.User.Address.AddressLine, &order.User.Address.CountryCode, &order.User.Address.City, &order.User.Address.ZipCode,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	order.Id, err = uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+
+	order.Amount, err = internal.NewMoney(amountMinor, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	order.CreatedAt = time.Unix(0, createdAt)
+	order.UpdatedAt = time.Unix(0, updatedAt)
+
+	// Orders made before users could register don't belong to anyone
+	if userId != "" {
+		order.User.Id, err = uuid.Parse(userId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &order, nil
+}
+
+func insertStatusChange(tx *sql.Tx, orderId string, change internal.StatusChange) error {
+	_, err := tx.Exec(
+		`INSERT INTO order_status_history (order_id, status, changed_at, source, error_message) VALUES (?, ?, ?, ?, ?)`,
+		orderId, change.Status, change.ChangedAt.UnixNano(), change.Source, change.ErrorMessage,
+	)
+
+	return err
+}
+
+// insertUser stores the user as they were when an order or payout was made
+// for them and returns the ID of the stored user
+func insertUser(tx *sql.Tx, user internal.User) (int64, error) {
+	result, err := tx.Exec(
+		`INSERT INTO users (account_id, email, first_name, last_name, ip_addr, phone, address_line, country_code, city, zip_code)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		accountId(user), user.Email, user.FirstName, user.LastName, user.IpAddr, user.Phone,
+		user.Address.AddressLine, user.Address.CountryCode, user.Address.City, user.Address.ZipCode,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// accountId returns the ID of the user's account, or an empty string for
+// users that haven't registered
+func accountId(user internal.User) string {
+	if user.Id == uuid.Nil {
+		return ""
+	}
+
+	return user.Id.String()
+}
+
+// expectAffected returns ErrNotFound if the statement didn't affect any rows
+func expectAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
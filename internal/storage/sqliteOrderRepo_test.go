@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+)
+
+func createTestOrder() *internal.Order {
+	user := internal.User{
+		Email:     "federlizer@protonmail.com",
+		FirstName: "Nikola",
+		LastName:  "Velichkov",
+		IpAddr:    "127.0.0.1",
+		Phone:     "+4511111111",
+		Address: internal.UserAddress{
+			AddressLine: "Line",
+			CountryCode: "DK",
+			City:        "City",
+			ZipCode:     "zip",
+		},
+	}
+
+	amount, _ := internal.NewMoney(1337, "USD")
+
+	// A deposit Zota has accepted, which is waiting for the user
+	order := internal.NewOrder(&user, amount, "Test order")
+	order.PaymentStatus = internal.PaymentStatusPending
+	return order
+}
+
+func openTestDB(t *testing.T, path string) *SQLiteOrderRepo {
+	db, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %q\n", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return NewSQLiteOrderRepo(db)
+}
+
+func TestSQLiteOrderRepoPersistsOrders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alokin.db")
+	repo := openTestDB(t, path)
+
+	order := createTestOrder()
+	err := repo.AddOrder(order)
+	if err != nil {
+		t.Fatalf("Failed to add order: %q\n", err)
+	}
+
+	err = repo.SetZotaOrder(order.Id.String(), "32ab44f0a5d9d6b6c0c9e41b1a9c7ea1a2b3c4d5", "https://zota.com/deposit")
+	if err != nil {
+		t.Fatalf("Failed to set Zota order: %q\n", err)
+	}
+
+	err = repo.SetProcessorTransactionId(order.Id.String(), "PTX-13371337")
+	if err != nil {
+		t.Fatalf("Failed to set processor transaction ID: %q\n", err)
+	}
+
+	err = repo.UpdateStatus(order.Id.String(), internal.PaymentStatusPending, internal.StatusChange{
+		Status: internal.PaymentStatusApproved,
+		Source: internal.StatusSourceCallback,
+	})
+	if err != nil {
+		t.Fatalf("Failed to update status: %q\n", err)
+	}
+
+	// Reopening the database must keep everything and not re-apply migrations
+	repo = openTestDB(t, path)
+
+	stored, err := repo.GetOrder(order.Id.String())
+	if err != nil {
+		t.Fatalf("Failed to get order: %q\n", err)
+	}
+
+	expected := *order
+	expected.ZotaOrderId = "32ab44f0a5d9d6b6c0c9e41b1a9c7ea1a2b3c4d5"
+	expected.DepositUrl = "https://zota.com/deposit"
+	expected.ProcessorTransactionId = "PTX-13371337"
+
+	if !stored.CreatedAt.Equal(order.CreatedAt) || stored.UpdatedAt.Before(order.UpdatedAt) {
+		t.Errorf("Stored timestamps %v, %v don't match order's %v, %v", stored.CreatedAt, stored.UpdatedAt, order.CreatedAt, order.UpdatedAt)
+	}
+	// Times read from the database don't have a monotonic clock reading
+	expected.CreatedAt, expected.UpdatedAt = stored.CreatedAt, stored.UpdatedAt
+	expected.PaymentStatus = internal.PaymentStatusApproved
+	if !reflect.DeepEqual(*stored, expected) {
+		t.Errorf("Stored order %+v doesn't equal expected %+v", *stored, expected)
+	}
+
+	history, err := repo.GetStatusHistory(order.Id.String())
+	if err != nil {
+		t.Fatalf("Failed to get status history: %q\n", err)
+	}
+
+	if len(history) != 2 || history[0].Status != internal.PaymentStatusPending || history[1].Status != internal.PaymentStatusApproved ||
+		history[1].Source != internal.StatusSourceCallback || !history[1].ChangedAt.Equal(stored.UpdatedAt) {
+		t.Errorf("Status history %+v doesn't contain every transition", history)
+	}
+
+	orders, err := repo.GetAll()
+	if err != nil {
+		t.Fatalf("Failed to get all orders: %q\n", err)
+	}
+
+	if len(orders) != 1 {
+		t.Errorf("Number of orders %d doesn't equal expected %d", len(orders), 1)
+	}
+
+	// The approved order's webhooks still have to be queued
+	finalised, err := repo.GetFinalised(10)
+	if err != nil {
+		t.Fatalf("Failed to get finalised orders: %q\n", err)
+	}
+
+	if len(finalised) != 1 || finalised[0] != order.Id.String() {
+		t.Errorf("Finalised orders %v don't contain the approved order", finalised)
+	}
+}
+
+func TestSQLiteOrderRepoNotFound(t *testing.T) {
+	repo := openTestDB(t, filepath.Join(t.TempDir(), "alokin.db"))
+	id := "e31edd0d-76a6-4f1c-be19-4504ff5b89d7"
+
+	_, err := repo.GetOrder(id)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetOrder error %v doesn't equal expected %v", err, ErrNotFound)
+	}
+
+	err = repo.UpdateStatus(id, internal.PaymentStatusPending, internal.StatusChange{Status: internal.PaymentStatusApproved})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateStatus error %v doesn't equal expected %v", err, ErrNotFound)
+	}
+
+	err = repo.SetZotaOrder(id, "", "")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetZotaOrder error %v doesn't equal expected %v", err, ErrNotFound)
+	}
+}
+
+func TestSQLiteMigratesFloatAmounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alokin.db")
+
+	// A database created before amounts were stored in minor units
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %q\n", err)
+	}
+
+	statements := []string{
+		`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY)`,
+		migrations[0],
+		migrations[1],
+		migrations[2],
+		`INSERT INTO schema_migrations (version) VALUES (1), (2), (3)`,
+		`INSERT INTO users (email, first_name, last_name, ip_addr, phone, address_line, country_code, city, zip_code)
+		VALUES ('federlizer@protonmail.com', '', '', '', '', '', '', '', '')`,
+		`INSERT INTO orders (id, description, amount, user_id, payment_status)
+		VALUES ('59fa8d26-2a16-4665-963a-65fd5c0d9da2', 'Test order', 0.30000000000000004, 1, 'PENDING')`,
+		`INSERT INTO order_status_history (order_id, status, changed_at)
+		VALUES ('59fa8d26-2a16-4665-963a-65fd5c0d9da2', 'PENDING', 1709294400000000000)`,
+	}
+	for _, statement := range statements {
+		_, err := db.Exec(statement)
+		if err != nil {
+			t.Fatalf("Failed to set up old schema: %q\n", err)
+		}
+	}
+	db.Close()
+
+	repo := openTestDB(t, path)
+
+	order, err := repo.GetOrder("59fa8d26-2a16-4665-963a-65fd5c0d9da2")
+	if err != nil {
+		t.Fatalf("Failed to get order: %q\n", err)
+	}
+
+	if order.Amount.Minor() != 30 || order.Amount.Currency() != "USD" {
+		t.Errorf("Migrated amount %v %v doesn't equal expected 0.30 USD", order.Amount, order.Amount.Currency())
+	}
+
+	// Orders made before timestamps were stored were created with their first status
+	if order.CreatedAt.UnixNano() != 1709294400000000000 || !order.UpdatedAt.Equal(order.CreatedAt) {
+		t.Errorf("Migrated timestamps %v, %v weren't backfilled from the status history", order.CreatedAt, order.UpdatedAt)
+	}
+}
+
+func TestSQLiteMigratesFailedStatus(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alokin.db")
+
+	// A database created before failures were told apart
+	db, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %q\n", err)
+	}
+
+	statements := []string{
+		`DELETE FROM schema_migrations WHERE version >= 10`,
+		`DROP TABLE finalised_orders`,
+		`DROP TABLE payouts`,
+		`ALTER TABLE poll_schedule DROP COLUMN request_id`,
+		`ALTER TABLE poll_schedule DROP COLUMN trace_parent`,
+		`ALTER TABLE order_status_history DROP COLUMN source`,
+		`ALTER TABLE order_status_history DROP COLUMN error_message`,
+		`INSERT INTO users (email, first_name, last_name, ip_addr, phone, address_line, country_code, city, zip_code)
+		VALUES ('federlizer@protonmail.com', '', '', '', '', '', '', '', '')`,
+		`INSERT INTO orders (id, description, amount_minor, currency, user_id, payment_status)
+		VALUES ('59fa8d26-2a16-4665-963a-65fd5c0d9da2', 'Test order', 1337, 'USD', 1, 'FAILED')`,
+		`INSERT INTO order_status_history (order_id, status, changed_at)
+		VALUES ('59fa8d26-2a16-4665-963a-65fd5c0d9da2', 'PENDING', 1709294400000000000),
+			('59fa8d26-2a16-4665-963a-65fd5c0d9da2', 'FAILED', 1709294460000000000)`,
+	}
+	for _, statement := range statements {
+		_, err := db.Exec(statement)
+		if err != nil {
+			t.Fatalf("Failed to set up old schema: %q\n", err)
+		}
+	}
+	db.Close()
+
+	repo := openTestDB(t, path)
+
+	order, err := repo.GetOrder("59fa8d26-2a16-4665-963a-65fd5c0d9da2")
+	if err != nil {
+		t.Fatalf("Failed to get order: %q\n", err)
+	}
+
+	if order.PaymentStatus != internal.PaymentStatusError {
+		t.Errorf("Migrated status %q doesn't equal expected %q", order.PaymentStatus, internal.PaymentStatusError)
+	}
+
+	history, err := repo.GetStatusHistory(order.Id.String())
+	if err != nil {
+		t.Fatalf("Failed to get status history: %q\n", err)
+	}
+
+	if len(history) != 2 || history[1].Status != internal.PaymentStatusError || history[1].Source != "" {
+		t.Errorf("Migrated status history %+v doesn't end in an unsourced error", history)
+	}
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+)
+
+// SQLitePayoutRepo is a durable storage for created Payouts backed by SQLite
+type SQLitePayoutRepo struct {
+	db *sql.DB
+}
+
+func NewSQLitePayoutRepo(db *sql.DB) *SQLitePayoutRepo {
+	return &SQLitePayoutRepo{db}
+}
+
+const selectPayouts = `
+	SELECT p.id, p.description, p.amount_minor, p.currency, p.payment_status, p.zota_order_id,
+		p.bank_code, p.account_number, p.account_name, p.branch_name, p.bank_country_code,
+		u.account_id, u.email, u.first_name, u.last_name, u.ip_addr, u.phone,
+		u.address_line, u.country_code, u.city, u.zip_code
+	FROM payouts p
+	JOIN users u ON u.id = p.user_id`
+
+func (r *SQLitePayoutRepo) AddPayout(payout *internal.Payout) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	userId, err := insertUser(tx, payout.User)
+	if err != nil {
+		return err
+	}
+
+	bankAccount := payout.BankAccount
+	_, err = tx.Exec(
+		`INSERT INTO payouts (id, description, amount_minor, currency, user_id, bank_code, account_number, account_name,
+			branch_name, bank_country_code, payment_status, zota_order_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		payout.Id.String(), payout.Description, payout.Amount.Minor(), payout.Amount.Currency(), userId,
+		bankAccount.BankCode, bankAccount.AccountNumber, bankAccount.AccountName, bankAccount.BranchName, bankAccount.CountryCode,
+		payout.PaymentStatus, payout.ZotaOrderId,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *SQLitePayoutRepo) GetPayout(id string) (*internal.Payout, error) {
+	row := r.db.QueryRow(selectPayouts+` WHERE p.id = ?`, id)
+
+	payout, err := scanPayout(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+
+	return payout, err
+}
+
+func (r *SQLitePayoutRepo) GetAll() ([]*internal.Payout, error) {
+	rows, err := r.db.Query(selectPayouts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payoutArray := make([]*internal.Payout, 0)
+	for rows.Next() {
+		payout, err := scanPayout(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		payoutArray = append(payoutArray, payout)
+	}
+
+	return payoutArray, rows.Err()
+}
+
+func (r *SQLitePayoutRepo) SetZotaOrderId(id, zotaOrderId string) error {
+	result, err := r.db.Exec(`UPDATE payouts SET zota_order_id = ? WHERE id = ?`, zotaOrderId, id)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
+
+func (r *SQLitePayoutRepo) UpdateStatus(id string, expected, status internal.PaymentStatus) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current internal.PaymentStatus
+	err = tx.QueryRow(`SELECT payment_status FROM payouts WHERE id = ?`, id).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if current != expected {
+		return ErrStatusConflict
+	}
+
+	err = expected.ValidateTransition(status)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE payouts SET payment_status = ? WHERE id = ?`, status, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func scanPayout(row scanner) (*internal.Payout, error) {
+	var payout internal.Payout
+	var id, currency, userId string
+	var amountMinor int64
+
+	err := row.Scan(
+		&id, &payout.Description, &amountMinor, &currency, &payout.PaymentStatus, &payout.ZotaOrderId,
+		&payout.BankAccount.BankCode, &payout.BankAccount.AccountNumber, &payout.BankAccount.AccountName,
+		&payout.BankAccount.BranchName, &payout.BankAccount.CountryCode,
+		&userId, &payout.User.Email, &payout.User.FirstName, &payout.User.LastName, &payout.User.IpAddr, &payout.User.Phone,
+		&payout.User.Address.AddressLine, &payout.User.Address.CountryCode, &payout.User.Address.City, &payout.User.Address.ZipCode,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	payout.Id, err = uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+
+	payout.Amount, err = internal.NewMoney(amountMinor, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	if userId != "" {
+		payout.User.Id, err = uuid.Parse(userId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &payout, nil
+}
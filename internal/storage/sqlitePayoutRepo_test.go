@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+)
+
+func openTestPayoutRepo(t *testing.T, path string) *SQLitePayoutRepo {
+	db, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %q\n", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return NewSQLitePayoutRepo(db)
+}
+
+func TestSQLitePayoutRepoPersistsPayouts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alokin.db")
+	repo := openTestPayoutRepo(t, path)
+
+	order := createTestOrder()
+	bankAccount := internal.BankAccount{
+		BankCode:      "NDEADKKK",
+		AccountNumber: "DK5000400440116243",
+		AccountName:   "Nikola Velichkov",
+		BranchName:    "Copenhagen",
+		CountryCode:   "DK",
+	}
+	payout := internal.NewPayout(&order.User, &bankAccount, order.Amount, "Test payout")
+
+	err := repo.AddPayout(payout)
+	if err != nil {
+		t.Fatalf("Failed to add payout: %q\n", err)
+	}
+
+	err = repo.AddPayout(payout)
+	if err == nil {
+		t.Errorf("Adding a payout with the same ID didn't fail")
+	}
+
+	err = repo.SetZotaOrderId(payout.Id.String(), "9697960f4561f634dd5363590e55c93586a3721e")
+	if err != nil {
+		t.Fatalf("Failed to set Zota order ID: %q\n", err)
+	}
+
+	err = repo.UpdateStatus(payout.Id.String(), internal.PaymentStatusPending, internal.PaymentStatusApproved)
+	if err != nil {
+		t.Fatalf("Failed to update status: %q\n", err)
+	}
+
+	// Reopening the database must keep everything
+	repo = openTestPayoutRepo(t, path)
+
+	stored, err := repo.GetPayout(payout.Id.String())
+	if err != nil {
+		t.Fatalf("Failed to get payout: %q\n", err)
+	}
+
+	expected := *payout
+	expected.ZotaOrderId = "9697960f4561f634dd5363590e55c93586a3721e"
+	expected.PaymentStatus = internal.PaymentStatusApproved
+	if !reflect.DeepEqual(*stored, expected) {
+		t.Errorf("Stored payout %+v doesn't equal expected %+v", *stored, expected)
+	}
+
+	err = repo.UpdateStatus(payout.Id.String(), internal.PaymentStatusPending, internal.PaymentStatusDeclined)
+	if !errors.Is(err, ErrStatusConflict) {
+		t.Errorf("UpdateStatus error %v doesn't equal expected %v", err, ErrStatusConflict)
+	}
+
+	payouts, err := repo.GetAll()
+	if err != nil {
+		t.Fatalf("Failed to get all payouts: %q\n", err)
+	}
+
+	if len(payouts) != 1 {
+		t.Errorf("Number of payouts %d doesn't equal expected %d", len(payouts), 1)
+	}
+}
+
+func TestSQLitePayoutRepoNotFound(t *testing.T) {
+	repo := openTestPayoutRepo(t, filepath.Join(t.TempDir(), "alokin.db"))
+	id := "e31edd0d-76a6-4f1c-be19-4504ff5b89d7"
+
+	_, err := repo.GetPayout(id)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetPayout error %v doesn't equal expected %v", err, ErrNotFound)
+	}
+
+	err = repo.UpdateStatus(id, internal.PaymentStatusPending, internal.PaymentStatusApproved)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateStatus error %v doesn't equal expected %v", err, ErrNotFound)
+	}
+
+	err = repo.SetZotaOrderId(id, "")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetZotaOrderId error %v doesn't equal expected %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SQLitePollSchedule is a durable PollSchedule backed by SQLite
+type SQLitePollSchedule struct {
+	db *sql.DB
+}
+
+func NewSQLitePollSchedule(db *sql.DB) *SQLitePollSchedule {
+	return &SQLitePollSchedule{db}
+}
+
+func (s *SQLitePollSchedule) Schedule(entry PollEntry) error {
+	_, err := s.db.Exec(
+		`INSERT INTO poll_schedule (id, kind, zota_order_id, attempts, started_at, next_check_at, last_checked_at, request_id, trace_parent)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			kind = excluded.kind,
+			zota_order_id = excluded.zota_order_id,
+			attempts = excluded.attempts,
+			started_at = excluded.started_at,
+			next_check_at = excluded.next_check_at,
+			last_checked_at = excluded.last_checked_at,
+			request_id = excluded.request_id,
+			trace_parent = excluded.trace_parent`,
+		entry.Id, entry.Kind, entry.ZotaOrderId, entry.Attempts, unixNanoOrZero(entry.StartedAt),
+		entry.NextCheckAt.UnixNano(), unixNanoOrZero(entry.LastCheckedAt), entry.RequestId, entry.TraceParent,
+	)
+
+	return err
+}
+
+func (s *SQLitePollSchedule) Remove(id string) error {
+	_, err := s.db.Exec(`DELETE FROM poll_schedule WHERE id = ?`, id)
+	return err
+}
+
+func (s *SQLitePollSchedule) GetAll() ([]PollEntry, error) {
+	rows, err := s.db.Query(
+		`SELECT id, kind, zota_order_id, attempts, started_at, next_check_at, last_checked_at, request_id, trace_parent FROM poll_schedule`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]PollEntry, 0)
+	for rows.Next() {
+		var entry PollEntry
+		var startedAt, nextCheckAt, lastCheckedAt int64
+
+		err := rows.Scan(&entry.Id, &entry.Kind, &entry.ZotaOrderId, &entry.Attempts, &startedAt, &nextCheckAt, &lastCheckedAt, &entry.RequestId, &entry.TraceParent)
+		if err != nil {
+			return nil, err
+		}
+
+		if startedAt != 0 {
+			entry.StartedAt = time.Unix(0, startedAt)
+		}
+		entry.NextCheckAt = time.Unix(0, nextCheckAt)
+		if lastCheckedAt != 0 {
+			entry.LastCheckedAt = time.Unix(0, lastCheckedAt)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// unixNanoOrZero stores the zero time as 0 instead of a large negative number
+func unixNanoOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
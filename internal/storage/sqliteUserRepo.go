@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+)
+
+// SQLiteUserRepo is a durable storage for users and their sessions backed by SQLite
+type SQLiteUserRepo struct {
+	db *sql.DB
+}
+
+func NewSQLiteUserRepo(db *sql.DB) *SQLiteUserRepo {
+	return &SQLiteUserRepo{db}
+}
+
+const selectAccounts = `
+	SELECT id, email, password_hash, first_name, last_name, phone,
+		address_line, country_code, city, zip_code
+	FROM accounts`
+
+func (r *SQLiteUserRepo) AddUser(user *internal.User) error {
+	_, err := r.db.Exec(
+		`INSERT INTO accounts (id, email, password_hash, first_name, last_name, phone,
+			address_line, country_code, city, zip_code, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		user.Id.String(), user.Email, user.PasswordHash, user.FirstName, user.LastName, user.Phone,
+		user.Address.AddressLine, user.Address.CountryCode, user.Address.City, user.Address.ZipCode,
+		time.Now().UnixNano(),
+	)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed: accounts.email") {
+		return ErrEmailTaken
+	}
+
+	return err
+}
+
+func (r *SQLiteUserRepo) GetUser(id string) (*internal.User, error) {
+	return r.getUser(r.db.QueryRow(selectAccounts+` WHERE id = ?`, id))
+}
+
+func (r *SQLiteUserRepo) GetUserByEmail(email string) (*internal.User, error) {
+	return r.getUser(r.db.QueryRow(selectAccounts+` WHERE email = ?`, email))
+}
+
+func (r *SQLiteUserRepo) getUser(row *sql.Row) (*internal.User, error) {
+	var user internal.User
+	var id string
+
+	err := row.Scan(
+		&id, &user.Email, &user.PasswordHash, &user.FirstName, &user.LastName, &user.Phone,
+		&user.Address.AddressLine, &user.Address.CountryCode, &user.Address.City, &user.Address.ZipCode,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user.Id, err = uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *SQLiteUserRepo) AddSession(session *internal.Session) error {
+	_, err := r.db.Exec(
+		`INSERT INTO sessions (token_hash, account_id, expires_at) VALUES (?, ?, ?)`,
+		session.TokenHash, session.UserId.String(), session.ExpiresAt.UnixNano(),
+	)
+	if err != nil && strings.Contains(err.Error(), "FOREIGN KEY constraint failed") {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (r *SQLiteUserRepo) GetSession(tokenHash string) (*internal.Session, error) {
+	var session internal.Session
+	var userId string
+	var expiresAt int64
+
+	err := r.db.QueryRow(
+		`SELECT token_hash, account_id, expires_at FROM sessions WHERE token_hash = ?`,
+		tokenHash,
+	).Scan(&session.TokenHash, &userId, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	session.UserId, err = uuid.Parse(userId)
+	if err != nil {
+		return nil, err
+	}
+	session.ExpiresAt = time.Unix(0, expiresAt)
+
+	return &session, nil
+}
+
+func (r *SQLiteUserRepo) RemoveSession(tokenHash string) error {
+	result, err := r.db.Exec(`DELETE FROM sessions WHERE token_hash = ?`, tokenHash)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
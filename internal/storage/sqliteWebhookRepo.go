@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// SQLiteWebhookRepo is a durable WebhookRepo backed by SQLite, so that queued
+// deliveries are still sent after a restart
+type SQLiteWebhookRepo struct {
+	db *sql.DB
+}
+
+func NewSQLiteWebhookRepo(db *sql.DB) *SQLiteWebhookRepo {
+	return &SQLiteWebhookRepo{db}
+}
+
+const deliveryColumns = `id, subscription_id, event_id, event_type, payload, state, attempts, next_attempt_at, last_attempt_at, last_error, created_at`
+
+func (r *SQLiteWebhookRepo) AddSubscription(subscription *WebhookSubscription) error {
+	_, err := r.db.Exec(
+		`INSERT INTO webhook_subscriptions (id, url, secret, event_types, disabled, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		subscription.Id, subscription.Url, subscription.Secret, strings.Join(subscription.EventTypes, ","),
+		subscription.Disabled, subscription.CreatedAt.UnixNano(),
+	)
+
+	return err
+}
+
+func (r *SQLiteWebhookRepo) GetSubscription(id string) (*WebhookSubscription, error) {
+	row := r.db.QueryRow(
+		`SELECT id, url, secret, event_types, disabled, created_at FROM webhook_subscriptions WHERE id = ?`,
+		id,
+	)
+
+	subscription, err := scanSubscription(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+
+	return subscription, err
+}
+
+func (r *SQLiteWebhookRepo) GetSubscriptions() ([]*WebhookSubscription, error) {
+	rows, err := r.db.Query(
+		`SELECT id, url, secret, event_types, disabled, created_at FROM webhook_subscriptions ORDER BY created_at, id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := make([]*WebhookSubscription, 0)
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+func (r *SQLiteWebhookRepo) DisableSubscription(id string) error {
+	result, err := r.db.Exec(`UPDATE webhook_subscriptions SET disabled = 1 WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
+
+func (r *SQLiteWebhookRepo) AddDelivery(delivery *WebhookDelivery) error {
+	_, err := r.db.Exec(
+		`INSERT INTO webhook_deliveries (`+deliveryColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		delivery.Id, delivery.SubscriptionId, delivery.EventId, delivery.EventType, []byte(delivery.Payload),
+		delivery.State, delivery.Attempts, delivery.NextAttemptAt.UnixNano(), unixNanoOrZero(delivery.LastAttemptAt),
+		delivery.LastError, delivery.CreatedAt.UnixNano(),
+	)
+
+	return err
+}
+
+func (r *SQLiteWebhookRepo) UpdateDelivery(delivery *WebhookDelivery) error {
+	result, err := r.db.Exec(
+		`UPDATE webhook_deliveries
+		SET state = ?, attempts = ?, next_attempt_at = ?, last_attempt_at = ?, last_error = ?
+		WHERE id = ?`,
+		delivery.State, delivery.Attempts, delivery.NextAttemptAt.UnixNano(), unixNanoOrZero(delivery.LastAttemptAt),
+		delivery.LastError, delivery.Id,
+	)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
+
+func (r *SQLiteWebhookRepo) GetDueDeliveries(now time.Time, limit int) ([]*WebhookDelivery, error) {
+	return r.queryDeliveries(
+		`SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE state = ? AND next_attempt_at <= ?
+		ORDER BY next_attempt_at, id LIMIT ?`,
+		DeliveryPending, now.UnixNano(), limit,
+	)
+}
+
+func (r *SQLiteWebhookRepo) GetDeliveries(subscriptionId, state string) ([]*WebhookDelivery, error) {
+	return r.queryDeliveries(
+		`SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE subscription_id = ? AND (? = '' OR state = ?)
+		ORDER BY created_at, id`,
+		subscriptionId, state, state,
+	)
+}
+
+func (r *SQLiteWebhookRepo) ReplayDeliveries(subscriptionId string, now time.Time) (int, error) {
+	result, err := r.db.Exec(
+		`UPDATE webhook_deliveries SET state = ?, attempts = 0, next_attempt_at = ?
+		WHERE subscription_id = ? AND state = ?`,
+		DeliveryPending, now.UnixNano(), subscriptionId, DeliveryDead,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed, err := result.RowsAffected()
+	return int(replayed), err
+}
+
+func (r *SQLiteWebhookRepo) queryDeliveries(query string, args ...any) ([]*WebhookDelivery, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]*WebhookDelivery, 0)
+	for rows.Next() {
+		var delivery WebhookDelivery
+		var payload []byte
+		var nextAttemptAt, lastAttemptAt, createdAt int64
+
+		err := rows.Scan(
+			&delivery.Id, &delivery.SubscriptionId, &delivery.EventId, &delivery.EventType, &payload,
+			&delivery.State, &delivery.Attempts, &nextAttemptAt, &lastAttemptAt, &delivery.LastError, &createdAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		delivery.Payload = payload
+		delivery.NextAttemptAt = time.Unix(0, nextAttemptAt)
+		if lastAttemptAt != 0 {
+			delivery.LastAttemptAt = time.Unix(0, lastAttemptAt)
+		}
+		delivery.CreatedAt = time.Unix(0, createdAt)
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func scanSubscription(row scanner) (*WebhookSubscription, error) {
+	var subscription WebhookSubscription
+	var eventTypes string
+	var createdAt int64
+
+	err := row.Scan(
+		&subscription.Id, &subscription.Url, &subscription.Secret, &eventTypes, &subscription.Disabled, &createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription.EventTypes = strings.Split(eventTypes, ",")
+	subscription.CreatedAt = time.Unix(0, createdAt)
+
+	return &subscription, nil
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+)
+
+// ErrEmailTaken is returned by AddUser when a user with the same email
+// address has already registered
+var ErrEmailTaken = errors.New("Email address is already registered")
+
+// UserRepo stores the registered users and their login sessions.
+//
+// Implementations are safe for concurrent use and hand out copies.
+type UserRepo interface {
+	AddUser(user *internal.User) error
+	GetUser(id string) (*internal.User, error)
+	GetUserByEmail(email string) (*internal.User, error)
+
+	AddSession(session *internal.Session) error
+	// GetSession returns the session with the token hash, whether or not it
+	// has expired
+	GetSession(tokenHash string) (*internal.Session, error)
+	RemoveSession(tokenHash string) error
+}
+
+// MemoryUserRepo is a simple in-memory storage for users and their sessions
+type MemoryUserRepo struct {
+	mu sync.RWMutex
+	// users holds the registered users keyed by their ID
+	users map[string]*internal.User
+	// emails maps the email addresses of the users to their IDs
+	emails map[string]string
+	// sessions holds the sessions keyed by their token hash
+	sessions map[string]*internal.Session
+}
+
+func NewMemoryUserRepo() *MemoryUserRepo {
+	return &MemoryUserRepo{
+		users:    make(map[string]*internal.User),
+		emails:   make(map[string]string),
+		sessions: make(map[string]*internal.Session),
+	}
+}
+
+func (r *MemoryUserRepo) AddUser(user *internal.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, exists := r.emails[user.Email]
+	if exists {
+		return ErrEmailTaken
+	}
+
+	_, exists = r.users[user.Id.String()]
+	if exists {
+		return errors.New("Another user with the same ID already exists")
+	}
+
+	stored := *user
+	r.users[user.Id.String()] = &stored
+	r.emails[user.Email] = user.Id.String()
+	return nil
+}
+
+func (r *MemoryUserRepo) GetUser(id string) (*internal.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, exists := r.users[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	userCopy := *user
+	return &userCopy, nil
+}
+
+func (r *MemoryUserRepo) GetUserByEmail(email string) (*internal.User, error) {
+	r.mu.RLock()
+	id, exists := r.emails[email]
+	r.mu.RUnlock()
+
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	return r.GetUser(id)
+}
+
+func (r *MemoryUserRepo) AddSession(session *internal.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, exists := r.users[session.UserId.String()]
+	if !exists {
+		return ErrNotFound
+	}
+
+	stored := *session
+	r.sessions[session.TokenHash] = &stored
+	return nil
+}
+
+func (r *MemoryUserRepo) GetSession(tokenHash string) (*internal.Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	session, exists := r.sessions[tokenHash]
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	sessionCopy := *session
+	return &sessionCopy, nil
+}
+
+func (r *MemoryUserRepo) RemoveSession(tokenHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, exists := r.sessions[tokenHash]
+	if !exists {
+		return ErrNotFound
+	}
+
+	delete(r.sessions, tokenHash)
+	return nil
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+)
+
+func createTestUser(email string) *internal.User {
+	address := internal.UserAddress{
+		AddressLine: "Line",
+		CountryCode: "DK",
+		City:        "City",
+		ZipCode:     "zip",
+	}
+
+	user := internal.NewUser(email, "Nikola", "Velichkov", "+4511111111", address)
+	user.SetPassword("correct horse battery staple")
+
+	return user
+}
+
+// testUserRepo checks the behaviour every UserRepo implementation must have
+func testUserRepo(t *testing.T, repo UserRepo) {
+	user := createTestUser("federlizer@protonmail.com")
+	err := repo.AddUser(user)
+	if err != nil {
+		t.Fatalf("Failed to add user: %q\n", err)
+	}
+
+	err = repo.AddUser(createTestUser("federlizer@protonmail.com"))
+	if !errors.Is(err, ErrEmailTaken) {
+		t.Errorf("Adding a user with a taken email returned %v, expected %v", err, ErrEmailTaken)
+	}
+
+	stored, err := repo.GetUserByEmail("federlizer@protonmail.com")
+	if err != nil {
+		t.Fatalf("Failed to get user by email: %q\n", err)
+	}
+
+	if stored.Id != user.Id || stored.Address != user.Address || !stored.CheckPassword("correct horse battery staple") {
+		t.Errorf("Stored user %+v doesn't equal expected %+v", *stored, *user)
+	}
+
+	_, err = repo.GetUser("e31edd0d-76a6-4f1c-be19-4504ff5b89d7")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Getting an unknown user returned %v, expected %v", err, ErrNotFound)
+	}
+
+	session, token, err := internal.NewSession(user.Id, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create session: %q\n", err)
+	}
+
+	err = repo.AddSession(session)
+	if err != nil {
+		t.Fatalf("Failed to add session: %q\n", err)
+	}
+
+	storedSession, err := repo.GetSession(internal.HashToken(token))
+	if err != nil {
+		t.Fatalf("Failed to get session: %q\n", err)
+	}
+
+	if storedSession.UserId != user.Id || !storedSession.ExpiresAt.Equal(session.ExpiresAt) {
+		t.Errorf("Stored session %+v doesn't equal expected %+v", *storedSession, *session)
+	}
+
+	err = repo.RemoveSession(session.TokenHash)
+	if err != nil {
+		t.Fatalf("Failed to remove session: %q\n", err)
+	}
+
+	_, err = repo.GetSession(session.TokenHash)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Getting a removed session returned %v, expected %v", err, ErrNotFound)
+	}
+}
+
+func TestMemoryUserRepo(t *testing.T) {
+	testUserRepo(t, NewMemoryUserRepo())
+}
+
+func TestSQLiteUserRepo(t *testing.T) {
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "alokin.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %q\n", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	testUserRepo(t, NewSQLiteUserRepo(db))
+}
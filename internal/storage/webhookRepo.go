@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DeliveryPending marks deliveries that are waiting to be sent
+	DeliveryPending = "pending"
+	// DeliveryDelivered marks deliveries the subscriber has accepted
+	DeliveryDelivered = "delivered"
+	// DeliveryDead marks deliveries that have been given up on
+	DeliveryDead = "dead"
+)
+
+// WebhookSubscription is an endpoint that's notified about order events
+type WebhookSubscription struct {
+	Id  string `json:"id"`
+	Url string `json:"url"`
+	// Secret is the key every delivery is signed with
+	Secret string `json:"-"`
+	// EventTypes are the events the endpoint is notified about
+	EventTypes []string  `json:"eventTypes"`
+	Disabled   bool      `json:"disabled"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// Subscribes reports whether the subscription wants to be notified about
+// events of the given type
+func (s *WebhookSubscription) Subscribes(eventType string) bool {
+	return !s.Disabled && slices.Contains(s.EventTypes, eventType)
+}
+
+// WebhookDelivery is a single event that's sent to a webhook subscription
+type WebhookDelivery struct {
+	Id             string `json:"id"`
+	SubscriptionId string `json:"subscriptionId"`
+	// EventId is the same for the deliveries of an event to every
+	// subscription, so subscribers can detect duplicates
+	EventId   string          `json:"eventId"`
+	EventType string          `json:"eventType"`
+	Payload   json.RawMessage `json:"payload"`
+
+	// State is either DeliveryPending, DeliveryDelivered or DeliveryDead
+	State    string `json:"state"`
+	Attempts int    `json:"attempts"`
+	// NextAttemptAt is when a pending delivery is due to be sent
+	NextAttemptAt time.Time `json:"nextAttemptAt"`
+	// LastAttemptAt is when the delivery was last sent, zero if never
+	LastAttemptAt time.Time `json:"lastAttemptAt"`
+	// LastError is why the last attempt failed, if it did
+	LastError string    `json:"lastError,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhookRepo stores the webhook subscriptions and the queue of deliveries to
+// them.
+//
+// Implementations are safe for concurrent use.
+type WebhookRepo interface {
+	AddSubscription(subscription *WebhookSubscription) error
+	GetSubscription(id string) (*WebhookSubscription, error)
+	// GetSubscriptions returns every subscription, oldest first
+	GetSubscriptions() ([]*WebhookSubscription, error)
+	DisableSubscription(id string) error
+
+	AddDelivery(delivery *WebhookDelivery) error
+	// UpdateDelivery replaces the stored delivery with the same ID
+	UpdateDelivery(delivery *WebhookDelivery) error
+	// GetDueDeliveries returns up to limit pending deliveries that are due
+	// at the given time, the longest due first
+	GetDueDeliveries(now time.Time, limit int) ([]*WebhookDelivery, error)
+	// GetDeliveries returns the subscription's deliveries in the given
+	// state, or in any state if it's empty, oldest first
+	GetDeliveries(subscriptionId, state string) ([]*WebhookDelivery, error)
+	// ReplayDeliveries queues the subscription's dead deliveries again, due
+	// at the given time and with their attempts reset. The number of
+	// replayed deliveries is returned.
+	ReplayDeliveries(subscriptionId string, now time.Time) (int, error)
+}
+
+// MemoryWebhookRepo is a simple in-memory WebhookRepo
+type MemoryWebhookRepo struct {
+	mu            sync.Mutex
+	subscriptions map[string]WebhookSubscription
+	deliveries    map[string]WebhookDelivery
+}
+
+func NewMemoryWebhookRepo() *MemoryWebhookRepo {
+	return &MemoryWebhookRepo{
+		subscriptions: make(map[string]WebhookSubscription),
+		deliveries:    make(map[string]WebhookDelivery),
+	}
+}
+
+func (r *MemoryWebhookRepo) AddSubscription(subscription *WebhookSubscription) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *subscription
+	stored.EventTypes = slices.Clone(subscription.EventTypes)
+	r.subscriptions[subscription.Id] = stored
+
+	return nil
+}
+
+func (r *MemoryWebhookRepo) GetSubscription(id string) (*WebhookSubscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subscription, exists := r.subscriptions[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	subscription.EventTypes = slices.Clone(subscription.EventTypes)
+	return &subscription, nil
+}
+
+func (r *MemoryWebhookRepo) GetSubscriptions() ([]*WebhookSubscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subscriptions := make([]*WebhookSubscription, 0, len(r.subscriptions))
+	for _, subscription := range r.subscriptions {
+		subscription.EventTypes = slices.Clone(subscription.EventTypes)
+		subscriptions = append(subscriptions, &subscription)
+	}
+
+	sort.Slice(subscriptions, func(i, j int) bool {
+		if subscriptions[i].CreatedAt.Equal(subscriptions[j].CreatedAt) {
+			return subscriptions[i].Id < subscriptions[j].Id
+		}
+
+		return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+	})
+
+	return subscriptions, nil
+}
+
+func (r *MemoryWebhookRepo) DisableSubscription(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subscription, exists := r.subscriptions[id]
+	if !exists {
+		return ErrNotFound
+	}
+
+	subscription.Disabled = true
+	r.subscriptions[id] = subscription
+
+	return nil
+}
+
+func (r *MemoryWebhookRepo) AddDelivery(delivery *WebhookDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.deliveries[delivery.Id] = *delivery
+	return nil
+}
+
+func (r *MemoryWebhookRepo) UpdateDelivery(delivery *WebhookDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, exists := r.deliveries[delivery.Id]
+	if !exists {
+		return ErrNotFound
+	}
+
+	r.deliveries[delivery.Id] = *delivery
+	return nil
+}
+
+func (r *MemoryWebhookRepo) GetDueDeliveries(now time.Time, limit int) ([]*WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	due := r.filterDeliveries(func(delivery WebhookDelivery) bool {
+		return delivery.State == DeliveryPending && !delivery.NextAttemptAt.After(now)
+	})
+
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].Id < due[j].Id
+		}
+
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	return due, nil
+}
+
+func (r *MemoryWebhookRepo) GetDeliveries(subscriptionId, state string) ([]*WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deliveries := r.filterDeliveries(func(delivery WebhookDelivery) bool {
+		return delivery.SubscriptionId == subscriptionId && (state == "" || delivery.State == state)
+	})
+
+	sortDeliveries(deliveries)
+	return deliveries, nil
+}
+
+func (r *MemoryWebhookRepo) ReplayDeliveries(subscriptionId string, now time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	replayed := 0
+	for id, delivery := range r.deliveries {
+		if delivery.SubscriptionId != subscriptionId || delivery.State != DeliveryDead {
+			continue
+		}
+
+		delivery.State = DeliveryPending
+		delivery.Attempts = 0
+		delivery.NextAttemptAt = now
+		r.deliveries[id] = delivery
+		replayed++
+	}
+
+	return replayed, nil
+}
+
+// filterDeliveries returns copies of the deliveries selected by keep, the
+// caller needs to hold the lock
+func (r *MemoryWebhookRepo) filterDeliveries(keep func(delivery WebhookDelivery) bool) []*WebhookDelivery {
+	deliveries := make([]*WebhookDelivery, 0)
+	for _, delivery := range r.deliveries {
+		if keep(delivery) {
+			deliveries = append(deliveries, &delivery)
+		}
+	}
+
+	return deliveries
+}
+
+// sortDeliveries sorts the deliveries oldest first
+func sortDeliveries(deliveries []*WebhookDelivery) {
+	sort.Slice(deliveries, func(i, j int) bool {
+		if deliveries[i].CreatedAt.Equal(deliveries[j].CreatedAt) {
+			return deliveries[i].Id < deliveries[j].Id
+		}
+
+		return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+	})
+}
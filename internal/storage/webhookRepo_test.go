@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func createTestDelivery(id, subscriptionId string, createdAt time.Time) *WebhookDelivery {
+	return &WebhookDelivery{
+		Id:             id,
+		SubscriptionId: subscriptionId,
+		EventId:        "event-" + id,
+		EventType:      "order.approved",
+		Payload:        []byte(`{"type":"order.approved"}`),
+		State:          DeliveryPending,
+		NextAttemptAt:  createdAt,
+		CreatedAt:      createdAt,
+	}
+}
+
+// testWebhookRepo checks the behaviour every WebhookRepo implementation must
+// have
+func testWebhookRepo(t *testing.T, repo WebhookRepo) {
+	now := time.Now()
+	subscription := &WebhookSubscription{
+		Id:         "subscription-1",
+		Url:        "https://merchant.com/webhooks",
+		Secret:     "secret",
+		EventTypes: []string{"order.approved", "order.failed"},
+		CreatedAt:  now,
+	}
+
+	err := repo.AddSubscription(subscription)
+	if err != nil {
+		t.Fatalf("Failed to add subscription: %q\n", err)
+	}
+
+	err = repo.AddSubscription(&WebhookSubscription{Id: "subscription-2", EventTypes: []string{"order.failed"}, CreatedAt: now.Add(time.Second)})
+	if err != nil {
+		t.Fatalf("Failed to add subscription: %q\n", err)
+	}
+
+	stored, err := repo.GetSubscription(subscription.Id)
+	if err != nil {
+		t.Fatalf("Failed to get subscription: %q\n", err)
+	}
+
+	if stored.Url != subscription.Url || stored.Secret != subscription.Secret || !stored.Subscribes("order.failed") || stored.Subscribes("order.pending") {
+		t.Errorf("Subscription %+v doesn't equal expected %+v", *stored, *subscription)
+	}
+
+	subscriptions, err := repo.GetSubscriptions()
+	if err != nil {
+		t.Fatalf("Failed to get subscriptions: %q\n", err)
+	}
+
+	if len(subscriptions) != 2 || subscriptions[0].Id != "subscription-1" || subscriptions[1].Id != "subscription-2" {
+		t.Errorf("Subscriptions %+v aren't sorted by creation", subscriptions)
+	}
+
+	_, err = repo.GetSubscription("unknown")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Getting an unknown subscription returned %v, expected %v", err, ErrNotFound)
+	}
+
+	for i, id := range []string{"a", "b", "c"} {
+		err := repo.AddDelivery(createTestDelivery(id, subscription.Id, now.Add(time.Duration(i)*time.Second)))
+		if err != nil {
+			t.Fatalf("Failed to add delivery: %q\n", err)
+		}
+	}
+
+	due, err := repo.GetDueDeliveries(now.Add(time.Second), 10)
+	if err != nil {
+		t.Fatalf("Failed to get due deliveries: %q\n", err)
+	}
+
+	if len(due) != 2 || due[0].Id != "a" || due[1].Id != "b" || string(due[0].Payload) != `{"type":"order.approved"}` {
+		t.Errorf("Due deliveries %+v don't equal the deliveries due by then", due)
+	}
+
+	due, _ = repo.GetDueDeliveries(now.Add(time.Hour), 1)
+	if len(due) != 1 || due[0].Id != "a" {
+		t.Errorf("Due deliveries %+v aren't limited to the longest due", due)
+	}
+
+	delivered := due[0]
+	delivered.State = DeliveryDelivered
+	delivered.Attempts = 1
+	delivered.LastAttemptAt = now
+	err = repo.UpdateDelivery(delivered)
+	if err != nil {
+		t.Fatalf("Failed to update delivery: %q\n", err)
+	}
+
+	dead := createTestDelivery("b", subscription.Id, now.Add(time.Second))
+	dead.State = DeliveryDead
+	dead.Attempts = 10
+	dead.LastError = "Webhook endpoint responded with 500"
+	repo.UpdateDelivery(dead)
+
+	err = repo.UpdateDelivery(createTestDelivery("unknown", subscription.Id, now))
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Updating an unknown delivery returned %v, expected %v", err, ErrNotFound)
+	}
+
+	deliveries, err := repo.GetDeliveries(subscription.Id, "")
+	if err != nil {
+		t.Fatalf("Failed to get deliveries: %q\n", err)
+	}
+
+	if len(deliveries) != 3 || deliveries[0].State != DeliveryDelivered || deliveries[0].Attempts != 1 || !deliveries[0].LastAttemptAt.Equal(now) {
+		t.Errorf("Deliveries %+v don't contain the updated delivery", deliveries)
+	}
+
+	deliveries, _ = repo.GetDeliveries(subscription.Id, DeliveryDead)
+	if len(deliveries) != 1 || deliveries[0].Id != "b" || deliveries[0].LastError != dead.LastError {
+		t.Errorf("Dead deliveries %+v don't equal expected [%+v]", deliveries, *dead)
+	}
+
+	replayed, err := repo.ReplayDeliveries(subscription.Id, now.Add(time.Minute))
+	if err != nil || replayed != 1 {
+		t.Errorf("Replaying deliveries returned %d, %v, expected 1 replayed delivery", replayed, err)
+	}
+
+	due, _ = repo.GetDueDeliveries(now.Add(time.Minute), 10)
+	if len(due) != 2 || due[0].Id != "c" || due[1].Id != "b" || due[1].Attempts != 0 {
+		t.Errorf("Due deliveries %+v don't contain the replayed delivery", due)
+	}
+
+	err = repo.DisableSubscription(subscription.Id)
+	if err != nil {
+		t.Fatalf("Failed to disable subscription: %q\n", err)
+	}
+
+	stored, _ = repo.GetSubscription(subscription.Id)
+	if !stored.Disabled || stored.Subscribes("order.approved") {
+		t.Errorf("Subscription %+v hasn't been disabled", *stored)
+	}
+
+	err = repo.DisableSubscription("unknown")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Disabling an unknown subscription returned %v, expected %v", err, ErrNotFound)
+	}
+}
+
+func TestMemoryWebhookRepo(t *testing.T) {
+	testWebhookRepo(t, NewMemoryWebhookRepo())
+}
+
+func TestSQLiteWebhookRepo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alokin.db")
+	db, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %q\n", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	testWebhookRepo(t, NewSQLiteWebhookRepo(db))
+}
@@ -0,0 +1,28 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceparentHeader carries the trace context, see
+// https://www.w3.org/TR/trace-context/
+const TraceparentHeader = "traceparent"
+
+// Traceparent formats the context of the span ctx carries as the value of a
+// traceparent header, "" if ctx doesn't carry a valid one
+func Traceparent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get(TraceparentHeader)
+}
+
+// ParseTraceparent parses the value of a traceparent header. The span
+// context of a value that can't be parsed isn't valid.
+func ParseTraceparent(value string) trace.SpanContext {
+	carrier := propagation.MapCarrier{TraceparentHeader: value}
+	ctx := propagation.TraceContext{}.Extract(context.Background(), carrier)
+	return trace.SpanContextFromContext(ctx)
+}
@@ -0,0 +1,224 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+)
+
+// The repositories don't take a context, so the traced ones are bound to the
+// context of the request or poller check they're used for
+
+// OrderRepo wraps the order repo, so that every call is recorded as a child
+// span of the span ctx carries. Without a recording span, the repo is
+// returned as is.
+func OrderRepo(ctx context.Context, repo storage.OrderRepo) storage.OrderRepo {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return repo
+	}
+
+	return &tracedOrderRepo{ctx: ctx, repo: repo}
+}
+
+// PayoutRepo wraps the payout repo like OrderRepo
+func PayoutRepo(ctx context.Context, repo storage.PayoutRepo) storage.PayoutRepo {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return repo
+	}
+
+	return &tracedPayoutRepo{ctx: ctx, repo: repo}
+}
+
+// UserRepo wraps the user repo like OrderRepo
+func UserRepo(ctx context.Context, repo storage.UserRepo) storage.UserRepo {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return repo
+	}
+
+	return &tracedUserRepo{ctx: ctx, repo: repo}
+}
+
+// startRepoSpan starts the span of a repository call
+func startRepoSpan(ctx context.Context, name string) trace.Span {
+	_, span := Start(ctx, name, trace.WithAttributes(attribute.String("db.operation", name)))
+	return span
+}
+
+// endRepoSpan ends the span of a repository call. Entities that don't exist
+// are an expected outcome, not a failure.
+func endRepoSpan(span trace.Span, err error) {
+	if !errors.Is(err, storage.ErrNotFound) {
+		RecordError(span, err)
+	}
+
+	span.End()
+}
+
+type tracedOrderRepo struct {
+	ctx  context.Context
+	repo storage.OrderRepo
+}
+
+func (r *tracedOrderRepo) AddOrder(order *internal.Order) error {
+	span := startRepoSpan(r.ctx, "OrderRepo.AddOrder")
+	err := r.repo.AddOrder(order)
+	endRepoSpan(span, err)
+	return err
+}
+
+func (r *tracedOrderRepo) GetOrder(id string) (*internal.Order, error) {
+	span := startRepoSpan(r.ctx, "OrderRepo.GetOrder")
+	order, err := r.repo.GetOrder(id)
+	endRepoSpan(span, err)
+	return order, err
+}
+
+func (r *tracedOrderRepo) GetAll() ([]*internal.Order, error) {
+	span := startRepoSpan(r.ctx, "OrderRepo.GetAll")
+	orders, err := r.repo.GetAll()
+	endRepoSpan(span, err)
+	return orders, err
+}
+
+func (r *tracedOrderRepo) QueryOrders(query storage.OrderQuery) (*storage.OrderPage, error) {
+	span := startRepoSpan(r.ctx, "OrderRepo.QueryOrders")
+	page, err := r.repo.QueryOrders(query)
+	endRepoSpan(span, err)
+	return page, err
+}
+
+func (r *tracedOrderRepo) SetZotaOrder(id, zotaOrderId, depositUrl string) error {
+	span := startRepoSpan(r.ctx, "OrderRepo.SetZotaOrder")
+	err := r.repo.SetZotaOrder(id, zotaOrderId, depositUrl)
+	endRepoSpan(span, err)
+	return err
+}
+
+func (r *tracedOrderRepo) SetProcessorTransactionId(id, processorTransactionId string) error {
+	span := startRepoSpan(r.ctx, "OrderRepo.SetProcessorTransactionId")
+	err := r.repo.SetProcessorTransactionId(id, processorTransactionId)
+	endRepoSpan(span, err)
+	return err
+}
+
+func (r *tracedOrderRepo) UpdateStatus(id string, expected internal.PaymentStatus, change internal.StatusChange) error {
+	span := startRepoSpan(r.ctx, "OrderRepo.UpdateStatus")
+	span.SetAttributes(attribute.String("payment.status", string(change.Status)))
+	err := r.repo.UpdateStatus(id, expected, change)
+	endRepoSpan(span, err)
+	return err
+}
+
+func (r *tracedOrderRepo) GetStatusHistory(id string) ([]internal.StatusChange, error) {
+	span := startRepoSpan(r.ctx, "OrderRepo.GetStatusHistory")
+	history, err := r.repo.GetStatusHistory(id)
+	endRepoSpan(span, err)
+	return history, err
+}
+
+func (r *tracedOrderRepo) GetFinalised(limit int) ([]string, error) {
+	span := startRepoSpan(r.ctx, "OrderRepo.GetFinalised")
+	ids, err := r.repo.GetFinalised(limit)
+	endRepoSpan(span, err)
+	return ids, err
+}
+
+func (r *tracedOrderRepo) AckFinalised(id string) error {
+	span := startRepoSpan(r.ctx, "OrderRepo.AckFinalised")
+	err := r.repo.AckFinalised(id)
+	endRepoSpan(span, err)
+	return err
+}
+
+type tracedPayoutRepo struct {
+	ctx  context.Context
+	repo storage.PayoutRepo
+}
+
+func (r *tracedPayoutRepo) AddPayout(payout *internal.Payout) error {
+	span := startRepoSpan(r.ctx, "PayoutRepo.AddPayout")
+	err := r.repo.AddPayout(payout)
+	endRepoSpan(span, err)
+	return err
+}
+
+func (r *tracedPayoutRepo) GetPayout(id string) (*internal.Payout, error) {
+	span := startRepoSpan(r.ctx, "PayoutRepo.GetPayout")
+	payout, err := r.repo.GetPayout(id)
+	endRepoSpan(span, err)
+	return payout, err
+}
+
+func (r *tracedPayoutRepo) GetAll() ([]*internal.Payout, error) {
+	span := startRepoSpan(r.ctx, "PayoutRepo.GetAll")
+	payouts, err := r.repo.GetAll()
+	endRepoSpan(span, err)
+	return payouts, err
+}
+
+func (r *tracedPayoutRepo) SetZotaOrderId(id, zotaOrderId string) error {
+	span := startRepoSpan(r.ctx, "PayoutRepo.SetZotaOrderId")
+	err := r.repo.SetZotaOrderId(id, zotaOrderId)
+	endRepoSpan(span, err)
+	return err
+}
+
+func (r *tracedPayoutRepo) UpdateStatus(id string, expected, status internal.PaymentStatus) error {
+	span := startRepoSpan(r.ctx, "PayoutRepo.UpdateStatus")
+	span.SetAttributes(attribute.String("payment.status", string(status)))
+	err := r.repo.UpdateStatus(id, expected, status)
+	endRepoSpan(span, err)
+	return err
+}
+
+type tracedUserRepo struct {
+	ctx  context.Context
+	repo storage.UserRepo
+}
+
+func (r *tracedUserRepo) AddUser(user *internal.User) error {
+	span := startRepoSpan(r.ctx, "UserRepo.AddUser")
+	err := r.repo.AddUser(user)
+	endRepoSpan(span, err)
+	return err
+}
+
+func (r *tracedUserRepo) GetUser(id string) (*internal.User, error) {
+	span := startRepoSpan(r.ctx, "UserRepo.GetUser")
+	user, err := r.repo.GetUser(id)
+	endRepoSpan(span, err)
+	return user, err
+}
+
+func (r *tracedUserRepo) GetUserByEmail(email string) (*internal.User, error) {
+	span := startRepoSpan(r.ctx, "UserRepo.GetUserByEmail")
+	user, err := r.repo.GetUserByEmail(email)
+	endRepoSpan(span, err)
+	return user, err
+}
+
+func (r *tracedUserRepo) AddSession(session *internal.Session) error {
+	span := startRepoSpan(r.ctx, "UserRepo.AddSession")
+	err := r.repo.AddSession(session)
+	endRepoSpan(span, err)
+	return err
+}
+
+func (r *tracedUserRepo) GetSession(tokenHash string) (*internal.Session, error) {
+	span := startRepoSpan(r.ctx, "UserRepo.GetSession")
+	session, err := r.repo.GetSession(tokenHash)
+	endRepoSpan(span, err)
+	return session, err
+}
+
+func (r *tracedUserRepo) RemoveSession(tokenHash string) error {
+	span := startRepoSpan(r.ctx, "UserRepo.RemoveSession")
+	err := r.repo.RemoveSession(tokenHash)
+	endRepoSpan(span, err)
+	return err
+}
@@ -0,0 +1,47 @@
+// Package tracing follows requests through the API, the requests made to
+// Zota and the poller's checks with OpenTelemetry.
+//
+// Spans are recorded with the global TracerProvider, which SetDefault sets.
+// Until then OpenTelemetry's no-op provider is used, so instrumented code
+// doesn't need to check whether tracing is enabled. The trace context is
+// propagated in W3C traceparent headers.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer the spans started by Start
+// and the traced repositories are recorded with
+const instrumentationName = "github.com/federlizer/alokin-zota-integration"
+
+// SetDefault makes Start, the traced repositories, the API's handlers and
+// the requests made to Zota record spans with the provider, and propagates
+// the trace context in traceparent headers. The handlers and ZotaAPIs
+// created before SetDefault is first called aren't traced.
+func SetDefault(provider trace.TracerProvider) {
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+}
+
+// Start starts a span that's a child of the span ctx carries, which might be
+// a remote one, and returns a copy of ctx that carries the new span
+func Start(ctx context.Context, name string, options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, options...)
+}
+
+// RecordError marks the operation of the span as failed with the error, if
+// it isn't nil
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,138 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+)
+
+// setupTracer makes the test record its spans with the returned recorder
+func setupTracer(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	SetDefault(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { SetDefault(noop.NewTracerProvider()) })
+
+	return recorder
+}
+
+func TestStartWithoutProvider(t *testing.T) {
+	ctx, span := Start(context.Background(), "Test")
+	defer span.End()
+
+	// Instrumented code doesn't need to check whether tracing is enabled
+	if span.IsRecording() || trace.SpanContextFromContext(ctx).IsValid() {
+		t.Errorf("Span was recorded without a provider")
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		valid bool
+	}{
+		{"valid", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+		{"later version with more fields", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"empty", "", false},
+		{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"version 00 with more fields", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"upper case", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01", false},
+		{"short trace ID", "00-4bf92f3577b34da6-00f067aa0ba902b7-01", false},
+		{"zero trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"zero span ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"not hex", "00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sc := ParseTraceparent(test.value)
+			if sc.IsValid() != test.valid {
+				t.Fatalf("Validity %t of %q doesn't equal expected %t", sc.IsValid(), test.value, test.valid)
+			}
+
+			if test.valid && (sc.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID().String() != "00f067aa0ba902b7") {
+				t.Errorf("Span context %+v doesn't match %q", sc, test.value)
+			}
+		})
+	}
+}
+
+func TestTraceparent(t *testing.T) {
+	if traceparent := Traceparent(context.Background()); traceparent != "" {
+		t.Errorf("Traceparent %q was formatted without a span", traceparent)
+	}
+
+	setupTracer(t)
+	ctx, span := Start(context.Background(), "Test")
+	defer span.End()
+
+	traceparent := Traceparent(ctx)
+	if !ParseTraceparent(traceparent).Equal(span.SpanContext().WithRemote(true)) {
+		t.Errorf("Traceparent %q doesn't identify the span %+v", traceparent, span.SpanContext())
+	}
+}
+
+func TestRepoSpans(t *testing.T) {
+	orderRepo := storage.NewMemoryOrderRepo()
+
+	// Without a span, the repo isn't wrapped
+	if OrderRepo(context.Background(), orderRepo) != storage.OrderRepo(orderRepo) {
+		t.Errorf("Repo was wrapped without a span")
+	}
+
+	recorder := setupTracer(t)
+
+	ctx, parent := Start(context.Background(), "Request")
+	tracedRepo := OrderRepo(ctx, orderRepo)
+
+	user := internal.User{Email: "federlizer@protonmail.com"}
+	amount, _ := internal.NewMoney(1337, "USD")
+	order := internal.NewOrder(&user, amount, "Test order")
+	err := tracedRepo.AddOrder(order)
+	if err != nil {
+		t.Fatalf("Failed to add order: %q\n", err)
+	}
+
+	_, err = tracedRepo.GetOrder("unknown")
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Error %v isn't storage.ErrNotFound", err)
+	}
+
+	err = tracedRepo.UpdateStatus(order.Id.String(), internal.PaymentStatusApproved, internal.StatusChange{Status: internal.PaymentStatusDeclined})
+	if !errors.Is(err, storage.ErrStatusConflict) {
+		t.Fatalf("Error %v isn't storage.ErrStatusConflict", err)
+	}
+
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 4 {
+		t.Fatalf("Number of ended spans %d doesn't equal expected %d", len(spans), 4)
+	}
+
+	for i, name := range []string{"OrderRepo.AddOrder", "OrderRepo.GetOrder"} {
+		span := spans[i]
+		if span.Name() != name || span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("Span %q isn't a child span named %q", span.Name(), name)
+		}
+
+		// Orders that don't exist aren't a failure
+		if span.Status().Code == codes.Error {
+			t.Errorf("Span %q recorded error %q", span.Name(), span.Status().Description)
+		}
+	}
+
+	if spans[2].Status().Code != codes.Error {
+		t.Errorf("Span %q didn't record the conflicting status", spans[2].Name())
+	}
+}
@@ -1,18 +1,71 @@
 package internal
 
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidPassword is returned by SetPassword for passwords that can't be
+// hashed, i.e. ones longer than 72 bytes
+var ErrInvalidPassword = errors.New("Invalid password")
+
+// User is a registered customer of the merchant. Orders and payouts keep a
+// copy of the user they were made by, with IpAddr set to the address the
+// request came from.
 type User struct {
-	Email     string
-	FirstName string
-	LastName  string
-	IpAddr    string
-	Phone     string
-	Address   UserAddress
+	Id        uuid.UUID   `json:"id"`
+	Email     string      `json:"email"`
+	FirstName string      `json:"firstName"`
+	LastName  string      `json:"lastName"`
+	IpAddr    string      `json:"-"`
+	Phone     string      `json:"phone"`
+	Address   UserAddress `json:"address"`
+	// PasswordHash is the bcrypt hash of the user's password
+	PasswordHash []byte `json:"-"`
 }
 
 type UserAddress struct {
-	AddressLine string
-	CountryCode string
-	City        string
+	AddressLine string `json:"addressLine"`
+	CountryCode string `json:"countryCode"`
+	City        string `json:"city"`
 	// state string
-	ZipCode string
+	ZipCode string `json:"zipCode"`
+}
+
+func NewUser(email, firstName, lastName, phone string, address UserAddress) *User {
+	return &User{
+		Id:        uuid.New(),
+		Email:     email,
+		FirstName: firstName,
+		LastName:  lastName,
+		Phone:     phone,
+		Address:   address,
+	}
+}
+
+// SetPassword hashes the password and stores the hash on the user
+func (u *User) SetPassword(password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		return ErrInvalidPassword
+	}
+	if err != nil {
+		return err
+	}
+
+	u.PasswordHash = hash
+	return nil
+}
+
+// CheckPassword reports whether the password matches the user's password hash
+func (u *User) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(password)) == nil
+}
+
+// LogValue logs only the user's ID, their personal details are never logged
+func (u User) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("id", u.Id.String()))
 }
@@ -0,0 +1,39 @@
+package webhooks
+
+import "time"
+
+// Backoff doubles the delay between the attempts of a delivery, starting from
+// Initial and capped at Max. A delivery is given up on, and moved to the dead
+// letters, after MaxAttempts failed attempts.
+type Backoff struct {
+	Initial     time.Duration
+	Max         time.Duration
+	MaxAttempts int
+}
+
+// DefaultBackoff retries a delivery for about a day
+var DefaultBackoff = Backoff{
+	Initial:     10 * time.Second,
+	Max:         6 * time.Hour,
+	MaxAttempts: 15,
+}
+
+// NextDelay returns how long to wait before the next attempt, given the
+// attempts that have been made so far. If the delivery should be given up
+// on, false is returned.
+func (b Backoff) NextDelay(attempts int) (time.Duration, bool) {
+	if attempts >= b.MaxAttempts {
+		return 0, false
+	}
+
+	delay := b.Initial
+	for i := 1; i < attempts && delay < b.Max; i++ {
+		delay *= 2
+	}
+
+	if delay > b.Max {
+		delay = b.Max
+	}
+
+	return delay, true
+}
@@ -0,0 +1,376 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+)
+
+const (
+	EventOrderApproved = "order.approved"
+	// EventOrderFailed is sent for every other final status, the order's
+	// payment status tells why it failed
+	EventOrderFailed = "order.failed"
+)
+
+// EventTypes are the events webhooks can be subscribed to
+var EventTypes = []string{EventOrderApproved, EventOrderFailed}
+
+// deliveryBatch is the maximum number of deliveries sent per tick
+const deliveryBatch = 100
+
+// OrderEvent is the payload sent to the subscribers of an order event
+type OrderEvent struct {
+	// Id is the same for every subscriber of the event
+	Id        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      struct {
+		Order  *internal.Order `json:"order"`
+		UserId string          `json:"userId"`
+	} `json:"data"`
+}
+
+// orderEventType returns the type of the event of an order reaching the
+// payment status, if there's one
+func orderEventType(status internal.PaymentStatus) (string, bool) {
+	switch status {
+	case internal.PaymentStatusApproved:
+		return EventOrderApproved, true
+	case internal.PaymentStatusDeclined, internal.PaymentStatusFiltered, internal.PaymentStatusError, internal.PaymentStatusExpired:
+		return EventOrderFailed, true
+	default:
+		return "", false
+	}
+}
+
+// Dispatcher queues the events of the orders that have reached a final status
+// for the webhook subscriptions interested in them and sends the queued
+// deliveries, retrying failed ones with Backoff.
+//
+// Finalised orders are taken from the storage.OrderRepo, which records them
+// together with their final status, whether it's set by the poller, a Zota
+// callback or the API. The queue is kept in a storage.WebhookRepo. When
+// durable repos are used, neither events that haven't been queued nor
+// deliveries that haven't been sent are lost in a restart.
+type Dispatcher struct {
+	repo    storage.WebhookRepo
+	orders  storage.OrderRepo
+	client  *http.Client
+	backoff Backoff
+	// tick is how often the queue is searched for due deliveries
+	tick time.Duration
+
+	mu      sync.Mutex
+	running bool
+	// cancel aborts the deliveries that are in progress, if any
+	cancel context.CancelFunc
+	// stopping is closed once no more deliveries should be started
+	stopping chan struct{}
+	done     chan struct{}
+}
+
+func New(repo storage.WebhookRepo, orders storage.OrderRepo, client *http.Client, backoff Backoff) *Dispatcher {
+	return &Dispatcher{
+		repo:    repo,
+		orders:  orders,
+		client:  client,
+		backoff: backoff,
+		tick:    time.Second,
+	}
+}
+
+// Start starts the delivery goroutine
+func (d *Dispatcher) Start() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.running {
+		return errors.New("Webhook dispatcher is already running")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	d.stopping = make(chan struct{})
+	d.done = make(chan struct{})
+	d.running = true
+
+	go d.run(ctx, d.done)
+
+	return nil
+}
+
+// Stop stops the delivery goroutine, aborting the deliveries in progress, and
+// waits for it to exit. Aborted deliveries stay queued.
+func (d *Dispatcher) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.running {
+		return
+	}
+
+	close(d.stopping)
+	d.cancel()
+	<-d.done
+	d.running = false
+}
+
+// Shutdown stops the delivery goroutine like Stop, but lets the delivery
+// that's in progress, if any, finish first, so that its outcome is recorded.
+// If ctx is done before then, the delivery is aborted like Stop does and
+// ctx's error is returned.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.running {
+		return nil
+	}
+
+	close(d.stopping)
+
+	var err error
+	select {
+	case <-d.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	d.cancel()
+	<-d.done
+	d.running = false
+
+	return err
+}
+
+// Notify queues a delivery of the order's event to every subscription
+// interested in it. Orders that haven't reached a final status don't have
+// an event.
+//
+// An order only reaches a final status once, so its event always has the same
+// ID, even if it's queued again after the process stopped before the order
+// was acknowledged. Once queued, a delivery is sent at least once.
+func (d *Dispatcher) Notify(order *internal.Order) error {
+	eventType, ok := orderEventType(order.PaymentStatus)
+	if !ok {
+		return nil
+	}
+
+	subscriptions, err := d.repo.GetSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	event := OrderEvent{
+		Id:        uuid.NewSHA1(order.Id, []byte(eventType)).String(),
+		Type:      eventType,
+		CreatedAt: now,
+	}
+	event.Data.Order = order
+	event.Data.UserId = order.User.Id.String()
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range subscriptions {
+		if !subscription.Subscribes(eventType) {
+			continue
+		}
+
+		err := d.repo.AddDelivery(&storage.WebhookDelivery{
+			Id:             uuid.NewString(),
+			SubscriptionId: subscription.Id,
+			EventId:        event.Id,
+			EventType:      eventType,
+			Payload:        payload,
+			State:          storage.DeliveryPending,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) run(ctx context.Context, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(d.tick)
+	defer ticker.Stop()
+
+	d.queueFinalised(ctx)
+	d.deliverDue(ctx, time.Now())
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.stopping:
+			return
+		case <-ticker.C:
+			d.queueFinalised(ctx)
+			d.deliverDue(ctx, time.Now())
+		}
+	}
+}
+
+// queueFinalised queues the events of the orders that have reached a final
+// status. An order is only acknowledged once its event has been queued, so
+// if queuing fails, it's tried again on the next tick.
+func (d *Dispatcher) queueFinalised(ctx context.Context) {
+	ids, err := d.orders.GetFinalised(deliveryBatch)
+	if err != nil {
+		slog.ErrorContext(ctx, "Couldn't get finalised orders", "error", err)
+		return
+	}
+
+	for _, id := range ids {
+		order, err := d.orders.GetOrder(id)
+		if err != nil {
+			slog.ErrorContext(ctx, "Couldn't get order to queue its webhooks", "orderId", id, "error", err)
+			continue
+		}
+
+		err = d.Notify(order)
+		if err != nil {
+			slog.ErrorContext(ctx, "Couldn't queue webhooks of order", "orderId", id, "error", err)
+			continue
+		}
+
+		err = d.orders.AckFinalised(id)
+		if err != nil {
+			slog.ErrorContext(ctx, "Couldn't acknowledge finalised order", "orderId", id, "error", err)
+		}
+	}
+}
+
+// isStopping reports whether the dispatcher is being stopped
+func (d *Dispatcher) isStopping() bool {
+	select {
+	case <-d.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// deliverDue sends every delivery that's due at the given time
+func (d *Dispatcher) deliverDue(ctx context.Context, now time.Time) {
+	deliveries, err := d.repo.GetDueDeliveries(now, deliveryBatch)
+	if err != nil {
+		slog.ErrorContext(ctx, "Couldn't get due webhook deliveries", "error", err)
+		return
+	}
+
+	for _, delivery := range deliveries {
+		// Don't hold up shutdown with the remaining deliveries
+		if ctx.Err() != nil || d.isStopping() {
+			return
+		}
+
+		d.deliver(ctx, delivery, now)
+	}
+}
+
+// deliver sends a single delivery to its subscription and either marks it as
+// delivered or schedules its next attempt
+func (d *Dispatcher) deliver(ctx context.Context, delivery *storage.WebhookDelivery, now time.Time) {
+	subscription, err := d.repo.GetSubscription(delivery.SubscriptionId)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		slog.ErrorContext(ctx, "Couldn't get webhook subscription", "subscriptionId", delivery.SubscriptionId, "error", err)
+		return
+	}
+
+	if err != nil || subscription.Disabled {
+		delivery.State = storage.DeliveryDead
+		delivery.LastError = "Subscription is disabled"
+		d.update(delivery)
+		return
+	}
+
+	err = d.send(ctx, subscription, delivery)
+	if ctx.Err() != nil {
+		// The dispatcher is being stopped, this attempt doesn't count
+		return
+	}
+
+	delivery.Attempts += 1
+	delivery.LastAttemptAt = now
+
+	if err == nil {
+		delivery.State = storage.DeliveryDelivered
+		delivery.LastError = ""
+		d.update(delivery)
+		return
+	}
+
+	slog.WarnContext(ctx, "Couldn't deliver webhook", "deliveryId", delivery.Id, "subscriptionId", subscription.Id, "attempts", delivery.Attempts, "error", err)
+	delivery.LastError = err.Error()
+
+	delay, ok := d.backoff.NextDelay(delivery.Attempts)
+	if !ok {
+		slog.ErrorContext(ctx, "Gave up on webhook", "deliveryId", delivery.Id, "subscriptionId", subscription.Id, "attempts", delivery.Attempts)
+		delivery.State = storage.DeliveryDead
+	} else {
+		delivery.NextAttemptAt = now.Add(delay)
+	}
+
+	d.update(delivery)
+}
+
+// send posts the delivery's payload to the subscription's URL, signed with
+// the subscription's secret. Any response other than 2xx is an error.
+func (d *Dispatcher) send(ctx context.Context, subscription *storage.WebhookSubscription, delivery *storage.WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", subscription.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Alokin-Event-Type", delivery.EventType)
+	req.Header.Set("Alokin-Delivery-Id", delivery.Id)
+	// Sign when the delivery is actually sent, which can be long after the
+	// tick that picked it up, e.g. behind slow deliveries of the same batch
+	req.Header.Set(SignatureHeader, Sign(subscription.Secret, time.Now(), delivery.Payload))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// Read some of the body, so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("Webhook endpoint responded with %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) update(delivery *storage.WebhookDelivery) {
+	err := d.repo.UpdateDelivery(delivery)
+	if err != nil {
+		slog.Error("Couldn't update webhook delivery", "deliveryId", delivery.Id, "error", err)
+	}
+}
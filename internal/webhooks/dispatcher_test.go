@@ -0,0 +1,382 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+)
+
+var testBackoff = Backoff{Initial: time.Second, Max: 4 * time.Second, MaxAttempts: 3}
+
+func createTestOrder() *internal.Order {
+	user := internal.NewUser("federlizer@protonmail.com", "Nikola", "Velichkov", "+359888888888", internal.UserAddress{})
+	amount, _ := internal.NewMoney(1337, "USD")
+
+	return internal.NewOrder(user, amount, "Test order")
+}
+
+func addSubscription(t *testing.T, repo storage.WebhookRepo, url string, eventTypes ...string) *storage.WebhookSubscription {
+	subscription, err := NewSubscription(url, eventTypes, "")
+	if err != nil {
+		t.Fatalf("Failed to create subscription: %q\n", err)
+	}
+
+	err = repo.AddSubscription(subscription)
+	if err != nil {
+		t.Fatalf("Failed to add subscription: %q\n", err)
+	}
+
+	return subscription
+}
+
+func getDeliveries(t *testing.T, repo storage.WebhookRepo, subscriptionId string) []*storage.WebhookDelivery {
+	deliveries, err := repo.GetDeliveries(subscriptionId, "")
+	if err != nil {
+		t.Fatalf("Failed to get deliveries: %q\n", err)
+	}
+
+	return deliveries
+}
+
+func TestNotifyQueuesDeliveriesForSubscribers(t *testing.T) {
+	repo := storage.NewMemoryWebhookRepo()
+	dispatcher := New(repo, storage.NewMemoryOrderRepo(), http.DefaultClient, testBackoff)
+
+	approved := addSubscription(t, repo, "https://merchant.com/approved", EventOrderApproved)
+	failed := addSubscription(t, repo, "https://merchant.com/failed", EventOrderFailed)
+	disabled := addSubscription(t, repo, "https://merchant.com/disabled", EventOrderApproved)
+	repo.DisableSubscription(disabled.Id)
+
+	order := createTestOrder()
+
+	// Orders that haven't reached a final status don't have an event
+	err := dispatcher.Notify(order)
+	if err != nil {
+		t.Fatalf("Failed to notify: %q\n", err)
+	}
+
+	order.PaymentStatus = internal.PaymentStatusApproved
+	err = dispatcher.Notify(order)
+	if err != nil {
+		t.Fatalf("Failed to notify: %q\n", err)
+	}
+
+	deliveries := getDeliveries(t, repo, approved.Id)
+	if len(deliveries) != 1 || deliveries[0].EventType != EventOrderApproved || deliveries[0].State != storage.DeliveryPending {
+		t.Fatalf("Deliveries %+v don't contain the approved order's event", deliveries)
+	}
+
+	var event OrderEvent
+	err = json.Unmarshal(deliveries[0].Payload, &event)
+	if err != nil {
+		t.Fatalf("Failed to parse payload: %q\n", err)
+	}
+
+	if event.Id != deliveries[0].EventId || event.Type != EventOrderApproved || event.Data.Order.Id != order.Id || event.Data.UserId != order.User.Id.String() {
+		t.Errorf("Event %+v doesn't describe the order", event)
+	}
+
+	if len(getDeliveries(t, repo, failed.Id)) != 0 || len(getDeliveries(t, repo, disabled.Id)) != 0 {
+		t.Errorf("Deliveries were queued for subscriptions that aren't interested in the event")
+	}
+}
+
+func TestDeliverSignsPayload(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ = io.ReadAll(req.Body)
+		received <- req
+	}))
+	defer server.Close()
+
+	repo := storage.NewMemoryWebhookRepo()
+	dispatcher := New(repo, storage.NewMemoryOrderRepo(), server.Client(), testBackoff)
+	subscription := addSubscription(t, repo, server.URL, EventOrderApproved)
+
+	order := createTestOrder()
+	order.PaymentStatus = internal.PaymentStatusApproved
+	dispatcher.Notify(order)
+
+	now := time.Now()
+	dispatcher.deliverDue(context.Background(), now)
+
+	req := <-received
+	err := Verify(subscription.Secret, req.Header.Get(SignatureHeader), body, now, time.Minute)
+	if err != nil {
+		t.Errorf("Failed to verify signature %q: %q\n", req.Header.Get(SignatureHeader), err)
+	}
+
+	if req.Header.Get("Alokin-Event-Type") != EventOrderApproved {
+		t.Errorf("Event type %q doesn't equal expected %q", req.Header.Get("Alokin-Event-Type"), EventOrderApproved)
+	}
+
+	deliveries := getDeliveries(t, repo, subscription.Id)
+	if deliveries[0].State != storage.DeliveryDelivered || deliveries[0].Attempts != 1 || req.Header.Get("Alokin-Delivery-Id") != deliveries[0].Id {
+		t.Errorf("Delivery %+v hasn't been marked as delivered", *deliveries[0])
+	}
+}
+
+func TestDeliverSignsWhenSent(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ = io.ReadAll(req.Body)
+		received <- req
+	}))
+	defer server.Close()
+
+	repo := storage.NewMemoryWebhookRepo()
+	dispatcher := New(repo, storage.NewMemoryOrderRepo(), server.Client(), testBackoff)
+	subscription := addSubscription(t, repo, server.URL, EventOrderApproved)
+
+	order := createTestOrder()
+	order.PaymentStatus = internal.PaymentStatusApproved
+	dispatcher.Notify(order)
+
+	// The time of the tick that picks up the delivery isn't when it's sent
+	dispatcher.deliverDue(context.Background(), time.Now().Add(time.Hour))
+
+	req := <-received
+	err := Verify(subscription.Secret, req.Header.Get(SignatureHeader), body, time.Now(), time.Minute)
+	if err != nil {
+		t.Errorf("Failed to verify signature %q: %q\n", req.Header.Get(SignatureHeader), err)
+	}
+}
+
+func TestDeliverRetriesUntilDead(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	repo := storage.NewMemoryWebhookRepo()
+	dispatcher := New(repo, storage.NewMemoryOrderRepo(), server.Client(), testBackoff)
+	subscription := addSubscription(t, repo, server.URL, EventOrderFailed)
+
+	order := createTestOrder()
+	order.PaymentStatus = internal.PaymentStatusFiltered
+	dispatcher.Notify(order)
+
+	now := time.Now()
+	dispatcher.deliverDue(context.Background(), now)
+
+	delivery := getDeliveries(t, repo, subscription.Id)[0]
+	if delivery.State != storage.DeliveryPending || delivery.Attempts != 1 || !delivery.NextAttemptAt.Equal(now.Add(testBackoff.Initial)) {
+		t.Errorf("Failed delivery %+v hasn't been scheduled for a retry", *delivery)
+	}
+
+	if delivery.LastError != "Webhook endpoint responded with 500" {
+		t.Errorf("Last error %q doesn't contain the response", delivery.LastError)
+	}
+
+	// Retries aren't sent before they're due
+	dispatcher.deliverDue(context.Background(), now)
+	if getDeliveries(t, repo, subscription.Id)[0].Attempts != 1 {
+		t.Errorf("Delivery has been retried before it was due")
+	}
+
+	for i := 0; i < testBackoff.MaxAttempts; i++ {
+		now = now.Add(testBackoff.Max)
+		dispatcher.deliverDue(context.Background(), now)
+	}
+
+	delivery = getDeliveries(t, repo, subscription.Id)[0]
+	if delivery.State != storage.DeliveryDead || delivery.Attempts != testBackoff.MaxAttempts {
+		t.Errorf("Delivery %+v hasn't been given up on after %d attempts", *delivery, testBackoff.MaxAttempts)
+	}
+}
+
+func TestDeliverToDisabledSubscription(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Errorf("Delivery has been sent to a disabled subscription")
+	}))
+	defer server.Close()
+
+	repo := storage.NewMemoryWebhookRepo()
+	dispatcher := New(repo, storage.NewMemoryOrderRepo(), server.Client(), testBackoff)
+	subscription := addSubscription(t, repo, server.URL, EventOrderApproved)
+
+	order := createTestOrder()
+	order.PaymentStatus = internal.PaymentStatusApproved
+	dispatcher.Notify(order)
+	repo.DisableSubscription(subscription.Id)
+
+	dispatcher.deliverDue(context.Background(), time.Now())
+
+	delivery := getDeliveries(t, repo, subscription.Id)[0]
+	if delivery.State != storage.DeliveryDead || delivery.Attempts != 0 {
+		t.Errorf("Delivery %+v to a disabled subscription isn't dead", *delivery)
+	}
+}
+
+// failingWebhookRepo fails to queue deliveries
+type failingWebhookRepo struct {
+	*storage.MemoryWebhookRepo
+}
+
+func (r failingWebhookRepo) AddDelivery(delivery *storage.WebhookDelivery) error {
+	return errors.New("disk I/O error")
+}
+
+func TestDispatcherQueuesFinalisedOrders(t *testing.T) {
+	webhookRepo := storage.NewMemoryWebhookRepo()
+	subscription := addSubscription(t, webhookRepo, "https://merchant.com/webhooks", EventOrderApproved, EventOrderFailed)
+
+	orderRepo := storage.NewMemoryOrderRepo()
+	order := createTestOrder()
+	orderRepo.AddOrder(order)
+
+	// The order is finalised while the process that should queue its
+	// webhooks isn't running, e.g. because it crashed
+	err := orderRepo.UpdateStatus(order.Id.String(), internal.PaymentStatusCreated, internal.StatusChange{Status: internal.PaymentStatusSubmitted})
+	if err != nil {
+		t.Fatalf("Failed to update status: %q\n", err)
+	}
+
+	err = orderRepo.UpdateStatus(order.Id.String(), internal.PaymentStatusSubmitted, internal.StatusChange{Status: internal.PaymentStatusDeclined})
+	if err != nil {
+		t.Fatalf("Failed to update status: %q\n", err)
+	}
+
+	// Orders stay finalised until their webhooks could be queued
+	failing := New(failingWebhookRepo{webhookRepo}, orderRepo, http.DefaultClient, testBackoff)
+	failing.queueFinalised(context.Background())
+
+	finalised, err := orderRepo.GetFinalised(10)
+	if err != nil || len(finalised) != 1 {
+		t.Fatalf("Finalised orders %v, %v don't contain the declined order", finalised, err)
+	}
+
+	dispatcher := New(webhookRepo, orderRepo, http.DefaultClient, testBackoff)
+	dispatcher.queueFinalised(context.Background())
+	dispatcher.queueFinalised(context.Background())
+
+	deliveries := getDeliveries(t, webhookRepo, subscription.Id)
+	if len(deliveries) != 1 || deliveries[0].EventType != EventOrderFailed {
+		t.Fatalf("Deliveries %+v don't contain the declined order's event", deliveries)
+	}
+
+	finalised, err = orderRepo.GetFinalised(10)
+	if err != nil || len(finalised) != 0 {
+		t.Errorf("Finalised orders %v, %v haven't been acknowledged", finalised, err)
+	}
+
+	// Queuing the event again, e.g. after a crash before the order was
+	// acknowledged, keeps its ID, so subscribers can tell it's a duplicate
+	stored, _ := orderRepo.GetOrder(order.Id.String())
+	dispatcher.Notify(stored)
+
+	deliveries = getDeliveries(t, webhookRepo, subscription.Id)
+	if len(deliveries) != 2 || deliveries[0].EventId != deliveries[1].EventId {
+		t.Errorf("Deliveries %+v of the same event have different event IDs", deliveries)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		delay    time.Duration
+		retry    bool
+	}{
+		{1, time.Second, true},
+		{2, 2 * time.Second, true},
+		{3, 4 * time.Second, true},
+		{4, 4 * time.Second, true},
+		{5, 0, false},
+	}
+
+	backoff := Backoff{Initial: time.Second, Max: 4 * time.Second, MaxAttempts: 5}
+	for _, test := range tests {
+		delay, retry := backoff.NextDelay(test.attempts)
+		if delay != test.delay || retry != test.retry {
+			t.Errorf("Delay after %d attempts %v, %v doesn't equal expected %v, %v", test.attempts, delay, retry, test.delay, test.retry)
+		}
+	}
+}
+
+func TestDispatcherShutdown(t *testing.T) {
+	tests := []struct {
+		name string
+		// finish is whether the delivery in progress finishes before the
+		// shutdown times out
+		finish bool
+	}{
+		{"delivery finishes", true},
+		{"delivery is aborted", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			started := make(chan struct{}, 1)
+			release := make(chan struct{})
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				// Aborted requests are only noticed once the body has been read
+				io.ReadAll(req.Body)
+				started <- struct{}{}
+
+				select {
+				case <-release:
+				case <-req.Context().Done():
+				}
+			}))
+			defer server.Close()
+
+			repo := storage.NewMemoryWebhookRepo()
+			dispatcher := New(repo, storage.NewMemoryOrderRepo(), server.Client(), testBackoff)
+			subscription := addSubscription(t, repo, server.URL, EventOrderApproved)
+
+			order := createTestOrder()
+			order.PaymentStatus = internal.PaymentStatusApproved
+			dispatcher.Notify(order)
+
+			err := dispatcher.Start()
+			if err != nil {
+				t.Fatalf("Failed to start dispatcher: %q\n", err)
+			}
+
+			select {
+			case <-started:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Dispatcher didn't send the delivery")
+			}
+
+			timeout := 50 * time.Millisecond
+			if test.finish {
+				timeout = 5 * time.Second
+				time.AfterFunc(10*time.Millisecond, func() { close(release) })
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			err = dispatcher.Shutdown(ctx)
+
+			expectedState := storage.DeliveryDelivered
+			if !test.finish {
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("Error %v isn't context.DeadlineExceeded", err)
+				}
+
+				// The aborted delivery is sent again once the dispatcher is
+				// started again
+				expectedState = storage.DeliveryPending
+			} else if err != nil {
+				t.Errorf("Failed to shut down dispatcher: %q\n", err)
+			}
+
+			deliveries := getDeliveries(t, repo, subscription.Id)
+			if len(deliveries) != 1 || deliveries[0].State != expectedState {
+				t.Errorf("Delivery %+v isn't %q", *deliveries[0], expectedState)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader is the header every delivery's signature is sent in
+const SignatureHeader = "Alokin-Signature"
+
+// ErrInvalidSignature is returned by Verify for deliveries that weren't signed
+// with the subscription's secret, or whose signature has expired
+var ErrInvalidSignature = errors.New("Invalid webhook signature")
+
+// Sign returns the signature header of a delivery sent at the given time, in
+// the form "t=<unix timestamp>,v1=<hex HMAC-SHA256>". The HMAC is computed
+// with the subscription's secret over "<unix timestamp>.<payload>", so that
+// a delivery can't be replayed later with a different timestamp.
+func Sign(secret string, timestamp time.Time, payload []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+
+	return "t=" + unix + ",v1=" + signature(secret, unix, payload)
+}
+
+// Verify checks the signature header of a delivery received at now. The
+// signature has to have been made within tolerance of now.
+func Verify(secret, header string, payload []byte, now time.Time, tolerance time.Duration) error {
+	var unix, mac string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			unix = value
+		case "v1":
+			mac = value
+		}
+	}
+
+	timestamp, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	age := now.Sub(time.Unix(timestamp, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrInvalidSignature
+	}
+
+	if !hmac.Equal([]byte(mac), []byte(signature(secret, unix, payload))) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func signature(secret, unix string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,70 @@
+package webhooks
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	payload := []byte(`{"type":"order.approved"}`)
+	signedAt := time.Unix(1711800000, 0)
+	header := Sign("secret", signedAt, payload)
+
+	tests := []struct {
+		name    string
+		secret  string
+		header  string
+		payload string
+		now     time.Time
+		valid   bool
+	}{
+		{"valid signature", "secret", header, string(payload), signedAt.Add(time.Minute), true},
+		{"wrong secret", "other secret", header, string(payload), signedAt, false},
+		{"changed payload", "secret", header, `{"type":"order.failed"}`, signedAt, false},
+		{"changed timestamp", "secret", "t=1711800001" + header[len("t=1711800000"):], string(payload), signedAt, false},
+		{"expired signature", "secret", header, string(payload), signedAt.Add(10 * time.Minute), false},
+		{"missing signature", "secret", "", string(payload), signedAt, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Verify(test.secret, test.header, []byte(test.payload), test.now, 5*time.Minute)
+			if test.valid && err != nil {
+				t.Errorf("Failed to verify signature: %q\n", err)
+			}
+
+			if !test.valid && !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Verifying signature returned %v, expected %v", err, ErrInvalidSignature)
+			}
+		})
+	}
+}
+
+func TestNewSubscription(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		eventTypes []string
+		valid      bool
+	}{
+		{"valid subscription", "https://merchant.com/webhooks", []string{EventOrderApproved}, true},
+		{"relative URL", "/webhooks", []string{EventOrderApproved}, false},
+		{"unsupported scheme", "ftp://merchant.com/webhooks", []string{EventOrderApproved}, false},
+		{"no event types", "https://merchant.com/webhooks", nil, false},
+		{"unknown event type", "https://merchant.com/webhooks", []string{"order.pending"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subscription, err := NewSubscription(test.url, test.eventTypes, "")
+			if test.valid && (err != nil || len(subscription.Secret) != 64) {
+				t.Errorf("Failed to create subscription with a generated secret: %v\n", err)
+			}
+
+			if !test.valid && err == nil {
+				t.Errorf("Invalid subscription was created")
+			}
+		})
+	}
+}
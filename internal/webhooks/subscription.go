@@ -0,0 +1,57 @@
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/federlizer/alokin-zota-integration/internal/storage"
+)
+
+// NewSubscription creates a subscription of the URL to the given event types.
+// If secret is empty, a random one is generated.
+func NewSubscription(rawUrl string, eventTypes []string, secret string) (*storage.WebhookSubscription, error) {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil || (parsedUrl.Scheme != "http" && parsedUrl.Scheme != "https") || parsedUrl.Host == "" {
+		return nil, fmt.Errorf("Webhook URL %q is not an absolute http or https URL", rawUrl)
+	}
+
+	if len(eventTypes) == 0 {
+		return nil, errors.New("At least one event type is required")
+	}
+
+	subscribed := make([]string, 0, len(eventTypes))
+	for _, eventType := range eventTypes {
+		if !slices.Contains(EventTypes, eventType) {
+			return nil, fmt.Errorf("Unknown event type %q, expected one of %v", eventType, EventTypes)
+		}
+
+		if !slices.Contains(subscribed, eventType) {
+			subscribed = append(subscribed, eventType)
+		}
+	}
+
+	if secret == "" {
+		secretBytes := make([]byte, 32)
+		_, err := rand.Read(secretBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		secret = hex.EncodeToString(secretBytes)
+	}
+
+	return &storage.WebhookSubscription{
+		Id:         uuid.NewString(),
+		Url:        rawUrl,
+		Secret:     secret,
+		EventTypes: subscribed,
+		CreatedAt:  time.Now(),
+	}, nil
+}
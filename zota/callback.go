@@ -0,0 +1,73 @@
+package zota
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+)
+
+const (
+	// CallbackTypeSale is the type of callbacks sent for deposits
+	CallbackTypeSale = "SALE"
+	// CallbackTypePayout is the type of callbacks sent for payouts
+	CallbackTypePayout = "PAYOUT"
+)
+
+// ZotaCallback represents the notification that Zota sends to the merchant's
+// callbackUrl whenever an order changes its status
+type ZotaCallback struct {
+	Type                   string      `json:"type"`
+	Status                 OrderStatus `json:"status"`
+	ErrorMessage           string      `json:"errorMessage"`
+	EndpointId             string      `json:"endpointID"`
+	ProcessorTransactionId string      `json:"processorTransactionID"`
+	OrderId                string      `json:"orderID"`
+	MerchantOrderId        string      `json:"merchantOrderID"`
+	Amount                 string      `json:"amount"`
+	Currency               string      `json:"currency"`
+	CustomerEmail          string      `json:"customerEmail"`
+	CustomParam            string      `json:"customParam"`
+	Signature              string      `json:"signature"`
+	// ExtraData interface{} `json:"extraData"`
+	// OriginalRequest interface{} `json:"originalRequest"`
+}
+
+// GenSignature generates the signature that Zota attaches to the
+// callback notification and returns it.
+//
+// The signature of a callback is generated by hashing a string of
+// concatenated parameters using SHA-256 in the exact following order:
+//
+// EndpointID + orderID + merchantOrderID + status + amount + customerEmail + MerchantSecretKey
+func (zc *ZotaCallback) GenSignature(endpointId, secretKey string) string {
+	signatureStr := fmt.Sprintf(
+		"%s%s%s%s%s%s%s",
+		endpointId,
+		zc.OrderId,
+		zc.MerchantOrderId,
+		zc.Status,
+		zc.Amount,
+		zc.CustomerEmail,
+		secretKey,
+	)
+
+	signature := fmt.Sprintf("%x", sha256.Sum256([]byte(signatureStr)))
+
+	return signature
+}
+
+// PaymentStatusFor returns the payment status of the order with the expected
+// amount, see ErrAmountMismatch
+func (zc *ZotaCallback) PaymentStatusFor(expected internal.Money) (internal.PaymentStatus, error) {
+	return paymentStatusFor(zc.Status, zc.Amount, zc.Currency, expected)
+}
+
+// VerifySignature reports whether the signature sent with the callback
+// matches the one we generate with our own endpointId and secretKey
+func (zc *ZotaCallback) VerifySignature(endpointId, secretKey string) bool {
+	expected := zc.GenSignature(endpointId, secretKey)
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(zc.Signature)) == 1
+}
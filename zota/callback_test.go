@@ -0,0 +1,82 @@
+package zota
+
+import "testing"
+
+func setupZotaCallback(orderId, merchantOrderId string, status OrderStatus, amount, customerEmail string) ZotaCallback {
+	return ZotaCallback{
+		Type:            "SALE",
+		Status:          status,
+		OrderId:         orderId,
+		MerchantOrderId: merchantOrderId,
+		Amount:          amount,
+		Currency:        "USD",
+		CustomerEmail:   customerEmail,
+	}
+}
+
+type callbackGenSignatureTest struct {
+	endpointId      string
+	secretKey       string
+	orderId         string
+	merchantOrderId string
+	status          OrderStatus
+	amount          string
+	customerEmail   string
+	expected        string
+}
+
+var callbackGenSignatureTests = []callbackGenSignatureTest{
+	{
+		endpointId:      "111111",
+		secretKey:       "00000000-1111-2222-3333-444444444444",
+		orderId:         "32ab44f0a5d9d6b6c0c9e41b1a9c7ea1a2b3c4d5",
+		merchantOrderId: "e31edd0d-76a6-4f1c-be19-4504ff5b89d7",
+		status:          Approved,
+		amount:          "13.37",
+		customerEmail:   "federlizer@protonmail.com",
+		expected:        "7718389965bc98b225f228b1739fbb2c3a2bf8100f9573ff369ea4fc4ad3ed99",
+	},
+
+	{
+		endpointId:      "234567",
+		secretKey:       "00000000-1111-2222-3333-444444444444",
+		orderId:         "9697960f4561f634dd5363590e55c93586a3721e",
+		merchantOrderId: "43590438-61d1-4e7a-a31b-6df4772d9b9a",
+		status:          Declined,
+		amount:          "1300.37",
+		customerEmail:   "test@gmail.com",
+		expected:        "cebc7c02148c42ad0ac5c9264bfffbe9c7631285029d4704e9d84ffa0fc742bd",
+	},
+}
+
+func TestCallbackGenSignature(t *testing.T) {
+	for _, test := range callbackGenSignatureTests {
+		callback := setupZotaCallback(test.orderId, test.merchantOrderId, test.status, test.amount, test.customerEmail)
+		signature := callback.GenSignature(test.endpointId, test.secretKey)
+
+		if signature != test.expected {
+			t.Errorf("Output %q does not equal expected %q\n", signature, test.expected)
+		}
+	}
+}
+
+func TestCallbackVerifySignature(t *testing.T) {
+	for _, test := range callbackGenSignatureTests {
+		callback := setupZotaCallback(test.orderId, test.merchantOrderId, test.status, test.amount, test.customerEmail)
+		callback.Signature = test.expected
+
+		if !callback.VerifySignature(test.endpointId, test.secretKey) {
+			t.Errorf("Expected signature %q to be valid\n", test.expected)
+		}
+
+		if callback.VerifySignature(test.endpointId, "wrong-secret-key") {
+			t.Errorf("Expected signature %q to be invalid with a different secret key\n", test.expected)
+		}
+
+		// Tampering with the status must invalidate the signature
+		callback.Status = Approved
+		if test.status != Approved && callback.VerifySignature(test.endpointId, test.secretKey) {
+			t.Errorf("Expected signature %q to be invalid after changing the status\n", test.expected)
+		}
+	}
+}
@@ -28,20 +28,32 @@ type ZotaDepositRequest struct {
 	// CustomerState       string `json:"customerState"`
 
 	RedirectUrl string `json:"redirectUrl"`
+	CallbackUrl string `json:"callbackUrl"`
 	CheckoutUrl string `json:"checkoutUrl"`
 	Signature   string `json:"signature"`
 }
 
-// FromOrder creates a new ZotaDepositRequest struct based on the order, endpointId
-// and secretKey passed. This function automatically generates a signature for the request
-// and assigns it to the ZotaDepositRequest returned.
-func FromOrder(order *internal.Order, endpointId, secretKey string) *ZotaDepositRequest {
+// MerchantUrls holds the merchant's URLs that are sent to Zota with every
+// deposit request
+type MerchantUrls struct {
+	// RedirectUrl is where Zota redirects the customer once the payment is done
+	RedirectUrl string
+	// CallbackUrl is where Zota sends order status notifications
+	CallbackUrl string
+	// CheckoutUrl is the page the customer started the payment from
+	CheckoutUrl string
+}
+
+// FromOrder creates a new ZotaDepositRequest struct based on the order, endpointId,
+// secretKey and merchant URLs passed. This function automatically generates a signature
+// for the request and assigns it to the ZotaDepositRequest returned.
+func FromOrder(order *internal.Order, endpointId, secretKey string, urls MerchantUrls) *ZotaDepositRequest {
 	// Create request body
 	zdr := ZotaDepositRequest{
 		MerchantOrderID:   order.Id.String(),
 		MerchantOrderDesc: order.Description,
 		OrderAmount:       order.AmountStr(),
-		OrderCurrency:     "USD",
+		OrderCurrency:     order.Amount.Currency(),
 
 		CustomerEmail:     order.User.Email,
 		CustomerFirstName: order.User.FirstName,
@@ -54,8 +66,9 @@ func FromOrder(order *internal.Order, endpointId, secretKey string) *ZotaDeposit
 		CustomerCity:        order.User.Address.City,
 		CustomerZipCode:     order.User.Address.ZipCode,
 
-		RedirectUrl: "https://federlizer.com/deposit-completed",
-		CheckoutUrl: "https://federlizer.com/checkout",
+		RedirectUrl: urls.RedirectUrl,
+		CallbackUrl: urls.CallbackUrl,
+		CheckoutUrl: urls.CheckoutUrl,
 		Signature:   "",
 	}
 
@@ -93,11 +106,14 @@ func (zdr *ZotaDepositRequest) GenSignature(endpointId, secretKey string) string
 type ZotaDepositResponse struct {
 	Code string `json:"code"`
 	// Success data
-	Data *struct {
-		MerchantOrderID string `json:"merchantOrderID"`
-		DepositUrl      string `json:"depositUrl"`
-		OrderId         string `json:"orderID"`
-	} `json:"data"`
+	Data *ZotaDepositData `json:"data"`
 	// Error message
 	Message *string
 }
+
+// ZotaDepositData holds the details of an accepted deposit request
+type ZotaDepositData struct {
+	MerchantOrderID string `json:"merchantOrderID"`
+	DepositUrl      string `json:"depositUrl"`
+	OrderId         string `json:"orderID"`
+}
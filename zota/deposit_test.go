@@ -20,6 +20,7 @@ func setupZotaDepositRequest(merchantOrderId, orderAmount, customerEmail string)
 		CustomerZipCode:     "zip",
 
 		RedirectUrl: "test",
+		CallbackUrl: "test",
 		CheckoutUrl: "test",
 		Signature:   "",
 	}
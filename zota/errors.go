@@ -0,0 +1,139 @@
+package zota
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sentinel errors that an *APIError matches with errors.Is, depending on the
+// code Zota responded with
+var (
+	// ErrInvalidRequest means Zota rejected the request's data, e.g. a
+	// missing field or an amount it doesn't accept
+	ErrInvalidRequest = errors.New("Zota rejected the request as invalid")
+	// ErrUnauthorized means Zota rejected the request's signature or the
+	// merchant's credentials
+	ErrUnauthorized = errors.New("Zota rejected the request's credentials")
+	// ErrNotFound means Zota doesn't know the order the request is about
+	ErrNotFound = errors.New("Zota couldn't find the requested order")
+	// ErrRateLimited means Zota is limiting the rate of our requests
+	ErrRateLimited = errors.New("Zota is rate limiting requests")
+	// ErrServer means Zota ran into an error of its own
+	ErrServer = errors.New("Zota responded with a server error")
+
+	// ErrMalformedResponse is returned when Zota's response can't be
+	// understood, e.g. invalid JSON or an OK response without data
+	ErrMalformedResponse = errors.New("Received malformed response from Zota API")
+)
+
+// APIError is returned when Zota responds to a request with a non-2xx HTTP
+// status or a code other than "200" in the response body
+type APIError struct {
+	HTTPStatus int
+	// Code is the code in the response body, empty if the body isn't JSON
+	Code    string
+	Message string
+	// RetryAfter is the delay Zota asked for in the Retry-After header, or
+	// zero if the header wasn't sent
+	RetryAfter time.Duration
+	// Body is the raw response body
+	Body []byte
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Received non-OK response from Zota API (HTTP %d, code %q) with no error message", e.HTTPStatus, e.Code)
+	}
+
+	return fmt.Sprintf("Received non-OK response from Zota API (HTTP %d, code %q): %s", e.HTTPStatus, e.Code, e.Message)
+}
+
+// Is makes errors.Is match the error with the sentinel error for its status
+func (e *APIError) Is(target error) bool {
+	status := e.status()
+
+	switch target {
+	case ErrInvalidRequest:
+		return status >= 400 && status < 500 && status != http.StatusUnauthorized &&
+			status != http.StatusForbidden && status != http.StatusNotFound && status != http.StatusTooManyRequests
+	case ErrUnauthorized:
+		return status == http.StatusUnauthorized || status == http.StatusForbidden
+	case ErrNotFound:
+		return status == http.StatusNotFound
+	case ErrRateLimited:
+		return status == http.StatusTooManyRequests
+	case ErrServer:
+		return status >= 500
+	default:
+		return false
+	}
+}
+
+// Temporary reports whether the request might succeed if it's retried later,
+// i.e. Zota is rate limiting us or has run into a server error
+func (e *APIError) Temporary() bool {
+	return errors.Is(e, ErrRateLimited) || errors.Is(e, ErrServer)
+}
+
+// status returns the code in the response body, which is more specific than
+// the HTTP status (Zota can respond with HTTP 200 and an error code), or the
+// HTTP status if there is no numeric code
+func (e *APIError) status() int {
+	code, err := strconv.Atoi(e.Code)
+	if err == nil && code != http.StatusOK {
+		return code
+	}
+
+	return e.HTTPStatus
+}
+
+// zotaResponse holds the fields every response from Zota has
+type zotaResponse struct {
+	Code    string  `json:"code"`
+	Message *string `json:"message"`
+}
+
+// newAPIError creates the error for a response that isn't OK
+func newAPIError(httpResponse *http.Response, body []byte, response zotaResponse) *APIError {
+	apiErr := &APIError{
+		HTTPStatus: httpResponse.StatusCode,
+		Code:       response.Code,
+		RetryAfter: parseRetryAfter(httpResponse.Header.Get("Retry-After"), time.Now()),
+		Body:       body,
+	}
+
+	if response.Message != nil {
+		apiErr.Message = strings.TrimSpace(*response.Message)
+	}
+
+	return apiErr
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a
+// number of seconds or an HTTP date. Zero is returned for missing or invalid
+// values.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err == nil {
+		if seconds < 0 {
+			return 0
+		}
+
+		return time.Duration(seconds) * time.Second
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil || !date.After(now) {
+		return 0
+	}
+
+	return date.Sub(now)
+}
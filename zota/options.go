@@ -0,0 +1,65 @@
+package zota
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/internal/metrics"
+)
+
+const (
+	// DefaultTimeout is the time a single request to Zota can take,
+	// unless configured otherwise with WithTimeout
+	DefaultTimeout = 30 * time.Second
+	// DefaultUserAgent is sent with every request to Zota, unless
+	// configured otherwise with WithUserAgent
+	DefaultUserAgent = "alokin-zota-integration"
+)
+
+// Option configures a ZotaAPI created by NewZotaAPI
+type Option func(api *ZotaAPI)
+
+// WithHTTPClient makes the ZotaAPI send its requests with the given client
+// instead of a new http.Client. Useful for custom transports, proxies and tests.
+// A nil client is the same as http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(api *ZotaAPI) {
+		api.httpClient = client
+	}
+}
+
+// WithTimeout limits the time a single request to Zota can take, including
+// reading the response body. A timeout of 0 disables the limit, leaving only
+// the cancellation of the request's context.
+func WithTimeout(timeout time.Duration) Option {
+	return func(api *ZotaAPI) {
+		api.timeout = timeout
+	}
+}
+
+// WithEndpoints sets the endpoint ID used for deposits in each currency,
+// e.g. {"USD": "111111", "EUR": "222222"}. Deposits in currencies without an
+// endpoint are rejected, so without this option no deposits can be made.
+func WithEndpoints(endpoints map[string]string) Option {
+	return func(api *ZotaAPI) {
+		api.endpoints = make(map[string]string, len(endpoints))
+		for currency, endpointId := range endpoints {
+			api.endpoints[currency] = endpointId
+		}
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request to Zota
+func WithUserAgent(userAgent string) Option {
+	return func(api *ZotaAPI) {
+		api.userAgent = userAgent
+	}
+}
+
+// WithMetrics records the endpoint, response code and duration of every
+// request to Zota in the metrics
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(api *ZotaAPI) {
+		api.metrics = m
+	}
+}
@@ -2,7 +2,10 @@ package zota
 
 import (
 	"crypto/sha256"
+	"errors"
 	"fmt"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
 )
 
 type OrderStatus string
@@ -34,6 +37,63 @@ var FinalStatuses = []OrderStatus{
 	Error,
 }
 
+// IsFinal reports whether the status is one of the FinalStatuses
+func (s OrderStatus) IsFinal() bool {
+	for _, finalStatus := range FinalStatuses {
+		if s == finalStatus {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PaymentStatus maps a Zota order status to the merchant's internal
+// payment status. UNKNOWN, and any status Zota might add, doesn't tell
+// anything about the order and is mapped to an empty status.
+func (s OrderStatus) PaymentStatus() internal.PaymentStatus {
+	switch s {
+	case Created:
+		return internal.PaymentStatusSubmitted
+	case Processing:
+		return internal.PaymentStatusProcessing
+	case Pending:
+		return internal.PaymentStatusPending
+	case Approved:
+		return internal.PaymentStatusApproved
+	case Declined:
+		return internal.PaymentStatusDeclined
+	case Filtered:
+		return internal.PaymentStatusFiltered
+	case Error:
+		return internal.PaymentStatusError
+	default:
+		return ""
+	}
+}
+
+// ErrAmountMismatch is returned when Zota approves an order with a different
+// amount or currency than the order was made with
+var ErrAmountMismatch = errors.New("Zota approved a different amount than ordered")
+
+// paymentStatusFor maps the status Zota reports for an order to a payment
+// status. An approval is only accepted if Zota reports exactly the expected
+// amount, otherwise the order ends in an error and ErrAmountMismatch is
+// returned.
+func paymentStatusFor(status OrderStatus, amount, currency string, expected internal.Money) (internal.PaymentStatus, error) {
+	paymentStatus := status.PaymentStatus()
+	if paymentStatus != internal.PaymentStatusApproved {
+		return paymentStatus, nil
+	}
+
+	reported, err := internal.ParseMoney(amount, currency)
+	if err != nil || !reported.Equal(expected) {
+		return internal.PaymentStatusError, fmt.Errorf("%w: expected %v %v, got %q %q", ErrAmountMismatch, expected, expected.Currency(), amount, currency)
+	}
+
+	return paymentStatus, nil
+}
+
 type ZotaOrderStatusRequest struct {
 	OrderId         string `json:"orderID"`
 	MerchantOrderId string `json:"merchantOrderID"`
@@ -75,38 +135,39 @@ func (zosb *ZotaOrderStatusRequest) GenSignature(merchantId, secretKey string) s
 }
 
 type ZotaOrderStatusResponse struct {
-	Code    string  `json:"code"`
-	Message *string `json:"message"`
-	Data    *struct {
-		Type                   string      `json:"type"`
-		Status                 OrderStatus `json:"status"`
-		ErrorMessage           string      `json:"errorMessage"`
-		ProcessorTransactionId string      `json:"processorTransactionID"`
-		OrderId                string      `json:"orderID"`
-		MerchantOrderId        string      `json:"merchantOrderID"`
-		Amount                 string      `json:"amount"`
-		Currency               string      `json:"currency"`
-		CustomerEmail          string      `json:"customerEmail"`
-		// CustomParam interface{} `json:"customParam"`
-		// ExtraData interface{} `json:"extraData"`
-		// Request interface{} `json:"request"`
-	} `json:"data"`
+	Code    string               `json:"code"`
+	Message *string              `json:"message"`
+	Data    *ZotaOrderStatusData `json:"data"`
 }
 
-func (zosr *ZotaOrderStatusResponse) IsInFinalStatus() bool {
-	var inFinalStatus = false
+// ZotaOrderStatusData holds the order details of a successful
+// Order Status response
+type ZotaOrderStatusData struct {
+	Type                   string      `json:"type"`
+	Status                 OrderStatus `json:"status"`
+	ErrorMessage           string      `json:"errorMessage"`
+	ProcessorTransactionId string      `json:"processorTransactionID"`
+	OrderId                string      `json:"orderID"`
+	MerchantOrderId        string      `json:"merchantOrderID"`
+	Amount                 string      `json:"amount"`
+	Currency               string      `json:"currency"`
+	CustomerEmail          string      `json:"customerEmail"`
+	// CustomParam interface{} `json:"customParam"`
+	// ExtraData interface{} `json:"extraData"`
+	// Request interface{} `json:"request"`
+}
 
+// PaymentStatusFor returns the payment status of the order with the expected
+// amount, see ErrAmountMismatch
+func (zosd *ZotaOrderStatusData) PaymentStatusFor(expected internal.Money) (internal.PaymentStatus, error) {
+	return paymentStatusFor(zosd.Status, zosd.Amount, zosd.Currency, expected)
+}
+
+func (zosr *ZotaOrderStatusResponse) IsInFinalStatus() bool {
 	// We're not good if we don't have data...
 	if zosr.Data == nil {
-		return inFinalStatus
-	}
-
-	for _, finalStatus := range FinalStatuses {
-		if zosr.Data.Status == finalStatus {
-			inFinalStatus = true
-			break
-		}
+		return false
 	}
 
-	return inFinalStatus
+	return zosr.Data.Status.IsFinal()
 }
@@ -1,6 +1,11 @@
 package zota
 
-import "testing"
+import (
+	"errors"
+	"testing"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+)
 
 func setupZotaOrderStatusRequest(orderId, merchantOrderId string, timestamp int64) ZotaOrderStatusRequest {
 	return ZotaOrderStatusRequest{
@@ -50,3 +55,66 @@ func TestGenSignature(t *testing.T) {
 		}
 	}
 }
+
+func TestPaymentStatusForChecksAmount(t *testing.T) {
+	expected, _ := internal.NewMoney(1337, "USD")
+
+	tests := []struct {
+		status        OrderStatus
+		amount        string
+		currency      string
+		expected      internal.PaymentStatus
+		expectedError bool
+	}{
+		{Approved, "13.37", "USD", internal.PaymentStatusApproved, false},
+		{Approved, "13.3700", "USD", internal.PaymentStatusApproved, false},
+		{Approved, "13.38", "USD", internal.PaymentStatusError, true},
+		{Approved, "13.37", "EUR", internal.PaymentStatusError, true},
+		{Approved, "", "", internal.PaymentStatusError, true},
+		// Declined orders don't need to match
+		{Declined, "0", "USD", internal.PaymentStatusDeclined, false},
+		{Processing, "", "", internal.PaymentStatusProcessing, false},
+	}
+
+	for _, test := range tests {
+		data := ZotaOrderStatusData{Status: test.status, Amount: test.amount, Currency: test.currency}
+
+		output, err := data.PaymentStatusFor(expected)
+		if output != test.expected {
+			t.Errorf("Output %q does not equal expected %q\n", output, test.expected)
+		}
+
+		if errors.Is(err, ErrAmountMismatch) != test.expectedError {
+			t.Errorf("Error %v doesn't match expected %v for %q %q", err, test.expectedError, test.amount, test.currency)
+		}
+	}
+}
+
+func TestPaymentStatus(t *testing.T) {
+	tests := []struct {
+		status   OrderStatus
+		expected internal.PaymentStatus
+	}{
+		{Created, internal.PaymentStatusSubmitted},
+		{Processing, internal.PaymentStatusProcessing},
+		{Pending, internal.PaymentStatusPending},
+		{Approved, internal.PaymentStatusApproved},
+		{Declined, internal.PaymentStatusDeclined},
+		{Filtered, internal.PaymentStatusFiltered},
+		{Error, internal.PaymentStatusError},
+		// Unknown statuses don't tell anything about the order
+		{Unknown, ""},
+		{"REFUNDED", ""},
+	}
+
+	for _, test := range tests {
+		output := test.status.PaymentStatus()
+		if output != test.expected {
+			t.Errorf("Output %q does not equal expected %q\n", output, test.expected)
+		}
+
+		if output.IsFinal() != test.status.IsFinal() {
+			t.Errorf("Zota status %v and payment status %v don't agree on being final", test.status, output)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package zota
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+)
+
+// ZotaPayoutRequest represents the request body that's required by Zota's
+// payout request
+type ZotaPayoutRequest struct {
+	MerchantOrderID   string `json:"merchantOrderID"`
+	MerchantOrderDesc string `json:"merchantOrderDesc"`
+	OrderAmount       string `json:"orderAmount"`
+	OrderCurrency     string `json:"orderCurrency"`
+
+	CustomerEmail       string `json:"customerEmail"`
+	CustomerFirstName   string `json:"customerFirstName"`
+	CustomerLastName    string `json:"customerLastName"`
+	CustomerPhone       string `json:"customerPhone"`
+	CustomerIP          string `json:"customerIP"`
+	CustomerCountryCode string `json:"customerCountryCode"`
+
+	CustomerBankCode          string `json:"customerBankCode"`
+	CustomerBankAccountNumber string `json:"customerBankAccountNumber"`
+	CustomerBankAccountName   string `json:"customerBankAccountName"`
+	CustomerBankBranch        string `json:"customerBankBranch"`
+	CustomerBankCountry       string `json:"customerBankCountry"`
+
+	CallbackUrl string `json:"callbackUrl"`
+	Signature   string `json:"signature"`
+}
+
+// FromPayout creates a new ZotaPayoutRequest struct based on the payout, endpointId,
+// secretKey and merchant URLs passed. This function automatically generates a signature
+// for the request and assigns it to the ZotaPayoutRequest returned.
+func FromPayout(payout *internal.Payout, endpointId, secretKey string, urls MerchantUrls) *ZotaPayoutRequest {
+	// Create request body
+	zpr := ZotaPayoutRequest{
+		MerchantOrderID:   payout.Id.String(),
+		MerchantOrderDesc: payout.Description,
+		OrderAmount:       payout.AmountStr(),
+		OrderCurrency:     payout.Amount.Currency(),
+
+		CustomerEmail:       payout.User.Email,
+		CustomerFirstName:   payout.User.FirstName,
+		CustomerLastName:    payout.User.LastName,
+		CustomerPhone:       payout.User.Phone,
+		CustomerIP:          payout.User.IpAddr,
+		CustomerCountryCode: payout.User.Address.CountryCode,
+
+		CustomerBankCode:          payout.BankAccount.BankCode,
+		CustomerBankAccountNumber: payout.BankAccount.AccountNumber,
+		CustomerBankAccountName:   payout.BankAccount.AccountName,
+		CustomerBankBranch:        payout.BankAccount.BranchName,
+		CustomerBankCountry:       payout.BankAccount.CountryCode,
+
+		CallbackUrl: urls.CallbackUrl,
+		Signature:   "",
+	}
+
+	// Generate request signature
+	signature := zpr.GenSignature(endpointId, secretKey)
+	zpr.Signature = signature
+
+	return &zpr
+}
+
+// GenSignature generates the signature required for the
+// Zota Payout request and returns it
+//
+// Every request must be signed by the merchant in order to be
+// successfully authenticated by Zotapay servers.
+//
+// EndpointID + merchantOrderID + orderAmount + customerEmail + customerBankAccountNumber + MerchantSecretKey
+func (zpr *ZotaPayoutRequest) GenSignature(endpointId, secretKey string) string {
+	signatureStr := fmt.Sprintf(
+		"%s%s%s%s%s%s",
+		endpointId,
+		zpr.MerchantOrderID,
+		zpr.OrderAmount,
+		zpr.CustomerEmail,
+		zpr.CustomerBankAccountNumber,
+		secretKey,
+	)
+
+	signature := fmt.Sprintf("%x", sha256.Sum256([]byte(signatureStr)))
+
+	return signature
+}
+
+// ZotaPayoutResponse represents the response that's received by Zota's
+// payout request
+type ZotaPayoutResponse struct {
+	Code string `json:"code"`
+	// Success data
+	Data *ZotaPayoutData `json:"data"`
+	// Error message
+	Message *string
+}
+
+// ZotaPayoutData holds the details of an accepted payout request
+type ZotaPayoutData struct {
+	MerchantOrderID string `json:"merchantOrderID"`
+	OrderId         string `json:"orderID"`
+}
@@ -0,0 +1,52 @@
+package zota
+
+import "testing"
+
+type payoutGenSignatureTest struct {
+	endpointId        string
+	merchantOrderId   string
+	orderAmount       string
+	customerEmail     string
+	bankAccountNumber string
+	merchantSecretKey string
+	expected          string
+}
+
+var payoutGenSignatureTests = []payoutGenSignatureTest{
+	{
+		endpointId:        "111111",
+		merchantOrderId:   "e31edd0d-76a6-4f1c-be19-4504ff5b89d7",
+		orderAmount:       "13.37",
+		customerEmail:     "federlizer@protonmail.com",
+		bankAccountNumber: "DK5000400440116243",
+		merchantSecretKey: "00000000-1111-2222-3333-444444444444",
+		expected:          "f6d356f690fbd22068697b11b63756b668a0ab6dfd7a3f66b996d5fc45b03521",
+	},
+
+	{
+		endpointId:        "234567",
+		merchantOrderId:   "43590438-61d1-4e7a-a31b-6df4772d9b9a",
+		orderAmount:       "1300.37",
+		customerEmail:     "test@gmail.com",
+		bankAccountNumber: "12345678",
+		merchantSecretKey: "00000000-1111-2222-3333-444444444444",
+		expected:          "28bb0a7d244cc302611971ba9f973268f97f328c2b4c054d0b78e946fe960c65",
+	},
+}
+
+func TestPayoutGenSignature(t *testing.T) {
+	for _, test := range payoutGenSignatureTests {
+		request := ZotaPayoutRequest{
+			MerchantOrderID:           test.merchantOrderId,
+			MerchantOrderDesc:         "Test payout",
+			OrderAmount:               test.orderAmount,
+			CustomerEmail:             test.customerEmail,
+			CustomerBankAccountNumber: test.bankAccountNumber,
+		}
+		signature := request.GenSignature(test.endpointId, test.merchantSecretKey)
+
+		if signature != test.expected {
+			t.Errorf("Output %q does not equal expected %q\n", signature, test.expected)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package zota
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+)
+
+// ZotaRedirect represents the query parameters that Zota appends to the
+// merchant's redirectUrl when sending the customer back after a payment
+type ZotaRedirect struct {
+	Status          OrderStatus `form:"status" binding:"required"`
+	ErrorMessage    string      `form:"errorMessage"`
+	OrderId         string      `form:"orderID" binding:"required"`
+	MerchantOrderId string      `form:"merchantOrderID" binding:"required"`
+	Signature       string      `form:"signature" binding:"required"`
+}
+
+// GenSignature generates the signature that Zota attaches to the
+// redirect and returns it.
+//
+// The signature of a redirect is generated by hashing a string of
+// concatenated parameters using SHA-256 in the exact following order:
+//
+// status + orderID + merchantOrderID + MerchantSecretKey
+func (zr *ZotaRedirect) GenSignature(secretKey string) string {
+	signatureStr := fmt.Sprintf(
+		"%s%s%s%s",
+		zr.Status,
+		zr.OrderId,
+		zr.MerchantOrderId,
+		secretKey,
+	)
+
+	signature := fmt.Sprintf("%x", sha256.Sum256([]byte(signatureStr)))
+
+	return signature
+}
+
+// VerifySignature reports whether the signature sent with the redirect
+// matches the one we generate with our own secretKey
+func (zr *ZotaRedirect) VerifySignature(secretKey string) bool {
+	expected := zr.GenSignature(secretKey)
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(zr.Signature)) == 1
+}
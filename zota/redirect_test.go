@@ -0,0 +1,53 @@
+package zota
+
+import "testing"
+
+type redirectGenSignatureTest struct {
+	secretKey       string
+	status          OrderStatus
+	orderId         string
+	merchantOrderId string
+	expected        string
+}
+
+var redirectGenSignatureTests = []redirectGenSignatureTest{
+	{
+		secretKey:       "00000000-1111-2222-3333-444444444444",
+		status:          Approved,
+		orderId:         "32ab44f0a5d9d6b6c0c9e41b1a9c7ea1a2b3c4d5",
+		merchantOrderId: "e31edd0d-76a6-4f1c-be19-4504ff5b89d7",
+		expected:        "0c44f44b72b0cc75993590f2e2c63ca176199870a0e70ecfcd517bd8274bcc91",
+	},
+
+	{
+		secretKey:       "00000000-1111-2222-3333-444444444444",
+		status:          Declined,
+		orderId:         "9697960f4561f634dd5363590e55c93586a3721e",
+		merchantOrderId: "43590438-61d1-4e7a-a31b-6df4772d9b9a",
+		expected:        "7fdb2efda548593d4d6a62ffa7f4feb8a73f61b0757d3d152bee81150beb77ee",
+	},
+}
+
+func TestRedirectGenSignature(t *testing.T) {
+	for _, test := range redirectGenSignatureTests {
+		redirect := ZotaRedirect{
+			Status:          test.status,
+			OrderId:         test.orderId,
+			MerchantOrderId: test.merchantOrderId,
+		}
+		signature := redirect.GenSignature(test.secretKey)
+
+		if signature != test.expected {
+			t.Errorf("Output %q does not equal expected %q\n", signature, test.expected)
+		}
+
+		redirect.Signature = signature
+		if !redirect.VerifySignature(test.secretKey) {
+			t.Errorf("Expected signature %q to be valid\n", signature)
+		}
+
+		if redirect.VerifySignature("wrong-secret-key") {
+			t.Errorf("Expected signature %q to be invalid with a different secret key\n", signature)
+		}
+	}
+}
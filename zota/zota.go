@@ -2,25 +2,51 @@ package zota
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/metrics"
+	"github.com/federlizer/alokin-zota-integration/internal/tracing"
 )
 
+// IZotaAPI is implemented by clients of Zota's API.
+//
+// Every request has a variant that accepts a context.Context. Cancelling the
+// context aborts the request. The variants without a context use
+// context.Background().
 type IZotaAPI interface {
 	SecretKey() string
+	// EndpointId is the endpoint payouts are made with
 	EndpointId() string
+	// EndpointIdFor returns the endpoint deposits in the currency are made
+	// with. Zota issues a separate endpoint ID per currency.
+	EndpointIdFor(currency string) (string, error)
+	// Currencies returns the currencies there are deposit endpoints for
+	Currencies() []string
 	MerchantId() string
 	BaseUrl() string
 
 	Deposit(request *ZotaDepositRequest) (*ZotaDepositResponse, error)
+	DepositContext(ctx context.Context, request *ZotaDepositRequest) (*ZotaDepositResponse, error)
+	Payout(request *ZotaPayoutRequest) (*ZotaPayoutResponse, error)
+	PayoutContext(ctx context.Context, request *ZotaPayoutRequest) (*ZotaPayoutResponse, error)
 	OrderStatus(request *ZotaOrderStatusRequest) (*ZotaOrderStatusResponse, error)
-	PollOrderStatus(request *ZotaOrderStatusRequest, order *internal.Order) (*ZotaOrderStatusResponse, error)
+	OrderStatusContext(ctx context.Context, request *ZotaOrderStatusRequest) (*ZotaOrderStatusResponse, error)
 }
 
 type ZotaAPI struct {
@@ -28,15 +54,65 @@ type ZotaAPI struct {
 	endpointId string
 	merchantId string
 	baseUrl    string
+	// endpoints maps currencies to the endpoint IDs used for their deposits
+	endpoints map[string]string
+
+	httpClient *http.Client
+	timeout    time.Duration
+	userAgent  string
+	// metrics records every request, nil if they aren't recorded
+	metrics *metrics.Metrics
 }
 
-func NewZotaAPI(secretKey, endpointId, merchantId, baseUrl string) *ZotaAPI {
-	return &ZotaAPI{
-		secretKey,
-		endpointId,
-		merchantId,
-		baseUrl,
+// NewZotaAPI creates a new client for Zota's API. The secretKey and
+// merchantId are required and the baseUrl must be an absolute http or https
+// URL, otherwise an error is returned. The endpointId is used for payouts,
+// the endpoints of deposits are set with WithEndpoints.
+func NewZotaAPI(secretKey, endpointId, merchantId, baseUrl string, options ...Option) (*ZotaAPI, error) {
+	if secretKey == "" || merchantId == "" {
+		return nil, errors.New("Zota secret key and merchant ID are required")
+	}
+
+	parsedUrl, err := url.Parse(baseUrl)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Zota base URL: %w", err)
+	}
+
+	if (parsedUrl.Scheme != "http" && parsedUrl.Scheme != "https") || parsedUrl.Host == "" {
+		return nil, fmt.Errorf("Invalid Zota base URL %q: must be an absolute http or https URL", baseUrl)
+	}
+
+	if parsedUrl.RawQuery != "" || parsedUrl.Fragment != "" {
+		return nil, fmt.Errorf("Invalid Zota base URL %q: must not have a query or fragment", baseUrl)
+	}
+
+	api := &ZotaAPI{
+		secretKey:  secretKey,
+		endpointId: endpointId,
+		merchantId: merchantId,
+		// Endpoint paths always start with a slash
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+
+		httpClient: &http.Client{},
+		timeout:    DefaultTimeout,
+		userAgent:  DefaultUserAgent,
+	}
+
+	for _, option := range options {
+		option(api)
+	}
+
+	// Every request, including those sent with a client passed to
+	// WithHTTPClient, is traced as a client span that Zota receives in the
+	// traceparent header
+	if api.httpClient == nil {
+		api.httpClient = http.DefaultClient
 	}
+	tracedClient := *api.httpClient
+	tracedClient.Transport = otelhttp.NewTransport(tracedClient.Transport)
+	api.httpClient = &tracedClient
+
+	return api, nil
 }
 
 func (api *ZotaAPI) SecretKey() string {
@@ -47,6 +123,30 @@ func (api *ZotaAPI) EndpointId() string {
 	return api.endpointId
 }
 
+// EndpointIdFor returns the endpoint ID configured for the currency with
+// WithEndpoints. Without one, deposits in the currency can't be made and an
+// error is returned.
+func (api *ZotaAPI) EndpointIdFor(currency string) (string, error) {
+	endpointId, ok := api.endpoints[currency]
+	if !ok || endpointId == "" {
+		return "", fmt.Errorf("%w %q: no Zota endpoint configured", internal.ErrUnsupportedCurrency, currency)
+	}
+
+	return endpointId, nil
+}
+
+func (api *ZotaAPI) Currencies() []string {
+	currencies := make([]string, 0, len(api.endpoints))
+	for currency, endpointId := range api.endpoints {
+		if endpointId != "" {
+			currencies = append(currencies, currency)
+		}
+	}
+	sort.Strings(currencies)
+
+	return currencies
+}
+
 func (api *ZotaAPI) MerchantId() string {
 	return api.merchantId
 }
@@ -55,139 +155,220 @@ func (api *ZotaAPI) BaseUrl() string {
 	return api.baseUrl
 }
 
-func (api *ZotaAPI) Deposit(request *ZotaDepositRequest) (*ZotaDepositResponse, error) {
-	endpointUrl := fmt.Sprintf("/api/v1/deposit/request/%s/", api.EndpointId())
-	url := fmt.Sprintf("%s%s", api.BaseUrl(), endpointUrl)
+// Ping checks that Zota's API can be reached. Any response counts, since
+// the base URL itself isn't an endpoint of the API.
+func (api *ZotaAPI) Ping(ctx context.Context) error {
+	if api.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, api.timeout)
+		defer cancel()
+	}
 
-	jsonBody, err := json.Marshal(request)
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodHead, api.baseUrl+"/", nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	httpRequest.Header.Set("User-Agent", api.userAgent)
 
-	response, err := http.Post(url, "application/json", bytes.NewReader(jsonBody))
+	httpResponse, err := api.httpClient.Do(httpRequest)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	httpResponse.Body.Close()
 
-	defer response.Body.Close()
+	return nil
+}
+
+func (api *ZotaAPI) Deposit(request *ZotaDepositRequest) (*ZotaDepositResponse, error) {
+	return api.DepositContext(context.Background(), request)
+}
 
-	responseBody, err := io.ReadAll(response.Body)
+// DepositContext sends the deposit request to the endpoint of the request's
+// currency, which the request must have been signed with
+func (api *ZotaAPI) DepositContext(ctx context.Context, request *ZotaDepositRequest) (*ZotaDepositResponse, error) {
+	endpointId, err := api.EndpointIdFor(request.OrderCurrency)
 	if err != nil {
 		return nil, err
 	}
 
+	endpointUrl := fmt.Sprintf("/api/v1/deposit/request/%s/", endpointId)
+	url := fmt.Sprintf("%s%s", api.BaseUrl(), endpointUrl)
+
 	zotaDepositResponse := ZotaDepositResponse{}
-	err = json.Unmarshal(responseBody, &zotaDepositResponse)
+	err = api.doJSON(ctx, endpointDeposit, http.MethodPost, url, request, &zotaDepositResponse)
 	if err != nil {
 		return nil, err
 	}
 
-	// Handle failed deposits
-	if zotaDepositResponse.Code != "200" {
-		errorMsg := ""
-		// The zota API has returned an error to us, report that same error up the chain
-		if zotaDepositResponse.Message == nil {
-			errorMsg = "Received non-OK response from Zota API with no error message"
-		} else {
-			errorMsg = fmt.Sprintf("Received non-OK response from Zota API: %s", *zotaDepositResponse.Message)
-		}
-
-		return nil, errors.New(errorMsg)
+	if zotaDepositResponse.Data == nil {
+		return nil, fmt.Errorf("%w: OK response with no data", ErrMalformedResponse)
 	}
 
 	return &zotaDepositResponse, nil
 }
 
+func (api *ZotaAPI) Payout(request *ZotaPayoutRequest) (*ZotaPayoutResponse, error) {
+	return api.PayoutContext(context.Background(), request)
+}
+
+func (api *ZotaAPI) PayoutContext(ctx context.Context, request *ZotaPayoutRequest) (*ZotaPayoutResponse, error) {
+	endpointUrl := fmt.Sprintf("/api/v1/payout/request/%s/", api.EndpointId())
+	url := fmt.Sprintf("%s%s", api.BaseUrl(), endpointUrl)
+
+	zotaPayoutResponse := ZotaPayoutResponse{}
+	err := api.doJSON(ctx, endpointPayout, http.MethodPost, url, request, &zotaPayoutResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	if zotaPayoutResponse.Data == nil {
+		return nil, fmt.Errorf("%w: OK response with no data", ErrMalformedResponse)
+	}
+
+	return &zotaPayoutResponse, nil
+}
+
 func (api *ZotaAPI) OrderStatus(request *ZotaOrderStatusRequest) (*ZotaOrderStatusResponse, error) {
+	return api.OrderStatusContext(context.Background(), request)
+}
+
+func (api *ZotaAPI) OrderStatusContext(ctx context.Context, request *ZotaOrderStatusRequest) (*ZotaOrderStatusResponse, error) {
 	// First ensure the timestamp and signautre are correct
 	ts := time.Now().Unix()
 	request.Timestamp = ts
 	request.Signature = request.GenSignature(api.MerchantId(), api.SecretKey())
 
 	endpointUrl := "/api/v1/query/order-status/"
-	params := fmt.Sprintf(
-		"?merchantID=%s&orderID=%s&merchantOrderID=%s&timestamp=%d&signature=%s",
-		api.MerchantId(),
-		request.OrderId,
-		request.MerchantOrderId,
-		request.Timestamp,
-		request.Signature,
-	)
-	url := fmt.Sprintf("%s%s%s", api.BaseUrl(), endpointUrl, params)
-
-	response, err := http.Get(url)
+	params := url.Values{}
+	params.Set("merchantID", api.MerchantId())
+	params.Set("orderID", request.OrderId)
+	params.Set("merchantOrderID", request.MerchantOrderId)
+	params.Set("timestamp", strconv.FormatInt(request.Timestamp, 10))
+	params.Set("signature", request.Signature)
+	url := fmt.Sprintf("%s%s?%s", api.BaseUrl(), endpointUrl, params.Encode())
+
+	zotaOrderStatusResponse := ZotaOrderStatusResponse{}
+	err := api.doJSON(ctx, endpointOrderStatus, http.MethodGet, url, nil, &zotaOrderStatusResponse)
 	if err != nil {
 		return nil, err
 	}
 
-	defer response.Body.Close()
+	if zotaOrderStatusResponse.Data == nil {
+		return nil, fmt.Errorf("%w: OK response with no data", ErrMalformedResponse)
+	}
 
-	responseBody, err := io.ReadAll(response.Body)
-	if err != nil {
-		return nil, err
+	return &zotaOrderStatusResponse, nil
+}
+
+// The endpoints requests are recorded in the metrics with
+const (
+	endpointDeposit     = "deposit"
+	endpointPayout      = "payout"
+	endpointOrderStatus = "order_status"
+)
+
+// doJSON sends a request with the body, if any, as JSON to the url and
+// unmarshals the JSON response into the response passed. Responses with a
+// non-2xx HTTP status or a code other than "200" are returned as an
+// *APIError. The request is aborted once ctx is cancelled or the configured
+// timeout has passed.
+func (api *ZotaAPI) doJSON(ctx context.Context, endpoint, method, requestUrl string, body interface{}, response interface{}) (err error) {
+	if api.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, api.timeout)
+		defer cancel()
 	}
 
-	zotaOrderStatusResponse := ZotaOrderStatusResponse{}
-	err = json.Unmarshal(responseBody, &zotaOrderStatusResponse)
+	// The HTTP request's span is a child of this one, which records Zota's
+	// response code
+	ctx, span := tracing.Start(ctx, "Zota "+endpoint, trace.WithAttributes(attribute.String("zota.endpoint", endpoint)))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	var bodyReader io.Reader
+	if body != nil {
+		jsonBody, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		bodyReader = bytes.NewReader(jsonBody)
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, method, requestUrl, bodyReader)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &zotaOrderStatusResponse, nil
-}
+	if body != nil {
+		httpRequest.Header.Set("Content-Type", "application/json")
+	}
+	httpRequest.Header.Set("Accept", "application/json")
+	httpRequest.Header.Set("User-Agent", api.userAgent)
+
+	// The query of order status requests holds their signature, so only the
+	// path is ever logged or returned in errors
+	logger := slog.With("method", method, "path", httpRequest.URL.Path)
+	start := time.Now()
 
-// PollOrderStatus will continuously poll the Zota API server for an order status.
-// This function is intended to work as a goroutine.
-func (api *ZotaAPI) PollOrderStatus(request *ZotaOrderStatusRequest, order *internal.Order) (*ZotaOrderStatusResponse, error) {
-	ticker := time.NewTicker(10 * time.Second)
-	quitChan := make(chan bool)
-	maxAttempts := 20
-	attempts := 0
-
-	for {
-		select {
-		case <-ticker.C:
-			// Stop querying after we've reached max attempts
-			if attempts >= maxAttempts {
-				fmt.Printf("Reached maximum retry attempts (%v) before receiving a final order status\n", maxAttempts)
-				order.PaymentStatus = internal.PaymentStatusFailed
-				close(quitChan)
-				continue
-			}
-
-			zosr, err := api.OrderStatus(request)
-			if err != nil {
-				fmt.Printf("Received error when checking order status: %v\n", err)
-				continue
-			}
-
-			if zosr.Code != "200" {
-				fmt.Printf("Received non-OK response from Zota: %v\n", zosr.Message)
-				continue
-			}
-
-			if zosr.Data == nil {
-				fmt.Printf("Received OK response from Zota, but no data field: %v\n", zosr)
-				continue
-			}
-
-			if zosr.IsInFinalStatus() {
-				fmt.Printf("We've received a final status for order %v\n", zosr.Data.MerchantOrderId)
-
-				// Set payment status
-				if zosr.Data.Status == Approved {
-					order.PaymentStatus = internal.PaymentStatusApproved
-				} else {
-					order.PaymentStatus = internal.PaymentStatusFailed
-				}
-
-				close(quitChan)
-				return zosr, nil
-			}
-
-		case <-quitChan:
-			ticker.Stop()
-			return nil, errors.New("Couldn't get response")
+	httpResponse, err := api.httpClient.Do(httpRequest)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = httpRequest.URL.Path
 		}
+
+		duration := time.Since(start)
+		api.metrics.ZotaRequest(endpoint, metrics.CodeError, duration)
+		logger.DebugContext(ctx, "Request to Zota failed", "duration", duration, "error", err)
+		return err
+	}
+
+	defer httpResponse.Body.Close()
+
+	responseBody, err := io.ReadAll(httpResponse.Body)
+	duration := time.Since(start)
+	if err != nil {
+		api.metrics.ZotaRequest(endpoint, metrics.CodeError, duration)
+		logger.DebugContext(ctx, "Request to Zota failed", "status", httpResponse.StatusCode, "duration", duration, "error", err)
+		return err
+	}
+
+	// Error responses don't necessarily have a JSON body
+	var zotaResp zotaResponse
+	jsonErr := json.Unmarshal(responseBody, &zotaResp)
+
+	code := zotaResp.Code
+	if jsonErr != nil || code == "" {
+		code = strconv.Itoa(httpResponse.StatusCode)
 	}
+	api.metrics.ZotaRequest(endpoint, code, duration)
+	span.SetAttributes(attribute.String("zota.code", code))
+	logger.DebugContext(ctx, "Request to Zota completed", "status", httpResponse.StatusCode, "code", code, "duration", duration)
+
+	if httpResponse.StatusCode < 200 || httpResponse.StatusCode > 299 {
+		return newAPIError(httpResponse, responseBody, zotaResp)
+	}
+
+	if jsonErr != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedResponse, jsonErr)
+	}
+
+	// A body like {} or null says nothing about the request's outcome
+	if zotaResp.Code == "" {
+		return fmt.Errorf("%w: response has no code", ErrMalformedResponse)
+	}
+
+	if zotaResp.Code != "200" {
+		return newAPIError(httpResponse, responseBody, zotaResp)
+	}
+
+	err = json.Unmarshal(responseBody, response)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+	}
+
+	return nil
 }
@@ -0,0 +1,447 @@
+package zota
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/internal/metrics"
+	"github.com/federlizer/alokin-zota-integration/internal/tracing"
+)
+
+func createTestZotaAPI(t *testing.T, baseUrl string, options ...Option) *ZotaAPI {
+	options = append([]Option{WithEndpoints(map[string]string{"USD": "123456"})}, options...)
+	api, err := NewZotaAPI("00000000-1111-2222-3333-444444444444", "123456", "COOKIES1337", baseUrl, options...)
+	if err != nil {
+		t.Fatalf("Failed to create Zota API: %q\n", err)
+	}
+
+	return api
+}
+
+var invalidBaseUrls = []string{
+	"",
+	"api.zotapay-sandbox.com",
+	"ftp://api.zotapay-sandbox.com",
+	"https://",
+	"https://api.zotapay-sandbox.com?debug=true",
+}
+
+func TestNewZotaAPIValidatesConfig(t *testing.T) {
+	for _, baseUrl := range invalidBaseUrls {
+		_, err := NewZotaAPI("secret", "123456", "COOKIES1337", baseUrl)
+		if err == nil {
+			t.Errorf("Expected base URL %q to be rejected", baseUrl)
+		}
+	}
+
+	_, err := NewZotaAPI("", "123456", "COOKIES1337", "https://api.zotapay-sandbox.com")
+	if err == nil {
+		t.Errorf("Expected a missing secret key to be rejected")
+	}
+
+	_, err = NewZotaAPI("secret", "123456", "", "https://api.zotapay-sandbox.com")
+	if err == nil {
+		t.Errorf("Expected a missing merchant ID to be rejected")
+	}
+
+	api := createTestZotaAPI(t, "https://api.zotapay-sandbox.com/")
+	if api.BaseUrl() != "https://api.zotapay-sandbox.com" {
+		t.Errorf("Base URL %q doesn't equal expected %q", api.BaseUrl(), "https://api.zotapay-sandbox.com")
+	}
+}
+
+func TestOrderStatusSendsRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query/order-status/" {
+			t.Errorf("Request path %q doesn't equal expected %q", r.URL.Path, "/api/v1/query/order-status/")
+		}
+
+		if r.Header.Get("User-Agent") != "alokin-test" {
+			t.Errorf("User agent %q doesn't equal expected %q", r.Header.Get("User-Agent"), "alokin-test")
+		}
+
+		if r.URL.Query().Get("merchantOrderID") != "e31edd0d-76a6-4f1c-be19-4504ff5b89d7" {
+			t.Errorf("Unexpected merchantOrderID in query %q", r.URL.RawQuery)
+		}
+
+		w.Write([]byte(`{"code": "200", "data": {"status": "APPROVED"}}`))
+	}))
+	defer server.Close()
+
+	api := createTestZotaAPI(t, server.URL, WithUserAgent("alokin-test"), WithHTTPClient(server.Client()))
+
+	request := NewZotaOrderStatusRequest("32ab44f0a5d9d6b6c0c9e41b1a9c7ea1a2b3c4d5", "e31edd0d-76a6-4f1c-be19-4504ff5b89d7")
+	response, err := api.OrderStatus(request)
+	if err != nil {
+		t.Fatalf("Order status request failed: %q\n", err)
+	}
+
+	if !response.IsInFinalStatus() || response.Data.Status != Approved {
+		t.Errorf("Order status response %+v isn't approved", response.Data)
+	}
+}
+
+func TestNilHTTPClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"code": "200", "data": {"status": "APPROVED"}}`))
+	}))
+	defer server.Close()
+
+	// A nil client is replaced by http.DefaultClient, which is left as is
+	api := createTestZotaAPI(t, server.URL, WithHTTPClient(nil))
+
+	request := NewZotaOrderStatusRequest("32ab44f0a5d9d6b6c0c9e41b1a9c7ea1a2b3c4d5", "e31edd0d-76a6-4f1c-be19-4504ff5b89d7")
+	_, err := api.OrderStatus(request)
+	if err != nil {
+		t.Fatalf("Order status request failed: %q\n", err)
+	}
+
+	if http.DefaultClient.Transport != nil {
+		t.Errorf("Transport of http.DefaultClient has been replaced")
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer server.Close()
+	defer close(unblock)
+
+	api := createTestZotaAPI(t, server.URL, WithTimeout(50*time.Millisecond))
+
+	start := time.Now()
+	_, err := api.Deposit(&ZotaDepositRequest{OrderCurrency: "USD"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Deposit error %v doesn't equal expected %v", err, context.DeadlineExceeded)
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Deposit request wasn't aborted after the timeout")
+	}
+}
+
+func TestRequestCancellation(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer server.Close()
+	defer close(unblock)
+
+	api := createTestZotaAPI(t, server.URL, WithTimeout(0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err := api.PayoutContext(ctx, &ZotaPayoutRequest{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Payout error %v doesn't equal expected %v", err, context.Canceled)
+	}
+}
+
+func TestRequestErrorHidesSignature(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer server.Close()
+	defer close(unblock)
+
+	api := createTestZotaAPI(t, server.URL, WithTimeout(50*time.Millisecond))
+
+	request := NewZotaOrderStatusRequest("32ab44f0a5d9d6b6c0c9e41b1a9c7ea1a2b3c4d5", "e31edd0d-76a6-4f1c-be19-4504ff5b89d7")
+	_, err := api.OrderStatus(request)
+	if err == nil {
+		t.Fatalf("Expected order status request to time out")
+	}
+
+	if strings.Contains(err.Error(), request.Signature) || strings.Contains(err.Error(), "merchantOrderID") {
+		t.Errorf("Order status error %q contains the request's query", err)
+	}
+}
+
+func TestResponseError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"code":"429","message":"Too many requests"}`))
+	}))
+	defer server.Close()
+
+	api := createTestZotaAPI(t, server.URL)
+
+	_, err := api.OrderStatus(NewZotaOrderStatusRequest("1234", "5678"))
+
+	var responseErr *APIError
+	if !errors.As(err, &responseErr) {
+		t.Fatalf("Order status error %v isn't a *APIError", err)
+	}
+
+	if responseErr.HTTPStatus != http.StatusTooManyRequests || responseErr.RetryAfter != 2*time.Minute {
+		t.Errorf("Response error %+v doesn't have the response's status and Retry-After", responseErr)
+	}
+
+	if !responseErr.Temporary() {
+		t.Errorf("Rate limited response error isn't temporary")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{"-5", 0},
+		{"Fri, 01 Mar 2024 12:01:30 GMT", 90 * time.Second},
+		{"Fri, 01 Mar 2024 11:00:00 GMT", 0},
+		{"soon", 0},
+	}
+
+	for _, test := range tests {
+		output := parseRetryAfter(test.value, now)
+		if output != test.expected {
+			t.Errorf("Output %v does not equal expected %v for %q\n", output, test.expected, test.value)
+		}
+	}
+}
+
+func TestAPIErrorSentinels(t *testing.T) {
+	tests := []struct {
+		name       string
+		httpStatus int
+		body       string
+		expected   error
+		temporary  bool
+	}{
+		{"invalid", http.StatusBadRequest, `{"code":"400","message":"invalid amount"}`, ErrInvalidRequest, false},
+		{"signature", http.StatusUnauthorized, `{"code":"401","message":"invalid signature"}`, ErrUnauthorized, false},
+		{"not found", http.StatusNotFound, `{"code":"404","message":"order not found"}`, ErrNotFound, false},
+		{"rate limited", http.StatusTooManyRequests, ``, ErrRateLimited, true},
+		{"server", http.StatusBadGateway, `<html>Bad Gateway</html>`, ErrServer, true},
+		// Zota can respond with HTTP 200 and an error code
+		{"code in body", http.StatusOK, `{"code":"401","message":"invalid signature"}`, ErrUnauthorized, false},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.httpStatus)
+			w.Write([]byte(test.body))
+		}))
+
+		api := createTestZotaAPI(t, server.URL)
+		_, err := api.Deposit(&ZotaDepositRequest{OrderCurrency: "USD"})
+		server.Close()
+
+		if !errors.Is(err, test.expected) {
+			t.Errorf("%s: Deposit error %v doesn't match expected %v", test.name, err, test.expected)
+		}
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			t.Errorf("%s: Deposit error %v isn't a *APIError", test.name, err)
+			continue
+		}
+
+		if apiErr.HTTPStatus != test.httpStatus || string(apiErr.Body) != test.body {
+			t.Errorf("%s: API error %+v doesn't have the response's status and body", test.name, apiErr)
+		}
+
+		if apiErr.Temporary() != test.temporary {
+			t.Errorf("%s: Output %v does not equal expected %v\n", test.name, apiErr.Temporary(), test.temporary)
+		}
+	}
+}
+
+func TestMalformedResponse(t *testing.T) {
+	bodies := []string{
+		`not json`,
+		`{"code":"200"}`,
+		`{}`,
+		`null`,
+	}
+
+	for _, body := range bodies {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}))
+
+		api := createTestZotaAPI(t, server.URL)
+		_, err := api.OrderStatus(NewZotaOrderStatusRequest("1234", "5678"))
+		server.Close()
+
+		if !errors.Is(err, ErrMalformedResponse) {
+			t.Errorf("Order status error %v doesn't match expected %v for %q", err, ErrMalformedResponse, body)
+		}
+	}
+}
+
+func TestDepositUsesCurrencyEndpoint(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Write([]byte(`{"code": "200", "data": {"orderID": "1234", "depositUrl": "https://zota.com/deposit"}}`))
+	}))
+	defer server.Close()
+
+	api := createTestZotaAPI(t, server.URL, WithEndpoints(map[string]string{"USD": "111111", "EUR": "222222"}))
+
+	_, err := api.Deposit(&ZotaDepositRequest{OrderCurrency: "EUR"})
+	if err != nil {
+		t.Fatalf("Deposit failed: %q\n", err)
+	}
+
+	if path != "/api/v1/deposit/request/222222/" {
+		t.Errorf("Request path %q doesn't equal expected %q", path, "/api/v1/deposit/request/222222/")
+	}
+
+	path = ""
+	_, err = api.Deposit(&ZotaDepositRequest{OrderCurrency: "GBP"})
+	if !errors.Is(err, internal.ErrUnsupportedCurrency) || path != "" {
+		t.Errorf("Deposit in a currency without an endpoint returned %v instead of %v", err, internal.ErrUnsupportedCurrency)
+	}
+
+	currencies := api.Currencies()
+	if len(currencies) != 2 || currencies[0] != "EUR" || currencies[1] != "USD" {
+		t.Errorf("Output %v does not equal expected %v\n", currencies, []string{"EUR", "USD"})
+	}
+}
+
+func TestDepositWithoutEndpoints(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Deposit request was sent without an endpoint")
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		endpoints map[string]string
+	}{
+		{"no endpoints", nil},
+		{"empty endpoint", map[string]string{"USD": ""}},
+	}
+
+	for _, test := range tests {
+		api, err := NewZotaAPI("00000000-1111-2222-3333-444444444444", "123456", "COOKIES1337", server.URL, WithEndpoints(test.endpoints))
+		if err != nil {
+			t.Fatalf("Failed to create Zota API: %q\n", err)
+		}
+
+		endpointId, err := api.EndpointIdFor("USD")
+		if !errors.Is(err, internal.ErrUnsupportedCurrency) || endpointId != "" {
+			t.Errorf("%s: Output (%q, %v) doesn't equal expected (%q, %v)", test.name, endpointId, err, "", internal.ErrUnsupportedCurrency)
+		}
+
+		if len(api.Currencies()) != 0 {
+			t.Errorf("%s: Currencies %v aren't empty", test.name, api.Currencies())
+		}
+
+		_, err = api.Deposit(&ZotaDepositRequest{OrderCurrency: "USD"})
+		if !errors.Is(err, internal.ErrUnsupportedCurrency) {
+			t.Errorf("%s: Deposit error %v doesn't equal expected %v", test.name, err, internal.ErrUnsupportedCurrency)
+		}
+	}
+}
+
+func TestRequestsAreRecordedInMetrics(t *testing.T) {
+	responses := []struct {
+		httpStatus int
+		body       string
+	}{
+		{http.StatusOK, `{"code": "200", "data": {"status": "APPROVED"}}`},
+		{http.StatusOK, `{"code":"401","message":"invalid signature"}`},
+		{http.StatusBadGateway, `<html>Bad Gateway</html>`},
+	}
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := responses[requests%len(responses)]
+		requests += 1
+
+		w.WriteHeader(response.httpStatus)
+		w.Write([]byte(response.body))
+	}))
+
+	zotaMetrics := metrics.New()
+	api := createTestZotaAPI(t, server.URL, WithMetrics(zotaMetrics))
+
+	for range responses {
+		api.OrderStatus(NewZotaOrderStatusRequest("1234", "5678"))
+	}
+
+	// Requests that don't get a response are recorded too
+	server.Close()
+	api.Deposit(&ZotaDepositRequest{OrderCurrency: "USD"})
+
+	resWriter := httptest.NewRecorder()
+	zotaMetrics.Handler().ServeHTTP(resWriter, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := resWriter.Body.String()
+	for _, expected := range []string{
+		`alokin_zota_requests_total{code="200",endpoint="order_status"} 1`,
+		`alokin_zota_requests_total{code="401",endpoint="order_status"} 1`,
+		`alokin_zota_requests_total{code="502",endpoint="order_status"} 1`,
+		`alokin_zota_requests_total{code="error",endpoint="deposit"} 1`,
+		`alokin_zota_request_duration_seconds_count{endpoint="order_status"} 3`,
+	} {
+		if !strings.Contains(body, expected+"\n") {
+			t.Errorf("Metrics %q don't contain %q", body, expected)
+		}
+	}
+}
+
+func TestRequestsContinueTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracing.SetDefault(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer tracing.SetDefault(noop.NewTracerProvider())
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get(tracing.TraceparentHeader)
+		w.Write([]byte(`{"code": "200", "data": {"status": "APPROVED"}}`))
+	}))
+	defer server.Close()
+
+	api := createTestZotaAPI(t, server.URL)
+
+	ctx, span := tracing.Start(context.Background(), "Test")
+	defer span.End()
+
+	_, err := api.OrderStatusContext(ctx, NewZotaOrderStatusRequest("1234", "5678"))
+	if err != nil {
+		t.Fatalf("Failed to get order status: %q\n", err)
+	}
+
+	// Zota receives the span of the HTTP request, a child of the request's
+	// span, which is a child of ctx's
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, ended := range recorder.Ended() {
+		spans[ended.Name()] = ended
+	}
+
+	request, found := spans["Zota order_status"]
+	if !found || request.Parent().SpanID() != span.SpanContext().SpanID() || request.SpanContext().TraceID() != span.SpanContext().TraceID() {
+		t.Fatalf("Zota request span %+v isn't a child of the test span", request)
+	}
+
+	received := tracing.ParseTraceparent(traceparent)
+	httpSpan, found := spans["HTTP GET"]
+	if !found || httpSpan.Parent().SpanID() != request.SpanContext().SpanID() || received.SpanID() != httpSpan.SpanContext().SpanID() {
+		t.Errorf("Traceparent %q doesn't continue the trace of the Zota request span", traceparent)
+	}
+}
@@ -0,0 +1,124 @@
+package zotasim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+// The /sim endpoints let developers inspect and drive the simulator over
+// HTTP when it runs on its own. They aren't part of Zota's API.
+
+func (s *Server) listOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"orders": s.Orders(),
+	})
+}
+
+type setStatusParams struct {
+	Status       zota.OrderStatus `json:"status"`
+	ErrorMessage string           `json:"errorMessage"`
+}
+
+func (s *Server) setStatusHandler(w http.ResponseWriter, r *http.Request) {
+	var params setStatusParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil || !isKnownStatus(params.Status) {
+		writeError(w, http.StatusBadRequest, "Invalid data")
+		return
+	}
+
+	orderId := r.PathValue("orderId")
+	err = s.SetStatus(orderId, params.Status, params.ErrorMessage)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	// The status has changed even if the merchant didn't take the callback
+	if err != nil {
+		slog.WarnContext(r.Context(), "Simulator callback failed", "orderId", orderId, "error", err)
+	}
+
+	order, _ := s.Order(orderId)
+	writeJSON(w, http.StatusOK, order)
+}
+
+// faultParams is the JSON form of a Fault, with durations such as "2s"
+type faultParams struct {
+	Delay       string `json:"delay"`
+	HTTPStatus  int    `json:"httpStatus"`
+	Code        string `json:"code"`
+	Message     string `json:"message"`
+	RetryAfter  string `json:"retryAfter"`
+	Malformed   bool   `json:"malformed"`
+	MissingData bool   `json:"missingData"`
+	Times       int    `json:"times"`
+}
+
+func (p faultParams) fault() (Fault, error) {
+	fault := Fault{
+		HTTPStatus:  p.HTTPStatus,
+		Code:        p.Code,
+		Message:     p.Message,
+		Malformed:   p.Malformed,
+		MissingData: p.MissingData,
+		Times:       p.Times,
+	}
+
+	var err error
+	if p.Delay != "" {
+		fault.Delay, err = time.ParseDuration(p.Delay)
+		if err != nil {
+			return fault, fmt.Errorf("Invalid delay %q", p.Delay)
+		}
+	}
+
+	if p.RetryAfter != "" {
+		fault.RetryAfter, err = time.ParseDuration(p.RetryAfter)
+		if err != nil {
+			return fault, fmt.Errorf("Invalid retryAfter %q", p.RetryAfter)
+		}
+	}
+
+	if fault.Times < 0 || (fault.HTTPStatus != 0 && (fault.HTTPStatus < 100 || fault.HTTPStatus > 599)) {
+		return fault, fmt.Errorf("Invalid fault")
+	}
+
+	return fault, nil
+}
+
+func (s *Server) injectFaultHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := Endpoint(r.PathValue("endpoint"))
+	if !slices.Contains(Endpoints, endpoint) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Unknown endpoint %q", endpoint))
+		return
+	}
+
+	var params faultParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid data")
+		return
+	}
+
+	fault, err := params.fault()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.InjectFault(endpoint, fault)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) clearFaultsHandler(w http.ResponseWriter, r *http.Request) {
+	s.ClearFaults()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,133 @@
+package zotasim
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Endpoint identifies the requests a Fault is injected into
+type Endpoint string
+
+const (
+	EndpointDeposit     Endpoint = "deposit"
+	EndpointPayout      Endpoint = "payout"
+	EndpointOrderStatus Endpoint = "order-status"
+)
+
+// Endpoints holds every endpoint faults can be injected into
+var Endpoints = []Endpoint{EndpointDeposit, EndpointPayout, EndpointOrderStatus}
+
+// Fault changes how the simulator responds to requests. A fault with only a
+// Delay slows requests down, which are then handled as usual.
+type Fault struct {
+	// Delay is how long the simulator waits before responding
+	Delay time.Duration
+	// HTTPStatus makes requests fail with the status, e.g. 500 or 429
+	HTTPStatus int
+	// Code is the code of the failed response, the HTTPStatus if empty. A
+	// Code without an HTTPStatus fails the request with a 200 response,
+	// like Zota does for some errors.
+	Code    string
+	Message string
+	// RetryAfter is sent in the Retry-After header of failed responses
+	RetryAfter time.Duration
+	// Malformed responds with a body that isn't valid JSON
+	Malformed bool
+	// MissingData responds with an OK response without any data
+	MissingData bool
+	// Times is how many requests the fault applies to, every request until
+	// ClearFaults is called if zero
+	Times int
+}
+
+// InjectFault makes requests to the endpoint respond according to the fault.
+// Faults of an endpoint apply in the order they were injected.
+func (s *Server) InjectFault(endpoint Endpoint, fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults[endpoint] = append(s.faults[endpoint], &fault)
+}
+
+// ClearFaults removes every injected fault
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = map[Endpoint][]*Fault{}
+}
+
+// nextFault returns the fault the next request to the endpoint responds
+// with, if any
+func (s *Server) nextFault(endpoint Endpoint) (Fault, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	faults := s.faults[endpoint]
+	if len(faults) == 0 {
+		return Fault{}, false
+	}
+
+	fault := *faults[0]
+	if faults[0].Times > 0 {
+		faults[0].Times -= 1
+		if faults[0].Times == 0 {
+			s.faults[endpoint] = faults[1:]
+		}
+	}
+
+	return fault, true
+}
+
+// applyFault responds to the request according to the endpoint's next
+// fault and reports whether the request should still be handled as usual
+func (s *Server) applyFault(w http.ResponseWriter, r *http.Request, endpoint Endpoint) bool {
+	fault, ok := s.nextFault(endpoint)
+	if !ok {
+		return true
+	}
+
+	if fault.Delay > 0 {
+		timer := time.NewTimer(fault.Delay)
+		defer timer.Stop()
+
+		select {
+		case <-r.Context().Done():
+			return false
+		case <-timer.C:
+		}
+	}
+
+	switch {
+	case fault.Malformed:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"code": "200", "data": {"orderID": `))
+		return false
+	case fault.MissingData:
+		writeJSON(w, http.StatusOK, map[string]string{"code": "200"})
+		return false
+	case fault.HTTPStatus != 0 || fault.Code != "":
+		status := fault.HTTPStatus
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		code := fault.Code
+		if code == "" {
+			code = strconv.Itoa(status)
+		}
+
+		if fault.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(fault.RetryAfter.Seconds())))
+		}
+
+		writeJSON(w, status, map[string]string{
+			"code":    code,
+			"message": fault.Message,
+		})
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,41 @@
+package zotasim
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Option configures a Server created by New
+type Option func(s *Server)
+
+// WithScript sets the steps every order goes through, see Server.SetScript.
+// Without a script, orders stay CREATED until SetStatus is called, just like
+// orders whose customer never finishes the deposit.
+func WithScript(steps ...Step) Option {
+	return func(s *Server) {
+		s.script = append([]Step(nil), steps...)
+	}
+}
+
+// WithoutCallbacks stops the simulator from sending callback notifications,
+// so order status changes are only seen by polling
+func WithoutCallbacks() Option {
+	return func(s *Server) {
+		s.callbacks = false
+	}
+}
+
+// WithHTTPClient makes the simulator send callbacks with the given client
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *Server) {
+		s.httpClient = client
+	}
+}
+
+// WithPublicUrl sets the URL the simulator is reachable at, which deposit
+// URLs are built from. By default it's taken from each deposit request.
+func WithPublicUrl(publicUrl string) Option {
+	return func(s *Server) {
+		s.publicUrl = strings.TrimSuffix(publicUrl, "/")
+	}
+}
@@ -0,0 +1,121 @@
+package zotasim
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+// Step moves an order to a status
+type Step struct {
+	Status       zota.OrderStatus
+	ErrorMessage string
+	// After is how long after the previous step, or after the order was
+	// received for the first step, the order moves to Status
+	After time.Duration
+	// Amount changes the amount Zota reports for the order, e.g. to approve
+	// a different amount than was requested. Empty keeps the amount.
+	Amount string
+}
+
+// knownStatuses are the statuses Zota can report for an order
+var knownStatuses = []zota.OrderStatus{
+	zota.Created,
+	zota.Processing,
+	zota.Pending,
+	zota.Approved,
+	zota.Declined,
+	zota.Filtered,
+	zota.Error,
+	zota.Unknown,
+}
+
+func isKnownStatus(status zota.OrderStatus) bool {
+	return slices.Contains(knownStatuses, status)
+}
+
+// ParseScript parses a comma separated list of steps, each written as
+// STATUS[:AFTER[:ERROR MESSAGE]], e.g.
+// "PROCESSING:2s,DECLINED:5s:Insufficient funds"
+func ParseScript(value string) ([]Step, error) {
+	var steps []Step
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, ":", 3)
+		step := Step{Status: zota.OrderStatus(strings.ToUpper(strings.TrimSpace(parts[0])))}
+		if !isKnownStatus(step.Status) {
+			return nil, fmt.Errorf("Invalid script step %q: unknown status %q", item, step.Status)
+		}
+
+		if len(parts) > 1 {
+			after, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+			if err != nil || after < 0 {
+				return nil, fmt.Errorf("Invalid script step %q: invalid delay %q", item, parts[1])
+			}
+
+			step.After = after
+		}
+
+		if len(parts) > 2 {
+			step.ErrorMessage = strings.TrimSpace(parts[2])
+		}
+
+		steps = append(steps, step)
+	}
+
+	return steps, nil
+}
+
+// scheduleLocked schedules the first of the steps for the order, the rest
+// are scheduled once it has been applied. s.mu must be held.
+func (s *Server) scheduleLocked(orderId string, steps []Step) {
+	if len(steps) == 0 || s.closed {
+		return
+	}
+
+	// The timer can't fire before it's stored, the step needs s.mu first
+	var timer *time.Timer
+	timer = time.AfterFunc(steps[0].After, func() {
+		s.mu.Lock()
+		delete(s.timers, timer)
+		closed := s.closed
+		s.mu.Unlock()
+
+		if closed {
+			return
+		}
+
+		s.runStep(orderId, steps)
+	})
+	s.timers[timer] = struct{}{}
+}
+
+// runStep applies the first of the steps to the order, sends the callback
+// of the change and schedules the remaining steps
+func (s *Server) runStep(orderId string, steps []Step) {
+	order, changed, err := s.changeStatus(orderId, steps[0])
+	if err != nil {
+		slog.Warn("Couldn't run script step of order", "orderId", orderId, "error", err)
+		return
+	}
+
+	if changed && s.callbacks {
+		err = s.sendCallback(order)
+		if err != nil {
+			slog.Warn("Simulator callback failed", "orderId", orderId, "error", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scheduleLocked(orderId, steps[1:])
+}
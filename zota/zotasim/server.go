@@ -0,0 +1,541 @@
+// Package zotasim implements a fake Zota API for development and tests.
+//
+// The simulator accepts deposit, payout and order status requests the same
+// way Zota's sandbox does, validating their signatures, and sends signed
+// callbacks to the merchant's callbackUrl whenever an order changes its
+// status. Orders are moved through statuses by a script, or by hand with
+// SetStatus, and faults can be injected to make requests slow, fail or
+// return malformed data.
+//
+// Server is an http.Handler, so it can be served with httptest.NewServer in
+// tests, or on its own with the cmd/zotasim binary.
+package zotasim
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+// ErrNotFound is returned for orders the simulator hasn't received
+var ErrNotFound = errors.New("Order not found")
+
+// Order is an order the simulator has received with a deposit or payout request
+type Order struct {
+	// Type is zota.CallbackTypeSale for deposits and zota.CallbackTypePayout
+	// for payouts
+	Type            string `json:"type"`
+	OrderId         string `json:"orderID"`
+	MerchantOrderId string `json:"merchantOrderID"`
+	EndpointId      string `json:"endpointID"`
+	// Amount is the amount Zota reports for the order, which a script step
+	// can change from the amount that was requested
+	Amount        string `json:"amount"`
+	Currency      string `json:"currency"`
+	CustomerEmail string `json:"customerEmail"`
+
+	Status                 zota.OrderStatus `json:"status"`
+	ErrorMessage           string           `json:"errorMessage"`
+	ProcessorTransactionId string           `json:"processorTransactionID"`
+
+	CallbackUrl string    `json:"callbackUrl"`
+	RedirectUrl string    `json:"redirectUrl"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Server is a fake Zota API. Create it with New.
+type Server struct {
+	merchantId string
+	secretKey  string
+	// endpoints maps endpoint IDs to the currency deposits are made in
+	endpoints map[string]string
+
+	publicUrl  string
+	callbacks  bool
+	httpClient *http.Client
+	mux        *http.ServeMux
+
+	mu     sync.Mutex
+	script []Step
+	orders map[string]*Order
+	// merchantOrders maps merchant order IDs to Zota order IDs
+	merchantOrders map[string]string
+	faults         map[Endpoint][]*Fault
+	// timers hold the pending script steps, which are stopped by Close
+	timers map[*time.Timer]struct{}
+	closed bool
+}
+
+// New creates a simulator of the merchant's Zota account. endpoints maps the
+// currencies deposits can be made in to their endpoint IDs, just like
+// zota.WithEndpoints. Payouts can be made with any of the endpoints.
+func New(merchantId, secretKey string, endpoints map[string]string, options ...Option) *Server {
+	s := &Server{
+		merchantId: merchantId,
+		secretKey:  secretKey,
+		endpoints:  make(map[string]string, len(endpoints)),
+
+		callbacks:  true,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+
+		orders:         map[string]*Order{},
+		merchantOrders: map[string]string{},
+		faults:         map[Endpoint][]*Fault{},
+		timers:         map[*time.Timer]struct{}{},
+	}
+
+	for currency, endpointId := range endpoints {
+		s.endpoints[endpointId] = currency
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("POST /api/v1/deposit/request/{endpointId}/", s.depositHandler)
+	s.mux.HandleFunc("POST /api/v1/payout/request/{endpointId}/", s.payoutHandler)
+	s.mux.HandleFunc("GET /api/v1/query/order-status/", s.orderStatusHandler)
+	s.mux.HandleFunc("GET /deposit/{orderId}", s.depositPageHandler)
+	s.mux.HandleFunc("GET /sim/orders", s.listOrdersHandler)
+	s.mux.HandleFunc("POST /sim/orders/{orderId}/status", s.setStatusHandler)
+	s.mux.HandleFunc("POST /sim/faults/{endpoint}", s.injectFaultHandler)
+	s.mux.HandleFunc("DELETE /sim/faults", s.clearFaultsHandler)
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close stops every pending script step. Orders keep the status they have.
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for timer := range s.timers {
+		timer.Stop()
+	}
+	s.timers = map[*time.Timer]struct{}{}
+}
+
+// SetScript sets the steps every order received from now on goes through
+func (s *Server) SetScript(steps ...Step) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.script = append([]Step(nil), steps...)
+}
+
+// Order returns a copy of the order with the given Zota order ID
+func (s *Server) Order(orderId string) (Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[orderId]
+	if !ok {
+		return Order{}, ErrNotFound
+	}
+
+	return *order, nil
+}
+
+// Orders returns a copy of every order, oldest first
+func (s *Server) Orders() []Order {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orders := make([]Order, 0, len(s.orders))
+	for _, order := range s.orders {
+		orders = append(orders, *order)
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].CreatedAt.Before(orders[j].CreatedAt)
+	})
+
+	return orders
+}
+
+// SetStatus moves the order to the status and, unless callbacks are
+// disabled, sends the callback notification of the change. The error of the
+// callback is returned, the status is changed either way.
+func (s *Server) SetStatus(orderId string, status zota.OrderStatus, errorMessage string) error {
+	order, changed, err := s.changeStatus(orderId, Step{Status: status, ErrorMessage: errorMessage})
+	if err != nil || !changed || !s.callbacks {
+		return err
+	}
+
+	return s.sendCallback(order)
+}
+
+// ResendCallback sends the callback notification of the order's current
+// status again, like Zota does when the merchant doesn't acknowledge it
+func (s *Server) ResendCallback(orderId string) error {
+	order, err := s.Order(orderId)
+	if err != nil {
+		return err
+	}
+
+	return s.sendCallback(order)
+}
+
+// changeStatus applies the step to the order and returns a copy of the order
+// and whether its status has changed
+func (s *Server) changeStatus(orderId string, step Step) (Order, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[orderId]
+	if !ok {
+		return Order{}, false, ErrNotFound
+	}
+
+	changed := order.Status != step.Status || order.ErrorMessage != step.ErrorMessage
+	order.Status = step.Status
+	order.ErrorMessage = step.ErrorMessage
+	if step.Amount != "" {
+		order.Amount = step.Amount
+	}
+
+	if order.ProcessorTransactionId == "" && order.Status != zota.Created {
+		order.ProcessorTransactionId = "SIM-" + randomHex(8)
+	}
+
+	return *order, changed, nil
+}
+
+// sendCallback posts the signed callback notification of the order's
+// current status to its callbackUrl
+func (s *Server) sendCallback(order Order) error {
+	if order.CallbackUrl == "" {
+		return nil
+	}
+
+	callback := zota.ZotaCallback{
+		Type:                   order.Type,
+		Status:                 order.Status,
+		ErrorMessage:           order.ErrorMessage,
+		EndpointId:             order.EndpointId,
+		ProcessorTransactionId: order.ProcessorTransactionId,
+		OrderId:                order.OrderId,
+		MerchantOrderId:        order.MerchantOrderId,
+		Amount:                 order.Amount,
+		Currency:               order.Currency,
+		CustomerEmail:          order.CustomerEmail,
+	}
+	callback.Signature = callback.GenSignature(order.EndpointId, s.secretKey)
+
+	body, err := json.Marshal(callback)
+	if err != nil {
+		return err
+	}
+
+	response, err := s.httpClient.Post(order.CallbackUrl, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Couldn't send callback of order %v: %w", order.OrderId, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("Callback of order %v was answered with %d", order.OrderId, response.StatusCode)
+	}
+
+	return nil
+}
+
+// addOrder stores a new order and starts its script
+func (s *Server) addOrder(order *Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.merchantOrders[order.MerchantOrderId]; exists {
+		return fmt.Errorf("merchantOrderID %v already exists", order.MerchantOrderId)
+	}
+
+	order.OrderId = randomHex(20)
+	order.Status = zota.Created
+	order.CreatedAt = time.Now()
+
+	s.orders[order.OrderId] = order
+	s.merchantOrders[order.MerchantOrderId] = order.OrderId
+	s.scheduleLocked(order.OrderId, s.script)
+
+	return nil
+}
+
+func (s *Server) depositHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.applyFault(w, r, EndpointDeposit) {
+		return
+	}
+
+	endpointId := r.PathValue("endpointId")
+	currency, ok := s.endpoints[endpointId]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Unknown endpoint "+endpointId)
+		return
+	}
+
+	var request zota.ZotaDepositRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if request.MerchantOrderID == "" || request.OrderAmount == "" || request.CustomerEmail == "" {
+		writeError(w, http.StatusBadRequest, "Missing required fields")
+		return
+	}
+
+	if !validSignature(request.GenSignature(endpointId, s.secretKey), request.Signature) {
+		writeError(w, http.StatusUnauthorized, "Invalid signature")
+		return
+	}
+
+	if request.OrderCurrency != currency {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Endpoint %v doesn't accept %v", endpointId, request.OrderCurrency))
+		return
+	}
+
+	order := &Order{
+		Type:            zota.CallbackTypeSale,
+		MerchantOrderId: request.MerchantOrderID,
+		EndpointId:      endpointId,
+		Amount:          request.OrderAmount,
+		Currency:        request.OrderCurrency,
+		CustomerEmail:   request.CustomerEmail,
+		CallbackUrl:     request.CallbackUrl,
+		RedirectUrl:     request.RedirectUrl,
+	}
+
+	err = s.addOrder(order)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeData(w, zota.ZotaDepositData{
+		MerchantOrderID: order.MerchantOrderId,
+		DepositUrl:      s.baseUrl(r) + "/deposit/" + order.OrderId,
+		OrderId:         order.OrderId,
+	})
+}
+
+func (s *Server) payoutHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.applyFault(w, r, EndpointPayout) {
+		return
+	}
+
+	endpointId := r.PathValue("endpointId")
+	if _, ok := s.endpoints[endpointId]; !ok {
+		writeError(w, http.StatusBadRequest, "Unknown endpoint "+endpointId)
+		return
+	}
+
+	var request zota.ZotaPayoutRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if request.MerchantOrderID == "" || request.OrderAmount == "" || request.OrderCurrency == "" || request.CustomerEmail == "" {
+		writeError(w, http.StatusBadRequest, "Missing required fields")
+		return
+	}
+
+	if !validSignature(request.GenSignature(endpointId, s.secretKey), request.Signature) {
+		writeError(w, http.StatusUnauthorized, "Invalid signature")
+		return
+	}
+
+	order := &Order{
+		Type:            zota.CallbackTypePayout,
+		MerchantOrderId: request.MerchantOrderID,
+		EndpointId:      endpointId,
+		Amount:          request.OrderAmount,
+		Currency:        request.OrderCurrency,
+		CustomerEmail:   request.CustomerEmail,
+		CallbackUrl:     request.CallbackUrl,
+	}
+
+	err = s.addOrder(order)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeData(w, zota.ZotaPayoutData{
+		MerchantOrderID: order.MerchantOrderId,
+		OrderId:         order.OrderId,
+	})
+}
+
+func (s *Server) orderStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.applyFault(w, r, EndpointOrderStatus) {
+		return
+	}
+
+	query := r.URL.Query()
+	timestamp, err := strconv.ParseInt(query.Get("timestamp"), 10, 64)
+	if err != nil || query.Get("orderID") == "" || query.Get("merchantOrderID") == "" {
+		writeError(w, http.StatusBadRequest, "Missing required parameters")
+		return
+	}
+
+	request := zota.ZotaOrderStatusRequest{
+		OrderId:         query.Get("orderID"),
+		MerchantOrderId: query.Get("merchantOrderID"),
+		Timestamp:       timestamp,
+	}
+
+	expected := request.GenSignature(s.merchantId, s.secretKey)
+	if query.Get("merchantID") != s.merchantId || !validSignature(expected, query.Get("signature")) {
+		writeError(w, http.StatusUnauthorized, "Invalid signature")
+		return
+	}
+
+	order, err := s.Order(request.OrderId)
+	if err != nil || order.MerchantOrderId != request.MerchantOrderId {
+		writeError(w, http.StatusNotFound, ErrNotFound.Error())
+		return
+	}
+
+	writeData(w, zota.ZotaOrderStatusData{
+		Type:                   order.Type,
+		Status:                 order.Status,
+		ErrorMessage:           order.ErrorMessage,
+		ProcessorTransactionId: order.ProcessorTransactionId,
+		OrderId:                order.OrderId,
+		MerchantOrderId:        order.MerchantOrderId,
+		Amount:                 order.Amount,
+		Currency:               order.Currency,
+		CustomerEmail:          order.CustomerEmail,
+	})
+}
+
+// depositPageHandler stands in for the page the customer makes the deposit
+// on. The status query parameter, e.g. ?status=APPROVED, completes the
+// deposit with that status. The customer is then sent back to the
+// merchant's redirectUrl with a signed redirect, like Zota does.
+func (s *Server) depositPageHandler(w http.ResponseWriter, r *http.Request) {
+	orderId := r.PathValue("orderId")
+
+	status := zota.OrderStatus(r.URL.Query().Get("status"))
+	if status != "" {
+		if !isKnownStatus(status) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Unknown status %q", status))
+			return
+		}
+
+		err := s.SetStatus(orderId, status, r.URL.Query().Get("errorMessage"))
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		if err != nil {
+			slog.WarnContext(r.Context(), "Simulator callback failed", "orderId", orderId, "error", err)
+		}
+	}
+
+	order, err := s.Order(orderId)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if order.RedirectUrl == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "Order %v is %v\n", order.OrderId, order.Status)
+		return
+	}
+
+	redirect := zota.ZotaRedirect{
+		Status:          order.Status,
+		ErrorMessage:    order.ErrorMessage,
+		OrderId:         order.OrderId,
+		MerchantOrderId: order.MerchantOrderId,
+	}
+
+	params := url.Values{}
+	params.Set("status", string(redirect.Status))
+	params.Set("errorMessage", redirect.ErrorMessage)
+	params.Set("orderID", redirect.OrderId)
+	params.Set("merchantOrderID", redirect.MerchantOrderId)
+	params.Set("signature", redirect.GenSignature(s.secretKey))
+
+	separator := "?"
+	if strings.Contains(order.RedirectUrl, "?") {
+		separator = "&"
+	}
+
+	http.Redirect(w, r, order.RedirectUrl+separator+params.Encode(), http.StatusFound)
+}
+
+// baseUrl is the URL the simulator is reachable at, used to build deposit
+// URLs. Unless configured with WithPublicUrl, it's taken from the request.
+func (s *Server) baseUrl(r *http.Request) string {
+	if s.publicUrl != "" {
+		return s.publicUrl
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host
+}
+
+// writeError writes an error response the way Zota does, with the HTTP
+// status repeated in the code
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{
+		"code":    strconv.Itoa(status),
+		"message": message,
+	})
+}
+
+// writeData writes an OK response with the data
+func writeData(w http.ResponseWriter, data any) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": "200",
+		"data": data,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func validSignature(expected, signature string) bool {
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(signature)) == 1
+}
+
+// randomHex returns n random bytes, hex encoded
+func randomHex(n int) string {
+	bytes := make([]byte, n)
+	rand.Read(bytes)
+
+	return hex.EncodeToString(bytes)
+}
@@ -0,0 +1,299 @@
+package zotasim
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/federlizer/alokin-zota-integration/internal"
+	"github.com/federlizer/alokin-zota-integration/zota"
+)
+
+const (
+	testSecretKey  = "00000000-1111-2222-3333-444444444444"
+	testMerchantId = "COOKIES1337"
+)
+
+var testEndpoints = map[string]string{"USD": "123456", "EUR": "654321"}
+
+// startSimulator serves a simulator and returns a Zota client of it
+func startSimulator(t *testing.T, options ...Option) (*Server, *zota.ZotaAPI) {
+	sim := New(testMerchantId, testSecretKey, testEndpoints, options...)
+	t.Cleanup(sim.Close)
+
+	server := httptest.NewServer(sim)
+	t.Cleanup(server.Close)
+
+	zotaApi, err := zota.NewZotaAPI(testSecretKey, "123456", testMerchantId, server.URL, zota.WithEndpoints(testEndpoints), zota.WithTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("Failed to create Zota API: %q\n", err)
+	}
+
+	return sim, zotaApi
+}
+
+func createDepositRequest(t *testing.T, zotaApi zota.IZotaAPI, callbackUrl string) *zota.ZotaDepositRequest {
+	user := internal.NewUser("federlizer@protonmail.com", "Nikola", "Velichkov", "+4550331329", internal.UserAddress{})
+	amount, _ := internal.NewMoney(1337, "USD")
+	order := internal.NewOrder(user, amount, "Test order")
+
+	endpointId, _ := zotaApi.EndpointIdFor("USD")
+	return zota.FromOrder(order, endpointId, zotaApi.SecretKey(), zota.MerchantUrls{
+		RedirectUrl: "https://federlizer.com/deposit/return",
+		CallbackUrl: callbackUrl,
+	})
+}
+
+func deposit(t *testing.T, zotaApi zota.IZotaAPI, request *zota.ZotaDepositRequest) *zota.ZotaDepositData {
+	response, err := zotaApi.Deposit(request)
+	if err != nil {
+		t.Fatalf("Deposit request failed: %q\n", err)
+	}
+
+	return response.Data
+}
+
+func TestDepositAndOrderStatus(t *testing.T) {
+	sim, zotaApi := startSimulator(t, WithoutCallbacks())
+
+	request := createDepositRequest(t, zotaApi, "")
+	data := deposit(t, zotaApi, request)
+	if data.MerchantOrderID != request.MerchantOrderID || !strings.HasSuffix(data.DepositUrl, "/deposit/"+data.OrderId) {
+		t.Errorf("Deposit data %+v doesn't describe the order", *data)
+	}
+
+	statusRequest := zota.NewZotaOrderStatusRequest(data.OrderId, request.MerchantOrderID)
+	response, err := zotaApi.OrderStatus(statusRequest)
+	if err != nil {
+		t.Fatalf("Order status request failed: %q\n", err)
+	}
+
+	if response.Data.Status != zota.Created || response.Data.Amount != "13.37" || response.Data.Currency != "USD" {
+		t.Errorf("Order status %+v doesn't equal the created order", *response.Data)
+	}
+
+	err = sim.SetStatus(data.OrderId, zota.Declined, "Insufficient funds")
+	if err != nil {
+		t.Fatalf("Failed to set status: %q\n", err)
+	}
+
+	response, err = zotaApi.OrderStatus(statusRequest)
+	if err != nil {
+		t.Fatalf("Order status request failed: %q\n", err)
+	}
+
+	if response.Data.Status != zota.Declined || response.Data.ErrorMessage != "Insufficient funds" || response.Data.ProcessorTransactionId == "" {
+		t.Errorf("Order status %+v isn't declined", *response.Data)
+	}
+
+	// Both IDs have to match
+	_, err = zotaApi.OrderStatus(zota.NewZotaOrderStatusRequest(data.OrderId, "e31edd0d-76a6-4f1c-be19-4504ff5b89d7"))
+	if !errors.Is(err, zota.ErrNotFound) {
+		t.Errorf("Order status request returned %v, expected %v", err, zota.ErrNotFound)
+	}
+}
+
+func TestRequestsAreValidated(t *testing.T) {
+	_, zotaApi := startSimulator(t)
+
+	tests := []struct {
+		name     string
+		modify   func(request *zota.ZotaDepositRequest)
+		expected error
+	}{
+		{"invalid signature", func(request *zota.ZotaDepositRequest) { request.Signature = "invalid" }, zota.ErrUnauthorized},
+		{"changed amount", func(request *zota.ZotaDepositRequest) { request.OrderAmount = "1337.00" }, zota.ErrUnauthorized},
+		{"missing email", func(request *zota.ZotaDepositRequest) { request.CustomerEmail = "" }, zota.ErrInvalidRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := createDepositRequest(t, zotaApi, "")
+			test.modify(request)
+
+			_, err := zotaApi.Deposit(request)
+			if !errors.Is(err, test.expected) {
+				t.Errorf("Deposit request returned %v, expected %v", err, test.expected)
+			}
+		})
+	}
+
+	// Merchant order IDs are unique
+	request := createDepositRequest(t, zotaApi, "")
+	deposit(t, zotaApi, request)
+	_, err := zotaApi.Deposit(request)
+	if !errors.Is(err, zota.ErrInvalidRequest) {
+		t.Errorf("Repeated deposit request returned %v, expected %v", err, zota.ErrInvalidRequest)
+	}
+
+	// Order status requests are signed with the merchant ID
+	otherMerchant, _ := zota.NewZotaAPI(testSecretKey, "123456", "OTHER", zotaApi.BaseUrl())
+	_, err = otherMerchant.OrderStatus(zota.NewZotaOrderStatusRequest("32ab44f0a5d9d6b6c0c9e41b1a9c7ea1a2b3c4d5", request.MerchantOrderID))
+	if !errors.Is(err, zota.ErrUnauthorized) {
+		t.Errorf("Order status request returned %v, expected %v", err, zota.ErrUnauthorized)
+	}
+}
+
+func TestScriptSendsCallbacks(t *testing.T) {
+	callbacks := make(chan zota.ZotaCallback, 4)
+	merchant := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var callback zota.ZotaCallback
+		json.NewDecoder(r.Body).Decode(&callback)
+		callbacks <- callback
+	}))
+	t.Cleanup(merchant.Close)
+
+	sim, zotaApi := startSimulator(t, WithScript(
+		Step{Status: zota.Processing},
+		Step{Status: zota.Approved, After: 10 * time.Millisecond, Amount: "13.00"},
+	))
+
+	data := deposit(t, zotaApi, createDepositRequest(t, zotaApi, merchant.URL))
+
+	for _, expected := range []zota.OrderStatus{zota.Processing, zota.Approved} {
+		select {
+		case callback := <-callbacks:
+			if callback.Status != expected || callback.OrderId != data.OrderId || callback.Type != zota.CallbackTypeSale {
+				t.Errorf("Callback %+v doesn't equal expected status %v", callback, expected)
+			}
+
+			if !callback.VerifySignature("123456", testSecretKey) {
+				t.Errorf("Callback %+v isn't signed", callback)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Callback with status %v wasn't sent", expected)
+		}
+	}
+
+	order, _ := sim.Order(data.OrderId)
+	if order.Status != zota.Approved || order.Amount != "13.00" {
+		t.Errorf("Order %+v isn't approved with the scripted amount", order)
+	}
+}
+
+func TestInjectedFaults(t *testing.T) {
+	tests := []struct {
+		name  string
+		fault Fault
+		check func(t *testing.T, err error)
+	}{
+		{"server error", Fault{HTTPStatus: 503, RetryAfter: 30 * time.Second, Times: 1}, func(t *testing.T, err error) {
+			var apiErr *zota.APIError
+			if !errors.As(err, &apiErr) || !apiErr.Temporary() || apiErr.RetryAfter != 30*time.Second {
+				t.Errorf("Request returned %v, expected a temporary error with Retry-After", err)
+			}
+		}},
+		{"error code", Fault{Code: "400", Message: "Invalid amount", Times: 1}, func(t *testing.T, err error) {
+			var apiErr *zota.APIError
+			if !errors.As(err, &apiErr) || apiErr.HTTPStatus != 200 || apiErr.Message != "Invalid amount" {
+				t.Errorf("Request returned %v, expected %v", err, zota.ErrInvalidRequest)
+			}
+		}},
+		{"malformed response", Fault{Malformed: true, Times: 1}, func(t *testing.T, err error) {
+			if !errors.Is(err, zota.ErrMalformedResponse) {
+				t.Errorf("Request returned %v, expected %v", err, zota.ErrMalformedResponse)
+			}
+		}},
+		{"missing data", Fault{MissingData: true, Times: 1}, func(t *testing.T, err error) {
+			if !errors.Is(err, zota.ErrMalformedResponse) {
+				t.Errorf("Request returned %v, expected %v", err, zota.ErrMalformedResponse)
+			}
+		}},
+		{"timeout", Fault{Delay: 2 * time.Second, Times: 1}, func(t *testing.T, err error) {
+			var apiErr *zota.APIError
+			if err == nil || errors.As(err, &apiErr) {
+				t.Errorf("Request returned %v, expected a timeout", err)
+			}
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sim, zotaApi := startSimulator(t, WithoutCallbacks())
+			data := deposit(t, zotaApi, createDepositRequest(t, zotaApi, ""))
+			statusRequest := zota.NewZotaOrderStatusRequest(data.OrderId, data.MerchantOrderID)
+
+			sim.InjectFault(EndpointOrderStatus, test.fault)
+			_, err := zotaApi.OrderStatus(statusRequest)
+			test.check(t, err)
+
+			// The fault only applied to a single request
+			_, err = zotaApi.OrderStatus(statusRequest)
+			if err != nil {
+				t.Errorf("Order status request after the fault failed: %q\n", err)
+			}
+		})
+	}
+}
+
+func TestDepositPageRedirectsBack(t *testing.T) {
+	_, zotaApi := startSimulator(t, WithoutCallbacks())
+	data := deposit(t, zotaApi, createDepositRequest(t, zotaApi, ""))
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	response, err := client.Get(data.DepositUrl + "?status=APPROVED")
+	if err != nil {
+		t.Fatalf("Failed to open deposit page: %q\n", err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusFound {
+		t.Fatalf("Server response %d doesn't equal expected %d", response.StatusCode, http.StatusFound)
+	}
+
+	location, _ := url.Parse(response.Header.Get("Location"))
+	query := location.Query()
+	redirect := zota.ZotaRedirect{
+		Status:          zota.OrderStatus(query.Get("status")),
+		OrderId:         query.Get("orderID"),
+		MerchantOrderId: query.Get("merchantOrderID"),
+		Signature:       query.Get("signature"),
+	}
+
+	if location.Path != "/deposit/return" || redirect.Status != zota.Approved || redirect.OrderId != data.OrderId {
+		t.Errorf("Redirect %v doesn't return the approved order", location)
+	}
+
+	if !redirect.VerifySignature(testSecretKey) {
+		t.Errorf("Redirect %v isn't signed", location)
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	steps, err := ParseScript("processing:2s, DECLINED:5s:Insufficient funds: try again,APPROVED")
+	if err != nil {
+		t.Fatalf("Failed to parse script: %q\n", err)
+	}
+
+	expected := []Step{
+		{Status: zota.Processing, After: 2 * time.Second},
+		{Status: zota.Declined, After: 5 * time.Second, ErrorMessage: "Insufficient funds: try again"},
+		{Status: zota.Approved},
+	}
+	if len(steps) != len(expected) {
+		t.Fatalf("Steps %+v don't equal expected %+v", steps, expected)
+	}
+
+	for i := range expected {
+		if steps[i] != expected[i] {
+			t.Errorf("Step %+v doesn't equal expected %+v", steps[i], expected[i])
+		}
+	}
+
+	for _, script := range []string{"PAID", "APPROVED:soon", "APPROVED:-1s"} {
+		_, err := ParseScript(script)
+		if err == nil {
+			t.Errorf("Expected script %q to be rejected", script)
+		}
+	}
+}